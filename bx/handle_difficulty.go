@@ -20,7 +20,7 @@ func CompactToTarget(input, powtype string) {
 	}
 	diffBig := pow.CompactToBig(uint32(u32))
 	switch powtype {
-	case "hash":
+	case "hash", "progpow", "cryptonight":
 		fmt.Printf("0x%064x\n", diffBig)
 	case "cuckoo24":
 		target := pow.CuckooDiffToTarget(48, diffBig)
@@ -41,7 +41,7 @@ func TargetToCompact(input, powtype string) {
 		return
 	}
 	switch powtype {
-	case "hash":
+	case "hash", "progpow", "cryptonight":
 		compact := pow.BigToCompact(bigT)
 		fmt.Printf("%d\n", compact)
 	case "cuckoo24":
@@ -133,6 +133,15 @@ func bitcoinpayMain(nodeChan chan<- *node.Node) error {
 		return err
 	}
 
+	if cfg.Metrics {
+		server, err := startMetricsServer(cfg.MetricsListen)
+		if err != nil {
+			log.Error("Unable to start metrics server", "error", err)
+			return err
+		}
+		defer server.Close()
+	}
+
 	if nodeChan != nil {
 		nodeChan <- n
 	}
@@ -0,0 +1,44 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/metrics"
+	"github.com/btceasypay/bitcoinpay/metrics/prometheus"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// processMetricsRefresh is the interval at which the memory and disk metrics
+// of the process are collected.
+const processMetricsRefresh = 3 * time.Second
+
+// startMetricsServer starts collecting the metrics of the process and serves
+// the metrics registry in the Prometheus text format at /metrics on the given
+// address.
+func startMetricsServer(listenAddr string) (*http.Server, error) {
+	if _, _, err := net.SplitHostPort(listenAddr); err != nil {
+		listenAddr = net.JoinHostPort("", listenAddr)
+	}
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	go metrics.CollectProcessMetrics(processMetricsRefresh)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.Handler(gometrics.DefaultRegistry))
+	server := &http.Server{Handler: mux}
+	go func() {
+		log.Info("Metrics server listening", "addr", listener.Addr())
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error("Metrics server failed", "error", err)
+		}
+	}()
+	return server, nil
+}
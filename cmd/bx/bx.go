@@ -203,13 +203,13 @@ func main() {
 	}
 
 	targetToCompactCmd := flag.NewFlagSet("target-to-compact", flag.ExitOnError)
-	targetToCompactCmd.StringVar(&mode, "pow", "hash", "pow type (hash , cuckoo24,cuckoo29)")
+	targetToCompactCmd.StringVar(&mode, "pow", "hash", "pow type (hash , progpow, cryptonight, cuckoo24,cuckoo29)")
 	targetToCompactCmd.Usage = func() {
 		cmdUsage(targetToCompactCmd, "Usage: bx target-to-compact [target]\n")
 	}
 
 	compactToTargetCmd := flag.NewFlagSet("compact-to-target", flag.ExitOnError)
-	compactToTargetCmd.StringVar(&powtype, "pow", "hash", "pow type (hash , cuckoo24,cuckoo29)")
+	compactToTargetCmd.StringVar(&powtype, "pow", "hash", "pow type (hash , progpow, cryptonight, cuckoo24,cuckoo29)")
 	compactToTargetCmd.Usage = func() {
 		cmdUsage(compactToTargetCmd, "Usage: bx compact-to-target [compact]\n")
 	}
@@ -97,6 +97,37 @@ func fastIBD() error {
 					return node.Import()
 				},
 			},
+			&cli.Command{
+				Name:        "snapshot",
+				Aliases:     []string{"s"},
+				Category:    "IBD",
+				Usage:       "Export the UTXO set from database",
+				Description: "Export a snapshot of the UTXO set at a block from database",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "path",
+						Aliases:     []string{"p"},
+						Usage:       "Path to output data",
+						Value:       defaultHomeDir,
+						Destination: &cfg.OutputPath,
+					},
+					&cli.StringFlag{
+						Name:        "endpoint",
+						Aliases:     []string{"e"},
+						Usage:       "Block of the UTXO set, the main chain tip by default",
+						Destination: &cfg.EndPoint,
+					},
+				},
+				Before: func(c *cli.Context) error {
+					return node.init(cfg)
+				},
+				After: func(c *cli.Context) error {
+					return node.exit()
+				},
+				Action: func(c *cli.Context) error {
+					return node.Snapshot()
+				},
+			},
 		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -65,6 +65,12 @@ func (node *Node) init(cfg *Config) error {
 }
 
 func (node *Node) exit() error {
+	if node.bc != nil {
+		node.bc.Stop()
+		if err := node.bc.FlushUtxoCache(); err != nil {
+			log.Error("flush utxo cache", "error", err)
+		}
+	}
 	if node.db != nil {
 		log.Info(fmt.Sprintf("Gracefully shutting down the database:%s", node.name))
 		node.db.Close()
@@ -248,3 +254,45 @@ func (node *Node) Import() error {
 	log.Info(fmt.Sprintf("New Info:%s  mainOrder=%d tips=%d", mainTip.GetHash().String(), mainTip.GetOrder(), node.bc.BlockDAG().GetTips().Size()))
 	return nil
 }
+
+func (node *Node) Snapshot() error {
+	mainTip := node.bc.BlockDAG().GetMainChainTip()
+	if mainTip.GetOrder() <= 0 {
+		return fmt.Errorf("No blocks in database")
+	}
+	outFilePath, err := GetSnapshotFilePath(node.cfg.OutputPath)
+	if err != nil {
+		return err
+	}
+
+	order := uint64(mainTip.GetOrder())
+	if len(node.cfg.EndPoint) > 0 {
+		ephash, err := hash.NewHashFromStr(node.cfg.EndPoint)
+		if err != nil {
+			return err
+		}
+		endPoint := node.bc.BlockDAG().GetBlock(ephash)
+		if endPoint == nil {
+			return fmt.Errorf("End point is error")
+		}
+		order = uint64(endPoint.GetOrder())
+		log.Info(fmt.Sprintf("End point:%s order:%d", ephash.String(), order))
+	}
+
+	outFile, err := os.OpenFile(outFilePath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		outFile.Close()
+	}()
+
+	log.Info("Snapshot...")
+	info, err := node.bc.DumpUtxoSet(outFile, order)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("Finish snapshot: order(%d) hash(%s) utxos(%d) contenthash(%s)    ------>File:%s",
+		info.Order, info.Hash.String(), info.Count, info.ContentHash.String(), outFilePath))
+	return nil
+}
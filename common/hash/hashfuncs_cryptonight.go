@@ -0,0 +1,18 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+package hash
+
+import (
+	"github.com/btceasypay/bitcoinpay/crypto/cryptonight"
+)
+
+// CryptonightVariant is the variant of CryptoNight used by the pow.
+const CryptonightVariant = 2
+
+// HashCryptonight calculates the CryptoNight hash of b and returns the
+// resulting bytes as a Hash.
+func HashCryptonight(b []byte) Hash {
+	r := cryptonight.Sum(b, CryptonightVariant)
+	hashR := [32]byte{}
+	copy(hashR[:32], r[:32])
+	return Hash(hashR)
+}
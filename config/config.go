@@ -20,6 +20,10 @@ type Config struct {
 	DisableListen      bool     `long:"nolisten" description:"Disable listening for incoming connections"`
 	RPCUser            string   `short:"u" long:"rpcuser" description:"Username for RPC connections"`
 	RPCPass            string   `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
+	RPCLimitUser       string   `long:"rpclimituser" description:"Username for limited RPC connections"`
+	RPCLimitPass       string   `long:"rpclimitpass" default-mask:"-" description:"Password for limited RPC connections"`
+	RPCLimitAllow      []string `long:"rpclimitallow" description:"Add a namespace or namespace_method the limited user may call, * for all (default: bitcoinpay)"`
+	RPCAPIKeys         []string `long:"rpcapikey" description:"Add an API key accepted as a bearer token, optionally followed by a colon and the comma separated namespaces or namespace_method it may call (default: bitcoinpay)"`
 	RPCCert            string   `long:"rpccert" description:"File containing the certificate file"`
 	RPCKey             string   `long:"rpckey" description:"File containing the certificate key"`
 	RPCMaxClients      int      `long:"rpcmaxclients" description:"Max number of RPC clients for standard connections"`
@@ -32,22 +36,31 @@ type Config struct {
 	DropTxIndex        bool     `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	AddrIndex          bool     `long:"addrindex" description:"Maintain a full address-based transaction index which makes the getrawtransactions RPC available"`
 	DropAddrIndex      bool     `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
+	NoCFilters         bool     `long:"nocfilters" description:"Disable committed filtering (CF) support"`
+	PeerBloomFilters   bool     `long:"peerbloomfilters" description:"Enable bloom filtering (BIP37) support for the light clients"`
 	LightNode          bool     `long:"light" description:"start as a bitcoinpay light node"`
 	SigCacheMaxSize    uint     `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
+	UtxoCacheMaxSize   uint     `long:"utxocachemaxsize" description:"The maximum size in MiB of the UTXO cache, 0 writes the UTXO set back after each block"`
+	UtxoSnapshot       string   `long:"loadutxosnapshot" description:"Start syncing from the UTXO set snapshot of the given file, which must be pinned in the network parameters"`
 	DumpBlockchain     string   `long:"dumpblockchain" description:"Write blockchain as a flat file of blocks for use with addblock, to the specified filename"`
 	TestNet            bool     `long:"testnet" description:"Use the test network"`
 	MixNet             bool     `long:"mixnet" description:"Use the test mix pow network"`
 	PrivNet            bool     `long:"privnet" description:"Use the private network"`
 	DbType             string   `long:"dbtype" description:"Database backend to use for the Block Chain"`
 	Profile            string   `long:"profile" description:"Enable HTTP profiling on given [addr:]port -- NOTE port must be between 1024 and 65536"`
+	Metrics            bool     `long:"metrics" description:"Enable metrics collection -- NOTE: must be given on the command line"`
+	MetricsListen      string   `long:"metricslisten" description:"Serve the metrics in the Prometheus text format at /metrics on the given [addr:]port"`
 	DebugLevel         string   `short:"d" long:"debuglevel" description:"Logging level {trace, debug, info, warn, error, critical} "`
 	DebugPrintOrigins  bool     `long:"printorigin" description:"Print log debug location (file:line) "`
 	// MemPool Config
-	NoRelayPriority  bool    `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
-	FreeTxRelayLimit float64 `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
-	AcceptNonStd     bool    `long:"acceptnonstd" description:"Accept and relay non-standard transactions to the network regardless of the default settings for the active network."`
-	MaxOrphanTxs     int     `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
-	MinTxFee         int64   `long:"mintxfee" description:"The minimum transaction fee in AtomBTP/kB."`
+	NoRelayPriority   bool    `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
+	FreeTxRelayLimit  float64 `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
+	AcceptNonStd      bool    `long:"acceptnonstd" description:"Accept and relay non-standard transactions to the network regardless of the default settings for the active network."`
+	MaxOrphanTxs      int     `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
+	MinTxFee          int64   `long:"mintxfee" description:"The minimum transaction fee in AtomBTP/kB."`
+	MaxMempool        int64   `long:"maxmempool" description:"Max size of the mempool in megabytes, 0 for no limit"`
+	NoPersistMempool  bool    `long:"nopersistmempool" description:"Do not save the mempool on shutdown and load it on startup"`
+	RejectReplacement bool    `long:"rejectreplacement" description:"Reject transactions that attempt to replace existing transactions within the mempool through the Replace-By-Fee (RBF) signaling policy."`
 	// Miner
 	Generate          bool     `long:"generate" description:"Generate (mine) coins using the CPU"`
 	MiningAddrs       []string `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
@@ -55,9 +68,15 @@ type Config struct {
 	BlockMinSize      uint32   `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
 	BlockMaxSize      uint32   `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
 	BlockPrioritySize uint32   `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
+	StratumListen     string   `long:"stratumlisten" description:"Listen for Stratum V1 miners on the given [addr:]port, paying to the mining addresses"`
+	StratumPow        string   `long:"stratumpow" description:"The pow of the Stratum jobs {blake2bd, x16rv3, x8r16, bitcoinpay_keccak256, progpow, cryptonight}"`
+	StratumDiff       float64  `long:"stratumdiff" description:"The minimum share difficulty of the Stratum miners, relative to the pow limit"`
+	StratumPass       string   `long:"stratumpass" description:"Password the Stratum miners must authorize with"`
+	StratumMaxClients int      `long:"stratummaxclients" description:"Max number of Stratum miner connections"`
 	miningAddrs       []types.Address
 	//WebSocket support
-	RPCMaxWebsockets int `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
+	RPCMaxWebsockets int      `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
+	RPCWSOrigins     []string `long:"rpcwsorigin" description:"Add an origin (eg. https://example.com) allowed to open RPC websocket connections from a browser, * for any (default: the RPC host only)"`
 	//P2P
 	BlocksOnly      bool     `long:"blocksonly" description:"Do not accept transactions from remote peers."`
 	MiningStateSync bool     `long:"miningstatesync" description:"Synchronizing the mining state with other nodes"`
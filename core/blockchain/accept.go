@@ -138,6 +138,9 @@ func (b *BlockChain) maybeAcceptBlock(block *types.SerializedBlock, flags Behavi
 	b.getReorganizeNodes(newNode, block, newOrders, &oldOrders)
 	b.index.AddNode(newNode)
 	newNode.SetStatusFlags(statusDataStored)
+	if hasInvalidatedParent(parentsNode) {
+		newNode.SetStatusFlags(statusInvalidatedAncestor)
+	}
 	err = newNode.FlushToDB(b)
 	if err != nil {
 		panic(err.Error())
@@ -229,6 +232,9 @@ func (b *BlockChain) FastAcceptBlock(block *types.SerializedBlock) error {
 	b.getReorganizeNodes(newNode, block, newOrders, &oldOrders)
 	b.index.AddNode(newNode)
 	newNode.SetStatusFlags(statusDataStored)
+	if hasInvalidatedParent(parentsNode) {
+		newNode.SetStatusFlags(statusInvalidatedAncestor)
+	}
 	err := newNode.FlushToDB(b)
 	if err != nil {
 		return err
@@ -14,6 +14,7 @@ import (
 	"github.com/btceasypay/bitcoinpay/engine/txscript"
 	"github.com/btceasypay/bitcoinpay/params"
 	"github.com/btceasypay/bitcoinpay/services/common/progresslog"
+	"github.com/btceasypay/bitcoinpay/trie"
 	"os"
 	"sort"
 	"sync"
@@ -55,6 +56,11 @@ type BlockChain struct {
 	// values.
 	subsidyCache *SubsidyCache
 
+	// subscribers are the additional callbacks registered after creation
+	// by Subscribe, they are protected by the subscribers lock.
+	subscribersLock sync.RWMutex
+	subscribers     []NotificationCallback
+
 	// chainLock protects concurrent access to the vast majority of the
 	// fields in this struct below this point.
 	chainLock sync.RWMutex
@@ -108,6 +114,33 @@ type BlockChain struct {
 	// block version
 	BlockVersion uint32
 
+	// deploymentCaches caches the threshold states of the deployments by
+	// name.  They are protected by the deployment lock.
+	deploymentLock   sync.Mutex
+	deploymentCaches map[string]thresholdStateCache
+
+	// utxoTrie is the secure trie of the utxo set whose root is committed
+	// in the state root of the blocks, and utxoTrieRoot the root of it
+	// last written to the database.  They are protected by the UTXO trie
+	// lock.
+	utxoTrieLock  sync.Mutex
+	utxoTrieStore *utxoTrieDB
+	utxoTrieDB    *trie.Database
+	utxoTrie      *trie.SecureTrie
+	utxoTrieRoot  hash.Hash
+
+	// utxoCache caches the utxo set in front of the database.
+	utxoCache *utxoCache
+
+	// utxoSnapshot is the utxo snapshot the node was started from until
+	// the blocks up to it are validated.  It is protected by the chain
+	// lock.
+	utxoSnapshot *utxoSnapshotState
+
+	// quit and wg stop the background work of the chain.
+	quit chan struct{}
+	wg   sync.WaitGroup
+
 	// Cache Invalid tx
 	CacheInvalidTx bool
 }
@@ -174,6 +207,19 @@ type Config struct {
 
 	// Cache Invalid tx
 	CacheInvalidTx bool
+
+	// UtxoCacheMaxSize is the maximum size in bytes of the utxo cache.  The
+	// modified utxos are written back to the database once the cache
+	// exceeds it.
+	//
+	// This field can be zero to write them back after each block.
+	UtxoCacheMaxSize uint64
+
+	// UtxoSnapshot is the path of a utxo snapshot to start the chain from.
+	// The snapshot must be pinned in the chain parameters.
+	//
+	// This field can be empty to sync the utxo set from the blocks.
+	UtxoSnapshot string
 }
 
 // BestState houses information about the current best block and other info
@@ -265,6 +311,8 @@ func New(config *Config) (*BlockChain, error) {
 		orphans:            make(map[hash.Hash]*orphanBlock),
 		BlockVersion:       config.BlockVersion,
 		CacheInvalidTx:     config.CacheInvalidTx,
+		deploymentCaches:   make(map[string]thresholdStateCache),
+		quit:               make(chan struct{}),
 	}
 	b.subsidyCache = NewSubsidyCache(0, b.params)
 
@@ -278,6 +326,23 @@ func New(config *Config) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// Open the UTXO trie, building it from the utxo set as needed.
+	if err := b.initUtxoTrie(); err != nil {
+		return nil, err
+	}
+
+	// Create the utxo cache, recovering the utxo set as needed when the
+	// cache was not flushed before shutting down.
+	if err := b.initUtxoCache(config.UtxoCacheMaxSize); err != nil {
+		return nil, err
+	}
+
+	// Load the utxo snapshot to start from, if any, and resume validating
+	// the blocks of the one the node was started from.
+	if err := b.initUtxoSnapshot(config.UtxoSnapshot); err != nil {
+		return nil, err
+	}
+
 	// Initialize and catch up all of the currently active optional indexes
 	// as needed.
 	if config.IndexManager != nil {
@@ -307,6 +372,12 @@ func New(config *Config) (*BlockChain, error) {
 	return &b, nil
 }
 
+// Stop stops the background work of the chain and waits for it to finish.
+func (b *BlockChain) Stop() {
+	close(b.quit)
+	b.wg.Wait()
+}
+
 // initChainState attempts to load and initialize the chain state from the
 // database.  When the db does not yet contain any chain state, both it and the
 // chain state are initialized to the genesis block.
@@ -741,7 +812,13 @@ func (b *BlockChain) connectDagChain(node *blockNode, block *types.SerializedBlo
 		view.SetViewpoints([]*hash.Hash{&node.hash})
 
 		stxos := []SpentTxOut{}
-		err := b.checkConnectBlock(node, block, view, &stxos)
+		var err error
+		// The blocks up to the utxo snapshot the node was started from
+		// are connected without their utxos, which are validated in the
+		// background.
+		if !b.isAssumedUtxo(node.order) {
+			err = b.checkConnectBlock(node, block, view, &stxos)
+		}
 		if err != nil {
 			node.Invalid(b)
 			stxos = []SpentTxOut{}
@@ -763,6 +840,14 @@ func (b *BlockChain) connectDagChain(node *blockNode, block *types.SerializedBlo
 		}
 		// TODO, validating previous block
 		log.Debug("Block connected to the main chain", "hash", node.hash, "order", node.order)
+		err = b.utxoSnapshotBlockConnected(node)
+		if err != nil {
+			return true, err
+		}
+		err = b.maybeFlushUtxoCache()
+		if err != nil {
+			return true, err
+		}
 		return true, nil
 	}
 
@@ -776,7 +861,18 @@ func (b *BlockChain) connectDagChain(node *blockNode, block *types.SerializedBlo
 
 	// Reorganize the chain.
 	log.Debug(fmt.Sprintf("Start DAG REORGANIZE: Block %v is causing a reorganize.", node.hash))
-	err := b.reorganizeChain(oldOrders, newOrders, block)
+	// The utxo set can't be recovered by connecting the blocks again while
+	// their order is changing, so it is marked until the reorganization is
+	// written back.
+	err := b.flushUtxoCache(utxoStateReorganizing)
+	if err != nil {
+		return false, err
+	}
+	err = b.reorganizeChain(oldOrders, newOrders, block)
+	if err != nil {
+		return false, err
+	}
+	err = b.flushUtxoCache(utxoStateConsistent)
 	if err != nil {
 		return false, err
 	}
@@ -834,11 +930,21 @@ func (b *BlockChain) updateBestState(node *blockNode, block *types.SerializedBlo
 	// this block.
 	numTxns := uint64(len(block.Block().Transactions))
 
+	return b.putBestState(block, curTotalTxns+numTxns)
+}
+
+// putBestState stores and sets the best state of the main chain tip of the
+// DAG, with the given block as the last one connected.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) putBestState(block *types.SerializedBlock, totalTxns uint64) error {
+	numTxns := uint64(len(block.Block().Transactions))
+
 	blockSize := uint64(block.Block().SerializeSize())
 
 	mainTip := b.index.LookupNode(b.bd.GetMainChainTip().GetHash())
 
-	state := newBestState(mainTip.GetHash(), mainTip.bits, blockSize, numTxns, mainTip.CalcPastMedianTime(b), curTotalTxns+numTxns,
+	state := newBestState(mainTip.GetHash(), mainTip.bits, blockSize, numTxns, mainTip.CalcPastMedianTime(b), totalTxns,
 		b.bd.GetMainChainTip().GetWeight(), b.bd.GetGraphState())
 
 	// Atomically insert info into the database.
@@ -878,22 +984,23 @@ func (b *BlockChain) updateBestState(node *blockNode, block *types.SerializedBlo
 //
 // This function MUST be called with the chain state lock held (for writes).
 func (b *BlockChain) connectBlock(node *blockNode, block *types.SerializedBlock, view *UtxoViewpoint, stxos []SpentTxOut) error {
+	// Apply the changes of the utxo view to the UTXO trie.  Its nodes are
+	// written with the block, its new root is stored along with the utxo
+	// set when the utxo cache is flushed.
+	utxoRoot, err := b.updateUtxoTrie(view)
+	if err != nil {
+		return err
+	}
+
 	// Atomically insert info into the database.
-	err := b.db.Update(func(dbTx database.Tx) error {
+	b.utxoTrieLock.Lock()
+	err = b.db.Update(func(dbTx database.Tx) error {
 		// Add the block hash and height to the block index.
 		err := dbPutBlockIndex(dbTx, block.Hash(), node.order)
 		if err != nil {
 			return err
 		}
 
-		// Update the utxo set using the state of the utxo view.  This
-		// entails removing all of the utxos spent and adding the new
-		// ones created by the block.
-		err = dbPutUtxoView(dbTx, view)
-		if err != nil {
-			return err
-		}
-
 		// Update the transaction spend journal by adding a record for
 		// the block that contains all txos spent by it.
 		err = dbPutSpendJournalEntry(dbTx, block.Hash(), stxos)
@@ -909,14 +1016,22 @@ func (b *BlockChain) connectBlock(node *blockNode, block *types.SerializedBlock,
 				return err
 			}
 		}
-		return nil
+		return b.commitUtxoTrie(dbTx, utxoRoot)
 	})
+	b.finishUtxoTrieUpdate(utxoRoot, err == nil)
+	b.utxoTrieLock.Unlock()
 	if err != nil {
 		return err
 	}
 
+	// Update the utxo set using the state of the utxo view.  This entails
+	// removing all of the utxos spent and adding the new ones
+	// created by the block.  The changes are written back to the database
+	// when the utxo cache is flushed.
+	b.utxoCache.commitView(view)
+
 	// Prune fully spent entries and mark all entries in the view unmodified
-	// now that the modifications have been committed to the database.
+	// now that the modifications have been committed to the utxo cache.
 	view.commit()
 
 	b.sendNotification(BlockConnected, []*types.SerializedBlock{block})
@@ -928,21 +1043,23 @@ func (b *BlockChain) connectBlock(node *blockNode, block *types.SerializedBlock,
 //
 // This function MUST be called with the chain state lock held (for writes).
 func (b *BlockChain) disconnectBlock(node *blockNode, block *types.SerializedBlock, view *UtxoViewpoint, stxos []SpentTxOut) error {
+	// Apply the changes of the utxo view to the UTXO trie.  Its nodes are
+	// written with the block, its new root is stored along with the utxo
+	// set when the utxo cache is flushed.
+	utxoRoot, err := b.updateUtxoTrie(view)
+	if err != nil {
+		return err
+	}
+
 	// Calculate the exact subsidy produced by adding the block.
-	err := b.db.Update(func(dbTx database.Tx) error {
+	b.utxoTrieLock.Lock()
+	err = b.db.Update(func(dbTx database.Tx) error {
 		// Remove the block hash and order from the block index.
 		err := dbRemoveBlockIndex(dbTx, block.Hash(), int64(node.order)) //TODO, remove type conversion
 		if err != nil {
 			return err
 		}
 
-		// Update the utxo set using the state of the utxo view.  This
-		// entails restoring all of the utxos spent and removing the new
-		// ones created by the block.
-		err = dbPutUtxoView(dbTx, view)
-		if err != nil {
-			return err
-		}
 		// Update the transaction spend journal by removing the record
 		// that contains all txos spent by the block .
 		err = dbRemoveSpendJournalEntry(dbTx, block.Hash())
@@ -958,14 +1075,22 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *types.SerializedBlo
 				return err
 			}
 		}
-		return nil
+		return b.commitUtxoTrie(dbTx, utxoRoot)
 	})
+	b.finishUtxoTrieUpdate(utxoRoot, err == nil)
+	b.utxoTrieLock.Unlock()
 	if err != nil {
 		return err
 	}
 
+	// Update the utxo set using the state of the utxo view.  This entails
+	// restoring all of the utxos spent and removing the new ones
+	// created by the block.  The changes are written back to the database
+	// when the utxo cache is flushed.
+	b.utxoCache.commitView(view)
+
 	// Prune fully spent entries and mark all entries in the view unmodified
-	// now that the modifications have been committed to the database.
+	// now that the modifications have been committed to the utxo cache.
 	view.commit()
 
 	b.sendNotification(BlockDisconnected, block)
@@ -1017,9 +1142,15 @@ func (b *BlockChain) reorganizeChain(detachNodes BlockNodeList, attachNodes *lis
 		var stxos []SpentTxOut
 		view := NewUtxoViewpoint()
 		view.SetViewpoints([]*hash.Hash{block.Hash()})
-		if !b.index.NodeStatus(n).KnownInvalid() {
+		assumed := b.isAssumedUtxo(n.order)
+		if assumed && b.utxoSnapshot.validating {
+			return fmt.Errorf("the block %v of order %d before the utxo "+
+				"snapshot can't be disconnected, the chain must be "+
+				"synced again without the snapshot", n.hash, n.order)
+		}
+		if !assumed && !b.index.NodeStatus(n).KnownInvalid() {
 			b.CalculateDAGDuplicateTxs(block)
-			err = view.fetchInputUtxos(b.db, block, b)
+			err = view.fetchInputUtxos(block, b)
 			if err != nil {
 				return err
 			}
@@ -1077,7 +1208,10 @@ func (b *BlockChain) reorganizeChain(detachNodes BlockNodeList, attachNodes *lis
 		view := NewUtxoViewpoint()
 		view.SetViewpoints([]*hash.Hash{n.GetHash()})
 		stxos := []SpentTxOut{}
-		err = b.checkConnectBlock(n, block, view, &stxos)
+		err = nil
+		if !b.isAssumedUtxo(n.order) {
+			err = b.checkConnectBlock(n, block, view, &stxos)
+		}
 		if err != nil {
 			n.Invalid(b)
 			stxos = []SpentTxOut{}
@@ -1093,6 +1227,10 @@ func (b *BlockChain) reorganizeChain(detachNodes BlockNodeList, attachNodes *lis
 		if !n.GetStatus().KnownInvalid() {
 			n.Valid(b)
 		}
+		err = b.utxoSnapshotBlockConnected(n)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Log the point where the chain forked and old and new best chain
@@ -1292,6 +1430,13 @@ func (b *BlockChain) GetFees(h *hash.Hash) int64 {
 	if BlockStatus(ib.GetStatus()).KnownInvalid() {
 		return 0
 	}
+	// Only the blocks up to the utxo snapshot have their fees stored
+	// until they are validated.
+	if b.isAssumedUtxo(uint64(ib.GetOrder())) {
+		if fees, ok := b.utxoSnapshotFees(h); ok {
+			return fees
+		}
+	}
 	block, err := b.FetchBlockByHash(h)
 	if err != nil {
 		return 0
@@ -33,6 +33,14 @@ const (
 
 	// statusInvalid indicates that the block has failed validation.
 	statusInvalid BlockStatus = 1 << 2
+
+	// statusInvalidated indicates that the block was invalidated by the
+	// operator.
+	statusInvalidated BlockStatus = 1 << 3
+
+	// statusInvalidatedAncestor indicates that an ancestor of the block was
+	// invalidated by the operator.
+	statusInvalidatedAncestor BlockStatus = 1 << 4
 )
 
 // HaveData returns whether the full block data is stored in the database.  This
@@ -54,6 +62,12 @@ func (status BlockStatus) KnownInvalid() bool {
 	return status&statusInvalid != 0
 }
 
+// Invalidated returns whether the block or one of its ancestors was
+// invalidated by the operator.
+func (status BlockStatus) Invalidated() bool {
+	return status&(statusInvalidated|statusInvalidatedAncestor) != 0
+}
+
 // blockNode represents a block within the block chain and is primarily used to
 // aid in selecting the best chain to be the main chain.  The main chain is
 // stored into the block database.
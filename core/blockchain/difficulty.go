@@ -9,8 +9,11 @@ package blockchain
 import (
 	"fmt"
 	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/common/util"
 	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/params"
 	"math/big"
+	"sort"
 	"time"
 )
 
@@ -61,43 +64,120 @@ func (b *BlockChain) calcEasiestDifficulty(bits uint32, duration time.Duration,
 	return pow.BigToCompact(newTarget)
 }
 
+// DifficultyNode is a block as seen by the difficulty retarget rules, which
+// only follow the main parents of the blocks.  It lets the header chain of a
+// light node share the rules of the block chain.
+type DifficultyNode interface {
+	// GetHash returns the hash of the block.
+	GetHash() *hash.Hash
+
+	// GetBits returns the difficulty of the block in compact form.
+	GetBits() uint32
+
+	// GetTimestamp returns the time of the block as a unix timestamp.
+	GetTimestamp() int64
+
+	// GetPowType returns the proof of work of the block.
+	GetPowType() pow.PowType
+
+	// GetOrder returns the order of the block in the DAG.
+	GetOrder() uint64
+
+	// GetHeight returns the main height of the block.
+	GetHeight() uint
+
+	// MainParentNode returns the main parent of the block, nil for the
+	// genesis block.
+	MainParentNode() DifficultyNode
+}
+
+// chainDiffNode is a block node of the block chain as seen by the difficulty
+// retarget rules.
+type chainDiffNode struct {
+	*blockNode
+	b *BlockChain
+}
+
+// GetBits returns the difficulty of the block in compact form.
+func (n chainDiffNode) GetBits() uint32 {
+	return n.bits
+}
+
+// MainParentNode returns the main parent of the block in the DAG.
+func (n chainDiffNode) MainParentNode() DifficultyNode {
+	if n.parents == nil {
+		return nil
+	}
+	block := n.b.bd.GetBlockById(n.GetID())
+	if block == nil {
+		return nil
+	}
+	mainParent := n.b.bd.GetBlockById(block.GetMainParent())
+	if mainParent == nil {
+		return nil
+	}
+	return n.b.diffNode(n.b.index.LookupNode(mainParent.GetHash()))
+}
+
+// diffNode returns the block node as seen by the difficulty retarget rules.
+func (b *BlockChain) diffNode(node *blockNode) DifficultyNode {
+	if node == nil {
+		return nil
+	}
+	return chainDiffNode{node, b}
+}
+
+// retarget returns the difficulty retarget rules of the chain.
+func (b *BlockChain) retarget() *Retarget {
+	return NewRetarget(b.params)
+}
+
+// calcNextRequiredDifficulty calculates the required difficulty for the block
+// after the passed previous block node based on the difficulty retarget rules.
+// This function differs from the exported CalcNextRequiredDifficulty in that
+// the exported version uses the current best chain as the previous block node
+// while this function accepts any block node.
+func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime time.Time, powInstance pow.IPow) (uint32, error) {
+	return b.retarget().NextRequiredDifficulty(b.diffNode(curNode), newBlockTime, powInstance)
+}
+
+// Retarget applies the difficulty retarget rules of a network to the main
+// chain of a block DAG.
+type Retarget struct {
+	params *params.Params
+}
+
+// NewRetarget returns the difficulty retarget rules of the network.
+func NewRetarget(par *params.Params) *Retarget {
+	return &Retarget{params: par}
+}
+
 // findPrevTestNetDifficulty returns the difficulty of the previous block which
 // did not have the special testnet minimum difficulty rule applied.
-//
-// This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) findPrevTestNetDifficulty(startNode *blockNode, powInstance pow.IPow) uint32 {
+func (r *Retarget) findPrevTestNetDifficulty(startNode DifficultyNode, powInstance pow.IPow) uint32 {
 	// Search backwards through the chain for the last block without
 	// the special rule applied.
-	blocksPerRetarget := uint64(b.params.WorkDiffWindowSize *
-		b.params.WorkDiffWindows)
-	iterBlock := b.bd.GetBlock(startNode.GetHash())
-	var iterNode *blockNode
-	target := powInstance.GetSafeDiff(0)
-	for {
-		if iterBlock == nil ||
-			uint64(iterBlock.GetHeight())%blocksPerRetarget == 0 {
-			break
-		}
-		iterNode = b.index.LookupNode(iterBlock.GetHash())
-		if iterNode.bits != pow.BigToCompact(target) {
-			break
-		}
+	blocksPerRetarget := uint64(r.params.WorkDiffWindowSize *
+		r.params.WorkDiffWindows)
+	minBits := pow.BigToCompact(powInstance.GetSafeDiff(0))
+	iterNode := startNode
+	for iterNode != nil && uint64(iterNode.GetHeight())%blocksPerRetarget != 0 &&
+		iterNode.GetBits() == minBits {
+		iterNode = iterNode.MainParentNode()
 	}
+
 	// Return the found difficulty or the minimum difficulty if no
 	// appropriate block was found.
-	lastBits := pow.BigToCompact(target)
+	lastBits := minBits
 	if iterNode != nil {
-		lastBits = iterNode.bits
+		lastBits = iterNode.GetBits()
 	}
 	return lastBits
 }
 
-// calcNextRequiredDifficulty calculates the required difficulty for the block
+// NextRequiredDifficulty calculates the required difficulty for the block
 // after the passed previous block node based on the difficulty retarget rules.
-// This function differs from the exported CalcNextRequiredDifficulty in that
-// the exported version uses the current best chain as the previous block node
-// while this function accepts any block node.
-func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime time.Time, powInstance pow.IPow) (uint32, error) {
+func (r *Retarget) NextRequiredDifficulty(curNode DifficultyNode, newBlockTime time.Time, powInstance pow.IPow) (uint32, error) {
 	baseTarget := powInstance.GetSafeDiff(0)
 	originCurrentNode := curNode
 	// Genesis block.
@@ -105,47 +185,43 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 		return pow.BigToCompact(baseTarget), nil
 	}
 
-	curNode = b.getPowTypeNode(curNode, powInstance.GetPowType())
-	if curNode == nil || curNode.GetHash().IsEqual(&hash.ZeroHash) {
-		return pow.BigToCompact(baseTarget), nil
-	}
-	curBlock := b.bd.GetBlock(curNode.GetHash())
-	if curBlock == nil {
+	curNode = r.powTypeNode(curNode, powInstance.GetPowType())
+	if curNode == nil {
 		return pow.BigToCompact(baseTarget), nil
 	}
 
 	// Get the old difficulty; if we aren't at a block height where it changes,
 	// just return this.
-	oldDiff := curNode.bits
-	oldDiffBig := pow.CompactToBig(curNode.bits)
-	windowsSizeBig := big.NewInt(b.params.WorkDiffWindowSize)
+	oldDiff := curNode.GetBits()
+	oldDiffBig := pow.CompactToBig(curNode.GetBits())
+	windowsSizeBig := big.NewInt(r.params.WorkDiffWindowSize)
 	// percent is *100 * 2^32
 	windowsSizeBig.Mul(windowsSizeBig, powInstance.PowPercent())
 	windowsSizeBig.Div(windowsSizeBig, big.NewInt(100))
 	windowsSizeBig.Rsh(windowsSizeBig, 32)
 	needAjustCount := int64(windowsSizeBig.Uint64())
 	// We're not at a retarget point, return the oldDiff.
-	if !b.needAjustPowDifficulty(curNode, powInstance.GetPowType(), needAjustCount) {
+	if !r.needAjustPowDifficulty(curNode, powInstance.GetPowType(), needAjustCount) {
 		// For networks that support it, allow special reduction of the
 		// required difficulty once too much time has elapsed without
 		// mining a block.
-		if b.params.ReduceMinDifficulty {
+		if r.params.ReduceMinDifficulty {
 			// Return minimum difficulty when more than the desired
 			// amount of time has elapsed without mining a block.
-			reductionTime := int64(b.params.MinDiffReductionTime /
+			reductionTime := int64(r.params.MinDiffReductionTime /
 				time.Second)
-			allowMinTime := curNode.timestamp + reductionTime
+			allowMinTime := curNode.GetTimestamp() + reductionTime
 
 			// For every extra target timespan that passes, we halve the
 			// difficulty.
 			if newBlockTime.Unix() > allowMinTime {
-				timePassed := newBlockTime.Unix() - curNode.timestamp
+				timePassed := newBlockTime.Unix() - curNode.GetTimestamp()
 				timePassed -= reductionTime
-				shifts := uint((timePassed / int64(b.params.TargetTimePerBlock/
+				shifts := uint((timePassed / int64(r.params.TargetTimePerBlock/
 					time.Second)) + 1)
 
 				// Scale the difficulty with time passed.
-				oldTarget := pow.CompactToBig(curNode.bits)
+				oldTarget := pow.CompactToBig(curNode.GetBits())
 				newTarget := new(big.Int)
 				if shifts < maxShift {
 					newTarget.Lsh(oldTarget, shifts)
@@ -164,60 +240,65 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 			// The block was mined within the desired timeframe, so
 			// return the difficulty for the last block which did
 			// not have the special minimum difficulty rule applied.
-			return b.findPrevTestNetDifficulty(curNode, powInstance), nil
+			return r.findPrevTestNetDifficulty(curNode, powInstance), nil
 		}
 
 		return oldDiff, nil
 	}
 	// Declare some useful variables.
-	RAFBig := big.NewInt(b.params.RetargetAdjustmentFactor)
-	nextDiffBigMin := pow.CompactToBig(curNode.bits)
+	RAFBig := big.NewInt(r.params.RetargetAdjustmentFactor)
+	nextDiffBigMin := pow.CompactToBig(curNode.GetBits())
 	nextDiffBigMin.Div(nextDiffBigMin, RAFBig)
-	nextDiffBigMax := pow.CompactToBig(curNode.bits)
+	nextDiffBigMax := pow.CompactToBig(curNode.GetBits())
 	nextDiffBigMax.Mul(nextDiffBigMax, RAFBig)
 
-	alpha := b.params.WorkDiffAlpha
+	alpha := r.params.WorkDiffAlpha
 
 	// Number of nodes to traverse while calculating difficulty.
-	nodesToTraverse := needAjustCount * b.params.WorkDiffWindows
-	percentStatsRecentCount := b.params.WorkDiffWindowSize * b.params.WorkDiffWindows
+	nodesToTraverse := needAjustCount * r.params.WorkDiffWindows
+	percentStatsRecentCount := r.params.WorkDiffWindowSize * r.params.WorkDiffWindows
 	//calc pow block count in last nodesToTraverse blocks
-	currentPowBlockCount := b.calcCurrentPowCount(originCurrentNode, percentStatsRecentCount, powInstance.GetPowType())
+	currentPowBlockCount := r.calcCurrentPowCount(originCurrentNode, percentStatsRecentCount, powInstance.GetPowType())
 
 	// Initialize bigInt slice for the percentage changes for each window period
 	// above or below the target.
-	windowChanges := make([]*big.Int, b.params.WorkDiffWindows)
+	windowChanges := make([]*big.Int, r.params.WorkDiffWindows)
 
 	// Regress through all of the previous blocks and store the percent changes
-	// per window period; use bigInts to emulate 64.32 bit fixed point.
+	// per window period; use bigInts to emulate 64.32 bit fixed point.  The
+	// walk stays at the genesis block, or past the oldest block of the pow,
+	// where the node is nil.
 	var olderTime, windowPeriod int64
 	var weights uint64
 	oldNode := curNode
-	recentTime := curNode.timestamp
+	recentTime := curNode.GetTimestamp()
 	for i := uint64(0); ; i++ {
 		// Store and reset after reaching the end of every window period.
 		if i%uint64(needAjustCount) == 0 && i != 0 {
-			olderTime = oldNode.timestamp
+			olderTime = 0
+			if oldNode != nil {
+				olderTime = oldNode.GetTimestamp()
+			}
 			timeDifference := recentTime - olderTime
 			// Just assume we're at the target (no change) if we've
 			// gone all the way back to the genesis block.
-			if oldNode.order == 0 {
-				timeDifference = int64(b.params.TargetTimespan /
+			if oldNode == nil || oldNode.GetOrder() == 0 {
+				timeDifference = int64(r.params.TargetTimespan /
 					time.Second)
 			}
 			timeDifBig := big.NewInt(timeDifference)
 			timeDifBig.Lsh(timeDifBig, 32) // Add padding
-			targetTemp := big.NewInt(int64(b.params.TargetTimespan /
+			targetTemp := big.NewInt(int64(r.params.TargetTimespan /
 				time.Second))
 			windowAdjusted := targetTemp.Div(timeDifBig, targetTemp)
 
 			// Weight it exponentially. Be aware that this could at some point
 			// overflow if alpha or the number of blocks used is really large.
 			windowAdjusted = windowAdjusted.Lsh(windowAdjusted,
-				uint((b.params.WorkDiffWindows-windowPeriod)*alpha))
+				uint((r.params.WorkDiffWindows-windowPeriod)*alpha))
 
 			// Sum up all the different weights incrementally.
-			weights += 1 << uint64((b.params.WorkDiffWindows-windowPeriod)*
+			weights += 1 << uint64((r.params.WorkDiffWindows-windowPeriod)*
 				alpha)
 
 			// Store it in the slice.
@@ -233,18 +314,15 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 		}
 		// Get the previous node while staying at the genesis block as
 		// needed.
-		if oldNode.parents != nil {
-			oldBlock := b.bd.GetBlockById(oldNode.GetID())
-			oldMainParent := b.bd.GetBlockById(oldBlock.GetMainParent())
-			if oldMainParent != nil {
-				oldNode = b.index.LookupNode(oldMainParent.GetHash())
-				oldNode = b.getPowTypeNode(oldNode, powInstance.GetPowType())
+		if oldNode != nil {
+			if mainParent := oldNode.MainParentNode(); mainParent != nil {
+				oldNode = r.powTypeNode(mainParent, powInstance.GetPowType())
 			}
 		}
 	}
 	// Sum up the weighted window periods.
 	weightedSum := big.NewInt(0)
-	for i := int64(0); i < b.params.WorkDiffWindows; i++ {
+	for i := int64(0); i < r.params.WorkDiffWindows; i++ {
 		weightedSum.Add(weightedSum, windowChanges[i])
 	}
 
@@ -285,8 +363,8 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 	// newTarget since conversion to the compact representation loses
 	// precision.
 	nextDiffBits := pow.BigToCompact(nextDiffBig)
-	log.Debug("Difficulty retarget", "block main height", curBlock.GetHeight()+1)
-	log.Debug("Old target", "bits", fmt.Sprintf("%08x", curNode.bits),
+	log.Debug("Difficulty retarget", "block main height", curNode.GetHeight()+1)
+	log.Debug("Old target", "bits", fmt.Sprintf("%08x", curNode.GetBits()),
 		"diff", fmt.Sprintf("(%064x)", oldDiffBig))
 	log.Debug("New target", "bits", fmt.Sprintf("%08x", nextDiffBits),
 		"diff", fmt.Sprintf("(%064x)", nextDiffBig))
@@ -295,7 +373,7 @@ func (b *BlockChain) calcNextRequiredDifficulty(curNode *blockNode, newBlockTime
 }
 
 // stats current pow count in nodesToTraverse
-func (b *BlockChain) calcCurrentPowCount(curNode *blockNode, nodesToTraverse int64, powType pow.PowType) int64 {
+func (r *Retarget) calcCurrentPowCount(curNode DifficultyNode, nodesToTraverse int64, powType pow.PowType) int64 {
 	// Genesis block.
 	if curNode == nil {
 		return 0
@@ -307,17 +385,13 @@ func (b *BlockChain) calcCurrentPowCount(curNode *blockNode, nodesToTraverse int
 	for i := int64(0); i < nodesToTraverse; i++ {
 		// Get the previous node while staying at the genesis block as
 		// needed.
-		if oldNode.order == 0 {
+		if oldNode.GetOrder() == 0 {
 			currentPowBlockCount--
 		}
-		if oldNode.parents != nil {
-			oldBlock := b.bd.GetBlockById(oldNode.GetID())
-			oldMainParent := b.bd.GetBlockById(oldBlock.GetMainParent())
-			if oldMainParent != nil {
-				oldNode = b.index.LookupNode(oldMainParent.GetHash())
-				if oldNode.order != 0 && oldNode.GetPowType() != powType {
-					currentPowBlockCount--
-				}
+		if mainParent := oldNode.MainParentNode(); mainParent != nil {
+			oldNode = mainParent
+			if oldNode.GetOrder() != 0 && oldNode.GetPowType() != powType {
+				currentPowBlockCount--
 			}
 		}
 	}
@@ -328,51 +402,75 @@ func (b *BlockChain) calcCurrentPowCount(curNode *blockNode, nodesToTraverse int
 // whether need ajust Pow Difficulty
 // recent b.params.WorkDiffWindowSize blocks
 // if current count arrived target block count . need ajustment difficulty
-func (b *BlockChain) needAjustPowDifficulty(curNode *blockNode, powType pow.PowType, needAjustCount int64) bool {
-	countFromLastAdjustment := b.getDistanceFromLastAdjustment(curNode, powType, needAjustCount)
+func (r *Retarget) needAjustPowDifficulty(curNode DifficultyNode, powType pow.PowType, needAjustCount int64) bool {
+	countFromLastAdjustment := r.getDistanceFromLastAdjustment(curNode, powType, needAjustCount)
 	// countFromLastAdjustment stats b.params.WorkDiffWindows Multiple count
-	countFromLastAdjustment /= b.params.WorkDiffWindows
+	countFromLastAdjustment /= r.params.WorkDiffWindows
 	return countFromLastAdjustment > 0 && countFromLastAdjustment%needAjustCount == 0
 }
 
 // Distance block count from last adjustment
-func (b *BlockChain) getDistanceFromLastAdjustment(curNode *blockNode, powType pow.PowType, needAjustCount int64) int64 {
+func (r *Retarget) getDistanceFromLastAdjustment(curNode DifficultyNode, powType pow.PowType, needAjustCount int64) int64 {
 	if curNode == nil {
 		return 0
 	}
 	//calculate
-	oldBits := curNode.bits
+	oldBits := curNode.GetBits()
 	count := int64(0)
-	currentTime := curNode.timestamp
+	currentTime := curNode.GetTimestamp()
 	for {
-		if curNode.pow.GetPowType() == powType {
-			if oldBits != curNode.bits {
+		if curNode.GetPowType() == powType {
+			if oldBits != curNode.GetBits() {
 				return count
 			}
 			count++
 		}
-		if curNode.order == 0 {
+		if curNode.GetOrder() == 0 {
 			//geniess block
 			return count
 		}
 		// if TargetTimespan have only one pow block need ajustment difficulty
 		// or count >= needAjustCount
-		if (count > 1 && currentTime-curNode.timestamp > (count-1)*int64(b.params.TargetTimespan/time.Second)) ||
+		if (count > 1 && currentTime-curNode.GetTimestamp() > (count-1)*int64(r.params.TargetTimespan/time.Second)) ||
 			count >= needAjustCount {
-			return needAjustCount * b.params.WorkDiffWindows
+			return needAjustCount * r.params.WorkDiffWindows
 		}
-		if curNode.parents == nil {
+
+		mainParent := curNode.MainParentNode()
+		if mainParent == nil {
 			return count
 		}
+		curNode = mainParent
+	}
+}
 
-		oldBlock := b.bd.GetBlockById(curNode.GetID())
-		oldMainParent := b.bd.GetBlockById(oldBlock.GetMainParent())
-		if oldMainParent != nil {
-			curNode = b.index.LookupNode(oldMainParent.GetHash())
-		} else {
-			return count
+// powTypeNode returns the latest block of the proof of work on the main chain
+// ending at the node, nil if there is none.
+func (r *Retarget) powTypeNode(curNode DifficultyNode, powType pow.PowType) DifficultyNode {
+	for curNode != nil {
+		if curNode.GetPowType() == powType {
+			return curNode
 		}
+		curNode = curNode.MainParentNode()
 	}
+	return nil
+}
+
+// PastMedianTime calculates the median time of the previous few blocks on the
+// main chain prior to, and including, the block node.
+func PastMedianTime(node DifficultyNode) time.Time {
+	timestamps := make([]int64, 0, medianTimeBlocks)
+	for iterNode := node; iterNode != nil && len(timestamps) < medianTimeBlocks; iterNode = iterNode.MainParentNode() {
+		timestamps = append(timestamps, iterNode.GetTimestamp())
+	}
+	if len(timestamps) == 0 {
+		return time.Unix(0, 0)
+	}
+	sort.Sort(util.TimeSorter(timestamps))
+
+	// The median of an even number of timestamps is the upper middle one,
+	// as in CalcPastMedianTime.
+	return time.Unix(timestamps[len(timestamps)/2], 0)
 }
 
 // CalcNextRequiredDiffFromNode calculates the required difficulty for the block
@@ -407,31 +505,6 @@ func (b *BlockChain) CalcNextRequiredDifficulty(timestamp time.Time, powType pow
 	return difficulty, err
 }
 
-// find block node by pow type
-func (b *BlockChain) getPowTypeNode(curNode *blockNode, powType pow.PowType) *blockNode {
-	for {
-		if curNode.pow.GetPowType() == powType {
-			return curNode
-		}
-
-		if curNode.parents == nil {
-			return &blockNode{
-				pow: pow.GetInstance(powType, 0, []byte{}),
-			}
-		}
-
-		oldBlock := b.bd.GetBlockById(curNode.GetID())
-		oldMainParent := b.bd.GetBlockById(oldBlock.GetMainParent())
-		if oldMainParent != nil {
-			curNode = b.index.LookupNode(oldMainParent.GetHash())
-		} else {
-			return &blockNode{
-				pow: pow.GetInstance(powType, 0, []byte{}),
-			}
-		}
-	}
-}
-
 // find block node by pow type
 func (b *BlockChain) GetCurrentPowDiff(curNode blockNode, powType pow.PowType) *big.Int {
 	instance := pow.GetInstance(powType, 0, []byte{})
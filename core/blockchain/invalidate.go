@@ -0,0 +1,284 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/types"
+)
+
+// hasInvalidatedParent returns whether one of the parents was invalidated by
+// the operator, or has an ancestor which was, so that a block built on them is
+// invalidated too.
+func hasInvalidatedParent(parents []*blockNode) bool {
+	for _, parent := range parents {
+		if parent.GetStatus().Invalidated() {
+			return true
+		}
+	}
+	return false
+}
+
+// InvalidateBlock marks a block and its future set invalidated on behalf of
+// the operator.  The blocks are kept in the DAG but left out of its order, so
+// they are not on the main chain, their transactions are removed from the
+// utxo set and the new blocks are mined on the tips of the DAG without them.
+// The decision is stored with the status of the blocks and the order so it
+// persists across restarts.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) InvalidateBlock(blockHash *hash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node := b.index.LookupNode(blockHash)
+	if node == nil {
+		return fmt.Errorf("block %v is not known", blockHash)
+	}
+	if node.GetID() == 0 {
+		return fmt.Errorf("the genesis block can't be invalidated")
+	}
+	if node.GetStatus()&statusInvalidated != 0 {
+		return nil
+	}
+
+	status := map[*blockNode]BlockStatus{
+		node: node.GetStatus() | statusInvalidated,
+	}
+	for _, h := range b.bd.GetFutureSet(blockHash) {
+		n := b.index.LookupNode(h)
+		if n == nil {
+			return AssertError(fmt.Sprintf("no block node for %v", h))
+		}
+		status[n] = n.GetStatus() | statusInvalidatedAncestor
+	}
+	err := b.updateInvalidated(status)
+	if err != nil {
+		return err
+	}
+	log.Info("Invalidated block", "hash", blockHash, "order", node.order,
+		"futureset", len(status)-1)
+	return nil
+}
+
+// ReconsiderBlock undoes the invalidation of a block by the operator, along
+// with the invalidations of its ancestors and of its future set.  The blocks
+// which are not in the future set of any block still invalidated are then
+// connected again with their transactions.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ReconsiderBlock(blockHash *hash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node := b.index.LookupNode(blockHash)
+	if node == nil {
+		return fmt.Errorf("block %v is not known", blockHash)
+	}
+
+	// Find the invalidated blocks among the block, its ancestors leading
+	// to an invalidated block, and its future set.
+	roots := map[*blockNode]struct{}{}
+	visited := map[*blockNode]struct{}{}
+	stack := []*blockNode{node}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[n]; ok {
+			continue
+		}
+		visited[n] = struct{}{}
+		if n.GetStatus()&statusInvalidated != 0 {
+			roots[n] = struct{}{}
+		}
+		if n.GetStatus()&statusInvalidatedAncestor != 0 {
+			stack = append(stack, n.parents...)
+		}
+	}
+	for _, h := range b.bd.GetFutureSet(blockHash) {
+		n := b.index.LookupNode(h)
+		if n != nil && n.GetStatus()&statusInvalidated != 0 {
+			roots[n] = struct{}{}
+		}
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+
+	// The blocks of the future sets of the invalidated blocks stay
+	// invalidated when built on another invalidated block, which is found
+	// by going through them after their parents.
+	region := BlockNodeList{}
+	inRegion := map[*blockNode]struct{}{}
+	for root := range roots {
+		for _, n := range append([]*blockNode{root}, b.lookupFutureSet(root)...) {
+			if _, ok := inRegion[n]; !ok {
+				inRegion[n] = struct{}{}
+				region = append(region, n)
+			}
+		}
+	}
+	sort.Slice(region, func(i, j int) bool {
+		return region[i].GetID() < region[j].GetID()
+	})
+	status := map[*blockNode]BlockStatus{}
+	statusOf := func(n *blockNode) BlockStatus {
+		if s, ok := status[n]; ok {
+			return s
+		}
+		return n.GetStatus()
+	}
+	for _, n := range region {
+		s := n.GetStatus() &^ statusInvalidatedAncestor
+		if _, ok := roots[n]; ok {
+			s &^= statusInvalidated
+		}
+		for _, parent := range n.parents {
+			if statusOf(parent).Invalidated() {
+				s |= statusInvalidatedAncestor
+				break
+			}
+		}
+		status[n] = s
+	}
+	err := b.updateInvalidated(status)
+	if err != nil {
+		return err
+	}
+	log.Info("Reconsidered block", "hash", blockHash, "invalidated",
+		len(roots))
+	return nil
+}
+
+// lookupFutureSet returns the block nodes of the future set of a block.
+func (b *BlockChain) lookupFutureSet(node *blockNode) []*blockNode {
+	futureSet := b.bd.GetFutureSet(node.GetHash())
+	nodes := make([]*blockNode, 0, len(futureSet))
+	for _, h := range futureSet {
+		if n := b.index.LookupNode(h); n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// updateInvalidated changes the status of the blocks invalidated by the
+// operator.  The DAG orders again the blocks without the invalidated ones and
+// stores the new order along with the new status of the blocks in one
+// transaction, then the blocks whose order changed are disconnected with
+// their old order and connected with the new one.  When that fails the utxo
+// set is built again from the stored order.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) updateInvalidated(status map[*blockNode]BlockStatus) error {
+	var exclude, include, changed []*hash.Hash
+	oldStatus := map[*blockNode]BlockStatus{}
+	for n, s := range status {
+		if s == n.GetStatus() {
+			continue
+		}
+		if b.isAssumedUtxo(n.order) {
+			return fmt.Errorf("the blocks before the utxo snapshot can't be " +
+				"reconnected until it is validated")
+		}
+		if s.Invalidated() != n.GetStatus().Invalidated() {
+			if s.Invalidated() {
+				exclude = append(exclude, n.GetHash())
+			} else {
+				include = append(include, n.GetHash())
+			}
+		}
+		changed = append(changed, n.GetHash())
+		oldStatus[n] = n.GetStatus()
+	}
+	if len(oldStatus) == 0 {
+		return nil
+	}
+	setStatus := func(status map[*blockNode]BlockStatus) {
+		for n, s := range status {
+			n.UnsetStatusFlags(statusInvalidated | statusInvalidatedAncestor)
+			n.SetStatusFlags(s & (statusInvalidated | statusInvalidatedAncestor))
+			b.bd.GetBlock(n.GetHash()).SetStatus(blockdag.BlockStatus(n.GetStatus()))
+		}
+	}
+
+	err := b.flushUtxoCache(utxoStateReorganizing)
+	if err != nil {
+		return err
+	}
+	// The DAG stores the new status of the blocks with the new order.
+	setStatus(status)
+	oldOrders, newOrders, err := b.bd.UpdateExcluded(exclude, include, changed)
+	if err != nil {
+		setStatus(oldStatus)
+		if flushErr := b.flushUtxoCache(utxoStateConsistent); flushErr != nil {
+			return fmt.Errorf("%v, then failed to flush the utxo cache: %v",
+				err, flushErr)
+		}
+		return err
+	}
+	if len(oldOrders) > 0 && b.isAssumedUtxo(b.index.LookupNode(oldOrders[0]).order) {
+		setStatus(oldStatus)
+		_, _, err = b.bd.UpdateExcluded(include, exclude, changed)
+		if err != nil {
+			return err
+		}
+		err = b.flushUtxoCache(utxoStateConsistent)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("the blocks before the utxo snapshot can't be " +
+			"reconnected until it is validated")
+	}
+
+	var mainBlock *types.SerializedBlock
+	reconnect := func() error {
+		detachNodes := BlockNodeList{}
+		for _, h := range oldOrders {
+			n := b.index.LookupNode(h)
+			if n == nil {
+				return AssertError(fmt.Sprintf("no block node for %v", h))
+			}
+			detachNodes = append(detachNodes, n.Clone())
+			n.SetOrder(uint64(b.bd.GetBlock(h).GetOrder()))
+		}
+		for e := newOrders.Front(); e != nil; e = e.Next() {
+			ib := e.Value.(blockdag.IBlock)
+			n := b.index.LookupNode(ib.GetHash())
+			if n == nil {
+				return AssertError(fmt.Sprintf("no block node for %v", ib.GetHash()))
+			}
+			n.SetOrder(uint64(ib.GetOrder()))
+		}
+		mainTip := b.index.LookupNode(b.bd.GetMainChainTip().GetHash())
+		mainBlock, err = b.fetchBlockByHash(&mainTip.hash)
+		if err != nil {
+			return err
+		}
+		mainBlock.SetOrder(mainTip.order)
+		return b.reorganizeChain(detachNodes, newOrders, mainBlock)
+	}
+	err = reconnect()
+	if err != nil {
+		// The new order and status are stored, so the utxo set is
+		// built again from them.
+		log.Warn("The utxo set is inconsistent with the invalidated blocks, "+
+			"rebuilding it", "error", err)
+		rerr := b.rebuildUtxoSet(b.lastBlockOrder())
+		if rerr != nil {
+			return fmt.Errorf("%v, the utxo set can't be rebuilt: %v", err, rerr)
+		}
+		return err
+	}
+	err = b.flushUtxoCache(utxoStateConsistent)
+	if err != nil {
+		return err
+	}
+	return b.putBestState(mainBlock, b.BestSnapshot().TotalTxns)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+)
+
+// TestInvalidatedStatus ensures the blocks invalidated by the operator are
+// told apart from the blocks which failed validation, and that the blocks
+// built on them are invalidated too.
+func TestInvalidatedStatus(t *testing.T) {
+	tests := []struct {
+		status       BlockStatus
+		knownInvalid bool
+		invalidated  bool
+	}{
+		{statusDataStored | statusValid, false, false},
+		{statusDataStored | statusInvalid, true, false},
+		{statusDataStored | statusInvalidated, false, true},
+		{statusDataStored | statusInvalidatedAncestor, false, true},
+		{statusDataStored | statusInvalid | statusInvalidated, true, true},
+	}
+	for i, test := range tests {
+		if test.status.KnownInvalid() != test.knownInvalid {
+			t.Errorf("%d: KnownInvalid %v, want %v", i,
+				test.status.KnownInvalid(), test.knownInvalid)
+		}
+		if test.status.Invalidated() != test.invalidated {
+			t.Errorf("%d: Invalidated %v, want %v", i,
+				test.status.Invalidated(), test.invalidated)
+		}
+	}
+
+	_, nodes := newVBTestChain(t, nil, []uint32{12, 12, 12})
+	if hasInvalidatedParent(nodes[2].parents) {
+		t.Fatalf("the parent of the block is not invalidated")
+	}
+	nodes[0].SetStatusFlags(statusInvalid)
+	if hasInvalidatedParent(nodes[1].parents) {
+		t.Fatalf("an invalid parent is taken for an invalidated one")
+	}
+	nodes[1].SetStatusFlags(statusInvalidatedAncestor)
+	if !hasInvalidatedParent(nodes[2].parents) {
+		t.Fatalf("the block on an invalidated ancestor is not invalidated")
+	}
+}
+
+// TestInvalidateBlockErrors ensures the unknown blocks and the genesis can't
+// be invalidated.
+func TestInvalidateBlockErrors(t *testing.T) {
+	b, nodes := newVBTestChain(t, nil, []uint32{12, 12})
+	if err := b.InvalidateBlock(&hash.Hash{0x01}); err == nil {
+		t.Fatalf("an unknown block was invalidated")
+	}
+	if err := b.InvalidateBlock(&nodes[0].hash); err == nil {
+		t.Fatalf("the genesis was invalidated")
+	}
+	if err := b.ReconsiderBlock(&hash.Hash{0x01}); err == nil {
+		t.Fatalf("an unknown block was reconsidered")
+	}
+	// Nothing changes for a block which is not invalidated.
+	if err := b.ReconsiderBlock(&nodes[1].hash); err != nil {
+		t.Fatalf("ReconsiderBlock: %v", err)
+	}
+}
@@ -98,12 +98,26 @@ type Notification struct {
 	Data interface{}
 }
 
+// Subscribe registers an additional callback which receives the same chain
+// notifications as the callback provided in the call to New.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) Subscribe(callback NotificationCallback) {
+	b.subscribersLock.Lock()
+	b.subscribers = append(b.subscribers, callback)
+	b.subscribersLock.Unlock()
+}
+
 // sendNotification sends a notification with the passed type and data if the
 // caller requested notifications by providing a callback function in the call
-// to New.
+// to New or by subscribing.
 func (b *BlockChain) sendNotification(typ NotificationType, data interface{}) {
+	b.subscribersLock.RLock()
+	subscribers := b.subscribers
+	b.subscribersLock.RUnlock()
+
 	// Ignore it if the caller didn't request notifications.
-	if b.notifications == nil {
+	if b.notifications == nil && len(subscribers) == 0 {
 		return
 	}
 
@@ -111,6 +125,11 @@ func (b *BlockChain) sendNotification(typ NotificationType, data interface{}) {
 	n := Notification{Type: typ, Data: data}
 	log.Trace("send blkmgr notification", "type", n.Type, "data", n.Data)
 	b.ChainUnlock()
-	b.notifications(&n)
+	if b.notifications != nil {
+		b.notifications(&n)
+	}
+	for _, callback := range subscribers {
+		callback(&n)
+	}
 	b.ChainLock()
 }
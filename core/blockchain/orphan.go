@@ -84,6 +84,25 @@ func (b *BlockChain) GetRecentOrphanParents(h *hash.Hash) []*hash.Hash {
 	return result.List()
 }
 
+// GetOrphanChildren returns the orphan blocks which have the block of the
+// provided hash as parent.
+func (b *BlockChain) GetOrphanChildren(h *hash.Hash) []*hash.Hash {
+	b.orphanLock.RLock()
+	defer b.orphanLock.RUnlock()
+
+	result := []*hash.Hash{}
+	for k, v := range b.orphans {
+		for _, parent := range v.block.Block().Parents {
+			if parent.IsEqual(h) {
+				orphanHash := k
+				result = append(result, &orphanHash)
+				break
+			}
+		}
+	}
+	return result
+}
+
 // Get the total of all orphans
 func (b *BlockChain) GetOrphansTotal() int {
 	b.orphanLock.RLock()
@@ -0,0 +1,298 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+)
+
+const (
+	// cuckooGraphFactor is the number of graphs searched on average to
+	// find a cycle, since the probability to find one in a graph is about
+	// 2.2%.
+	cuckooGraphFactor = 50
+
+	// maxPowStatsWindowFactor bounds the window of the statistics of the
+	// proofs of work to a multiple of the default window, since it is
+	// walked with the chain lock held.
+	maxPowStatsWindowFactor = 4
+)
+
+// graphPow is implemented by the cuckoo proofs of work, whose difficulty is
+// scaled by the weight of the graph.
+type graphPow interface {
+	GraphWeight() uint64
+}
+
+// PowDifficulty houses the difficulty of a proof of work at the tip of the
+// main chain.
+type PowDifficulty struct {
+	PowType pow.PowType
+
+	// Bits is the difficulty of the latest main chain block of the proof of
+	// work, or its limit if there is none, and NextBits the difficulty
+	// required of the next block.  They are targets, except for the cuckoo
+	// proofs of work, whose difficulty is the smallest cycle difficulty.
+	Bits     uint32
+	NextBits uint32
+
+	// Difficulty and NextDifficulty are the difficulties relative to the
+	// limit of the proof of work.
+	Difficulty     float64
+	NextDifficulty float64
+
+	// TargetPercent is the share of the blocks aimed at by the difficulty
+	// adjustment, and Available whether the next block may use the proof of
+	// work.
+	TargetPercent int
+	Available     bool
+}
+
+// PowStats houses the statistics of a proof of work over the recent blocks of
+// the main chain.
+type PowStats struct {
+	PowType pow.PowType
+
+	// Blocks is the number of blocks of the proof of work, and Percent
+	// their share of the blocks.
+	Blocks  int64
+	Percent float64
+
+	// TargetPercent is the share of the blocks aimed at by the difficulty
+	// adjustment at the next main height.
+	TargetPercent int
+
+	// Work is the expected number of hashes, or of graphs for the cuckoo
+	// proofs of work, needed to find the blocks, and HashRate the number
+	// per second over the window.  Graphs is whether they count graphs.
+	Work     *big.Int
+	HashRate float64
+	Graphs   bool
+}
+
+// PowDistribution houses the statistics of the proofs of work over the recent
+// blocks of the main chain.
+type PowDistribution struct {
+	// Window is the number of main chain blocks requested, up to the
+	// largest window, and Blocks the number of them found, excluding the
+	// genesis block.
+	Window int64
+	Blocks int64
+
+	// Timespan is the number of seconds between the main parent of the
+	// oldest block and the newest block, which spans the intervals of all
+	// the blocks.
+	Timespan int64
+
+	// Pows has the statistics of each proof of work, by pow type.
+	Pows []*PowStats
+}
+
+// DefaultPowStatsWindow returns the number of main chain blocks the difficulty
+// adjustment counts the blocks of each proof of work in.
+func (b *BlockChain) DefaultPowStatsWindow() int64 {
+	return b.params.WorkDiffWindowSize * b.params.WorkDiffWindows
+}
+
+// MaxPowStatsWindow returns the largest number of main chain blocks the
+// statistics of the proofs of work are computed over.
+func (b *BlockChain) MaxPowStatsWindow() int64 {
+	return b.DefaultPowStatsWindow() * maxPowStatsWindowFactor
+}
+
+// newPowInstance returns a proof of work instance at the main height.
+func (b *BlockChain) newPowInstance(powType pow.PowType, mainHeight int64) pow.IPow {
+	instance := pow.GetInstance(powType, 0, []byte{})
+	instance.SetParams(b.params.PowConfig)
+	instance.SetMainHeight(mainHeight)
+	return instance
+}
+
+// powRatio returns the difficulty of the bits relative to the limit of the
+// proof of work.
+func powRatio(instance pow.IPow, bits uint32) float64 {
+	limit := new(big.Float).SetInt(instance.GetSafeDiff(0))
+	diff := new(big.Float).SetInt(pow.CompactToBig(bits))
+	if diff.Sign() <= 0 || limit.Sign() <= 0 {
+		return 0
+	}
+	var ratio float64
+	if _, ok := instance.(graphPow); ok {
+		ratio, _ = new(big.Float).Quo(diff, limit).Float64()
+	} else {
+		ratio, _ = new(big.Float).Quo(limit, diff).Float64()
+	}
+	return ratio
+}
+
+// targetPercent returns the share of the blocks of the proof of work aimed at
+// by the difficulty adjustment.
+func targetPercent(instance pow.IPow) int {
+	return int(new(big.Int).Rsh(instance.PowPercent(), 32).Int64())
+}
+
+// CalcPowDifficulty returns the current difficulty of the proof of work, and
+// the difficulty required of a block after the main chain tip with the
+// timestamp.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcPowDifficulty(powType pow.PowType, timestamp time.Time) (*PowDifficulty, error) {
+	if _, ok := pow.PowMapString[powType]; !ok {
+		return nil, fmt.Errorf("unknown pow type %d", powType)
+	}
+
+	b.ChainRLock()
+	defer b.ChainRUnlock()
+
+	node := b.index.LookupNode(b.bd.GetMainChainTip().GetHash())
+	if node == nil {
+		return nil, fmt.Errorf("the main chain tip is not known")
+	}
+	instance := b.newPowInstance(powType, int64(node.height+1))
+	nextBits, err := b.calcNextRequiredDifficulty(node, timestamp, instance)
+	if err != nil {
+		return nil, err
+	}
+	bits := pow.BigToCompact(b.GetCurrentPowDiff(*node, powType))
+	return &PowDifficulty{
+		PowType:        powType,
+		Bits:           bits,
+		NextBits:       nextBits,
+		Difficulty:     powRatio(instance, bits),
+		NextDifficulty: powRatio(instance, nextBits),
+		TargetPercent:  targetPercent(instance),
+		Available:      instance.CheckAvailable(),
+	}, nil
+}
+
+// calcBlockWork returns the expected number of hashes, or of graphs for the
+// cuckoo proofs of work, needed to find the block of the node.
+func (b *BlockChain) calcBlockWork(node *blockNode) *big.Int {
+	powType := node.pow.GetPowType()
+	instance := b.newPowInstance(powType, int64(node.height))
+	diff := pow.CompactToBig(node.bits)
+	if diff.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	if _, ok := instance.(graphPow); !ok {
+		// 2^256 / (target + 1)
+		return new(big.Int).Div(pow.OneLsh256, diff.Add(diff, big.NewInt(1)))
+	}
+
+	// The graph weight depends on the edge bits of the proof.
+	proofData, err := hex.DecodeString(node.pow.GetProofData())
+	if err == nil {
+		instance.SetProofData(proofData)
+	}
+	scale := instance.(graphPow).GraphWeight()
+	if scale == 0 {
+		scale = 1
+	}
+	work := diff.Mul(diff, big.NewInt(cuckooGraphFactor))
+	return work.Div(work, new(big.Int).SetUint64(scale))
+}
+
+// CalcPowDistribution returns the statistics of the proofs of work over the
+// window of blocks ending at the main chain tip.  The window is reduced to
+// MaxPowStatsWindow when it is larger.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcPowDistribution(window int64) (*PowDistribution, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("the window %d is not positive", window)
+	}
+	if max := b.MaxPowStatsWindow(); window > max {
+		window = max
+	}
+
+	b.ChainRLock()
+	defer b.ChainRUnlock()
+
+	node := b.index.LookupNode(b.bd.GetMainChainTip().GetHash())
+	if node == nil {
+		return nil, fmt.Errorf("the main chain tip is not known")
+	}
+	nextHeight := int64(node.height + 1)
+	stats := make(map[pow.PowType]*PowStats, len(pow.PowMapString))
+	for powType := range pow.PowMapString {
+		instance := b.newPowInstance(powType, nextHeight)
+		_, graphs := instance.(graphPow)
+		stats[powType] = &PowStats{
+			PowType:       powType,
+			TargetPercent: targetPercent(instance),
+			Work:          big.NewInt(0),
+			Graphs:        graphs,
+		}
+	}
+
+	dist := &PowDistribution{Window: window}
+	newest, oldest := node.timestamp, node.timestamp
+	for i := int64(0); i < window && node != nil && node.order != 0; i++ {
+		oldest = node.timestamp
+		dist.Blocks++
+		if s, ok := stats[node.pow.GetPowType()]; ok {
+			s.Blocks++
+			s.Work.Add(s.Work, b.calcBlockWork(node))
+		}
+
+		block := b.bd.GetBlockById(node.GetID())
+		if block == nil {
+			break
+		}
+		mainParent := b.bd.GetBlockById(block.GetMainParent())
+		if mainParent == nil {
+			break
+		}
+		node = b.index.LookupNode(mainParent.GetHash())
+
+		// The work of the oldest block starts at its main parent.
+		if node != nil {
+			oldest = node.timestamp
+		}
+	}
+	dist.Timespan = newest - oldest
+
+	for _, s := range stats {
+		if dist.Blocks > 0 {
+			s.Percent = float64(s.Blocks) * 100 / float64(dist.Blocks)
+		}
+		if dist.Timespan > 0 {
+			work, _ := new(big.Float).SetInt(s.Work).Float64()
+			s.HashRate = work / float64(dist.Timespan)
+		}
+		dist.Pows = append(dist.Pows, s)
+	}
+	sort.Slice(dist.Pows, func(i, j int) bool {
+		return dist.Pows[i].PowType < dist.Pows[j].PowType
+	})
+	return dist, nil
+}
+
+// CalcPowStats returns the statistics of the proof of work over the window of
+// blocks ending at the main chain tip, along with the distribution they come
+// from, which has the window and the number of seconds it spans.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcPowStats(powType pow.PowType, window int64) (*PowStats, *PowDistribution, error) {
+	if _, ok := pow.PowMapString[powType]; !ok {
+		return nil, nil, fmt.Errorf("unknown pow type %d", powType)
+	}
+	dist, err := b.CalcPowDistribution(window)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range dist.Pows {
+		if s.PowType == powType {
+			return s, dist, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown pow type %d", powType)
+}
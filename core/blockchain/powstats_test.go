@@ -0,0 +1,69 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/params"
+)
+
+func TestPowRatio(t *testing.T) {
+	tests := []struct {
+		powType pow.PowType
+		// scale multiplies the limit of the proof of work.
+		scale *big.Rat
+		want  float64
+	}{
+		{powType: pow.BLAKE2BD, scale: big.NewRat(1, 1), want: 1},
+		{powType: pow.BLAKE2BD, scale: big.NewRat(1, 4), want: 4},
+		{powType: pow.BITCOINPAYKECCAK256, scale: big.NewRat(1, 16), want: 16},
+		{powType: pow.CUCKAROO, scale: big.NewRat(1, 1), want: 1},
+		{powType: pow.CUCKAROO, scale: big.NewRat(8, 1), want: 8},
+	}
+	for i, test := range tests {
+		instance := pow.GetInstance(test.powType, 0, []byte{})
+		instance.SetParams(params.PrivNetParams.PowConfig)
+		limit := instance.GetSafeDiff(0)
+		bits := new(big.Int).Mul(limit, test.scale.Num())
+		bits.Div(bits, test.scale.Denom())
+
+		got := powRatio(instance, pow.BigToCompact(bits))
+		if got < test.want*0.999 || got > test.want*1.001 {
+			t.Errorf("test %d: ratio %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+// TestCalcPowDistribution ensures the timespan covers an interval for each
+// block of the window, and that the window is bounded.
+func TestCalcPowDistribution(t *testing.T) {
+	versions := make([]uint32, 20)
+	b, _ := newVBTestChain(t, nil, versions)
+	b.params.WorkDiffWindowSize = 2
+	b.params.WorkDiffWindows = 2
+
+	dist, err := b.CalcPowDistribution(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dist.Window != 3 || dist.Blocks != 3 {
+		t.Fatalf("window %d with %d blocks, want 3", dist.Window, dist.Blocks)
+	}
+	if dist.Timespan != 3*vbTestBlockTime {
+		t.Fatalf("timespan %d, want %d", dist.Timespan, 3*vbTestBlockTime)
+	}
+
+	dist, err = b.CalcPowDistribution(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max := b.MaxPowStatsWindow(); dist.Window != max || dist.Blocks != max {
+		t.Fatalf("window %d with %d blocks, want %d", dist.Window,
+			dist.Blocks, max)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/blockdag/anticone"
+)
+
+const (
+	// DefaultRiskAlpha is the relative computational power of the attacker
+	// assumed when computing the risk of a block.
+	DefaultRiskAlpha = 0.1
+
+	// riskStates is the number of states of the Markov chain of the blocks
+	// withheld by the attacker.
+	riskStates = 100
+
+	// maxRiskAntiPast is the most blocks walked in the future sets of a
+	// block and of its anticone.  The risk of a block with a larger
+	// anti-past is negligible, and the bound keeps the walk from spanning
+	// the DAG for the old blocks.
+	maxRiskAntiPast = 10 * riskStates
+)
+
+// BlockRisk houses the risk that a block is reversed by an attacker.
+type BlockRisk struct {
+	Hash  hash.Hash
+	Alpha float64
+
+	// AntiPast is the smallest size of the future sets of the block and of
+	// the blocks in its anticone, and Anticone the size of the anticone.
+	// The anticone is not walked once the anti-past reaches its bound.
+	AntiPast int
+	Anticone int
+
+	// WaitingTime is the number of seconds elapsed since the timestamp of
+	// the block.
+	WaitingTime uint
+
+	// Risk is the probability that the block is reversed, in the SPECTRE
+	// model.
+	Risk float64
+}
+
+// CalcBlockRisk returns the risk that the block of the hash is reversed by an
+// attacker with the relative computational power alpha, given the anticone of
+// the block observed by the node and the time elapsed since it was mined.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcBlockRisk(blockHash *hash.Hash, alpha float64) (*BlockRisk, error) {
+	if alpha <= 0 || alpha >= 0.5 {
+		return nil, fmt.Errorf("the attacker power %v is not between 0 "+
+			"and 0.5", alpha)
+	}
+	node := b.index.LookupNode(blockHash)
+	if node == nil {
+		return nil, fmt.Errorf("block %v is not known", blockHash)
+	}
+	antiPast, anticoneSize, err := b.bd.GetAntiPast(blockHash, maxRiskAntiPast)
+	if err != nil {
+		return nil, err
+	}
+	risk := &BlockRisk{
+		Hash:     *blockHash,
+		Alpha:    alpha,
+		AntiPast: antiPast,
+		Anticone: anticoneSize,
+		Risk:     1,
+	}
+	elapsed := b.timeSource.AdjustedTime().Unix() - node.timestamp
+	if elapsed > 0 {
+		risk.WaitingTime = uint(elapsed)
+	}
+
+	// The transactions of an invalid block are not applied, and a block
+	// without future set is not confirmed at all.
+	if b.index.NodeStatus(node).KnownInvalid() || antiPast == 0 {
+		return risk, nil
+	}
+	risk.Risk, err = blockdag.GetRisk(riskStates, alpha, b.riskBlockRate(),
+		b.riskBlockDelay(), risk.WaitingTime, antiPast)
+	if err != nil {
+		return nil, err
+	}
+	return risk, nil
+}
+
+// riskBlockRate returns the number of blocks per second of the network.
+func (b *BlockChain) riskBlockRate() float64 {
+	if b.params.BlockRate > 0 {
+		return b.params.BlockRate
+	}
+	return 1.0 / float64(b.params.TargetTimePerBlock/time.Second)
+}
+
+// riskBlockDelay returns the upper bound in seconds of the delay for a block to
+// propagate in the network.
+func (b *BlockChain) riskBlockDelay() float64 {
+	if b.params.BlockDelay > 0 {
+		return b.params.BlockDelay
+	}
+	return anticone.BlockDelay
+}
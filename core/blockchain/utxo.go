@@ -219,7 +219,7 @@ func (view *UtxoViewpoint) SetViewpoints(views []*hash.Hash) {
 // Upon completion of this function, the view will contain an entry for each
 // requested transaction.  Fully spent transactions, or those which otherwise
 // don't exist, will result in a nil entry in the view.
-func (view *UtxoViewpoint) fetchUtxosMain(db database.DB, outpoints map[types.TxOutPoint]struct{}) error {
+func (view *UtxoViewpoint) fetchUtxosMain(cache *utxoCache, outpoints map[types.TxOutPoint]struct{}) error {
 	// Nothing to do if there are no requested hashes.
 	if len(outpoints) == 0 {
 		return nil
@@ -233,20 +233,7 @@ func (view *UtxoViewpoint) fetchUtxosMain(db database.DB, outpoints map[types.Tx
 	// since other code uses the presence of an entry in the store as a way
 	// to optimize spend and unspend updates to apply only to the specific
 	// utxos that the caller needs access to.
-	return db.View(func(dbTx database.Tx) error {
-		for outpoint := range outpoints {
-			entry, err := dbFetchUtxoEntry(dbTx, outpoint)
-			if err != nil {
-				return err
-			}
-			if entry == nil {
-				continue
-			}
-			view.entries[outpoint] = entry
-		}
-
-		return nil
-	})
+	return cache.fetchEntries(view, outpoints)
 }
 
 func (view *UtxoViewpoint) FilterInvalidOut(bc *BlockChain) {
@@ -271,8 +258,8 @@ func (view *UtxoViewpoint) LookupEntry(outpoint types.TxOutPoint) *UtxoEntry {
 	return entry
 }
 
-func (view *UtxoViewpoint) FetchInputUtxos(db database.DB, block *types.SerializedBlock, bc *BlockChain) error {
-	return view.fetchInputUtxos(db, block, bc)
+func (view *UtxoViewpoint) FetchInputUtxos(block *types.SerializedBlock, bc *BlockChain) error {
+	return view.fetchInputUtxos(block, bc)
 }
 
 // fetchInputUtxos loads utxo details about the input transactions referenced
@@ -280,7 +267,7 @@ func (view *UtxoViewpoint) FetchInputUtxos(db database.DB, block *types.Serializ
 // needed.  In particular, referenced entries that are earlier in the block are
 // added to the view and entries that are already in the view are not modified.
 // TODO, revisit the usage on the parent block
-func (view *UtxoViewpoint) fetchInputUtxos(db database.DB, block *types.SerializedBlock, bc *BlockChain) error {
+func (view *UtxoViewpoint) fetchInputUtxos(block *types.SerializedBlock, bc *BlockChain) error {
 	// Build a map of in-flight transactions because some of the inputs in
 	// this block could be referencing other transactions earlier in this
 	// block which are not yet in the chain.
@@ -331,7 +318,7 @@ func (view *UtxoViewpoint) fetchInputUtxos(db database.DB, block *types.Serializ
 			txNeededSet[txIn.PreviousOut] = struct{}{}
 		}
 	}
-	err := view.fetchUtxosMain(db, txNeededSet)
+	err := view.fetchUtxosMain(bc.utxoCache, txNeededSet)
 	if err != nil {
 		return err
 	}
@@ -344,7 +331,7 @@ func (view *UtxoViewpoint) fetchInputUtxos(db database.DB, block *types.Serializ
 // fetchUtxos loads the unspent transaction outputs for the provided set of
 // outputs into the view from the database as needed unless they already exist
 // in the view in which case they are ignored.
-func (view *UtxoViewpoint) fetchUtxos(db database.DB, outpoints map[types.TxOutPoint]struct{}) error {
+func (view *UtxoViewpoint) fetchUtxos(cache *utxoCache, outpoints map[types.TxOutPoint]struct{}) error {
 	// Nothing to do if there are no requested outputs.
 	if len(outpoints) == 0 {
 		return nil
@@ -362,7 +349,7 @@ func (view *UtxoViewpoint) fetchUtxos(db database.DB, outpoints map[types.TxOutP
 	}
 
 	// Request the input utxos from the database.
-	return view.fetchUtxosMain(db, neededSet)
+	return view.fetchUtxosMain(cache, neededSet)
 }
 
 // connectTransaction updates the view by adding all new utxos created by the
@@ -559,7 +546,7 @@ func (b *BlockChain) FetchUtxoView(tx *types.Tx) (*UtxoViewpoint, error) {
 	view := NewUtxoViewpoint()
 	view.SetViewpoints(b.GetMiningTips())
 	b.ChainRLock()
-	err := view.fetchUtxosMain(b.db, neededSet)
+	err := view.fetchUtxosMain(b.utxoCache, neededSet)
 	b.ChainRUnlock()
 	if err != nil {
 		return view, err
@@ -582,12 +569,7 @@ func (b *BlockChain) FetchUtxoEntry(outpoint types.TxOutPoint) (*UtxoEntry, erro
 	b.ChainRLock()
 	defer b.ChainRUnlock()
 
-	var entry *UtxoEntry
-	err := b.db.View(func(dbTx database.Tx) error {
-		var err error
-		entry, err = dbFetchUtxoEntry(dbTx, outpoint)
-		return err
-	})
+	entry, err := b.utxoCache.fetchEntry(outpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -603,10 +585,16 @@ func (b *BlockChain) FetchUtxoEntry(outpoint types.TxOutPoint) (*UtxoEntry, erro
 // When there is no entry for the provided hash, nil will be returned for the
 // both the entry and the error.
 func dbFetchUtxoEntry(dbTx database.Tx, outpoint types.TxOutPoint) (*UtxoEntry, error) {
+	utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+	return fetchUtxoEntry(utxoBucket, outpoint)
+}
+
+// fetchUtxoEntry fetches the unspent output for the provided outpoint from the
+// given bucket housing a utxo set.
+func fetchUtxoEntry(utxoBucket database.Bucket, outpoint types.TxOutPoint) (*UtxoEntry, error) {
 	// Fetch the unspent transaction output information for the passed
 	// transaction output.  Return now when there is no entry.
 	key := outpointKey(outpoint)
-	utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
 	serializedUtxo := utxoBucket.Get(*key)
 	recycleOutpointKey(key)
 	if serializedUtxo == nil {
@@ -641,6 +629,12 @@ func dbFetchUtxoEntry(dbTx database.Tx, outpoint types.TxOutPoint) (*UtxoEntry,
 
 func dbPutUtxoView(dbTx database.Tx, view *UtxoViewpoint) error {
 	utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+	return putUtxoView(utxoBucket, view)
+}
+
+// putUtxoView updates the utxo set housed in the given bucket with the entries
+// modified by the view.
+func putUtxoView(utxoBucket database.Bucket, view *UtxoViewpoint) error {
 	for outpoint, entry := range view.entries {
 		// No need to update the database if the entry was not modified.
 		if entry == nil || !entry.isModified() {
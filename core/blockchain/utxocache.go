@@ -0,0 +1,646 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	"github.com/btceasypay/bitcoinpay/metrics"
+)
+
+const (
+	// utxoCacheFlushInterval is the longest time the modified entries of
+	// the utxo cache are kept in memory before being written back to the
+	// database.
+	utxoCacheFlushInterval = 5 * time.Minute
+
+	// utxoCacheFlushBatch is the number of entries written back to the
+	// database per transaction when the utxo cache is flushed.
+	utxoCacheFlushBatch = 100000
+
+	// utxoEntryOverhead is the approximate memory used by a cached entry
+	// besides its public key script: the outpoint key, the entry itself
+	// and the map buckets referencing them.
+	utxoEntryOverhead = 160
+)
+
+var (
+	utxoCacheHitCounter   = metrics.NewRegisteredCounter("blockchain/utxocache/hits", nil)
+	utxoCacheMissCounter  = metrics.NewRegisteredCounter("blockchain/utxocache/misses", nil)
+	utxoCacheFlushCounter = metrics.NewRegisteredCounter("blockchain/utxocache/flushes", nil)
+)
+
+// utxoStateCode describes the state of the utxo set stored in the database.
+type utxoStateCode byte
+
+const (
+	// utxoStateConsistent means the utxo set is the one after connecting
+	// the blocks up to the order of the state.
+	utxoStateConsistent utxoStateCode = iota
+
+	// utxoStateFlushing means a flush of the utxo cache was started on top
+	// of the utxo set after the order of the state.  When found on startup
+	// the flush didn't complete and the utxo set is a mix of both.
+	utxoStateFlushing
+
+	// utxoStateReorganizing means the order of the blocks is changing, so
+	// the utxo set can't be recovered by connecting the following blocks
+	// when found on startup.
+	utxoStateReorganizing
+)
+
+// String returns the utxo state code as a human-readable name.
+func (code utxoStateCode) String() string {
+	switch code {
+	case utxoStateConsistent:
+		return "consistent"
+	case utxoStateFlushing:
+		return "flushing"
+	case utxoStateReorganizing:
+		return "reorganizing"
+	}
+	return fmt.Sprintf("unknown (%d)", byte(code))
+}
+
+// utxoState is the flush marker stored along with the utxo set.  It tells on
+// startup whether the utxo set is consistent with the blocks and from which
+// block the blocks must be connected again when it is not.
+type utxoState struct {
+	code  utxoStateCode
+	order uint64
+	hash  hash.Hash
+}
+
+// utxoStateSize is the size of a serialized utxo state: the code, the order
+// and the hash of the last block.
+const utxoStateSize = 1 + 8 + hash.HashSize
+
+// serializeUtxoState returns the utxo state serialized for the database.
+func serializeUtxoState(state *utxoState) []byte {
+	serialized := make([]byte, utxoStateSize)
+	serialized[0] = byte(state.code)
+	dbnamespace.ByteOrder.PutUint64(serialized[1:9], state.order)
+	copy(serialized[9:], state.hash[:])
+	return serialized
+}
+
+// dbFetchUtxoState returns the utxo state stored in the database, or nil when
+// there is none.
+func dbFetchUtxoState(dbTx database.Tx) (*utxoState, error) {
+	serialized := dbTx.Metadata().Get(dbnamespace.UtxoStateKeyName)
+	if serialized == nil {
+		return nil, nil
+	}
+	if len(serialized) != utxoStateSize {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt utxo state of %d bytes",
+				len(serialized)),
+		}
+	}
+	state := &utxoState{
+		code:  utxoStateCode(serialized[0]),
+		order: dbnamespace.ByteOrder.Uint64(serialized[1:9]),
+	}
+	copy(state.hash[:], serialized[9:])
+	return state, nil
+}
+
+// dbPutUtxoState stores the utxo state in the database.
+func dbPutUtxoState(dbTx database.Tx, state *utxoState) error {
+	return dbTx.Metadata().Put(dbnamespace.UtxoStateKeyName,
+		serializeUtxoState(state))
+}
+
+// UtxoCacheStats describes the utxo cache.
+type UtxoCacheStats struct {
+	Entries   int
+	Dirty     int
+	Size      uint64
+	MaxSize   uint64
+	Hits      uint64
+	Misses    uint64
+	LastFlush time.Time
+}
+
+// utxoCache is a write-back cache of the utxo set in front of the database.
+// The entries loaded from the database are kept, and the entries modified by
+// the connected blocks are only written back to the database when the cache
+// is flushed, either periodically or when it exceeds its maximum size.  The
+// spent entries are kept until then so they are deleted from the database.
+//
+// The cache is safe for concurrent access.
+type utxoCache struct {
+	db      database.DB
+	maxSize uint64
+
+	mtx       sync.Mutex
+	entries   map[types.TxOutPoint]*UtxoEntry
+	dirty     map[types.TxOutPoint]struct{}
+	size      uint64
+	hits      uint64
+	misses    uint64
+	lastFlush time.Time
+
+	// state is the utxo state last stored in the database.
+	state utxoState
+}
+
+// newUtxoCache returns a utxo cache of the given maximum size in bytes.  A
+// maximum size of zero writes the modified entries back after each block.
+func newUtxoCache(db database.DB, maxSize uint64) *utxoCache {
+	return &utxoCache{
+		db:        db,
+		maxSize:   maxSize,
+		entries:   make(map[types.TxOutPoint]*UtxoEntry),
+		dirty:     make(map[types.TxOutPoint]struct{}),
+		lastFlush: time.Now(),
+	}
+}
+
+// utxoEntrySize returns the approximate memory used by a cached entry.
+func utxoEntrySize(entry *UtxoEntry) uint64 {
+	return utxoEntryOverhead + uint64(len(entry.pkScript))
+}
+
+// add caches an entry, replacing the cached entry of the outpoint if any.
+//
+// This function MUST be called with the cache lock held.
+func (c *utxoCache) add(outpoint types.TxOutPoint, entry *UtxoEntry) {
+	if cached, ok := c.entries[outpoint]; ok {
+		c.size -= utxoEntrySize(cached)
+	}
+	c.entries[outpoint] = entry
+	c.size += utxoEntrySize(entry)
+}
+
+// fetch returns a copy of the unspent entry of an outpoint, loading it from
+// the database when it is not cached.  Nil is returned when the outpoint is
+// not in the utxo set.
+//
+// This function MUST be called with the cache lock held.
+func (c *utxoCache) fetch(dbTx database.Tx, outpoint types.TxOutPoint) (*UtxoEntry, error) {
+	entry, ok := c.entries[outpoint]
+	if ok {
+		c.hits++
+		utxoCacheHitCounter.Inc(1)
+		if entry.IsSpent() {
+			return nil, nil
+		}
+		return entry.Clone(), nil
+	}
+
+	c.misses++
+	utxoCacheMissCounter.Inc(1)
+	entry, err := dbFetchUtxoEntry(dbTx, outpoint)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	c.add(outpoint, entry)
+	return entry.Clone(), nil
+}
+
+// fetchEntries adds copies of the unspent entries of the outpoints to the
+// view.  The outpoints which are not in the utxo set are left out.
+func (c *utxoCache) fetchEntries(view *UtxoViewpoint, outpoints map[types.TxOutPoint]struct{}) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.db.View(func(dbTx database.Tx) error {
+		for outpoint := range outpoints {
+			entry, err := c.fetch(dbTx, outpoint)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				continue
+			}
+			view.entries[outpoint] = entry
+		}
+		return nil
+	})
+}
+
+// fetchEntry returns a copy of the unspent entry of an outpoint, or nil when
+// the outpoint is not in the utxo set.
+func (c *utxoCache) fetchEntry(outpoint types.TxOutPoint) (*UtxoEntry, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var entry *UtxoEntry
+	err := c.db.View(func(dbTx database.Tx) error {
+		var err error
+		entry, err = c.fetch(dbTx, outpoint)
+		return err
+	})
+	return entry, err
+}
+
+// view calls fn with copies of the modified entries which are not yet written
+// back and a read-only database transaction they go on top of.  The unlock
+// function is called as soon as the entries are copied, so the caller can
+// release the locks keeping them consistent with the chain before fn runs.
+func (c *utxoCache) view(unlock func(), fn func(view *UtxoViewpoint, dbTx database.Tx) error) error {
+	c.mtx.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			c.mtx.Unlock()
+			unlock()
+		}
+	}()
+
+	// A flush holds the cache lock, so the database can't change while
+	// the dirty entries are copied.
+	return c.db.View(func(dbTx database.Tx) error {
+		view := NewUtxoViewpoint()
+		for outpoint := range c.dirty {
+			view.entries[outpoint] = c.entries[outpoint].Clone()
+		}
+		c.mtx.Unlock()
+		unlock()
+		locked = false
+		return fn(view, dbTx)
+	})
+}
+
+// commitView caches the entries modified by the view as dirty entries to be
+// written back to the database on the next flush.
+func (c *utxoCache) commitView(view *UtxoViewpoint) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for outpoint, entry := range view.entries {
+		if entry == nil || !entry.isModified() {
+			continue
+		}
+		cached := entry.Clone()
+		cached.packedFlags &^= tfModified
+		c.add(outpoint, cached)
+		c.dirty[outpoint] = struct{}{}
+	}
+}
+
+// needsFlush returns whether the modified entries must be written back to
+// the database, because the cache exceeds its maximum size or they were kept
+// for too long.
+func (c *utxoCache) needsFlush() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if len(c.dirty) == 0 {
+		return false
+	}
+	return c.size > c.maxSize || time.Since(c.lastFlush) > utxoCacheFlushInterval
+}
+
+// flush writes the modified entries back to the database along with the new
+// utxo state and the root of the UTXO trie of the utxo set.  The entries are
+// written in several transactions when there are many of them, after a first
+// transaction marking the utxo state as flushing.
+func (c *utxoCache) flush(state *utxoState, utxoRoot hash.Hash) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	start := time.Now()
+	outpoints := make([]types.TxOutPoint, 0, len(c.dirty))
+	for outpoint := range c.dirty {
+		outpoints = append(outpoints, outpoint)
+	}
+	if len(outpoints) > utxoCacheFlushBatch {
+		flushing := c.state
+		flushing.code = utxoStateFlushing
+		err := c.db.Update(func(dbTx database.Tx) error {
+			return dbPutUtxoState(dbTx, &flushing)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for len(outpoints) > 0 {
+		batch := outpoints
+		if len(batch) > utxoCacheFlushBatch {
+			batch = batch[:utxoCacheFlushBatch]
+		}
+		outpoints = outpoints[len(batch):]
+		last := len(outpoints) == 0
+
+		err := c.db.Update(func(dbTx database.Tx) error {
+			view := NewUtxoViewpoint()
+			for _, outpoint := range batch {
+				entry := c.entries[outpoint].Clone()
+				entry.packedFlags |= tfModified
+				view.entries[outpoint] = entry
+			}
+			err := dbPutUtxoView(dbTx, view)
+			if err != nil || !last {
+				return err
+			}
+			err = dbPutUtxoTrieRoot(dbTx, utxoRoot)
+			if err != nil {
+				return err
+			}
+			return dbPutUtxoState(dbTx, state)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.dirty) == 0 && c.state != *state {
+		err := c.db.Update(func(dbTx database.Tx) error {
+			err := dbPutUtxoTrieRoot(dbTx, utxoRoot)
+			if err != nil {
+				return err
+			}
+			return dbPutUtxoState(dbTx, state)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The spent entries are no longer needed, and the unspent ones are
+	// only kept while the cache doesn't exceed its maximum size.
+	flushed := len(c.dirty)
+	for outpoint := range c.dirty {
+		if entry := c.entries[outpoint]; entry.IsSpent() {
+			c.size -= utxoEntrySize(entry)
+			delete(c.entries, outpoint)
+		}
+	}
+	c.dirty = make(map[types.TxOutPoint]struct{})
+	if c.size > c.maxSize {
+		c.entries = make(map[types.TxOutPoint]*UtxoEntry)
+		c.size = 0
+	}
+	c.state = *state
+	c.lastFlush = time.Now()
+	utxoCacheFlushCounter.Inc(1)
+	log.Debug("Flushed the utxo cache", "entries", flushed, "state", state.code,
+		"order", state.order, "time", time.Since(start))
+	return nil
+}
+
+// stats returns the statistics of the cache.
+func (c *utxoCache) stats() UtxoCacheStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return UtxoCacheStats{
+		Entries:   len(c.entries),
+		Dirty:     len(c.dirty),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		LastFlush: c.lastFlush,
+	}
+}
+
+// lastBlockOrder returns the order of the last ordered block of the DAG.
+func (b *BlockChain) lastBlockOrder() uint64 {
+	order := uint64(b.bd.GetMainChainTip().GetOrder())
+	for b.bd.GetBlockByOrder(uint(order+1)) != nil {
+		order++
+	}
+	return order
+}
+
+// tipUtxoState returns the utxo state of the utxo set after connecting all
+// the ordered blocks, or the one of the utxo snapshot the node was started
+// from until its last block is connected.
+func (b *BlockChain) tipUtxoState(code utxoStateCode) *utxoState {
+	if b.utxoSnapshot != nil && !b.isUtxoSnapshotBlockConnected() {
+		return &utxoState{
+			code:  code,
+			order: b.utxoSnapshot.Order,
+			hash:  b.utxoSnapshot.Hash,
+		}
+	}
+	order := b.lastBlockOrder()
+	return &utxoState{
+		code:  code,
+		order: order,
+		hash:  *b.bd.GetBlockByOrder(uint(order)),
+	}
+}
+
+// flushUtxoCache writes the modified entries of the utxo cache back to the
+// database with the given state code for the current tip.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) flushUtxoCache(code utxoStateCode) error {
+	return b.utxoCache.flush(b.tipUtxoState(code), b.UtxoTrieRoot())
+}
+
+// maybeFlushUtxoCache flushes the utxo cache when it exceeds its maximum size
+// or its modified entries were kept for too long.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) maybeFlushUtxoCache() error {
+	if !b.utxoCache.needsFlush() {
+		return nil
+	}
+	return b.flushUtxoCache(utxoStateConsistent)
+}
+
+// FlushUtxoCache writes all the modified entries of the utxo cache back to
+// the database.  It must be called before shutting down.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FlushUtxoCache() error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	return b.flushUtxoCache(utxoStateConsistent)
+}
+
+// UtxoCacheStats returns the statistics of the utxo cache.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) UtxoCacheStats() UtxoCacheStats {
+	return b.utxoCache.stats()
+}
+
+// initUtxoCache creates the utxo cache and checks the utxo state stored in the
+// database.  When the node didn't shut down cleanly, the blocks connected
+// after the last flush are connected to the utxo set again, or the whole utxo
+// set is rebuilt when the order of the blocks was changing.
+//
+// The UTXO trie must be opened first since it is updated the same way.
+func (b *BlockChain) initUtxoCache(maxSize uint64) error {
+	b.utxoCache = newUtxoCache(b.db, maxSize)
+
+	var state *utxoState
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		state, err = dbFetchUtxoState(dbTx)
+		if err != nil {
+			return err
+		}
+		b.utxoSnapshot, err = dbFetchUtxoSnapshot(dbTx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// The utxo set was written through for every block before the utxo
+	// cache, so it is consistent when there is no state yet.
+	if state == nil {
+		return b.flushUtxoCache(utxoStateConsistent)
+	}
+	b.utxoCache.state = *state
+
+	// The utxo set is the one of the utxo snapshot the node was started
+	// from until its last block is connected.
+	snapshot := b.utxoSnapshot
+	if snapshot != nil && !b.isUtxoSnapshotBlockConnected() {
+		if state.order != snapshot.Order || state.hash != snapshot.Hash {
+			return fmt.Errorf("the utxo set doesn't match the utxo " +
+				"snapshot, the chain must be synced again")
+		}
+		return nil
+	}
+
+	lastOrder := b.lastBlockOrder()
+	blockHash := b.bd.GetBlockByOrder(uint(state.order))
+	switch {
+	case state.code == utxoStateReorganizing ||
+		blockHash == nil || *blockHash != state.hash ||
+		snapshot != nil && state.order < snapshot.Order:
+		// The utxos of the blocks before the utxo snapshot can't be
+		// rebuilt until they are validated.
+		if snapshot != nil {
+			return fmt.Errorf("the utxo set is inconsistent with the " +
+				"blocks of the utxo snapshot, the chain must be " +
+				"synced again")
+		}
+		log.Warn("The utxo set is inconsistent with the blocks, rebuilding it",
+			"state", state.code, "order", state.order)
+		return b.rebuildUtxoSet(lastOrder)
+
+	case state.code == utxoStateFlushing || state.order < lastOrder:
+		log.Info("Recovering the utxo set", "state", state.code,
+			"from", state.order+1, "to", lastOrder)
+		return b.replayUtxoSet(state.order+1, lastOrder)
+	}
+	return nil
+}
+
+// rebuildUtxoSet drops the utxo set and the UTXO trie and builds them again
+// by connecting all the blocks up to the given order.
+func (b *BlockChain) rebuildUtxoSet(lastOrder uint64) error {
+	err := b.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		err := meta.DeleteBucket(dbnamespace.UtxoSetBucketName)
+		if err != nil {
+			return err
+		}
+		_, err = meta.CreateBucket(dbnamespace.UtxoSetBucketName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	b.utxoCache = newUtxoCache(b.db, b.utxoCache.maxSize)
+
+	b.utxoTrieLock.Lock()
+	err = b.rebuildUtxoTrie()
+	b.utxoTrieLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return b.replayUtxoSet(0, lastOrder)
+}
+
+// replayUtxoSet connects the blocks of the given range of orders to the utxo
+// set and the UTXO trie again.  The blocks are not validated again: their
+// spent outputs are removed and their new outputs are added, as it was done
+// when they were connected, unless they were found invalid.  This is
+// idempotent for the blocks already in the utxo set so the range can start
+// before the first missing block.
+func (b *BlockChain) replayUtxoSet(fromOrder, toOrder uint64) error {
+	for order := fromOrder; order <= toOrder; order++ {
+		blockHash := b.bd.GetBlockByOrder(uint(order))
+		if blockHash == nil {
+			return AssertError(fmt.Sprintf("no block of order %d", order))
+		}
+		view, err := b.replayBlockView(order, blockHash)
+		if err != nil {
+			return err
+		}
+
+		root, err := b.updateUtxoTrie(view)
+		if err != nil {
+			return err
+		}
+		err = b.writeUtxoTrie(root)
+		if err != nil {
+			return err
+		}
+		b.utxoCache.commitView(view)
+
+		// The utxo set is consistent after each replayed block, so
+		// the cache can be flushed as usual.
+		if order == toOrder || b.utxoCache.needsFlush() {
+			state := &utxoState{
+				code:  utxoStateConsistent,
+				order: order,
+				hash:  *blockHash,
+			}
+			err = b.utxoCache.flush(state, root)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replayBlockView returns a view with the outputs spent by the block of the
+// given order marked spent and the outputs it creates added.
+func (b *BlockChain) replayBlockView(order uint64, blockHash *hash.Hash) (*UtxoViewpoint, error) {
+	view := NewUtxoViewpoint()
+	node := b.index.LookupNode(blockHash)
+	if node == nil {
+		return nil, AssertError(fmt.Sprintf("no block node for %v", blockHash))
+	}
+	// The invalid blocks were connected without changing the utxo set.
+	if b.index.NodeStatus(node).KnownInvalid() {
+		return view, nil
+	}
+	block, err := b.fetchBlockByHash(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	block.SetOrder(order)
+	b.CalculateDAGDuplicateTxs(block)
+
+	for _, tx := range block.Transactions() {
+		if tx.IsDuplicate && !tx.Tx.IsCoinBase() {
+			continue
+		}
+		if !tx.Tx.IsCoinBase() {
+			for _, txIn := range tx.Tx.TxIn {
+				entry := view.entries[txIn.PreviousOut]
+				if entry == nil {
+					entry = new(UtxoEntry)
+					view.entries[txIn.PreviousOut] = entry
+				}
+				entry.Spend()
+			}
+		}
+		view.AddTxOuts(tx, blockHash)
+	}
+	return view, nil
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	_ "github.com/btceasypay/bitcoinpay/database/ffldb"
+	"github.com/btceasypay/bitcoinpay/params"
+)
+
+// TestUtxoCache ensures the utxo cache serves the utxos from memory, keeps
+// the modified ones until it is flushed and then writes them back along with
+// the utxo state.
+func TestUtxoCache(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "utxocache_ffldb")
+	db, err := database.Create("ffldb", dbPath, params.PrivNetParams.Net)
+	if err != nil {
+		t.Fatalf("database.Create: %v", err)
+	}
+	defer db.Close()
+
+	blockHash := hash.Hash{0x01}
+	op0 := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 0}
+	op1 := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 1}
+	view := NewUtxoViewpoint()
+	view.addTxOut(op0, &types.TxOutput{Amount: 100, PkScript: []byte{0x51}}, false, &blockHash)
+	err = db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucket(dbnamespace.UtxoSetBucketName)
+		if err != nil {
+			return err
+		}
+		return dbPutUtxoView(dbTx, view)
+	})
+	if err != nil {
+		t.Fatalf("db.Update: %v", err)
+	}
+
+	// fetchDB returns the entry of an outpoint stored in the database.
+	fetchDB := func(outpoint types.TxOutPoint) *UtxoEntry {
+		var entry *UtxoEntry
+		err := db.View(func(dbTx database.Tx) error {
+			var err error
+			entry, err = dbFetchUtxoEntry(dbTx, outpoint)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("dbFetchUtxoEntry: %v", err)
+		}
+		return entry
+	}
+
+	cache := newUtxoCache(db, 1<<20)
+	for i := 0; i < 2; i++ {
+		entry, err := cache.fetchEntry(op0)
+		if err != nil {
+			t.Fatalf("fetchEntry: %v", err)
+		}
+		if entry == nil || entry.Amount() != 100 {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+	}
+	if stats := cache.stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats after fetching %+v", stats)
+	}
+
+	// Spending the cached utxo and adding a new one only changes the
+	// cache until it is flushed.
+	view = NewUtxoViewpoint()
+	err = view.fetchUtxosMain(cache, map[types.TxOutPoint]struct{}{op0: {}})
+	if err != nil {
+		t.Fatalf("fetchUtxosMain: %v", err)
+	}
+	view.LookupEntry(op0).Spend()
+	view.addTxOut(op1, &types.TxOutput{Amount: 200, PkScript: []byte{0x52}}, false, &blockHash)
+	cache.commitView(view)
+	if entry, _ := cache.fetchEntry(op0); entry != nil {
+		t.Fatalf("spent utxo still served by the cache")
+	}
+	if entry, _ := cache.fetchEntry(op1); entry == nil || entry.Amount() != 200 {
+		t.Fatalf("unexpected new entry %+v", entry)
+	}
+	if fetchDB(op0) == nil || fetchDB(op1) != nil {
+		t.Fatalf("utxo set written back before flushing")
+	}
+	if stats := cache.stats(); stats.Dirty != 2 {
+		t.Fatalf("unexpected stats before flushing %+v", stats)
+	}
+
+	// The view of the cache is the modified entries on top of the
+	// database as it was when they were copied, even when a flush runs
+	// once the locks are released.
+	state := &utxoState{code: utxoStateConsistent, order: 7, hash: blockHash}
+	root := hash.Hash{0x02}
+	var unlocked bool
+	err = cache.view(func() { unlocked = true }, func(view *UtxoViewpoint, dbTx database.Tx) error {
+		if !unlocked {
+			t.Fatalf("view called before the unlock function")
+		}
+		if entry := view.LookupEntry(op0); entry == nil || !entry.IsSpent() {
+			t.Fatalf("unexpected spent entry in the view %+v", entry)
+		}
+		if entry := view.LookupEntry(op1); entry == nil || entry.Amount() != 200 {
+			t.Fatalf("unexpected new entry in the view %+v", entry)
+		}
+		if err := cache.flush(state, root); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		entry, err := dbFetchUtxoEntry(dbTx, op1)
+		if err != nil || entry != nil {
+			t.Fatalf("flushed entry seen by the view: %+v %v", entry, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("view: %v", err)
+	}
+	if fetchDB(op0) != nil {
+		t.Fatalf("spent utxo not deleted by the flush")
+	}
+	if entry := fetchDB(op1); entry == nil || entry.Amount() != 200 {
+		t.Fatalf("unexpected flushed entry %+v", entry)
+	}
+	if stats := cache.stats(); stats.Dirty != 0 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats after flushing %+v", stats)
+	}
+
+	var stored *utxoState
+	var storedRoot *hash.Hash
+	err = db.View(func(dbTx database.Tx) error {
+		var err error
+		stored, err = dbFetchUtxoState(dbTx)
+		storedRoot = dbFetchUtxoTrieRoot(dbTx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("dbFetchUtxoState: %v", err)
+	}
+	if stored == nil || *stored != *state {
+		t.Fatalf("stored utxo state %+v, want %+v", stored, state)
+	}
+	if storedRoot == nil || *storedRoot != root {
+		t.Fatalf("stored utxo trie root %v, want %v", storedRoot, root)
+	}
+}
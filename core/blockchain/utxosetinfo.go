@@ -0,0 +1,106 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"io/ioutil"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+)
+
+// UtxoClassInfo houses the statistics of the utxos of a script class.
+type UtxoClassInfo struct {
+	Count  uint64
+	Amount uint64
+}
+
+// UtxoSetInfo houses the statistics of the utxo set after the blocks up to an
+// order.
+type UtxoSetInfo struct {
+	// Order and Hash are the DAG order and hash of the last block whose
+	// utxos are in the set.
+	Order uint64
+	Hash  hash.Hash
+
+	// Transactions is the number of transactions with utxos and Count the
+	// number of utxos.
+	Transactions uint64
+	Count        uint64
+
+	// Amount is the total amount of the utxos, including the fees paid to
+	// the coinbases.
+	Amount uint64
+
+	// TotalSubsidy is the subsidy issued by the main chain when the last
+	// block was connected.
+	TotalSubsidy uint64
+
+	// SerializedSize is the size of the utxos as stored in the database.
+	SerializedSize uint64
+
+	// SetHash is the hash of the utxos, which is the content hash of the
+	// utxo snapshot at the same order.
+	SetHash hash.Hash
+
+	// Classes are the statistics of the utxos by the class of their
+	// public key script.
+	Classes map[txscript.ScriptClass]*UtxoClassInfo
+}
+
+// FetchUtxoSetInfo walks the utxo set after the last ordered block and returns
+// its statistics.  The chain state lock is only held to take a snapshot of the
+// utxo set, which is then walked while blocks keep being connected.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchUtxoSetInfo() (*UtxoSetInfo, error) {
+	b.chainLock.RLock()
+	state := b.tipUtxoState(utxoStateConsistent)
+	info := &UtxoSetInfo{
+		Order:        state.order,
+		Hash:         state.hash,
+		TotalSubsidy: b.BestSnapshot().TotalSubsidy,
+		Classes:      make(map[txscript.ScriptClass]*UtxoClassInfo),
+	}
+
+	sw := newUtxoSnapshotWriter(ioutil.Discard)
+	var lastTx *hash.Hash
+	err := b.utxoCache.view(b.chainLock.RUnlock, func(view *UtxoViewpoint, dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+		return forEachUtxo(utxoBucket, view, b.GetFees,
+			func(outpoint types.TxOutPoint, entry *UtxoEntry, serialized []byte, fees uint64) error {
+				if lastTx == nil || *lastTx != outpoint.Hash {
+					info.Transactions++
+					lastTx = &outpoint.Hash
+				}
+				amount := entry.Amount() + fees
+				info.Amount += amount
+				info.SerializedSize += uint64(hash.HashSize +
+					serializeSizeVLQ(uint64(outpoint.OutIndex)) +
+					len(serialized))
+
+				class := txscript.GetScriptClass(
+					txscript.DefaultScriptVersion, entry.PkScript())
+				classInfo := info.Classes[class]
+				if classInfo == nil {
+					classInfo = &UtxoClassInfo{}
+					info.Classes[class] = classInfo
+				}
+				classInfo.Count++
+				classInfo.Amount += amount
+
+				return sw.write(outpoint, serialized, fees)
+			})
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Count = sw.count
+	info.SetHash = sw.contentHash()
+	return info, nil
+}
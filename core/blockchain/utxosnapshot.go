@@ -0,0 +1,1008 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/common/util"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	"github.com/btceasypay/bitcoinpay/core/protocol"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+)
+
+// The utxo snapshot format is a header followed by the utxos in the order of
+// their keys in the utxo set:
+//
+//   Field           Type        Size
+//   magic           [4]byte     4
+//   version         uint32      4
+//   network         uint32      4
+//   order           uint64      8
+//   block hash      hash.Hash   32
+//   count           uint64      8
+//   content hash    hash.Hash   32
+//   utxos           []utxo      count
+//
+// Each utxo is:
+//
+//   Field           Type        Size
+//   txid            hash.Hash   32
+//   vout            uint32      4
+//   fees            uint64      8
+//   entry size      uint32      4
+//   entry           []byte      entry size
+//
+// The entry is serialized as in the utxo set.  The fees are the fees of the
+// block of a coinbase utxo paying them, zero otherwise.  The content hash is
+// the BLAKE2b-256 hash of the serialized utxos.  All integers are little
+// endian.
+
+const (
+	// utxoSnapshotVersion is the version of the utxo snapshot format.
+	utxoSnapshotVersion = 1
+
+	// utxoSnapshotHeaderSize is the size of the header of a utxo snapshot.
+	utxoSnapshotHeaderSize = 4 + 4 + 4 + 8 + hash.HashSize + 8 + hash.HashSize
+
+	// utxoSnapshotRecordSize is the size of a utxo of a snapshot besides its
+	// serialized entry.
+	utxoSnapshotRecordSize = hash.HashSize + 4 + 8 + 4
+
+	// maxUtxoSnapshotEntrySize is the largest serialized entry accepted in
+	// a utxo snapshot.
+	maxUtxoSnapshotEntrySize = 1 << 20
+
+	// utxoSnapshotLoadBatch is the number of utxos stored per database
+	// transaction when a utxo snapshot is loaded.
+	utxoSnapshotLoadBatch = 100000
+)
+
+// utxoSnapshotMagic identifies a utxo snapshot.
+var utxoSnapshotMagic = [4]byte{'u', 't', 'x', 'o'}
+
+// UtxoSnapshotInfo describes a utxo snapshot.
+type UtxoSnapshotInfo struct {
+	// Order and Hash are the DAG order and hash of the last block whose
+	// utxos are in the snapshot.
+	Order uint64
+	Hash  hash.Hash
+
+	// Count is the number of utxos of the snapshot.
+	Count uint64
+
+	// ContentHash is the hash of the utxos of the snapshot.
+	ContentHash hash.Hash
+}
+
+// writeUtxoSnapshotHeader writes the header of a utxo snapshot.
+func writeUtxoSnapshotHeader(w io.Writer, net protocol.Network, info *UtxoSnapshotInfo) error {
+	var header [utxoSnapshotHeaderSize]byte
+	copy(header[:], utxoSnapshotMagic[:])
+	offset := len(utxoSnapshotMagic)
+	dbnamespace.ByteOrder.PutUint32(header[offset:], utxoSnapshotVersion)
+	offset += 4
+	dbnamespace.ByteOrder.PutUint32(header[offset:], uint32(net))
+	offset += 4
+	dbnamespace.ByteOrder.PutUint64(header[offset:], info.Order)
+	offset += 8
+	copy(header[offset:], info.Hash[:])
+	offset += hash.HashSize
+	dbnamespace.ByteOrder.PutUint64(header[offset:], info.Count)
+	offset += 8
+	copy(header[offset:], info.ContentHash[:])
+
+	_, err := w.Write(header[:])
+	return err
+}
+
+// readUtxoSnapshotHeader reads the header of a utxo snapshot.
+func readUtxoSnapshotHeader(r io.Reader) (protocol.Network, *UtxoSnapshotInfo, error) {
+	var header [utxoSnapshotHeaderSize]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if !bytes.Equal(header[:len(utxoSnapshotMagic)], utxoSnapshotMagic[:]) {
+		return 0, nil, fmt.Errorf("not a utxo snapshot")
+	}
+	offset := len(utxoSnapshotMagic)
+	version := dbnamespace.ByteOrder.Uint32(header[offset:])
+	if version != utxoSnapshotVersion {
+		return 0, nil, fmt.Errorf("unsupported utxo snapshot version %d",
+			version)
+	}
+	offset += 4
+	net := protocol.Network(dbnamespace.ByteOrder.Uint32(header[offset:]))
+	offset += 4
+
+	info := &UtxoSnapshotInfo{}
+	info.Order = dbnamespace.ByteOrder.Uint64(header[offset:])
+	offset += 8
+	copy(info.Hash[:], header[offset:])
+	offset += hash.HashSize
+	info.Count = dbnamespace.ByteOrder.Uint64(header[offset:])
+	offset += 8
+	copy(info.ContentHash[:], header[offset:])
+	return net, info, nil
+}
+
+// utxoSnapshotWriter writes the utxos of a snapshot and hashes them.
+type utxoSnapshotWriter struct {
+	w      io.Writer
+	hasher hash.Hasher
+	count  uint64
+}
+
+// newUtxoSnapshotWriter returns a utxo snapshot writer writing to w.
+func newUtxoSnapshotWriter(w io.Writer) *utxoSnapshotWriter {
+	return &utxoSnapshotWriter{
+		w:      w,
+		hasher: hash.GetHasher(hash.Blake2b_256),
+	}
+}
+
+// write writes a utxo given its serialized entry.
+func (sw *utxoSnapshotWriter) write(outpoint types.TxOutPoint, serialized []byte, fees uint64) error {
+	var record [utxoSnapshotRecordSize]byte
+	copy(record[:], outpoint.Hash[:])
+	offset := hash.HashSize
+	dbnamespace.ByteOrder.PutUint32(record[offset:], outpoint.OutIndex)
+	offset += 4
+	dbnamespace.ByteOrder.PutUint64(record[offset:], fees)
+	offset += 8
+	dbnamespace.ByteOrder.PutUint32(record[offset:], uint32(len(serialized)))
+
+	sw.hasher.Write(record[:])
+	sw.hasher.Write(serialized)
+	sw.count++
+	_, err := sw.w.Write(record[:])
+	if err != nil {
+		return err
+	}
+	_, err = sw.w.Write(serialized)
+	return err
+}
+
+// contentHash returns the hash of the utxos written so far.
+func (sw *utxoSnapshotWriter) contentHash() hash.Hash {
+	var contentHash hash.Hash
+	copy(contentHash[:], sw.hasher.Sum(nil))
+	return contentHash
+}
+
+// readUtxoSnapshot reads a utxo snapshot, calling fn with each of its utxos
+// when it is not nil, and checks the utxos match the content hash of its
+// header.
+func readUtxoSnapshot(r io.Reader, fn func(outpoint types.TxOutPoint, serialized []byte, fees uint64) error) (protocol.Network, *UtxoSnapshotInfo, error) {
+	br := bufio.NewReader(r)
+	net, info, err := readUtxoSnapshotHeader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sw := newUtxoSnapshotWriter(ioutil.Discard)
+	var record [utxoSnapshotRecordSize]byte
+	for i := uint64(0); i < info.Count; i++ {
+		_, err := io.ReadFull(br, record[:])
+		if err != nil {
+			return 0, nil, err
+		}
+		var outpoint types.TxOutPoint
+		copy(outpoint.Hash[:], record[:])
+		offset := hash.HashSize
+		outpoint.OutIndex = dbnamespace.ByteOrder.Uint32(record[offset:])
+		offset += 4
+		fees := dbnamespace.ByteOrder.Uint64(record[offset:])
+		offset += 8
+		size := dbnamespace.ByteOrder.Uint32(record[offset:])
+		if size == 0 || size > maxUtxoSnapshotEntrySize {
+			return 0, nil, fmt.Errorf("utxo %v of the snapshot has an "+
+				"entry of %d bytes", outpoint, size)
+		}
+		serialized := make([]byte, size)
+		_, err = io.ReadFull(br, serialized)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		sw.write(outpoint, serialized, fees)
+		if fn != nil {
+			err = fn(outpoint, serialized, fees)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	if contentHash := sw.contentHash(); contentHash != info.ContentHash {
+		return 0, nil, fmt.Errorf("the utxos of the snapshot hash to %v "+
+			"instead of %v", contentHash, info.ContentHash)
+	}
+	return net, info, nil
+}
+
+// decodeOutpointKey returns the outpoint of a key of the utxo set.
+func decodeOutpointKey(key []byte) types.TxOutPoint {
+	var outpoint types.TxOutPoint
+	copy(outpoint.Hash[:], key[:hash.HashSize])
+	idx, _ := deserializeVLQ(key[hash.HashSize:])
+	outpoint.OutIndex = uint32(idx)
+	return outpoint
+}
+
+// forEachUtxo calls fn with the utxos of the utxo set housed in the bucket,
+// with the entries of the view replacing its own, in the order of their keys.
+// The fees of the coinbase utxos paying them are given by the fees function.
+func forEachUtxo(utxoBucket database.Bucket, view *UtxoViewpoint, fees func(*hash.Hash) int64, fn func(outpoint types.TxOutPoint, entry *UtxoEntry, serialized []byte, fees uint64) error) error {
+	type viewEntry struct {
+		key      []byte
+		outpoint types.TxOutPoint
+		entry    *UtxoEntry
+	}
+	entries := make([]viewEntry, 0, len(view.entries))
+	for outpoint, entry := range view.entries {
+		key := outpointKey(outpoint)
+		entries = append(entries, viewEntry{
+			key:      util.CopyBytes(*key),
+			outpoint: outpoint,
+			entry:    entry,
+		})
+		recycleOutpointKey(key)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	call := func(outpoint types.TxOutPoint, entry *UtxoEntry, serialized []byte) error {
+		var blockFees int64
+		if entry.IsCoinBase() && outpoint.OutIndex == 0 {
+			blockFees = fees(&entry.blockHash)
+		}
+		return fn(outpoint, entry, serialized, uint64(blockFees))
+	}
+	callViewEntry := func(e *viewEntry) error {
+		if e.entry == nil || e.entry.IsSpent() {
+			return nil
+		}
+		serialized, err := serializeUtxoEntry(e.entry)
+		if err != nil {
+			return err
+		}
+		return call(e.outpoint, e.entry, serialized)
+	}
+
+	next := 0
+	err := utxoBucket.ForEach(func(k, v []byte) error {
+		for ; next < len(entries); next++ {
+			cmp := bytes.Compare(entries[next].key, k)
+			if cmp > 0 {
+				break
+			}
+			err := callViewEntry(&entries[next])
+			if err != nil {
+				return err
+			}
+			if cmp == 0 {
+				next++
+				return nil
+			}
+		}
+
+		entry, err := DeserializeUtxoEntry(v)
+		if err != nil {
+			return err
+		}
+		return call(decodeOutpointKey(k), entry, v)
+	})
+	if err != nil {
+		return err
+	}
+	for ; next < len(entries); next++ {
+		err := callViewEntry(&entries[next])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeUtxoSet writes the utxo set housed in the bucket, with the entries of
+// the view replacing its own, in the order of the keys.  The fees of the
+// coinbase utxos paying them are given by the fees function.
+func writeUtxoSet(sw *utxoSnapshotWriter, utxoBucket database.Bucket, view *UtxoViewpoint, fees func(*hash.Hash) int64) error {
+	return forEachUtxo(utxoBucket, view, fees, func(outpoint types.TxOutPoint, entry *UtxoEntry, serialized []byte, fees uint64) error {
+		return sw.write(outpoint, serialized, fees)
+	})
+}
+
+// undoUtxoView returns a view undoing the changes to the utxo set of the
+// blocks after the given order, up to the last order.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) undoUtxoView(order, lastOrder uint64) (*UtxoViewpoint, error) {
+	view := NewUtxoViewpoint()
+	for o := lastOrder; o > order; o-- {
+		blockHash := b.bd.GetBlockByOrder(uint(o))
+		if blockHash == nil {
+			return nil, AssertError(fmt.Sprintf("no block of order %d", o))
+		}
+		node := b.index.LookupNode(blockHash)
+		if node == nil {
+			return nil, AssertError(fmt.Sprintf("no block node for %v", blockHash))
+		}
+		if b.index.NodeStatus(node).KnownInvalid() {
+			continue
+		}
+		block, err := b.fetchBlockByHash(blockHash)
+		if err != nil {
+			return nil, err
+		}
+		block.SetOrder(o)
+		b.CalculateDAGDuplicateTxs(block)
+		stxos, err := b.fetchSpendJournal(block)
+		if err != nil {
+			return nil, err
+		}
+		err = view.disconnectTransactions(block, stxos, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return view, nil
+}
+
+// DumpUtxoSet writes a snapshot of the utxo set after the block of the given
+// DAG order.  The header, which gives the number of utxos and the hash of
+// their content, is written last so the writer must be seekable.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DumpUtxoSet(w io.WriteSeeker, order uint64) (*UtxoSnapshotInfo, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	lastOrder := b.lastBlockOrder()
+	if order > lastOrder {
+		return nil, fmt.Errorf("no block of order %d, the last order "+
+			"is %d", order, lastOrder)
+	}
+	if b.utxoSnapshot != nil && order < b.utxoSnapshot.Order {
+		return nil, fmt.Errorf("the utxo set before order %d is not "+
+			"known until the utxo snapshot is validated",
+			b.utxoSnapshot.Order)
+	}
+	start := time.Now()
+
+	// The utxo set is dumped from the database, with the changes of the
+	// blocks after the order undone in memory.
+	err := b.flushUtxoCache(utxoStateConsistent)
+	if err != nil {
+		return nil, err
+	}
+	view, err := b.undoUtxoView(order, lastOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &UtxoSnapshotInfo{
+		Order: order,
+		Hash:  *b.bd.GetBlockByOrder(uint(order)),
+	}
+	headerOffset, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	err = writeUtxoSnapshotHeader(w, b.params.Net, info)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(w)
+	sw := newUtxoSnapshotWriter(bw)
+	err = b.db.View(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+		return writeUtxoSet(sw, utxoBucket, view, b.GetFees)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = bw.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	info.Count = sw.count
+	info.ContentHash = sw.contentHash()
+	_, err = w.Seek(headerOffset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	err = writeUtxoSnapshotHeader(w, b.params.Net, info)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Dumped the utxo set", "order", info.Order, "hash", info.Hash,
+		"utxos", info.Count, "contenthash", info.ContentHash,
+		"time", time.Since(start))
+	return info, nil
+}
+
+// utxoSnapshotState is the utxo snapshot the node was started from, kept until
+// the blocks up to it are validated.
+type utxoSnapshotState struct {
+	UtxoSnapshotInfo
+
+	// nextOrder is the order of the next block to validate.
+	nextOrder uint64
+
+	// validating is whether the blocks are being validated.
+	validating bool
+}
+
+// utxoSnapshotStateSize is the size of a serialized utxo snapshot state.
+const utxoSnapshotStateSize = 8 + hash.HashSize + 8 + hash.HashSize + 8
+
+// dbFetchUtxoSnapshot returns the utxo snapshot state stored in the database,
+// or nil when there is none.
+func dbFetchUtxoSnapshot(dbTx database.Tx) (*utxoSnapshotState, error) {
+	serialized := dbTx.Metadata().Get(dbnamespace.UtxoSnapshotKeyName)
+	if serialized == nil {
+		return nil, nil
+	}
+	if len(serialized) != utxoSnapshotStateSize {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt utxo snapshot state of %d "+
+				"bytes", len(serialized)),
+		}
+	}
+	state := &utxoSnapshotState{}
+	offset := 0
+	state.Order = dbnamespace.ByteOrder.Uint64(serialized[offset:])
+	offset += 8
+	copy(state.Hash[:], serialized[offset:])
+	offset += hash.HashSize
+	state.Count = dbnamespace.ByteOrder.Uint64(serialized[offset:])
+	offset += 8
+	copy(state.ContentHash[:], serialized[offset:])
+	offset += hash.HashSize
+	state.nextOrder = dbnamespace.ByteOrder.Uint64(serialized[offset:])
+	return state, nil
+}
+
+// dbPutUtxoSnapshot stores the utxo snapshot state in the database.
+func dbPutUtxoSnapshot(dbTx database.Tx, state *utxoSnapshotState) error {
+	serialized := make([]byte, utxoSnapshotStateSize)
+	offset := 0
+	dbnamespace.ByteOrder.PutUint64(serialized[offset:], state.Order)
+	offset += 8
+	copy(serialized[offset:], state.Hash[:])
+	offset += hash.HashSize
+	dbnamespace.ByteOrder.PutUint64(serialized[offset:], state.Count)
+	offset += 8
+	copy(serialized[offset:], state.ContentHash[:])
+	offset += hash.HashSize
+	dbnamespace.ByteOrder.PutUint64(serialized[offset:], state.nextOrder)
+	return dbTx.Metadata().Put(dbnamespace.UtxoSnapshotKeyName, serialized)
+}
+
+// isAssumedUtxo returns whether the utxos of the block of the given order are
+// accounted for by the utxo snapshot the node was started from, so the block
+// is connected without them.
+//
+// This function MUST be called with the chain state lock held.
+func (b *BlockChain) isAssumedUtxo(order uint64) bool {
+	return b.utxoSnapshot != nil && order <= b.utxoSnapshot.Order
+}
+
+// isUtxoSnapshotBlockConnected returns whether the last block of the utxo
+// snapshot the node was started from is connected, so the utxo set follows
+// the blocks after it.
+//
+// This function MUST be called with the chain state lock held.
+func (b *BlockChain) isUtxoSnapshotBlockConnected() bool {
+	blockHash := b.bd.GetBlockByOrder(uint(b.utxoSnapshot.Order))
+	return blockHash != nil && *blockHash == b.utxoSnapshot.Hash
+}
+
+// utxoSnapshotFees returns the fees of a block before the utxo snapshot, which
+// can't be computed from its spend journal until the blocks are validated.
+func (b *BlockChain) utxoSnapshotFees(blockHash *hash.Hash) (int64, bool) {
+	var fees int64
+	var ok bool
+	b.db.View(func(dbTx database.Tx) error {
+		feesBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSnapshotFeesBucketName)
+		if feesBucket == nil {
+			return nil
+		}
+		serialized := feesBucket.Get(blockHash[:])
+		if len(serialized) != 8 {
+			return nil
+		}
+		fees = int64(dbnamespace.ByteOrder.Uint64(serialized))
+		ok = true
+		return nil
+	})
+	return fees, ok
+}
+
+// initUtxoSnapshot loads the utxo snapshot of the given file, if any, and
+// resumes the validation of the blocks before the utxo snapshot the node was
+// started from.
+func (b *BlockChain) initUtxoSnapshot(path string) error {
+	if path != "" {
+		err := b.loadUtxoSnapshot(path)
+		if err != nil {
+			return err
+		}
+	}
+	if b.utxoSnapshot != nil && b.isUtxoSnapshotBlockConnected() {
+		b.startUtxoSnapshotValidation()
+	}
+	return nil
+}
+
+// loadUtxoSnapshot replaces the utxo set with the utxo snapshot of the file.
+// The snapshot must be pinned in the chain parameters, and the chain must not
+// have any block yet besides the genesis block.  The blocks up to the snapshot
+// are then connected without their utxos, and validated in the background
+// once they are all connected.
+func (b *BlockChain) loadUtxoSnapshot(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	net, info, err := readUtxoSnapshotHeader(file)
+	if err != nil {
+		return err
+	}
+	if net != b.params.Net {
+		return fmt.Errorf("the utxo snapshot is for the network %v", net)
+	}
+	if b.utxoSnapshot != nil {
+		if b.utxoSnapshot.UtxoSnapshotInfo == *info {
+			log.Info("The utxo snapshot is already loaded", "order",
+				info.Order, "hash", info.Hash)
+			return nil
+		}
+		return fmt.Errorf("the utxo snapshot of order %d is already "+
+			"loaded", b.utxoSnapshot.Order)
+	}
+	if lastOrder := b.lastBlockOrder(); lastOrder != 0 {
+		blockHash := b.bd.GetBlockByOrder(uint(info.Order))
+		if blockHash != nil && *blockHash == info.Hash {
+			log.Info("The chain is already synced past the utxo snapshot",
+				"order", info.Order, "hash", info.Hash)
+			return nil
+		}
+		return fmt.Errorf("the utxo snapshot can only be loaded before "+
+			"syncing, the chain has blocks up to order %d", lastOrder)
+	}
+	pinned := false
+	for _, assumeUtxo := range b.params.AssumeUtxos {
+		if assumeUtxo.Order == info.Order && assumeUtxo.Hash == info.Hash &&
+			assumeUtxo.Count == info.Count &&
+			assumeUtxo.ContentHash == info.ContentHash {
+			pinned = true
+			break
+		}
+	}
+	if !pinned {
+		return fmt.Errorf("the utxo snapshot of block %v at order %d is "+
+			"not pinned in the chain parameters", info.Hash, info.Order)
+	}
+
+	// Check the whole content before replacing the utxo set.
+	log.Info("Checking the utxo snapshot", "order", info.Order, "hash",
+		info.Hash, "utxos", info.Count)
+	start := time.Now()
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, _, err = readUtxoSnapshot(file, nil)
+	if err != nil {
+		return err
+	}
+
+	// The utxo set is rebuilt from the blocks when the node stops before
+	// the utxo snapshot is loaded.
+	err = b.flushUtxoCache(utxoStateReorganizing)
+	if err != nil {
+		return err
+	}
+	err = b.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		err := meta.DeleteBucket(dbnamespace.UtxoSetBucketName)
+		if err != nil {
+			return err
+		}
+		_, err = meta.CreateBucket(dbnamespace.UtxoSetBucketName)
+		if err != nil {
+			return err
+		}
+		_, err = meta.CreateBucketIfNotExists(dbnamespace.UtxoSnapshotFeesBucketName)
+		if err != nil {
+			return err
+		}
+		_, err = meta.CreateBucketIfNotExists(dbnamespace.UtxoSnapshotCheckBucketName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	type record struct {
+		key        []byte
+		serialized []byte
+		blockHash  *hash.Hash
+		fees       uint64
+	}
+	batch := make([]record, 0, utxoSnapshotLoadBatch)
+	storeBatch := func() error {
+		err := b.db.Update(func(dbTx database.Tx) error {
+			meta := dbTx.Metadata()
+			utxoBucket := meta.Bucket(dbnamespace.UtxoSetBucketName)
+			feesBucket := meta.Bucket(dbnamespace.UtxoSnapshotFeesBucketName)
+			for _, r := range batch {
+				err := utxoBucket.Put(r.key, r.serialized)
+				if err != nil {
+					return err
+				}
+				if r.blockHash == nil {
+					continue
+				}
+				var fees [8]byte
+				dbnamespace.ByteOrder.PutUint64(fees[:], r.fees)
+				err = feesBucket.Put(r.blockHash[:], fees[:])
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, _, err = readUtxoSnapshot(file, func(outpoint types.TxOutPoint, serialized []byte, fees uint64) error {
+		entry, err := DeserializeUtxoEntry(serialized)
+		if err != nil {
+			return err
+		}
+		key := outpointKey(outpoint)
+		r := record{key: util.CopyBytes(*key), serialized: serialized}
+		recycleOutpointKey(key)
+		if entry.IsCoinBase() && outpoint.OutIndex == 0 {
+			r.blockHash = &entry.blockHash
+			r.fees = fees
+		}
+		batch = append(batch, r)
+		if len(batch) < utxoSnapshotLoadBatch {
+			return nil
+		}
+		return storeBatch()
+	})
+	if err != nil {
+		return err
+	}
+	err = storeBatch()
+	if err != nil {
+		return err
+	}
+
+	state := &utxoSnapshotState{UtxoSnapshotInfo: *info}
+	err = b.db.Update(func(dbTx database.Tx) error {
+		return dbPutUtxoSnapshot(dbTx, state)
+	})
+	if err != nil {
+		return err
+	}
+	b.utxoSnapshot = state
+	b.utxoCache = newUtxoCache(b.db, b.utxoCache.maxSize)
+	b.utxoTrieLock.Lock()
+	err = b.rebuildUtxoTrie()
+	b.utxoTrieLock.Unlock()
+	if err != nil {
+		return err
+	}
+	err = b.flushUtxoCache(utxoStateConsistent)
+	if err != nil {
+		return err
+	}
+	log.Info("Loaded the utxo snapshot", "order", info.Order, "hash",
+		info.Hash, "utxos", info.Count, "time", time.Since(start))
+	return nil
+}
+
+// utxoSnapshotBlockConnected is called once a block is connected.  When it is
+// the last block of the utxo snapshot, the utxo set is the one of the
+// snapshot and the blocks up to it are validated in the background.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) utxoSnapshotBlockConnected(node *blockNode) error {
+	if b.utxoSnapshot == nil || node.order != b.utxoSnapshot.Order {
+		return nil
+	}
+	if node.hash != b.utxoSnapshot.Hash {
+		return fmt.Errorf("the block %v of order %d is not the block %v "+
+			"of the utxo snapshot, the chain must be synced again "+
+			"without it", node.hash, node.order, b.utxoSnapshot.Hash)
+	}
+	log.Info("Connected the block of the utxo snapshot", "order", node.order,
+		"hash", node.hash)
+	err := b.flushUtxoCache(utxoStateConsistent)
+	if err != nil {
+		return err
+	}
+	b.startUtxoSnapshotValidation()
+	return nil
+}
+
+// startUtxoSnapshotValidation starts validating the blocks up to the utxo
+// snapshot in the background.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) startUtxoSnapshotValidation() {
+	if b.utxoSnapshot.validating {
+		return
+	}
+	b.utxoSnapshot.validating = true
+	log.Info("Validating the blocks of the utxo snapshot in the background",
+		"from", b.utxoSnapshot.nextOrder, "to", b.utxoSnapshot.Order)
+	b.wg.Add(1)
+	go b.validateUtxoSnapshot()
+}
+
+// validateUtxoSnapshot builds the utxo set from the blocks up to the utxo
+// snapshot, one block at a time, and compares it with the snapshot once done.
+// The blocks, which were connected without their utxos, are fully checked
+// against the utxo set built so far, scripts included.  Their spend journal is
+// written along the way, and the blocks failing the checks are marked invalid.
+//
+// This MUST be run as a goroutine.
+func (b *BlockChain) validateUtxoSnapshot() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-b.quit:
+			return
+		default:
+		}
+
+		done, err := b.validateUtxoSnapshotBlock()
+		if err != nil {
+			log.Error("Failed to validate the utxo snapshot", "error", err)
+			return
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// validateUtxoSnapshotBlock validates the next block up to the utxo snapshot,
+// and finishes the validation when there is no block left.
+func (b *BlockChain) validateUtxoSnapshotBlock() (bool, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	snapshot := b.utxoSnapshot
+	if snapshot.nextOrder > snapshot.Order {
+		return true, b.finishUtxoSnapshotValidation()
+	}
+	order := snapshot.nextOrder
+	blockHash := b.bd.GetBlockByOrder(uint(order))
+	if blockHash == nil {
+		return false, AssertError(fmt.Sprintf("no block of order %d", order))
+	}
+	node := b.index.LookupNode(blockHash)
+	if node == nil {
+		return false, AssertError(fmt.Sprintf("no block node for %v", blockHash))
+	}
+
+	view := NewUtxoViewpoint()
+	var stxos []SpentTxOut
+	if !b.index.NodeStatus(node).KnownInvalid() {
+		block, err := b.fetchBlockByHash(blockHash)
+		if err != nil {
+			return false, err
+		}
+		block.SetOrder(order)
+		b.CalculateDAGDuplicateTxs(block)
+		err = b.db.View(func(dbTx database.Tx) error {
+			checkBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSnapshotCheckBucketName)
+			var err error
+			stxos, err = b.checkUtxoSnapshotBlock(checkBucket, node, block, view)
+			return err
+		})
+		if _, ok := err.(RuleError); ok {
+			log.Warn("Invalid block of the utxo snapshot", "order", order,
+				"hash", blockHash, "error", err)
+			node.Invalid(b)
+			view = NewUtxoViewpoint()
+			stxos = nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	snapshot.nextOrder++
+	err := b.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		err := putUtxoView(meta.Bucket(dbnamespace.UtxoSnapshotCheckBucketName), view)
+		if err != nil {
+			return err
+		}
+		err = dbPutSpendJournalEntry(dbTx, blockHash, stxos)
+		if err != nil {
+			return err
+		}
+		return dbPutUtxoSnapshot(dbTx, snapshot)
+	})
+	if err != nil {
+		snapshot.nextOrder--
+		return false, err
+	}
+	return false, nil
+}
+
+// checkUtxoSnapshotBlock checks the connection of a block up to the utxo
+// snapshot, as checkConnectBlock does when a block is connected, against the
+// utxo set housed in the bucket, and returns the outputs it spends.  The
+// genesis block is connected without checks, as it is when the chain is
+// created.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) checkUtxoSnapshotBlock(utxoBucket database.Bucket, node *blockNode, block *types.SerializedBlock, view *UtxoViewpoint) ([]SpentTxOut, error) {
+	if node.hash.IsEqual(b.params.GenesisHash) {
+		return b.connectUtxoSnapshotBlock(utxoBucket, node, block, view)
+	}
+
+	// Load the inputs from the bucket first, so checkConnectBlock only
+	// adds the outputs of the block itself and never reads the current
+	// utxo set.  The missing inputs are loaded spent.
+	txInFlight := make(map[hash.Hash]int)
+	transactions := block.Transactions()
+	for i, tx := range transactions {
+		if tx.IsDuplicate {
+			continue
+		}
+		txInFlight[*tx.Hash()] = i
+	}
+	for i, tx := range transactions {
+		if tx.IsDuplicate || tx.Tx.IsCoinBase() {
+			continue
+		}
+		for _, txIn := range tx.Tx.TxIn {
+			inFlightIndex, ok := txInFlight[txIn.PreviousOut.Hash]
+			if ok && i > inFlightIndex {
+				continue
+			}
+			if _, ok := view.entries[txIn.PreviousOut]; ok {
+				continue
+			}
+			entry, err := fetchUtxoEntry(utxoBucket, txIn.PreviousOut)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				entry = new(UtxoEntry)
+				entry.Spend()
+			}
+			view.entries[txIn.PreviousOut] = entry
+		}
+	}
+
+	var stxos []SpentTxOut
+	err := b.checkConnectBlock(node, block, view, &stxos)
+	if err != nil {
+		return nil, err
+	}
+	return stxos, nil
+}
+
+// connectUtxoSnapshotBlock connects the transactions of a block up to the utxo
+// snapshot to the view over the utxo set housed in the bucket, and returns
+// the outputs they spend.
+func (b *BlockChain) connectUtxoSnapshotBlock(utxoBucket database.Bucket, node *blockNode, block *types.SerializedBlock, view *UtxoViewpoint) ([]SpentTxOut, error) {
+	var stxos []SpentTxOut
+	for _, tx := range block.Transactions() {
+		if tx.IsDuplicate && !tx.Tx.IsCoinBase() {
+			continue
+		}
+		if !tx.Tx.IsCoinBase() {
+			for txInIndex, txIn := range tx.Tx.TxIn {
+				entry := view.entries[txIn.PreviousOut]
+				if entry == nil {
+					var err error
+					entry, err = fetchUtxoEntry(utxoBucket, txIn.PreviousOut)
+					if err != nil {
+						return nil, err
+					}
+				}
+				if entry == nil || entry.IsSpent() {
+					str := fmt.Sprintf("output %v referenced from "+
+						"transaction %s:%d either does not exist or "+
+						"has already been spent", txIn.PreviousOut,
+						tx.Hash(), txInIndex)
+					return nil, ruleError(ErrMissingTxOut, str)
+				}
+				view.entries[txIn.PreviousOut] = entry
+			}
+		}
+		err := view.connectTransaction(tx, node, uint32(tx.Index()), &stxos, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stxos, nil
+}
+
+// finishUtxoSnapshotValidation compares the utxo set built from the blocks up
+// to the utxo snapshot with the snapshot.  The utxo set is rebuilt from the
+// blocks when they don't match.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) finishUtxoSnapshotValidation() error {
+	// The fees of the blocks are now computed from their spend journal.
+	err := b.db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().DeleteBucket(dbnamespace.UtxoSnapshotFeesBucketName)
+	})
+	if err != nil && !database.IsError(err, database.ErrBucketNotFound) {
+		return err
+	}
+
+	sw := newUtxoSnapshotWriter(ioutil.Discard)
+	err = b.db.View(func(dbTx database.Tx) error {
+		checkBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSnapshotCheckBucketName)
+		return writeUtxoSet(sw, checkBucket, NewUtxoViewpoint(), b.GetFees)
+	})
+	if err != nil {
+		return err
+	}
+
+	snapshot := b.utxoSnapshot
+	err = b.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		err := meta.DeleteBucket(dbnamespace.UtxoSnapshotCheckBucketName)
+		if err != nil {
+			return err
+		}
+		return meta.Delete(dbnamespace.UtxoSnapshotKeyName)
+	})
+	if err != nil {
+		return err
+	}
+	b.utxoSnapshot = nil
+
+	contentHash := sw.contentHash()
+	if sw.count == snapshot.Count && contentHash == snapshot.ContentHash {
+		log.Info("Validated the utxo snapshot", "order", snapshot.Order,
+			"hash", snapshot.Hash, "utxos", sw.count)
+		return nil
+	}
+	log.Error("The utxo snapshot doesn't match its blocks, rebuilding the "+
+		"utxo set from them", "order", snapshot.Order, "utxos", sw.count,
+		"contenthash", contentHash, "want", snapshot.ContentHash)
+	return b.rebuildUtxoSet(b.lastBlockOrder())
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	_ "github.com/btceasypay/bitcoinpay/database/ffldb"
+	"github.com/btceasypay/bitcoinpay/params"
+)
+
+// TestUtxoSnapshot ensures a utxo snapshot holds the utxo set with the
+// changes of a view in the order of the keys, reads back the same utxos and
+// is rejected once its content is modified.
+func TestUtxoSnapshot(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "utxosnapshot_ffldb")
+	db, err := database.Create("ffldb", dbPath, params.PrivNetParams.Net)
+	if err != nil {
+		t.Fatalf("database.Create: %v", err)
+	}
+	defer db.Close()
+
+	blockHash := hash.Hash{0x01}
+	op0 := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 0}
+	op1 := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 1}
+	op2 := types.TxOutPoint{Hash: hash.Hash{0xbb}, OutIndex: 0}
+	view := NewUtxoViewpoint()
+	view.addTxOut(op0, &types.TxOutput{Amount: 100, PkScript: []byte{0x51}}, true, &blockHash)
+	view.addTxOut(op1, &types.TxOutput{Amount: 200, PkScript: []byte{0x52}}, true, &blockHash)
+	err = db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucket(dbnamespace.UtxoSetBucketName)
+		if err != nil {
+			return err
+		}
+		return dbPutUtxoView(dbTx, view)
+	})
+	if err != nil {
+		t.Fatalf("db.Update: %v", err)
+	}
+
+	// The view spends a utxo of the set and adds a new one.
+	view = NewUtxoViewpoint()
+	view.addTxOut(op1, &types.TxOutput{Amount: 200, PkScript: []byte{0x52}}, true, &blockHash)
+	view.LookupEntry(op1).Spend()
+	view.addTxOut(op2, &types.TxOutput{Amount: 300, PkScript: []byte{0x53}}, false, &blockHash)
+
+	var buf bytes.Buffer
+	sw := newUtxoSnapshotWriter(&buf)
+	err = db.View(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+		return writeUtxoSet(sw, utxoBucket, view, func(*hash.Hash) int64 {
+			return 7
+		})
+	})
+	if err != nil {
+		t.Fatalf("writeUtxoSet: %v", err)
+	}
+	info := &UtxoSnapshotInfo{
+		Order:       3,
+		Hash:        blockHash,
+		Count:       sw.count,
+		ContentHash: sw.contentHash(),
+	}
+	var snapshot bytes.Buffer
+	err = writeUtxoSnapshotHeader(&snapshot, params.PrivNetParams.Net, info)
+	if err != nil {
+		t.Fatalf("writeUtxoSnapshotHeader: %v", err)
+	}
+	snapshot.Write(buf.Bytes())
+
+	type utxo struct {
+		outpoint types.TxOutPoint
+		amount   uint64
+		fees     uint64
+	}
+	var utxos []utxo
+	net, readInfo, err := readUtxoSnapshot(bytes.NewReader(snapshot.Bytes()),
+		func(outpoint types.TxOutPoint, serialized []byte, fees uint64) error {
+			entry, err := DeserializeUtxoEntry(serialized)
+			if err != nil {
+				return err
+			}
+			utxos = append(utxos, utxo{outpoint, entry.Amount(), fees})
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("readUtxoSnapshot: %v", err)
+	}
+	if net != params.PrivNetParams.Net || *readInfo != *info {
+		t.Fatalf("read snapshot %v %+v, want %v %+v", net, readInfo,
+			params.PrivNetParams.Net, info)
+	}
+	want := []utxo{{op0, 100, 7}, {op2, 300, 0}}
+	if len(utxos) != len(want) {
+		t.Fatalf("read %d utxos, want %d", len(utxos), len(want))
+	}
+	for i := range want {
+		if utxos[i] != want[i] {
+			t.Fatalf("utxo #%d is %+v, want %+v", i, utxos[i], want[i])
+		}
+	}
+
+	// Any modified byte of the utxos changes the content hash.
+	corrupted := snapshot.Bytes()
+	corrupted[len(corrupted)-1] ^= 0xff
+	_, _, err = readUtxoSnapshot(bytes.NewReader(corrupted), nil)
+	if err == nil {
+		t.Fatalf("corrupted snapshot accepted")
+	}
+}
+
+// TestCheckUtxoSnapshotBlock ensures the blocks up to a utxo snapshot are
+// checked against the utxo set built from them, scripts included, and never
+// against the current utxo set.
+func TestCheckUtxoSnapshotBlock(t *testing.T) {
+	b, nodes := newVBTestChain(t, nil, []uint32{12, 12, 12})
+	b.subsidyCache = NewSubsidyCache(0, b.params)
+	b.utxoCache = newUtxoCache(b.db, 1<<20)
+	err := b.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		_, err := meta.CreateBucket(dbnamespace.UtxoSetBucketName)
+		if err != nil {
+			return err
+		}
+		_, err = meta.CreateBucket(dbnamespace.UtxoSnapshotCheckBucketName)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+
+	// putUtxo stores an output of the second block in the bucket.
+	op := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 0}
+	putUtxo := func(bucketName []byte, pkScript []byte) {
+		view := NewUtxoViewpoint()
+		view.addTxOut(op, &types.TxOutput{Amount: 100, PkScript: pkScript},
+			false, &nodes[1].hash)
+		err := b.db.Update(func(dbTx database.Tx) error {
+			return putUtxoView(dbTx.Metadata().Bucket(bucketName), view)
+		})
+		if err != nil {
+			t.Fatalf("putUtxoView: %v", err)
+		}
+	}
+
+	// The block spending the output pays the subsidy to its coinbase.
+	parents := []*hash.Hash{&nodes[1].hash}
+	blues := b.bd.GetBlues(b.bd.GetIdSet(parents))
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{},
+		types.MaxPrevOutIndex), []byte{0x51, 0x51}))
+	coinbase.AddTxOut(types.NewTxOutput(uint64(
+		b.subsidyCache.CalcBlockSubsidy(int64(blues))), []byte{0x51}))
+	spend := types.NewTransaction()
+	spend.AddTxIn(types.NewTxInput(&op, nil))
+	spend.AddTxOut(types.NewTxOutput(90, []byte{0x51}))
+	block := types.NewBlock(&types.Block{
+		Header:       nodes[2].Header(),
+		Parents:      parents,
+		Transactions: []*types.Transaction{coinbase, spend},
+	})
+
+	check := func() error {
+		return b.db.View(func(dbTx database.Tx) error {
+			checkBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSnapshotCheckBucketName)
+			_, err := b.checkUtxoSnapshotBlock(checkBucket, nodes[2], block,
+				NewUtxoViewpoint())
+			return err
+		})
+	}
+
+	// The output is only in the current utxo set.
+	putUtxo(dbnamespace.UtxoSetBucketName, []byte{0x51})
+	err = check()
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrMissingTxOut {
+		t.Fatalf("spending an output missing from the snapshot: %v", err)
+	}
+
+	// The scripts of the block are run.
+	putUtxo(dbnamespace.UtxoSnapshotCheckBucketName, []byte{0x00})
+	err = check()
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrScriptValidation {
+		t.Fatalf("spending an output with a failing script: %v", err)
+	}
+
+	putUtxo(dbnamespace.UtxoSnapshotCheckBucketName, []byte{0x51})
+	if err := check(); err != nil {
+		t.Fatalf("checkUtxoSnapshotBlock: %v", err)
+	}
+}
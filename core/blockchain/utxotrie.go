@@ -0,0 +1,468 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"errors"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/common/util"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	"github.com/btceasypay/bitcoinpay/database/statedb"
+	"github.com/btceasypay/bitcoinpay/params"
+	"github.com/btceasypay/bitcoinpay/trie"
+)
+
+// utxoTrieCacheLimit is the number of commits the nodes of the UTXO trie are
+// kept in memory for after they were last used.
+const utxoTrieCacheLimit = 120
+
+// errUtxoTrieNodeNotFound is returned by the UTXO trie database when a node is
+// not stored.
+var errUtxoTrieNodeNotFound = errors.New("utxo trie node not found")
+
+// utxoTrieDB implements the statedb.Database interface on top of a bucket of
+// the chain database to store the nodes of the UTXO trie.  The nodes are
+// written in the transaction set in dbTx while the trie is committed, which
+// is the one writing the block changing it.
+type utxoTrieDB struct {
+	db   database.DB
+	dbTx database.Tx
+}
+
+// Ensure utxoTrieDB implements the statedb.Database interface.
+var _ statedb.Database = (*utxoTrieDB)(nil)
+
+// update runs the function with the bucket of the trie nodes in the current
+// transaction, or in a new one when the trie is not being committed.
+func (tdb *utxoTrieDB) update(fn func(bucket database.Bucket) error) error {
+	if tdb.dbTx != nil {
+		return fn(tdb.dbTx.Metadata().Bucket(dbnamespace.UtxoTrieBucketName))
+	}
+	return tdb.db.Update(func(dbTx database.Tx) error {
+		return fn(dbTx.Metadata().Bucket(dbnamespace.UtxoTrieBucketName))
+	})
+}
+
+// view runs the function with the bucket of the trie nodes in the current
+// transaction, so the nodes it wrote are seen, or in a new one.
+func (tdb *utxoTrieDB) view(fn func(bucket database.Bucket) error) error {
+	if tdb.dbTx != nil {
+		return fn(tdb.dbTx.Metadata().Bucket(dbnamespace.UtxoTrieBucketName))
+	}
+	return tdb.db.View(func(dbTx database.Tx) error {
+		return fn(dbTx.Metadata().Bucket(dbnamespace.UtxoTrieBucketName))
+	})
+}
+
+// Put stores the value of a key.
+func (tdb *utxoTrieDB) Put(key []byte, value []byte) error {
+	return tdb.update(func(bucket database.Bucket) error {
+		return bucket.Put(key, value)
+	})
+}
+
+// Get returns a copy of the value of a key, or errUtxoTrieNodeNotFound when
+// the key is not stored.
+func (tdb *utxoTrieDB) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := tdb.view(func(bucket database.Bucket) error {
+		v := bucket.Get(key)
+		if v == nil {
+			return errUtxoTrieNodeNotFound
+		}
+		value = util.CopyBytes(v)
+		return nil
+	})
+	return value, err
+}
+
+// Has returns whether a key is stored.
+func (tdb *utxoTrieDB) Has(key []byte) (bool, error) {
+	var has bool
+	err := tdb.view(func(bucket database.Bucket) error {
+		has = bucket.Get(key) != nil
+		return nil
+	})
+	return has, err
+}
+
+// Delete removes a key.
+func (tdb *utxoTrieDB) Delete(key []byte) error {
+	return tdb.update(func(bucket database.Bucket) error {
+		return bucket.Delete(key)
+	})
+}
+
+// Close does nothing, the chain database is closed by its owner.
+func (tdb *utxoTrieDB) Close() {}
+
+// NewBatch returns a batch writing all its values at once.
+func (tdb *utxoTrieDB) NewBatch() statedb.Batch {
+	return &utxoTrieBatch{db: tdb}
+}
+
+// utxoTrieBatch implements the statedb.Batch interface for utxoTrieDB.
+type utxoTrieBatch struct {
+	db     *utxoTrieDB
+	keys   [][]byte
+	values [][]byte
+	size   int
+}
+
+// Put queues the value of a key.  Both are copied since the trie reuses its
+// buffers.
+func (batch *utxoTrieBatch) Put(key []byte, value []byte) error {
+	batch.keys = append(batch.keys, util.CopyBytes(key))
+	batch.values = append(batch.values, util.CopyBytes(value))
+	batch.size += len(value)
+	return nil
+}
+
+// ValueSize returns the size of the queued values.
+func (batch *utxoTrieBatch) ValueSize() int {
+	return batch.size
+}
+
+// Write stores the queued values.
+func (batch *utxoTrieBatch) Write() error {
+	if len(batch.keys) == 0 {
+		return nil
+	}
+	return batch.db.update(func(bucket database.Bucket) error {
+		for i, key := range batch.keys {
+			err := bucket.Put(key, batch.values[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reset drops the queued values.
+func (batch *utxoTrieBatch) Reset() {
+	batch.keys = batch.keys[:0]
+	batch.values = batch.values[:0]
+	batch.size = 0
+}
+
+// dbFetchUtxoTrieRoot returns the root of the UTXO trie stored along with the
+// utxo set, or nil when there is none.
+func dbFetchUtxoTrieRoot(dbTx database.Tx) *hash.Hash {
+	serialized := dbTx.Metadata().Get(dbnamespace.UtxoTrieRootKeyName)
+	if len(serialized) != hash.HashSize {
+		return nil
+	}
+	var root hash.Hash
+	copy(root[:], serialized)
+	return &root
+}
+
+// dbPutUtxoTrieRoot stores the root of the UTXO trie.  It must be called in
+// the transaction finishing a flush of the utxo cache, so the root always
+// matches the stored utxo set.
+func dbPutUtxoTrieRoot(dbTx database.Tx, root hash.Hash) error {
+	return dbTx.Metadata().Put(dbnamespace.UtxoTrieRootKeyName, root.Bytes())
+}
+
+// initUtxoTrie opens the UTXO trie at the root stored with the utxo set.  The
+// trie is rebuilt from the utxo set when there is no root yet or when its
+// nodes were not all written, e.g. after a crash.
+func (b *BlockChain) initUtxoTrie() error {
+	var root *hash.Hash
+	err := b.db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucketIfNotExists(dbnamespace.UtxoTrieBucketName)
+		if err != nil {
+			return err
+		}
+		root = dbFetchUtxoTrieRoot(dbTx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.utxoTrieLock.Lock()
+	defer b.utxoTrieLock.Unlock()
+
+	b.utxoTrieStore = &utxoTrieDB{db: b.db}
+	b.utxoTrieDB = trie.NewDatabase(b.utxoTrieStore)
+	if root != nil {
+		t, err := trie.NewSecure(*root, b.utxoTrieDB, utxoTrieCacheLimit)
+		if err == nil {
+			b.utxoTrie = t
+			b.utxoTrieRoot = *root
+			return nil
+		}
+		log.Warn("The UTXO trie is incomplete", "root", root, "error", err)
+	}
+	return b.rebuildUtxoTrie()
+}
+
+// rebuildUtxoTrie builds the UTXO trie from the whole utxo set.
+//
+// This function MUST be called with the UTXO trie lock held.
+func (b *BlockChain) rebuildUtxoTrie() error {
+	log.Info("Building the UTXO trie from the utxo set")
+	t, err := trie.NewSecure(hash.Hash{}, b.utxoTrieDB, utxoTrieCacheLimit)
+	if err != nil {
+		return err
+	}
+	var count int
+	err = b.db.View(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+		return utxoBucket.ForEach(func(k, v []byte) error {
+			count++
+			return t.TryUpdate(util.CopyBytes(k), util.CopyBytes(v))
+		})
+	})
+	if err != nil {
+		return err
+	}
+	b.utxoTrie = t
+
+	// The nodes are written along with the root so a crash can't leave a
+	// stored root without its nodes.
+	root := t.Hash()
+	err = b.db.Update(func(dbTx database.Tx) error {
+		err := b.commitUtxoTrie(dbTx, root)
+		if err != nil {
+			return err
+		}
+		return dbPutUtxoTrieRoot(dbTx, root)
+	})
+	if err != nil {
+		return err
+	}
+	b.utxoTrieRoot = root
+	log.Info("Built the UTXO trie", "utxos", count, "root", root)
+	return nil
+}
+
+// updateUtxoTrie applies the modified entries of the utxo view to the UTXO
+// trie and returns its new root.  The nodes of the trie must then be written
+// along with the block with commitUtxoTrie, and finishUtxoTrieUpdate called
+// with the outcome.
+func (b *BlockChain) updateUtxoTrie(view *UtxoViewpoint) (hash.Hash, error) {
+	b.utxoTrieLock.Lock()
+	defer b.utxoTrieLock.Unlock()
+
+	for outpoint, entry := range view.entries {
+		if entry == nil || !entry.isModified() {
+			continue
+		}
+
+		key := outpointKey(outpoint)
+		if entry.IsSpent() {
+			err := b.utxoTrie.TryDelete(*key)
+			recycleOutpointKey(key)
+			if err != nil {
+				return hash.Hash{}, err
+			}
+			continue
+		}
+
+		serialized, err := serializeUtxoEntry(entry)
+		if err != nil {
+			recycleOutpointKey(key)
+			return hash.Hash{}, err
+		}
+		err = b.utxoTrie.TryUpdate(*key, serialized)
+		recycleOutpointKey(key)
+		if err != nil {
+			return hash.Hash{}, err
+		}
+	}
+	return b.utxoTrie.Hash(), nil
+}
+
+// commitUtxoTrie writes the nodes of the UTXO trie with the given root in the
+// database transaction.
+//
+// This function MUST be called with the UTXO trie lock held.
+func (b *BlockChain) commitUtxoTrie(dbTx database.Tx, root hash.Hash) error {
+	b.utxoTrieStore.dbTx = dbTx
+	defer func() {
+		b.utxoTrieStore.dbTx = nil
+	}()
+
+	_, err := b.utxoTrie.Commit(nil)
+	if err != nil {
+		return err
+	}
+	return b.utxoTrieDB.Commit(root, false)
+}
+
+// finishUtxoTrieUpdate records the root of the UTXO trie once the transaction
+// writing its nodes was committed, or drops the changes applied by
+// updateUtxoTrie when writing the block failed.
+//
+// This function MUST be called with the UTXO trie lock held.
+func (b *BlockChain) finishUtxoTrieUpdate(root hash.Hash, stored bool) {
+	if stored {
+		b.utxoTrieRoot = root
+		return
+	}
+
+	// The nodes of the last stored root are all in the database, the ones
+	// dropped from memory by the failed commit are not needed anymore.
+	t, err := trie.NewSecure(b.utxoTrieRoot, b.utxoTrieDB, utxoTrieCacheLimit)
+	if err != nil {
+		log.Error("Failed to reopen the UTXO trie", "root", b.utxoTrieRoot, "error", err)
+		return
+	}
+	b.utxoTrie = t
+}
+
+// writeUtxoTrie writes the nodes of the UTXO trie with the given root in their
+// own database transaction, for the updates which are not written along with
+// a block.
+func (b *BlockChain) writeUtxoTrie(root hash.Hash) error {
+	b.utxoTrieLock.Lock()
+	defer b.utxoTrieLock.Unlock()
+
+	err := b.db.Update(func(dbTx database.Tx) error {
+		return b.commitUtxoTrie(dbTx, root)
+	})
+	b.finishUtxoTrieUpdate(root, err == nil)
+	return err
+}
+
+// UtxoTrieRoot returns the root of the UTXO trie of the current utxo set.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) UtxoTrieRoot() hash.Hash {
+	b.utxoTrieLock.Lock()
+	defer b.utxoTrieLock.Unlock()
+
+	return b.utxoTrie.Hash()
+}
+
+// UtxoProof is a merkle proof of the presence or absence of an outpoint in the
+// UTXO trie.
+type UtxoProof struct {
+	// Root is the root of the UTXO trie the proof is for.
+	Root hash.Hash
+
+	// Key is the key of the outpoint in the trie, which is the hash of
+	// its serialized outpoint.
+	Key []byte
+
+	// Value is the serialized utxo entry of the outpoint, nil when the
+	// outpoint is not in the utxo set.
+	Value []byte
+
+	// Nodes are the encoded trie nodes on the path from the root to the
+	// outpoint.
+	Nodes [][]byte
+}
+
+// utxoProofNodes collects the nodes of a merkle proof in path order.
+type utxoProofNodes [][]byte
+
+// Put is part of the statedb.Putter interface.
+func (nodes *utxoProofNodes) Put(key []byte, value []byte) error {
+	*nodes = append(*nodes, util.CopyBytes(value))
+	return nil
+}
+
+// FetchUtxoProof returns a merkle proof of the presence or absence of the
+// outpoint in the current utxo set.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchUtxoProof(outpoint types.TxOutPoint) (*UtxoProof, error) {
+	b.utxoTrieLock.Lock()
+	defer b.utxoTrieLock.Unlock()
+
+	key := outpointKey(outpoint)
+	hashedKey := hash.CalcHash(*key, hash.GetHasher(hash.Keccak_256))
+	value, err := b.utxoTrie.TryGet(*key)
+	recycleOutpointKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes utxoProofNodes
+	err = b.utxoTrie.Prove(hashedKey, 0, &nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &UtxoProof{
+		Root:  b.utxoTrie.Hash(),
+		Key:   hashedKey,
+		Value: util.CopyBytes(value),
+		Nodes: nodes,
+	}, nil
+}
+
+// isUtxoCommitted returns whether the blocks with the given main parent
+// commit the utxo set in their state root, which they do once the UTXO
+// commitment deployment is active.
+func (b *BlockChain) isUtxoCommitted(mainParent *blockNode) bool {
+	if mainParent == nil {
+		return false
+	}
+	// The deployment is not defined on every network.
+	active, err := b.isDeploymentActive(mainParent, params.DeploymentUtxoCommitment)
+	return err == nil && active
+}
+
+// calcStateRoot returns the root of the UTXO trie once the transactions of a
+// block connected after the current utxo set have spent their inputs.  The
+// outputs created by the block are committed by the blocks after it, since
+// their utxo entries have the hash of the block, which covers its state root.
+//
+// The state root is the utxo set seen by the miner of the block and is not
+// validated: the blocks ordered before a block change with the blocks of its
+// anticone, so the parallel blocks would not agree on it.
+//
+// This function MUST be called with the chain state lock held.
+func (b *BlockChain) calcStateRoot(txs []*types.Tx) (hash.Hash, error) {
+	b.utxoTrieLock.Lock()
+	defer b.utxoTrieLock.Unlock()
+
+	// The spends are applied to a copy, the trie only follows the
+	// connected blocks.
+	t := b.utxoTrie.Copy()
+	for _, tx := range txs {
+		if tx.Tx.IsCoinBase() {
+			continue
+		}
+		// The inputs already spent by a duplicate of the transaction
+		// and the outputs of the block itself are not in the trie, so
+		// deleting them does nothing.
+		for _, txIn := range tx.Tx.TxIn {
+			key := outpointKey(txIn.PreviousOut)
+			err := t.TryDelete(*key)
+			recycleOutpointKey(key)
+			if err != nil {
+				return hash.Hash{}, err
+			}
+		}
+	}
+	return t.Hash(), nil
+}
+
+// CalcStateRoot returns the state root of a block built on the given parents
+// with the transactions and connected after the current utxo set, or the zero
+// hash when the block does not commit the utxo set.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcStateRoot(parents []*hash.Hash, txs []*types.Tx) (hash.Hash, error) {
+	b.ChainRLock()
+	defer b.ChainRUnlock()
+
+	mainParent := b.bd.GetMainParent(b.bd.GetIdSet(parents))
+	if mainParent == nil {
+		return hash.Hash{}, nil
+	}
+	if !b.isUtxoCommitted(b.index.LookupNode(mainParent.GetHash())) {
+		return hash.Hash{}, nil
+	}
+	return b.calcStateRoot(txs)
+}
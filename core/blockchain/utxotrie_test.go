@@ -0,0 +1,245 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	_ "github.com/btceasypay/bitcoinpay/database/ffldb"
+	"github.com/btceasypay/bitcoinpay/database/statedb"
+	"github.com/btceasypay/bitcoinpay/params"
+	"github.com/btceasypay/bitcoinpay/trie"
+)
+
+// verifyUtxoProof checks a proof of the UTXO trie and returns the value it
+// proves for its key.
+func verifyUtxoProof(t *testing.T, proof *UtxoProof) []byte {
+	proofDb := statedb.NewMemDatabase()
+	for _, node := range proof.Nodes {
+		proofDb.Put(hash.CalcHash(node, hash.GetHasher(hash.Keccak_256)), node)
+	}
+	value, _, err := trie.VerifyProof(proof.Root, proof.Key, proofDb)
+	if err != nil {
+		t.Fatalf("VerifyProof: %v", err)
+	}
+	return value
+}
+
+// newUtxoTrieTestChain returns a chain holding only an empty UTXO trie, with a
+// function applying a view to the utxo set the way connectBlock does.
+func newUtxoTrieTestChain(t *testing.T) (*BlockChain, database.DB, func(*UtxoViewpoint) hash.Hash) {
+	dbPath := filepath.Join(t.TempDir(), "utxotrie_ffldb")
+	db, err := database.Create("ffldb", dbPath, params.PrivNetParams.Net)
+	if err != nil {
+		t.Fatalf("database.Create: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucket(dbnamespace.UtxoSetBucketName)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+
+	b := &BlockChain{db: db}
+	if err := b.initUtxoTrie(); err != nil {
+		t.Fatalf("initUtxoTrie: %v", err)
+	}
+
+	connect := func(view *UtxoViewpoint) hash.Hash {
+		root, err := b.updateUtxoTrie(view)
+		if err != nil {
+			t.Fatalf("updateUtxoTrie: %v", err)
+		}
+		b.utxoTrieLock.Lock()
+		err = db.Update(func(dbTx database.Tx) error {
+			err := dbPutUtxoView(dbTx, view)
+			if err != nil {
+				return err
+			}
+			err = b.commitUtxoTrie(dbTx, root)
+			if err != nil {
+				return err
+			}
+			return dbPutUtxoTrieRoot(dbTx, root)
+		})
+		b.finishUtxoTrieUpdate(root, err == nil)
+		b.utxoTrieLock.Unlock()
+		if err != nil {
+			t.Fatalf("db.Update: %v", err)
+		}
+		view.commit()
+		return root
+	}
+	return b, db, connect
+}
+
+// TestUtxoTrie ensures the UTXO trie follows the utxo set, proves its
+// outpoints and is reopened or rebuilt to the same root.
+func TestUtxoTrie(t *testing.T) {
+	b, db, connect := newUtxoTrieTestChain(t)
+	emptyRoot := b.UtxoTrieRoot()
+
+	blockHash := hash.Hash{0x01}
+	op0 := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 0}
+	op1 := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 1}
+	view := NewUtxoViewpoint()
+	view.addTxOut(op0, &types.TxOutput{Amount: 100, PkScript: []byte{0x51}}, false, &blockHash)
+	view.addTxOut(op1, &types.TxOutput{Amount: 200, PkScript: []byte{0x52}}, false, &blockHash)
+	root := connect(view)
+	if root == emptyRoot {
+		t.Fatalf("root did not change after adding utxos")
+	}
+
+	proof, err := b.FetchUtxoProof(op0)
+	if err != nil {
+		t.Fatalf("FetchUtxoProof: %v", err)
+	}
+	if proof.Root != root || proof.Value == nil {
+		t.Fatalf("unexpected proof of an unspent outpoint: %+v", proof)
+	}
+	if value := verifyUtxoProof(t, proof); !bytes.Equal(value, proof.Value) {
+		t.Fatalf("proof verifies %x, want %x", value, proof.Value)
+	}
+
+	// The state root of a block spending an outpoint is the root once it
+	// is removed, without changing the trie.
+	spendTx := types.NewTransaction()
+	spendTx.AddTxIn(types.NewTxInput(&op0, nil))
+	spendTx.AddTxOut(&types.TxOutput{Amount: 90, PkScript: []byte{0x53}})
+	stateRoot, err := b.calcStateRoot([]*types.Tx{types.NewTx(spendTx)})
+	if err != nil {
+		t.Fatalf("calcStateRoot: %v", err)
+	}
+	if got := b.UtxoTrieRoot(); got != root {
+		t.Fatalf("calcStateRoot changed the root to %v, want %v", got, root)
+	}
+
+	// Spending an outpoint removes it from the trie.
+	view.LookupEntry(op0).Spend()
+	root = connect(view)
+	if stateRoot != root {
+		t.Fatalf("state root %v, want %v", stateRoot, root)
+	}
+	proof, err = b.FetchUtxoProof(op0)
+	if err != nil {
+		t.Fatalf("FetchUtxoProof: %v", err)
+	}
+	if proof.Root != root || proof.Value != nil {
+		t.Fatalf("unexpected proof of a spent outpoint: %+v", proof)
+	}
+	if value := verifyUtxoProof(t, proof); value != nil {
+		t.Fatalf("proof of a spent outpoint verifies %x", value)
+	}
+
+	// The changes of a block whose transaction fails are dropped, along
+	// with the nodes written in it.
+	view.LookupEntry(op1).Spend()
+	if _, err := b.updateUtxoTrie(view); err != nil {
+		t.Fatalf("updateUtxoTrie: %v", err)
+	}
+	errFailedWrite := errors.New("failed write")
+	b.utxoTrieLock.Lock()
+	err = db.Update(func(dbTx database.Tx) error {
+		err := b.commitUtxoTrie(dbTx, b.utxoTrie.Hash())
+		if err != nil {
+			return err
+		}
+		return errFailedWrite
+	})
+	b.finishUtxoTrieUpdate(hash.Hash{}, err == nil)
+	b.utxoTrieLock.Unlock()
+	if err != errFailedWrite {
+		t.Fatalf("db.Update: %v", err)
+	}
+	if got := b.UtxoTrieRoot(); got != root {
+		t.Fatalf("root after the failed write %v, want %v", got, root)
+	}
+
+	// The trie is reopened at the stored root, and rebuilt to the same
+	// root without it.
+	reopened := &BlockChain{db: db}
+	if err := reopened.initUtxoTrie(); err != nil {
+		t.Fatalf("initUtxoTrie: %v", err)
+	}
+	if got := reopened.UtxoTrieRoot(); got != root {
+		t.Fatalf("reopened root %v, want %v", got, root)
+	}
+	err = db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Delete(dbnamespace.UtxoTrieRootKeyName)
+	})
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	rebuilt := &BlockChain{db: db}
+	if err := rebuilt.initUtxoTrie(); err != nil {
+		t.Fatalf("initUtxoTrie: %v", err)
+	}
+	if got := rebuilt.UtxoTrieRoot(); got != root {
+		t.Fatalf("rebuilt root %v, want %v", got, root)
+	}
+}
+
+// TestParallelStateRoots ensures two parallel blocks committing the utxo set
+// seen by their miners are both connected, whichever is ordered first, though
+// the later one commits a root the chain no longer has.
+func TestParallelStateRoots(t *testing.T) {
+	parentHash := hash.Hash{0x01}
+	op0 := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 0}
+	op1 := types.TxOutPoint{Hash: hash.Hash{0xaa}, OutIndex: 1}
+	spend := func(op types.TxOutPoint) *types.Tx {
+		tx := types.NewTransaction()
+		tx.AddTxIn(types.NewTxInput(&op, nil))
+		tx.AddTxOut(&types.TxOutput{Amount: 90, PkScript: []byte{0x53}})
+		return types.NewTx(tx)
+	}
+	txA, txB := spend(op0), spend(op1)
+
+	var roots []hash.Hash
+	for _, order := range [][]*types.Tx{{txA, txB}, {txB, txA}} {
+		b, _, connect := newUtxoTrieTestChain(t)
+		view := NewUtxoViewpoint()
+		view.addTxOut(op0, &types.TxOutput{Amount: 100, PkScript: []byte{0x51}}, false, &parentHash)
+		view.addTxOut(op1, &types.TxOutput{Amount: 200, PkScript: []byte{0x52}}, false, &parentHash)
+		connect(view)
+
+		// Both miners build on the parent.
+		committed := make(map[*types.Tx]hash.Hash)
+		for _, tx := range order {
+			root, err := b.calcStateRoot([]*types.Tx{tx})
+			if err != nil {
+				t.Fatalf("calcStateRoot: %v", err)
+			}
+			committed[tx] = root
+		}
+
+		first, second := order[0], order[1]
+		view.LookupEntry(first.Tx.TxIn[0].PreviousOut).Spend()
+		if root := connect(view); root != committed[first] {
+			t.Fatalf("root after the first block %v, want %v", root, committed[first])
+		}
+		root, err := b.calcStateRoot([]*types.Tx{second})
+		if err != nil {
+			t.Fatalf("calcStateRoot: %v", err)
+		}
+		if root == committed[second] {
+			t.Fatalf("the second block commits the root of the chain")
+		}
+		view.LookupEntry(second.Tx.TxIn[0].PreviousOut).Spend()
+		roots = append(roots, connect(view))
+	}
+	if roots[0] != roots[1] {
+		t.Fatalf("the root depends on the order of the parallel blocks: %v, %v",
+			roots[0], roots[1])
+	}
+}
@@ -778,7 +778,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 	// scripts.
 	// Do this for all TxTrees.
 
-	err = utxoView.fetchInputUtxos(b.db, block, b)
+	err = utxoView.fetchInputUtxos(block, b)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,276 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Copyright (c) 2016-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/btceasypay/bitcoinpay/params"
+)
+
+const (
+	// The lower 2 bytes of the block version hold the version of the
+	// network, which must match exactly, so the deployments are signalled
+	// in the upper 2 bytes.
+
+	// vbTopBits defines the bits to set in the version to signal that the
+	// version bits scheme is being used.
+	vbTopBits = 0x20000000
+
+	// vbTopMask is the bitmask to use to determine whether or not the
+	// version bits scheme is in use.
+	vbTopMask = 0xe0000000
+
+	// vbBitsShift is the position of the bit of the first deployment in
+	// the block version.
+	vbBitsShift = 16
+
+	// VBNumBits is the total number of bits available for use with the
+	// version bits scheme.
+	VBNumBits = 13
+)
+
+// DeploymentState describes the state of a consensus deployment for the block
+// after the tip of the main chain.
+type DeploymentState struct {
+	Name       string
+	Bit        uint8
+	StartTime  uint64
+	ExpireTime uint64
+	State      ThresholdState
+
+	// Since is the main height from which the deployment is in its
+	// current state.
+	Since uint
+}
+
+// deploymentChecker provides a thresholdConditionChecker which can be used to
+// test a specific deployment rule.  This is required for properly detecting
+// and activating consensus rule changes.
+type deploymentChecker struct {
+	deployment *params.ConsensusDeployment
+	chain      *BlockChain
+}
+
+// Ensure the deploymentChecker type implements the thresholdConditionChecker
+// interface.
+var _ thresholdConditionChecker = deploymentChecker{}
+
+// BeginTime returns the unix timestamp for the median block time after which
+// voting on a rule change starts (at the next window).
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) BeginTime() uint64 {
+	return c.deployment.StartTime
+}
+
+// EndTime returns the unix timestamp for the median block time after which an
+// attempted rule change fails if it has not already been locked in or
+// activated.
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) EndTime() uint64 {
+	return c.deployment.ExpireTime
+}
+
+// RuleChangeActivationThreshold is the number of blocks for which the condition
+// must be true in order to lock in a rule change.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) RuleChangeActivationThreshold() uint32 {
+	return c.chain.params.RuleChangeActivationThreshold
+}
+
+// MinerConfirmationWindow is the number of blocks in each threshold state
+// retarget window.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) MinerConfirmationWindow() uint32 {
+	return c.chain.params.MinerConfirmationWindow
+}
+
+// Condition returns true when the specific bit defined by the deployment
+// associated with the checker is set.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) Condition(node *blockNode) bool {
+	return isDeploymentSignalled(node.blockVersion, c.deployment.BitNumber)
+}
+
+// isDeploymentSignalled returns whether the block version signals the
+// deployment of the given bit.
+func isDeploymentSignalled(version uint32, bit uint8) bool {
+	return version&vbTopMask == vbTopBits &&
+		version&(uint32(1)<<(vbBitsShift+bit)) != 0
+}
+
+// deployments returns the deployments voted on with the block version of the
+// chain.
+func (b *BlockChain) deployments() []params.ConsensusDeployment {
+	return b.params.Deployments[b.BlockVersion]
+}
+
+// deploymentCache returns the threshold state cache of the named deployment.
+//
+// This function MUST be called with the deployment lock held (for writes).
+func (b *BlockChain) deploymentCache(name string) thresholdStateCache {
+	cache, ok := b.deploymentCaches[name]
+	if !ok {
+		cache = make(thresholdStateCache)
+		b.deploymentCaches[name] = cache
+	}
+	return cache
+}
+
+// deploymentState returns the current rule change threshold for the block
+// AFTER the given node of the named deployment.
+//
+// This function MUST be called with the deployment lock held (for writes).
+func (b *BlockChain) deploymentState(prevNode *blockNode,
+	name string) (ThresholdState, error) {
+
+	deployments := b.deployments()
+	for i := range deployments {
+		if deployments[i].Name != name {
+			continue
+		}
+		checker := deploymentChecker{deployment: &deployments[i], chain: b}
+		return b.thresholdState(prevNode, checker, b.deploymentCache(name)), nil
+	}
+	return ThresholdFailed, fmt.Errorf("deployment %s is not defined", name)
+}
+
+// isDeploymentActive returns whether the named deployment is active for the
+// block AFTER the given node.  It is the hook validation rules use to be gated
+// on a deployment, e.g. from checkBlockContext with the main parent.
+func (b *BlockChain) isDeploymentActive(prevNode *blockNode, name string) (bool, error) {
+	b.deploymentLock.Lock()
+	defer b.deploymentLock.Unlock()
+
+	state, err := b.deploymentState(prevNode, name)
+	if err != nil {
+		return false, err
+	}
+	return state == ThresholdActive, nil
+}
+
+// mainTipNode returns the block node of the tip of the main chain.
+func (b *BlockChain) mainTipNode() *blockNode {
+	return b.index.LookupNode(b.bd.GetMainChainTip().GetHash())
+}
+
+// IsDeploymentActive returns whether the named deployment is active for the
+// block after the tip of the main chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsDeploymentActive(name string) (bool, error) {
+	return b.isDeploymentActive(b.mainTipNode(), name)
+}
+
+// DeploymentStates returns the states of the deployments voted on with the
+// block version of the chain for the block after the tip of the main chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DeploymentStates() []DeploymentState {
+	b.deploymentLock.Lock()
+	defer b.deploymentLock.Unlock()
+
+	prevNode := b.mainTipNode()
+	deployments := b.deployments()
+	states := make([]DeploymentState, 0, len(deployments))
+	for i := range deployments {
+		deployment := &deployments[i]
+		checker := deploymentChecker{deployment: deployment, chain: b}
+		cache := b.deploymentCache(deployment.Name)
+		state := b.thresholdState(prevNode, checker, cache)
+
+		// The state only changes at the start of a window, walk back
+		// the windows while the state stays the same.
+		since := uint(0)
+		window := uint(b.params.MinerConfirmationWindow)
+		if window > 0 && prevNode != nil {
+			since = prevNode.height + 1 - (prevNode.height+1)%window
+			node := prevNode
+			for since > 0 {
+				// The state of the previous window is the state of
+				// its last block, which follows the block before.
+				var prevWindowNode *blockNode
+				if since >= 2 {
+					node = b.mainAncestor(node, since-2)
+					prevWindowNode = node
+				}
+				if b.thresholdState(prevWindowNode, checker, cache) != state {
+					break
+				}
+				if since < window || prevWindowNode == nil {
+					since = 0
+					break
+				}
+				since -= window
+			}
+		}
+
+		states = append(states, DeploymentState{
+			Name:       deployment.Name,
+			Bit:        deployment.BitNumber,
+			StartTime:  deployment.StartTime,
+			ExpireTime: deployment.ExpireTime,
+			State:      state,
+			Since:      since,
+		})
+	}
+	return states
+}
+
+// calcNextBlockVersion calculates the expected version of the block after the
+// passed previous block node based on the state of started and locked in rule
+// change deployments.  The version bits are only set while some deployment
+// is voted on, otherwise the block version of the chain is returned as is.
+//
+// This function MUST be called with the deployment lock held (for writes).
+func (b *BlockChain) calcNextBlockVersion(prevNode *blockNode) uint32 {
+	// Set the appropriate bits for each actively defined rule deployment
+	// that is either in the process of being voted on, or locked in for the
+	// activation at the next threshold window change.
+	bits := uint32(0)
+	deployments := b.deployments()
+	for i := range deployments {
+		deployment := &deployments[i]
+		checker := deploymentChecker{deployment: deployment, chain: b}
+		cache := b.deploymentCache(deployment.Name)
+		state := b.thresholdState(prevNode, checker, cache)
+		if state == ThresholdStarted || state == ThresholdLockedIn {
+			bits |= uint32(1) << (vbBitsShift + deployment.BitNumber)
+		}
+	}
+	if bits == 0 {
+		return b.BlockVersion
+	}
+	return b.BlockVersion | vbTopBits | bits
+}
+
+// CalcNextBlockVersion calculates the expected version of the block after the
+// end of the current main chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcNextBlockVersion() uint32 {
+	b.deploymentLock.Lock()
+	defer b.deploymentLock.Unlock()
+
+	return b.calcNextBlockVersion(b.mainTipNode())
+}
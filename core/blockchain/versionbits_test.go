@@ -0,0 +1,266 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/database"
+	_ "github.com/btceasypay/bitcoinpay/database/ffldb"
+	"github.com/btceasypay/bitcoinpay/params"
+)
+
+// TestDeploymentSignalling ensures the deployments are signalled in the upper
+// bytes of the block version without changing the version of the network.
+func TestDeploymentSignalling(t *testing.T) {
+	tests := []struct {
+		version uint32
+		bit     uint8
+		want    bool
+	}{
+		{12, 0, false},
+		{12 | vbTopBits | 1<<vbBitsShift, 0, true},
+		{12 | vbTopBits | 1<<vbBitsShift, 1, false},
+		{12 | vbTopBits | 1<<(vbBitsShift+12), 12, true},
+		// The top bits must be exactly 001.
+		{12 | 0x60000000 | 1<<vbBitsShift, 0, false},
+		{12 | 1<<vbBitsShift, 0, false},
+	}
+	for i, test := range tests {
+		got := isDeploymentSignalled(test.version, test.bit)
+		if got != test.want {
+			t.Errorf("test #%d: version %#x bit %d got %v, want %v",
+				i, test.version, test.bit, got, test.want)
+		}
+		header := types.BlockHeader{Version: test.version}
+		if header.GetVersion() != 12 {
+			t.Errorf("test #%d: version %#x changes the network "+
+				"version to %d", i, test.version, header.GetVersion())
+		}
+	}
+
+	if s := ThresholdLockedIn.String(); s != "locked_in" {
+		t.Errorf("ThresholdLockedIn is %q", s)
+	}
+}
+
+// vbTestWindow is the confirmation window of the deployments of the version
+// bits tests, and vbTestBlockTime the time between two blocks.
+const (
+	vbTestWindow    = 10
+	vbTestBlockTime = 600
+)
+
+// vbTestBase is the timestamp of the genesis block of the version bits tests.
+var vbTestBase = time.Unix(1600000000, 0)
+
+// vbTestTime returns the timestamp of the block at the given main height of
+// the version bits tests.  The median time past the block at height h is the
+// one of the block at height h-5.
+func vbTestTime(height int) uint64 {
+	return uint64(vbTestBase.Unix()) + uint64(height*vbTestBlockTime)
+}
+
+// newVBTestChain returns a chain of blocks with the given versions, linked
+// into a DAG, which votes on the passed deployments.
+func newVBTestChain(t *testing.T, deployments []params.ConsensusDeployment,
+	versions []uint32) (*BlockChain, []*blockNode) {
+
+	dbPath := filepath.Join(t.TempDir(), "versionbits_ffldb")
+	db, err := database.Create("ffldb", dbPath, params.PrivNetParams.Net)
+	if err != nil {
+		t.Fatalf("database.Create: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	par := params.PrivNetParams
+	par.MinerConfirmationWindow = vbTestWindow
+	par.RuleChangeActivationThreshold = 8
+	par.Deployments = map[uint32][]params.ConsensusDeployment{
+		12: deployments,
+	}
+	b := &BlockChain{
+		params:           &par,
+		db:               db,
+		index:            newBlockIndex(db, &par),
+		bd:               &blockdag.BlockDAG{},
+		BlockVersion:     12,
+		deploymentCaches: make(map[string]thresholdStateCache),
+	}
+	b.bd.Init("phantom", func(int64, *hash.Hash, byte) int64 { return 1 },
+		-1, b.index.GetDAGBlockID, db)
+
+	var nodes []*blockNode
+	for i, version := range versions {
+		header := types.BlockHeader{
+			Version:    version,
+			Timestamp:  time.Unix(int64(vbTestTime(i)), 0),
+			Difficulty: 0x1d00ffff,
+			Pow:        pow.GetInstance(pow.BLAKE2BD, 0, []byte{}),
+		}
+		var parents []*blockNode
+		if i > 0 {
+			parents = []*blockNode{nodes[i-1]}
+			header.ParentRoot = nodes[i-1].hash
+		}
+		node := newBlockNode(&header, parents)
+		b.index.AddNode(node)
+		_, ib := b.bd.AddBlock(node)
+		if ib == nil {
+			t.Fatalf("block %d not added to the DAG", i)
+		}
+		node.dagID = ib.GetID()
+		node.SetHeight(ib.GetHeight())
+		node.SetOrder(uint64(ib.GetOrder()))
+		nodes = append(nodes, node)
+	}
+	return b, nodes
+}
+
+// TestThresholdState ensures the deployments go through the threshold states
+// on the window boundaries of the main chain, start and expire with the median
+// time and lock in with enough votes.
+func TestThresholdState(t *testing.T) {
+	deployments := []params.ConsensusDeployment{
+		// Voted by every block of the second window.
+		{Name: "voted", BitNumber: 1, StartTime: vbTestTime(0),
+			ExpireTime: vbTestTime(1000)},
+		// Voted by 7 blocks of each window, below the threshold of 8,
+		// until it expires in the third window.
+		{Name: "undervoted", BitNumber: 2, StartTime: vbTestTime(0),
+			ExpireTime: vbTestTime(20)},
+		// Expires before it had a chance to start.
+		{Name: "expired", BitNumber: 3, StartTime: vbTestTime(12),
+			ExpireTime: vbTestTime(13)},
+		// Starts long after the chain.
+		{Name: "future", BitNumber: 4, StartTime: vbTestTime(1000),
+			ExpireTime: vbTestTime(2000)},
+	}
+	versions := make([]uint32, 45)
+	for i := range versions {
+		versions[i] = 12
+		bits := uint32(0)
+		if i >= 10 && i < 20 {
+			bits |= 1 << (vbBitsShift + 1)
+		}
+		if i >= 10 && i%10 < 7 {
+			bits |= 1 << (vbBitsShift + 2)
+		}
+		if bits != 0 {
+			versions[i] |= vbTopBits | bits
+		}
+	}
+	b, nodes := newVBTestChain(t, deployments, versions)
+
+	tests := []struct {
+		// height is the main height of the block the state is for.
+		height int
+		want   [4]ThresholdState
+	}{
+		{1, [4]ThresholdState{ThresholdDefined, ThresholdDefined, ThresholdDefined, ThresholdDefined}},
+		{9, [4]ThresholdState{ThresholdDefined, ThresholdDefined, ThresholdDefined, ThresholdDefined}},
+		{10, [4]ThresholdState{ThresholdStarted, ThresholdStarted, ThresholdDefined, ThresholdDefined}},
+		{19, [4]ThresholdState{ThresholdStarted, ThresholdStarted, ThresholdDefined, ThresholdDefined}},
+		{20, [4]ThresholdState{ThresholdLockedIn, ThresholdStarted, ThresholdFailed, ThresholdDefined}},
+		{29, [4]ThresholdState{ThresholdLockedIn, ThresholdStarted, ThresholdFailed, ThresholdDefined}},
+		{30, [4]ThresholdState{ThresholdActive, ThresholdFailed, ThresholdFailed, ThresholdDefined}},
+		{44, [4]ThresholdState{ThresholdActive, ThresholdFailed, ThresholdFailed, ThresholdDefined}},
+	}
+	for _, test := range tests {
+		for i := range deployments {
+			checker := deploymentChecker{deployment: &deployments[i], chain: b}
+			got := b.thresholdState(nodes[test.height-1], checker,
+				b.deploymentCache(deployments[i].Name))
+			if got != test.want[i] {
+				t.Errorf("height %d: deployment %s is %v, want %v",
+					test.height, deployments[i].Name, got, test.want[i])
+			}
+		}
+	}
+
+	// The states of the tip report since when they hold.
+	wantSince := map[string]struct {
+		state ThresholdState
+		since uint
+	}{
+		"voted":      {ThresholdActive, 30},
+		"undervoted": {ThresholdFailed, 30},
+		"expired":    {ThresholdFailed, 20},
+		"future":     {ThresholdDefined, 0},
+	}
+	states := b.DeploymentStates()
+	if len(states) != len(deployments) {
+		t.Fatalf("got %d deployment states, want %d", len(states),
+			len(deployments))
+	}
+	for _, state := range states {
+		want := wantSince[state.Name]
+		if state.State != want.state || state.Since != want.since {
+			t.Errorf("deployment %s is %v since %d, want %v since %d",
+				state.Name, state.State, state.Since, want.state,
+				want.since)
+		}
+	}
+	if active, err := b.IsDeploymentActive("voted"); err != nil || !active {
+		t.Errorf("IsDeploymentActive(voted): %v, %v", active, err)
+	}
+	if _, err := b.IsDeploymentActive("unknown"); err == nil {
+		t.Errorf("IsDeploymentActive accepted an unknown deployment")
+	}
+}
+
+// TestCalcNextBlockVersion ensures the next block version signals the
+// deployments which are started or locked in, and is the block version of the
+// chain otherwise.
+func TestCalcNextBlockVersion(t *testing.T) {
+	deployments := []params.ConsensusDeployment{
+		{Name: "voted", BitNumber: 1, StartTime: vbTestTime(0),
+			ExpireTime: vbTestTime(1000)},
+		{Name: "future", BitNumber: 4, StartTime: vbTestTime(1000),
+			ExpireTime: vbTestTime(2000)},
+	}
+	versions := make([]uint32, 35)
+	for i := range versions {
+		versions[i] = 12
+		if i >= 10 && i < 20 {
+			versions[i] |= vbTopBits | 1<<(vbBitsShift+1)
+		}
+	}
+	b, nodes := newVBTestChain(t, deployments, versions)
+
+	tests := []struct {
+		height int
+		want   uint32
+	}{
+		// Defined.
+		{5, 12},
+		// Started.
+		{10, 12 | vbTopBits | 1<<(vbBitsShift+1)},
+		// Locked in.
+		{25, 12 | vbTopBits | 1<<(vbBitsShift+1)},
+		// Active.
+		{30, 12},
+	}
+	for _, test := range tests {
+		got := b.calcNextBlockVersion(nodes[test.height-1])
+		if got != test.want {
+			t.Errorf("height %d: version %#x, want %#x", test.height,
+				got, test.want)
+		}
+		if !isDeploymentSignalled(got, 1) != (got == 12) {
+			t.Errorf("height %d: version %#x signals inconsistently",
+				test.height, got)
+		}
+	}
+	if got := b.CalcNextBlockVersion(); got != 12 {
+		t.Errorf("CalcNextBlockVersion: %#x, want 12", got)
+	}
+}
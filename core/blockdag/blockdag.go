@@ -164,6 +164,10 @@ type BlockDAG struct {
 	// The terminal block is in block dag,this block have not any connecting at present.
 	tips *IdSet
 
+	// The blocks left out of the order, which are the blocks invalidated by
+	// the operator and their future set.
+	excluded *IdSet
+
 	// This is time when the last block have added
 	lastTime time.Time
 
@@ -210,6 +214,7 @@ func (bd *BlockDAG) Init(dagType string, calcWeight CalcWeight, blockRate float6
 	if bd.blockRate < 0 {
 		bd.blockRate = anticone.DefaultBlockRate
 	}
+	bd.excluded = NewIdSet()
 	bd.instance = NewBlockDAG(dagType)
 	bd.instance.Init(bd)
 	return bd.instance
@@ -412,6 +417,51 @@ func (bd *BlockDAG) GetTipsList() []IBlock {
 	return result
 }
 
+// TipInfo describes a tip of the DAG.
+type TipInfo struct {
+	Hash  *hash.Hash
+	Layer uint
+	// Order is MaxBlockOrder while the tip is not ordered.
+	Order   uint
+	BlueNum uint
+	// MainChain is whether the tip is the main chain tip.
+	MainChain bool
+	// ValidTip is whether the tip is close enough to the layer of the main
+	// chain tip to be a parent of a new block.
+	ValidTip bool
+}
+
+// GetTipsInfo returns the information of the tips of the DAG, sorted by
+// increasing layer.
+func (bd *BlockDAG) GetTipsInfo() []*TipInfo {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	validTips := NewIdSet()
+	for _, ib := range bd.getValidTips(false) {
+		validTips.Add(ib.GetID())
+	}
+	result := []*TipInfo{}
+	for _, k := range bd.tips.SortList(false) {
+		ib := bd.getBlockById(k)
+		info := &TipInfo{
+			Hash:      ib.GetHash(),
+			Layer:     ib.GetLayer(),
+			Order:     ib.GetOrder(),
+			MainChain: bd.isOnMainChain(k),
+			ValidTip:  validTips.Has(k),
+		}
+		if pb, ok := ib.(*PhantomBlock); ok {
+			info.BlueNum = pb.GetBlueNum()
+		}
+		result = append(result, info)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Layer < result[j].Layer
+	})
+	return result
+}
+
 // build merkle tree form current DAG tips
 func (bd *BlockDAG) BuildMerkleTreeStoreFromTips() []*hash.Hash {
 	parents := bd.GetTips().SortList(false)
@@ -434,6 +484,77 @@ func (bd *BlockDAG) updateTips(b IBlock) {
 	bd.tips.AddPair(b.GetID(), b)
 }
 
+// getIncludedTips returns the tips of the DAG without the excluded blocks,
+// which are the blocks left whose children are all excluded.
+func (bd *BlockDAG) getIncludedTips() *IdSet {
+	if bd.excluded.IsEmpty() {
+		return bd.tips
+	}
+	tips := NewIdSet()
+	for k, v := range bd.tips.GetMap() {
+		if !bd.excluded.Has(k) {
+			tips.AddPair(k, v)
+		}
+	}
+	for k := range bd.excluded.GetMap() {
+		for pk, pv := range bd.getBlockById(k).GetParents().GetMap() {
+			if bd.excluded.Has(pk) || tips.Has(pk) {
+				continue
+			}
+			if bd.excluded.Contain(pv.(IBlock).GetChildren()) {
+				tips.AddPair(pk, pv)
+			}
+		}
+	}
+	return tips
+}
+
+// UpdateExcluded leaves the blocks of exclude out of the order and puts the
+// blocks of include back, then rebuilds the order of the blocks left.  The
+// excluded blocks must come with their future set, so the blocks left keep
+// their past and their colors, and the blocks added later on top of them are
+// excluded too.  It returns the hashes of the blocks which were ordered from
+// the first order that changed, in their old order, and the blocks ordered
+// from there now.  The blocks whose order or exclusion changed are stored,
+// with the main chain and the blocks of changed, in one transaction.
+func (bd *BlockDAG) UpdateExcluded(exclude []*hash.Hash, include []*hash.Hash, changed []*hash.Hash) ([]*hash.Hash, *list.List, error) {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	ph, ok := bd.instance.(*Phantom)
+	if !ok {
+		return nil, nil, fmt.Errorf("the %s dag can't leave blocks out of the order",
+			bd.instance.GetName())
+	}
+	toSet := func(hs []*hash.Hash) (*IdSet, error) {
+		result := NewIdSet()
+		for _, h := range hs {
+			ib := bd.getBlock(h)
+			if ib == nil {
+				return nil, fmt.Errorf("no block %v", h)
+			}
+			if ib.GetID() == 0 {
+				return nil, fmt.Errorf("the genesis can't be excluded")
+			}
+			result.AddPair(ib.GetID(), ib)
+		}
+		return result, nil
+	}
+	excludeSet, err := toSet(exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+	includeSet, err := toSet(include)
+	if err != nil {
+		return nil, nil, err
+	}
+	changedSet, err := toSet(changed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ph.updateExcluded(excludeSet, includeSet, changedSet)
+}
+
 // The last time is when add one block to DAG.
 func (bd *BlockDAG) GetLastTime() *time.Time {
 	bd.stateLock.Lock()
@@ -500,6 +621,24 @@ func (bd *BlockDAG) getFutureSet(fs *IdSet, b IBlock) {
 	}
 }
 
+// Returns the hashes of the blocks in the future set of the given block.
+func (bd *BlockDAG) GetFutureSet(h *hash.Hash) []*hash.Hash {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	ib := bd.getBlock(h)
+	if ib == nil {
+		return nil
+	}
+	fs := NewIdSet()
+	bd.getFutureSet(fs, ib)
+	result := make([]*hash.Hash, 0, fs.Size())
+	for _, v := range fs.GetMap() {
+		result = append(result, v.(IBlock).GetHash())
+	}
+	return result
+}
+
 // Query whether a given block is on the main chain.
 // Note that some DAG protocols may not support this feature.
 func (bd *BlockDAG) IsOnMainChain(id uint) bool {
@@ -710,6 +849,70 @@ func (bd *BlockDAG) getAnticone(b IBlock, exclude *IdSet) *IdSet {
 	return anticone
 }
 
+// GetAntiPast returns the smallest size of the future sets of the block of the
+// hash and of the blocks in its anticone, which is the number of blocks an
+// attacker has to outpace to reverse the block, along with the size of the
+// anticone. The future sets are walked up to max blocks: when the future set
+// of the block reaches max, max is returned and its anticone is not walked, so
+// its size is given as 0.
+func (bd *BlockDAG) GetAntiPast(h *hash.Hash, max int) (int, int, error) {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	ib := bd.getBlock(h)
+	if ib == nil {
+		return 0, 0, fmt.Errorf("No find block")
+	}
+	futureSet := NewIdSet()
+	bd.getBoundedFutureSet(futureSet, ib, max)
+	if futureSet.Size() >= max {
+		return max, 0, nil
+	}
+	anticone := NewIdSet()
+	bs := NewIdSet()
+	bs.AddPair(ib.GetID(), ib)
+	for _, v := range bd.tips.GetMap() {
+		bd.recAnticone(bs, futureSet, anticone, v.(IBlock))
+	}
+
+	antiPast := futureSet.Size()
+	for _, v := range anticone.GetMap() {
+		if antiPast == 0 {
+			break
+		}
+		fs := NewIdSet()
+		bd.getBoundedFutureSet(fs, v.(IBlock), antiPast)
+		if fs.Size() < antiPast {
+			antiPast = fs.Size()
+		}
+	}
+	return antiPast, anticone.Size(), nil
+}
+
+// getBoundedFutureSet adds the future set of the block to fs, stopping once fs
+// holds max blocks.
+func (bd *BlockDAG) getBoundedFutureSet(fs *IdSet, b IBlock, max int) {
+	queue := []IBlock{b}
+	for len(queue) > 0 && fs.Size() < max {
+		cur := queue[0]
+		queue = queue[1:]
+		children := cur.GetChildren()
+		if children == nil {
+			continue
+		}
+		for k, v := range children.GetMap() {
+			if fs.Has(k) {
+				continue
+			}
+			fs.AddPair(k, v)
+			if fs.Size() >= max {
+				return
+			}
+			queue = append(queue, v.(IBlock))
+		}
+	}
+}
+
 // getParentsAnticone
 func (bd *BlockDAG) getParentsAnticone(parents *IdSet) *IdSet {
 	anticone := NewIdSet()
@@ -978,7 +1181,7 @@ func (bd *BlockDAG) GetValidTips() []*hash.Hash {
 }
 
 func (bd *BlockDAG) getValidTips(limit bool) []IBlock {
-	temp := bd.tips.Clone()
+	temp := bd.getIncludedTips().Clone()
 	mainParent := bd.getMainChainTip()
 	temp.Remove(mainParent.GetID())
 	var parents []uint
@@ -1121,6 +1324,7 @@ func (bd *BlockDAG) Load(dbTx database.Tx, blockTotal uint, genesis *hash.Hash)
 	bd.blockTotal = blockTotal
 	bd.blocks = map[uint]IBlock{}
 	bd.tips = NewIdSet()
+	bd.excluded = NewIdSet()
 	return bd.instance.Load(dbTx)
 }
 
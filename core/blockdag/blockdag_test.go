@@ -154,11 +154,17 @@ func InitBlockDAG(dagType string, graph string) IBlockDAG {
 	} else {
 		return nil
 	}
+	return buildBlockDAG(dagType, tbd)
+}
+
+// Build the dag of the blocks data
+func buildBlockDAG(dagType string, tbd []TestBlocksData) IBlockDAG {
 	blen := len(tbd)
 	if blen < 2 {
 		return nil
 	}
 
+	var err error
 	var db database.DB
 	if dagType == phantom {
 		cfg := &config.Config{DbType: "ffldb", DataDir: "."}
@@ -170,6 +176,10 @@ func InitBlockDAG(dagType string, graph string) IBlockDAG {
 		db.Update(func(dbTx database.Tx) error {
 			meta := dbTx.Metadata()
 			_, err := meta.CreateBucket(dbnamespace.DagMainChainBucketName)
+			if err != nil {
+				return err
+			}
+			_, err = meta.CreateBucket(dbnamespace.BlockIndexBucketName)
 			return err
 		})
 	}
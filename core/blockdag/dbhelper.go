@@ -80,3 +80,35 @@ func DBRemoveMainChainBlock(dbTx database.Tx, id uint) error {
 	key := serializedID[:]
 	return bucket.Delete(key)
 }
+
+func DBPutExcludedBlock(dbTx database.Tx, id uint) error {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.DagExcludedBucketName)
+	var serializedID [4]byte
+	dbnamespace.ByteOrder.PutUint32(serializedID[:], uint32(id))
+
+	key := serializedID[:]
+	return bucket.Put(key, []byte{0})
+}
+
+func DBRemoveExcludedBlock(dbTx database.Tx, id uint) error {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.DagExcludedBucketName)
+	var serializedID [4]byte
+	dbnamespace.ByteOrder.PutUint32(serializedID[:], uint32(id))
+
+	key := serializedID[:]
+	return bucket.Delete(key)
+}
+
+// DBGetExcludedBlocks returns the ids of the blocks left out of the order.
+func DBGetExcludedBlocks(dbTx database.Tx) ([]uint, error) {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.DagExcludedBucketName)
+	if bucket == nil {
+		return nil, nil
+	}
+	ids := []uint{}
+	err := bucket.ForEach(func(k, v []byte) error {
+		ids = append(ids, uint(dbnamespace.ByteOrder.Uint32(k)))
+		return nil
+	})
+	return ids, err
+}
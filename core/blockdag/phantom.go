@@ -53,6 +53,12 @@ func (ph *Phantom) Init(bd *BlockDAG) bool {
 		var err error
 		if mchBucket == nil {
 			_, err = meta.CreateBucket(dbnamespace.DagMainChainBucketName)
+			if err != nil {
+				return err
+			}
+		}
+		if meta.Bucket(dbnamespace.DagExcludedBucketName) == nil {
+			_, err = meta.CreateBucket(dbnamespace.DagExcludedBucketName)
 		}
 		return err
 	})
@@ -74,11 +80,36 @@ func (ph *Phantom) AddBlock(ib IBlock) *list.List {
 	ph.updateBlockColor(pb)
 	ph.updateBlockOrder(pb)
 
-	changeBlock := ph.updateMainChain(ph.getBluest(ph.bd.tips), pb)
+	// The blocks built on excluded blocks are left out of the order too.
+	if pb.HasParents() {
+		for k := range pb.GetParents().GetMap() {
+			if ph.bd.excluded.Has(k) {
+				return ph.excludeBlock(pb)
+			}
+		}
+	}
+
+	changeBlock := ph.updateMainChain(ph.getBluest(ph.bd.getIncludedTips()), pb)
 	ph.preUpdateVirtualBlock()
 	return ph.getOrderChangeList(changeBlock)
 }
 
+// excludeBlock leaves a new block out of the order.
+func (ph *Phantom) excludeBlock(pb *PhantomBlock) *list.List {
+	ph.bd.excluded.AddPair(pb.GetID(), pb)
+	if ph.bd.db != nil {
+		err := ph.bd.db.Update(func(dbTx database.Tx) error {
+			return DBPutExcludedBlock(dbTx, pb.GetID())
+		})
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}
+	refNodes := list.New()
+	refNodes.PushBack(pb)
+	return refNodes
+}
+
 // Build self block
 func (ph *Phantom) CreateBlock(b *Block) IBlock {
 	return &PhantomBlock{b, 0, NewIdSet(), NewIdSet()}
@@ -359,22 +390,147 @@ func (ph *Phantom) updateMainOrder(path []uint, intersection uint) {
 	l := len(path)
 	for i := l - 1; i >= 0; i-- {
 		curBlock := ph.getBlock(path[i])
-		curBlock.SetOrder(startOrder + uint(curBlock.blueDiffAnticone.Size()+curBlock.redDiffAnticone.Size()+1))
-		ph.bd.order[curBlock.GetOrder()] = curBlock.GetID()
+		startOrder = ph.orderMainBlock(curBlock, startOrder)
 		ph.mainChain.Add(curBlock.GetID())
-		for k, v := range curBlock.blueDiffAnticone.GetMap() {
-			dab := ph.getBlock(k)
-			dab.SetOrder(startOrder + v.(uint))
-			ph.bd.order[dab.GetOrder()] = dab.GetID()
+	}
+	//
+}
+
+// orderMainBlock orders a block of the main chain after its diff anticone,
+// from the order of its main parent, and returns its order.
+func (ph *Phantom) orderMainBlock(curBlock *PhantomBlock, startOrder uint) uint {
+	curBlock.SetOrder(startOrder + uint(curBlock.blueDiffAnticone.Size()+curBlock.redDiffAnticone.Size()+1))
+	ph.bd.order[curBlock.GetOrder()] = curBlock.GetID()
+	for k, v := range curBlock.blueDiffAnticone.GetMap() {
+		dab := ph.getBlock(k)
+		dab.SetOrder(startOrder + v.(uint))
+		ph.bd.order[dab.GetOrder()] = dab.GetID()
+	}
+	for k, v := range curBlock.redDiffAnticone.GetMap() {
+		dab := ph.getBlock(k)
+		dab.SetOrder(startOrder + v.(uint))
+		ph.bd.order[dab.GetOrder()] = dab.GetID()
+	}
+	return curBlock.GetOrder()
+}
+
+// updateExcluded changes the excluded blocks and orders again the blocks
+// after the point where the main chain of the bluest tip left forks from the
+// current one.
+func (ph *Phantom) updateExcluded(exclude *IdSet, include *IdSet, changed *IdSet) ([]*hash.Hash, *list.List, error) {
+	oldExcluded := ph.bd.excluded.Clone()
+	oldTip := ph.mainChain.tip
+	oldDiffAnticone := ph.diffAnticone
+	oldVirtualOrder := ph.virtualBlock.GetOrder()
+	ph.bd.excluded.AddSet(exclude)
+	ph.bd.excluded.RemoveSet(include)
+
+	tip := ph.getBluest(ph.bd.getIncludedTips())
+	intersection, path := ph.getIntersectionPathWithMainChain(tip)
+	if intersection == MaxId {
+		ph.bd.excluded = oldExcluded
+		return nil, nil, fmt.Errorf("DAG can't find intersection")
+	}
+	removed := []uint{}
+	for cur := ph.getBlock(oldTip); cur.GetID() != intersection; cur = ph.getBlock(cur.mainParent) {
+		removed = append(removed, cur.GetID())
+	}
+
+	// The blocks ordered after the intersection lose their order, and are
+	// ordered again if they are in the past of the new tip.
+	fromOrder := ph.getBlock(intersection).GetOrder() + 1
+	lastOrder := ph.getBlock(oldTip).GetOrder()
+	old := []*hash.Hash{}
+	oldOrder := map[uint]uint{}
+	resetOrder := func() {
+		for order := fromOrder; ; order++ {
+			id, ok := ph.bd.order[order]
+			if !ok {
+				break
+			}
+			delete(ph.bd.order, order)
+			ib := ph.getBlock(id)
+			if ib.GetOrder() != order {
+				continue
+			}
+			if order <= lastOrder {
+				old = append(old, ib.GetHash())
+			}
+			oldOrder[order] = id
+			ib.SetOrder(MaxBlockOrder)
+		}
+	}
+	resetOrder()
+	startOrder := fromOrder - 1
+	for i := len(path) - 1; i >= 0; i-- {
+		startOrder = ph.orderMainBlock(ph.getBlock(path[i]), startOrder)
+	}
+	ph.mainChain.tip = tip.GetID()
+	ph.diffAnticone = ph.bd.getAnticone(tip, ph.bd.excluded)
+	ph.virtualBlock.SetOrder(MaxBlockOrder)
+	ph.preUpdateVirtualBlock()
+
+	newOrders := list.New()
+	stored := changed.Clone()
+	stored.AddSet(exclude)
+	stored.AddSet(include)
+	for _, id := range oldOrder {
+		stored.Add(id)
+	}
+	for order := fromOrder; order <= tip.GetOrder(); order++ {
+		ib := ph.getBlock(ph.bd.order[order])
+		newOrders.PushBack(ib)
+		stored.Add(ib.GetID())
+	}
+	err := ph.bd.db.Update(func(dbTx database.Tx) error {
+		for _, id := range removed {
+			err := DBRemoveMainChainBlock(dbTx, id)
+			if err != nil {
+				return err
+			}
+		}
+		for _, id := range path {
+			err := DBPutMainChainBlock(dbTx, id)
+			if err != nil {
+				return err
+			}
+		}
+		for k := range exclude.GetMap() {
+			err := DBPutExcludedBlock(dbTx, k)
+			if err != nil {
+				return err
+			}
+		}
+		for k := range include.GetMap() {
+			err := DBRemoveExcludedBlock(dbTx, k)
+			if err != nil {
+				return err
+			}
 		}
-		for k, v := range curBlock.redDiffAnticone.GetMap() {
-			dab := ph.getBlock(k)
-			dab.SetOrder(startOrder + v.(uint))
-			ph.bd.order[dab.GetOrder()] = dab.GetID()
+		for k := range stored.GetMap() {
+			err := DBPutDAGBlock(dbTx, ph.getBlock(k))
+			if err != nil {
+				return err
+			}
 		}
-		startOrder = curBlock.GetOrder()
+		return nil
+	})
+	if err != nil {
+		// Put the old order back.
+		restored := oldOrder
+		oldOrder = map[uint]uint{}
+		resetOrder()
+		for order, id := range restored {
+			ph.getBlock(id).SetOrder(order)
+			ph.bd.order[order] = id
+		}
+		ph.bd.excluded = oldExcluded
+		ph.mainChain.tip = oldTip
+		ph.diffAnticone = oldDiffAnticone
+		ph.virtualBlock.SetOrder(oldVirtualOrder)
+		return nil, nil, err
 	}
-	//
+	return old, newOrders, nil
 }
 
 func (ph *Phantom) UpdateVirtualBlockOrder() *PhantomBlock {
@@ -384,7 +540,7 @@ func (ph *Phantom) UpdateVirtualBlockOrder() *PhantomBlock {
 	}
 	ph.virtualBlock.parents = NewIdSet()
 	var maxLayer uint = 0
-	for k := range ph.bd.tips.GetMap() {
+	for k := range ph.bd.getIncludedTips().GetMap() {
 		parent := ph.bd.getBlockById(k)
 		ph.virtualBlock.parents.AddPair(k, parent)
 
@@ -499,7 +655,7 @@ func (ph *Phantom) getOrderChangeList(pb *PhantomBlock) *list.List {
 		return refNodes
 	}
 	if pb != nil {
-		tips := ph.bd.tips
+		tips := ph.bd.getIncludedTips()
 		if tips.HasOnly(pb.GetID()) {
 			refNodes.PushBack(pb)
 			return refNodes
@@ -575,6 +731,12 @@ func (ph *Phantom) Load(dbTx database.Tx) error {
 
 	ph.mainChain.genesis = 0
 
+	excluded, err := DBGetExcludedBlocks(dbTx)
+	if err != nil {
+		return err
+	}
+	ph.bd.excluded.AddList(excluded)
+
 	for i := uint(0); i < ph.bd.blockTotal; i++ {
 		block := Block{id: i}
 		ib := ph.CreateBlock(&block)
@@ -602,12 +764,14 @@ func (ph *Phantom) Load(dbTx database.Tx) error {
 		//
 		ph.bd.order[ib.GetOrder()] = ib.GetID()
 
-		if !ib.IsOrdered() {
+		if ph.bd.excluded.Has(ib.GetID()) {
+			ph.bd.excluded.AddPair(ib.GetID(), ib)
+		} else if !ib.IsOrdered() {
 			ph.diffAnticone.AddPair(ib.GetID(), ib)
 		}
 	}
 
-	ph.mainChain.tip = ph.GetMainParent(ph.bd.tips).GetID()
+	ph.mainChain.tip = ph.GetMainParent(ph.bd.getIncludedTips()).GetID()
 	return nil
 }
 
@@ -2,6 +2,7 @@ package blockdag
 
 import (
 	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
 	_ "github.com/btceasypay/bitcoinpay/database/ffldb"
 	"strconv"
 	"testing"
@@ -193,6 +194,73 @@ func Test_LocateMaxBlocks(t *testing.T) {
 	}
 }
 
+func Test_GetTipsInfo(t *testing.T) {
+	ibd := InitBlockDAG(phantom, "PH_fig2-blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	tips := bd.GetTips()
+	infos := bd.GetTipsInfo()
+	if len(infos) != tips.Size() {
+		t.Fatalf("got %d tips info, want %d", len(infos), tips.Size())
+	}
+	mainTip := bd.GetMainChainTip()
+	for i, info := range infos {
+		if !tips.Has(info.Hash) {
+			t.Fatalf("%v is not a tip", info.Hash)
+		}
+		if i > 0 && info.Layer < infos[i-1].Layer {
+			t.Fatalf("tips are not sorted by layer")
+		}
+		if info.MainChain != info.Hash.IsEqual(mainTip.GetHash()) {
+			t.Fatalf("wrong main chain status of %v", info.Hash)
+		}
+		if info.MainChain && !info.ValidTip {
+			t.Fatalf("the main chain tip is not a valid tip")
+		}
+	}
+}
+
+func Test_GetAntiPast(t *testing.T) {
+	ibd := InitBlockDAG(phantom, "PH_fig2-blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	anBlock := tbMap[testData.PH_GetAnticone.Input]
+	antiPast, anticoneSize, err := bd.GetAntiPast(anBlock.GetHash(), len(tbMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anticone := bd.getAnticone(anBlock, nil)
+	if anticoneSize != anticone.Size() {
+		t.Fatalf("got anticone of %d blocks, want %d", anticoneSize, anticone.Size())
+	}
+	futureSet := NewIdSet()
+	bd.getFutureSet(futureSet, anBlock)
+	want := futureSet.Size()
+	for _, v := range anticone.GetMap() {
+		fs := NewIdSet()
+		bd.getFutureSet(fs, v.(IBlock))
+		if fs.Size() < want {
+			want = fs.Size()
+		}
+	}
+	if antiPast != want {
+		t.Fatalf("got anti past %d, want %d", antiPast, want)
+	}
+
+	// The walk stops at the bound.
+	if futureSet.Size() > 1 {
+		antiPast, _, err = bd.GetAntiPast(anBlock.GetHash(), futureSet.Size()-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if antiPast != futureSet.Size()-1 {
+			t.Fatalf("got bounded anti past %d, want %d", antiPast, futureSet.Size()-1)
+		}
+	}
+}
+
 func Test_Confirmations(t *testing.T) {
 	ibd := InitBlockDAG(phantom, "PH_fig2-blocks")
 	if ibd == nil {
@@ -284,3 +352,103 @@ func Test_GetBlockConcurrency(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func Test_UpdateExcluded(t *testing.T) {
+	if InitBlockDAG(phantom, "PH_fig2-blocks") == nil {
+		t.FailNow()
+	}
+	orderTags := func(ph *Phantom) []string {
+		ph.UpdateVirtualBlockOrder()
+		tags := []string{}
+		for i := uint(0); ; i++ {
+			id, ok := bd.order[i]
+			if !ok || bd.getBlockById(id).GetOrder() != i {
+				break
+			}
+			tags = append(tags, getBlockTag(id))
+		}
+		return tags
+	}
+
+	// The DAG without G and its future set.
+	blocks := []TestBlocksData{}
+	for _, b := range testData.PH_Fig2Blocks {
+		if b.Tag != "G" && b.Tag != "J" {
+			blocks = append(blocks, b)
+		}
+	}
+	ibd := buildBlockDAG(phantom, blocks)
+	if ibd == nil {
+		t.FailNow()
+	}
+	expected := orderTags(ibd.(*Phantom))
+	expectedTip := getBlockTag(bd.GetMainChainTip().GetID())
+
+	// The whole DAG with a block L on J and K.
+	blocks = append([]TestBlocksData{}, testData.PH_Fig2Blocks...)
+	blocks = append(blocks, TestBlocksData{Tag: "L", Parents: []string{"J", "K"}})
+	ibd = buildBlockDAG(phantom, blocks)
+	if ibd == nil {
+		t.FailNow()
+	}
+	expectedAll := orderTags(ibd.(*Phantom))
+
+	ibd = InitBlockDAG(phantom, "PH_fig2-blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	ph := ibd.(*Phantom)
+	excluded := []*hash.Hash{tbMap["G"].GetHash(), tbMap["J"].GetHash()}
+	old, newOrders, err := bd.UpdateExcluded(excluded, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old) == 0 || newOrders.Len() == 0 {
+		t.Fatalf("the order didn't change: %d %d", len(old), newOrders.Len())
+	}
+	if tbMap["G"].IsOrdered() || tbMap["J"].IsOrdered() {
+		t.Fatalf("the excluded blocks are ordered")
+	}
+	if tip := getBlockTag(bd.GetMainChainTip().GetID()); tip != expectedTip {
+		t.Fatalf("main chain tip %s, expected %s", tip, expectedTip)
+	}
+	if bd.IsOnMainChain(tbMap["J"].GetID()) {
+		t.Fatalf("the excluded block J is on the main chain")
+	}
+	for _, h := range bd.GetValidTips() {
+		if h.IsEqual(tbMap["G"].GetHash()) || h.IsEqual(tbMap["J"].GetHash()) {
+			t.Fatalf("the excluded block %v is a tip", h)
+		}
+	}
+	if order := orderTags(ph); fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Fatalf("order %v, expected %v", order, expected)
+	}
+
+	// The blocks built on the excluded blocks are excluded too.
+	parents := NewIdSet()
+	parents.AddList([]uint{tbMap["J"].GetID(), tbMap["K"].GetID()})
+	l, ib := bd.AddBlock(buildBlock(parents))
+	if l == nil || l.Len() != 1 || ib.IsOrdered() || !bd.excluded.Has(ib.GetID()) {
+		t.Fatalf("the block built on an excluded block is not excluded")
+	}
+	tbMap["L"] = ib
+
+	// Putting the blocks back gives the order of the whole DAG.
+	_, _, err = bd.UpdateExcluded(nil, excluded, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bd.excluded.Has(ib.GetID()) {
+		t.Fatalf("the block L is not excluded")
+	}
+	_, _, err = bd.UpdateExcluded(nil, []*hash.Hash{ib.GetHash()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bd.excluded.IsEmpty() {
+		t.Fatalf("blocks are still excluded")
+	}
+	if order := orderTags(ph); fmt.Sprint(order) != fmt.Sprint(expectedAll) {
+		t.Fatalf("order %v, expected %v", order, expectedAll)
+	}
+}
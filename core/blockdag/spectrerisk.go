@@ -19,9 +19,18 @@ const tol = 1e-10
 // antiPast: min(|future(x')|), where x' is x or any block in anticone(x)
 // and x is the block we want to confirm, ideally this should be
 // about waitingTime * lambda.
-func GetRisk(N int, alpha float64, lambda float64, delay float64, waitingTime uint, antiPast int) float64 {
-	if N < 3 || antiPast <= 0 {
-		return 0
+// An error is returned when the risk can't be computed, which callers must not
+// take for a low risk. A block without anti-past is not confirmed, so its risk
+// is 1.
+func GetRisk(N int, alpha float64, lambda float64, delay float64, waitingTime uint, antiPast int) (float64, error) {
+	if N < 3 {
+		return 1, fmt.Errorf("the number of states %d is less than 3", N)
+	}
+	if alpha <= 0 || alpha >= 0.5 {
+		return 1, fmt.Errorf("the attacker power %v is not between 0 and 0.5", alpha)
+	}
+	if antiPast <= 0 {
+		return 1, nil
 	}
 	delta := alpha * lambda * delay
 
@@ -47,7 +56,7 @@ func GetRisk(N int, alpha float64, lambda float64, delay float64, waitingTime ui
 	var eig mat.Eigen
 	ok := eig.Factorize(tMat, mat.EigenLeft)
 	if !ok {
-		fmt.Println("Eigendecomposition failed")
+		return 1, fmt.Errorf("eigendecomposition failed")
 	}
 
 	ceigenvalues := eig.Values(nil)
@@ -65,8 +74,7 @@ func GetRisk(N int, alpha float64, lambda float64, delay float64, waitingTime ui
 		break
 	}
 	if featuresIndex == -1 {
-		fmt.Println("eigen vector failed")
-		return 0
+		return 1, fmt.Errorf("no stationary eigenvector")
 	}
 	ceigenvectors := eig.LeftVectorsTo(nil)
 	r, _ := ceigenvectors.Dims()
@@ -77,6 +85,9 @@ func GetRisk(N int, alpha float64, lambda float64, delay float64, waitingTime ui
 		vecData = append(vecData, realData)
 		vecMod += realData
 	}
+	if vecMod == 0 {
+		return 1, fmt.Errorf("no stationary eigenvector")
+	}
 	vecRMod := 1 / vecMod
 	vect := mat.NewVecDense(r, vecData)
 	vect.ScaleVec(vecRMod, vect)
@@ -95,5 +106,9 @@ func GetRisk(N int, alpha float64, lambda float64, delay float64, waitingTime ui
 		sum_m += 1 - pa.CDF(float64(mj))
 		riskHidden += vect.AtVec(i) * sum_m
 	}
-	return riskHidden
+	if math.IsNaN(riskHidden) || math.IsInf(riskHidden, 0) {
+		return 1, fmt.Errorf("the risk is not a number")
+	}
+	// Rounding errors may take the probability slightly out of range.
+	return math.Min(math.Max(riskHidden, 0), 1), nil
 }
@@ -6,7 +6,25 @@ import (
 )
 
 func TestOnlineRiskInSpectre(t *testing.T) {
-	if floats.EqualWithinAbs(GetRisk(300, 0.1, 10, 5, 10, 30), 0.1509544, tol) {
-		t.FailNow()
+	risk, err := GetRisk(300, 0.1, 10, 5, 10, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !floats.EqualWithinAbs(risk, 0.1509544, 1e-7) {
+		t.Fatalf("risk %v, want 0.1509544", risk)
+	}
+}
+
+// TestRiskFailure ensures the risk is never reported low when it can't be
+// computed.
+func TestRiskFailure(t *testing.T) {
+	if risk, err := GetRisk(300, 0.1, 10, 5, 10, 0); err != nil || risk != 1 {
+		t.Fatalf("a block without anti-past has the risk %v, %v", risk, err)
+	}
+	if risk, err := GetRisk(2, 0.1, 10, 5, 10, 30); err == nil || risk != 1 {
+		t.Fatalf("too few states gave the risk %v", risk)
+	}
+	if risk, err := GetRisk(300, 0.6, 10, 5, 10, 30); err == nil || risk != 1 {
+		t.Fatalf("a majority attacker gave the risk %v", risk)
 	}
 }
@@ -56,6 +56,31 @@ var (
 	// unspent transaction output set.
 	UtxoSetBucketName = []byte("utxoset")
 
+	// UtxoTrieBucketName is the name of the db bucket used to house the
+	// nodes of the trie of the unspent transaction output set.
+	UtxoTrieBucketName = []byte("utxotrie")
+
+	// UtxoTrieRootKeyName is the name of the db key used to store the root
+	// of the trie of the unspent transaction output set.
+	UtxoTrieRootKeyName = []byte("utxotrieroot")
+
+	// UtxoStateKeyName is the name of the db key used to store the block
+	// the unspent transaction output set was last written for.
+	UtxoStateKeyName = []byte("utxostate")
+
+	// UtxoSnapshotKeyName is the name of the db key used to store the
+	// utxo set snapshot the node was started from until it is validated.
+	UtxoSnapshotKeyName = []byte("utxosnapshot")
+
+	// UtxoSnapshotFeesBucketName is the name of the db bucket used to house
+	// the fees of the blocks before the utxo set snapshot.
+	UtxoSnapshotFeesBucketName = []byte("utxosnapshotfees")
+
+	// UtxoSnapshotCheckBucketName is the name of the db bucket used to
+	// house the unspent transaction output set built from the blocks to
+	// validate the utxo set snapshot.
+	UtxoSnapshotCheckBucketName = []byte("utxosnapshotcheck")
+
 	// BlockIndexBucketName is the name of the db bucket used to house the
 	// block which consists of metadata for all known blocks in DAG.
 	BlockIndexBucketName = []byte("blockidx")
@@ -71,6 +96,14 @@ var (
 	// CacheInvalidTx is the name of the db bucket used to cache invalid tx
 	CacheInvalidTxName = []byte("cacheinvalidtx")
 
+	// FeeEstimationKeyName is the name of the db key used to store the
+	// statistics of the fee estimator.
+	FeeEstimationKeyName = []byte("estimatefee")
+
 	// DAG Main Chain Blocks
 	DagMainChainBucketName = []byte("dagmainchain")
+
+	// DagExcludedBucketName is the name of the db bucket used to house the
+	// blocks left out of the order of the dag.
+	DagExcludedBucketName = []byte("dagexcluded")
 )
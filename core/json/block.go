@@ -50,3 +50,118 @@ type GetBlockHeaderVerboseResult struct {
 	Time          int64     `json:"time"`
 	PowResult     PowResult `json:"pow"`
 }
+
+// DeploymentInfo models a consensus deployment of the getdeploymentinfo
+// command.
+type DeploymentInfo struct {
+	Name       string `json:"name"`
+	Bit        uint8  `json:"bit"`
+	StartTime  uint64 `json:"starttime"`
+	ExpireTime uint64 `json:"expiretime"`
+	Status     string `json:"status"`
+	Since      uint   `json:"since"`
+}
+
+// GetDeploymentInfoResult models the data from the getdeploymentinfo command.
+type GetDeploymentInfoResult struct {
+	Hash                          string           `json:"hash"`
+	Height                        uint             `json:"height"`
+	NextBlockVersion              uint32           `json:"nextblockversion"`
+	RuleChangeActivationThreshold uint32           `json:"rulechangeactivationthreshold"`
+	MinerConfirmationWindow       uint32           `json:"minerconfirmationwindow"`
+	Deployments                   []DeploymentInfo `json:"deployments"`
+}
+
+// GetUtxoProofResult models the data from the getutxoproof command.
+type GetUtxoProofResult struct {
+	StateRoot string   `json:"stateroot"`
+	Txid      string   `json:"txid"`
+	Vout      uint32   `json:"vout"`
+	Key       string   `json:"key"`
+	Unspent   bool     `json:"unspent"`
+	Value     string   `json:"value,omitempty"`
+	Proof     []string `json:"proof"`
+}
+
+// DumpUtxoSetResult models the data from the dumputxoset command.
+type DumpUtxoSetResult struct {
+	Path        string `json:"path"`
+	Order       uint64 `json:"order"`
+	Hash        string `json:"hash"`
+	Count       uint64 `json:"count"`
+	ContentHash string `json:"contenthash"`
+}
+
+// DagTip models a tip of the getdagtips command.
+type DagTip struct {
+	Hash        string   `json:"hash"`
+	Layer       uint     `json:"layer"`
+	Order       *uint64  `json:"order,omitempty"`
+	Blues       uint     `json:"blues"`
+	MainChain   bool     `json:"mainchain"`
+	ValidTip    bool     `json:"validtip"`
+	MiningTip   bool     `json:"miningtip"`
+	HeadersOnly bool     `json:"headersonly"`
+	Orphans     []string `json:"orphans"`
+}
+
+// GetDagTipsResult models the data from the getdagtips command.
+type GetDagTipsResult struct {
+	MainOrder     uint     `json:"mainorder"`
+	MainLayer     uint     `json:"mainlayer"`
+	Tips          []DagTip `json:"tips"`
+	OrphanParents []string `json:"orphanparents"`
+}
+
+// GetBlockRiskResult models the data from the getblockrisk command.
+type GetBlockRiskResult struct {
+	Hash        string  `json:"hash"`
+	Alpha       float64 `json:"alpha"`
+	AntiPast    int     `json:"antipast"`
+	Anticone    int     `json:"anticone"`
+	WaitingTime uint    `json:"waitingtime"`
+	Risk        float64 `json:"risk"`
+}
+
+// GetDifficultyResult models the data from the getdifficulty command.
+type GetDifficultyResult struct {
+	PowType        uint8   `json:"powtype"`
+	PowName        string  `json:"powname"`
+	Bits           string  `json:"bits"`
+	Target         string  `json:"target"`
+	Difficulty     float64 `json:"difficulty"`
+	NextBits       string  `json:"nextbits"`
+	NextTarget     string  `json:"nexttarget"`
+	NextDifficulty float64 `json:"nextdifficulty"`
+	TargetPercent  int     `json:"targetpercent"`
+	Available      bool    `json:"available"`
+}
+
+// PowStatsResult models the statistics of a proof of work in the results of
+// the getnetworkhashrate and getpowdistribution commands.
+type PowStatsResult struct {
+	PowType       uint8   `json:"powtype"`
+	PowName       string  `json:"powname"`
+	Blocks        int64   `json:"blocks"`
+	Percent       float64 `json:"percent"`
+	TargetPercent int     `json:"targetpercent"`
+	HashRate      float64 `json:"hashrate"`
+	Unit          string  `json:"unit"`
+}
+
+// GetNetworkHashrateResult models the data from the getnetworkhashrate
+// command.
+type GetNetworkHashrateResult struct {
+	Window   int64 `json:"window"`
+	Timespan int64 `json:"timespan"`
+	PowStatsResult
+}
+
+// GetPowDistributionResult models the data from the getpowdistribution
+// command.
+type GetPowDistributionResult struct {
+	Window   int64            `json:"window"`
+	Blocks   int64            `json:"blocks"`
+	Timespan int64            `json:"timespan"`
+	Pows     []PowStatsResult `json:"pows"`
+}
@@ -12,6 +12,10 @@ type PowDiffReference struct {
 	X8r16Target            string `json:"x8r16_target"`
 	BitcoinpayKeccak256Bits   string `json:"bitcoinpay_keccak256_bits"`
 	BitcoinpayKeccak256Target string `json:"bitcoinpay_keccak256_target"`
+	ProgpowBits            string `json:"progpow_bits"`
+	ProgpowTarget          string `json:"progpow_target"`
+	CryptonightBits        string `json:"cryptonight_bits"`
+	CryptonightTarget      string `json:"cryptonight_target"`
 
 	//cuckoo mining min diff
 	CuckarooMinDiff  uint64 `json:"cuckaroo_min_diff,omitempty"`
@@ -110,3 +114,22 @@ type GetBlockTemplateResult struct {
 	Capabilities  []string `json:"capabilities,omitempty"`
 	RejectReasion string   `json:"reject-reason,omitempty"`
 }
+
+// MiningPowResult models the workers of a proof of work in the result of the
+// getmininginfo command.
+type MiningPowResult struct {
+	PowType      uint8   `json:"powtype"`
+	PowName      string  `json:"powname"`
+	Workers      int32   `json:"workers"`
+	HashesPerSec float64 `json:"hashespersec"`
+}
+
+// GetMiningInfoResult models the data from the getmininginfo command.
+type GetMiningInfoResult struct {
+	Blocks       uint              `json:"blocks"`
+	Generate     bool              `json:"generate"`
+	Workers      int32             `json:"workers"`
+	HashesPerSec float64           `json:"hashespersec"`
+	Pows         []MiningPowResult `json:"pows"`
+	MiningAddrs  []string          `json:"miningaddrs"`
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package json
+
+// BlockNotifyResult models the data pushed to the subscribers of new blocks
+// and of DAG order changes.
+type BlockNotifyResult struct {
+	Hash          string `json:"hash"`
+	Order         uint64 `json:"order"`
+	IsOrdered     bool   `json:"isordered"`
+	Height        uint64 `json:"height"`
+	Layer         uint64 `json:"layer"`
+	IsBlue        bool   `json:"isblue"`
+	IsOnMainChain bool   `json:"mainchain"`
+	Txs           int    `json:"txs"`
+	Connected     bool   `json:"connected"`
+}
+
+// TxNotifyResult models the data pushed to the subscribers of transactions.
+// The block fields are only set when the transaction was connected by a block,
+// otherwise the transaction has just been accepted into the mempool.
+type TxNotifyResult struct {
+	Txid       string   `json:"txid"`
+	Size       int      `json:"size"`
+	Fee        int64    `json:"fee,omitempty"`
+	FeePerKB   int64    `json:"feeperkb,omitempty"`
+	Time       int64    `json:"time,omitempty"`
+	Addresses  []string `json:"addresses,omitempty"`
+	BlockHash  string   `json:"blockhash,omitempty"`
+	BlockOrder uint64   `json:"blockorder,omitempty"`
+}
@@ -6,24 +6,25 @@ import "encoding/json"
 
 // TxRawResult models the data from the getrawtransaction command.
 type TxRawResult struct {
-	Hex           string `json:"hex"`
-	Txid          string `json:"txid"`
-	TxHash        string `json:"txhash,omitempty"`
-	Size          int32  `json:"size,omitempty"`
-	Version       uint32 `json:"version"`
-	LockTime      uint32 `json:"locktime"`
-	Timestamp     string `json:"timestamp,omitempty"`
-	Expire        uint32 `json:"expire"`
-	Vin           []Vin  `json:"vin"`
-	Vout          []Vout `json:"vout"`
-	BlockHash     string `json:"blockhash,omitempty"`
-	BlockOrder    uint64 `json:"blockorder,omitempty"`
-	TxIndex       uint32 `json:"txindex,omitempty"`
-	Confirmations int64  `json:"confirmations"`
-	Time          int64  `json:"time,omitempty"`
-	Blocktime     int64  `json:"blocktime,omitempty"`
-	Duplicate     bool   `json:"duplicate,omitempty"`
-	Txsvalid      bool   `json:"txsvalid"`
+	Hex           string   `json:"hex"`
+	Txid          string   `json:"txid"`
+	TxHash        string   `json:"txhash,omitempty"`
+	Size          int32    `json:"size,omitempty"`
+	Version       uint32   `json:"version"`
+	LockTime      uint32   `json:"locktime"`
+	Timestamp     string   `json:"timestamp,omitempty"`
+	Expire        uint32   `json:"expire"`
+	Vin           []Vin    `json:"vin"`
+	Vout          []Vout   `json:"vout"`
+	BlockHash     string   `json:"blockhash,omitempty"`
+	BlockOrder    uint64   `json:"blockorder,omitempty"`
+	TxIndex       uint32   `json:"txindex,omitempty"`
+	Confirmations int64    `json:"confirmations"`
+	Time          int64    `json:"time,omitempty"`
+	Blocktime     int64    `json:"blocktime,omitempty"`
+	Duplicate     bool     `json:"duplicate,omitempty"`
+	Txsvalid      bool     `json:"txsvalid"`
+	Risk          *float64 `json:"risk,omitempty"`
 }
 
 // Vin models parts of the tx data.  It is defined separately since
@@ -104,6 +105,27 @@ type GetUtxoResult struct {
 	Coinbase      bool               `json:"coinbase"`
 }
 
+// GetTxOutSetInfoResult models the data from the gettxoutsetinfo command.
+type GetTxOutSetInfoResult struct {
+	BestBlock      string                       `json:"bestblock"`
+	Order          uint64                       `json:"order"`
+	Transactions   uint64                       `json:"transactions"`
+	TxOuts         uint64                       `json:"txouts"`
+	SerializedSize uint64                       `json:"serializedsize"`
+	HashSerialized string                       `json:"hashserialized"`
+	TotalAmount    uint64                       `json:"totalamount"`
+	GenesisSubsidy uint64                       `json:"genesissubsidy"`
+	TotalSubsidy   uint64                       `json:"totalsubsidy"`
+	ScriptClasses  map[string]TxOutSetClassInfo `json:"scriptclasses"`
+}
+
+// TxOutSetClassInfo models the utxos of a script class of the
+// gettxoutsetinfo command.
+type TxOutSetClassInfo struct {
+	TxOuts uint64 `json:"txouts"`
+	Amount uint64 `json:"amount"`
+}
+
 // GetRawTransactionsResult models the data from the getrawtransactions
 // command.
 type GetRawTransactionsResult struct {
@@ -135,3 +157,67 @@ type PrevOut struct {
 	Addresses []string `json:"addresses,omitempty"`
 	Value     float64  `json:"value"`
 }
+
+// EstimateSmartFeeResult models the data returned by the estimateSmartFee
+// command.  The fee rate is in atoms/kB.
+type EstimateSmartFeeResult struct {
+	FeeRate int64    `json:"feerate,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+	Blocks  uint32   `json:"blocks"`
+}
+
+// GetTxRiskResult models the data from the gettxrisk and waitforconfidence
+// commands.
+type GetTxRiskResult struct {
+	Txid        string  `json:"txid"`
+	BlockHash   string  `json:"blockhash,omitempty"`
+	Alpha       float64 `json:"alpha"`
+	AntiPast    int     `json:"antipast"`
+	Anticone    int     `json:"anticone"`
+	WaitingTime uint    `json:"waitingtime"`
+	Risk        float64 `json:"risk"`
+	Confident   bool    `json:"confident,omitempty"`
+}
+
+// GetMempoolInfoResult models the data from the getmempoolinfo command.
+type GetMempoolInfoResult struct {
+	Size          int   `json:"size"`
+	Bytes         int64 `json:"bytes"`
+	Usage         int64 `json:"usage"`
+	MaxMempool    int64 `json:"maxmempool"`
+	MempoolMinFee int64 `json:"mempoolminfee"`
+	MinRelayTxFee int64 `json:"minrelaytxfee"`
+	Orphans       int   `json:"orphans"`
+}
+
+// GetMempoolEntryResult models the data from the getmempoolentry command and
+// the verbose getmempoolancestors and getmempooldescendants commands.
+type GetMempoolEntryResult struct {
+	Size             int32    `json:"size"`
+	Fee              int64    `json:"fee"`
+	FeePerKB         int64    `json:"feeperkb"`
+	Time             int64    `json:"time"`
+	Height           int64    `json:"height"`
+	StartingPriority float64  `json:"startingpriority"`
+	CurrentPriority  float64  `json:"currentpriority"`
+	AncestorCount    int      `json:"ancestorcount"`
+	AncestorSize     int64    `json:"ancestorsize"`
+	AncestorFees     int64    `json:"ancestorfees"`
+	DescendantCount  int      `json:"descendantcount"`
+	DescendantSize   int64    `json:"descendantsize"`
+	DescendantFees   int64    `json:"descendantfees"`
+	Depends          []string `json:"depends"`
+	SpentBy          []string `json:"spentby"`
+}
+
+// TestMempoolAcceptResult models the data from the testmempoolaccept command.
+type TestMempoolAcceptResult struct {
+	Txid           string   `json:"txid"`
+	Allowed        bool     `json:"allowed"`
+	RejectReason   string   `json:"rejectreason,omitempty"`
+	MissingParents []string `json:"missingparents,omitempty"`
+	Size           int32    `json:"size,omitempty"`
+	Fee            int64    `json:"fee,omitempty"`
+	FeePerKB       int64    `json:"feeperkb,omitempty"`
+	Priority       float64  `json:"priority,omitempty"`
+}
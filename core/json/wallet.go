@@ -0,0 +1,23 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package json
+
+// ListUnspentResult models a wallet unspent output returned by listUnspent.
+type ListUnspentResult struct {
+	Txid          string `json:"txid"`
+	Vout          uint32 `json:"vout"`
+	Address       string `json:"address"`
+	Account       string `json:"account"`
+	Amount        uint64 `json:"amount"`
+	ScriptPubKey  string `json:"scriptPubKey"`
+	Confirmations uint   `json:"confirmations"`
+	Coinbase      bool   `json:"coinbase"`
+	Spendable     bool   `json:"spendable"`
+}
+
+// AccountResult models an account of the wallet returned by listAccounts.
+type AccountResult struct {
+	Name    string `json:"name"`
+	Index   uint32 `json:"index"`
+	Balance uint64 `json:"balance"`
+}
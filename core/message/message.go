@@ -70,6 +70,10 @@ const (
 	CmdCFilter      = "cfilter"
 	CmdCFHeaders    = "cfheaders"
 	CmdCFTypes      = "cftypes"
+	CmdFilterLoad   = "filterload"
+	CmdFilterAdd    = "filteradd"
+	CmdFilterClear  = "filterclear"
+	CmdMerkleBlock  = "merkleblock"
 )
 
 // Message is an interface that describes a Bitcoinpay message.  A type that
@@ -134,29 +138,29 @@ func makeEmptyMessage(command string) (Message, error) {
 		msg = &MsgSyncPoint{}
 	case CmdFeeFilter:
 		msg = &MsgFeeFilter{}
+	case CmdGetCFilter:
+		msg = &MsgGetCFilter{}
+	case CmdGetCFHeaders:
+		msg = &MsgGetCFHeaders{}
+	case CmdGetCFTypes:
+		msg = &MsgGetCFTypes{}
+	case CmdCFilter:
+		msg = &MsgCFilter{}
+	case CmdCFHeaders:
+		msg = &MsgCFHeaders{}
+	case CmdCFTypes:
+		msg = &MsgCFTypes{}
+	case CmdFilterLoad:
+		msg = &MsgFilterLoad{}
+	case CmdFilterAdd:
+		msg = &MsgFilterAdd{}
+	case CmdFilterClear:
+		msg = &MsgFilterClear{}
+	case CmdMerkleBlock:
+		msg = &MsgMerkleBlock{}
 	/*
 		case CmdSendHeaders:
 			msg = &MsgSendHeaders{}
-
-
-
-		case CmdGetCFilter:
-			msg = &MsgGetCFilter{}
-
-		case CmdGetCFHeaders:
-			msg = &MsgGetCFHeaders{}
-
-		case CmdGetCFTypes:
-			msg = &MsgGetCFTypes{}
-
-		case CmdCFilter:
-			msg = &MsgCFilter{}
-
-		case CmdCFHeaders:
-			msg = &MsgCFHeaders{}
-
-		case CmdCFTypes:
-			msg = &MsgCFTypes{}
 	*/
 
 	default:
@@ -0,0 +1,77 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	s "github.com/btceasypay/bitcoinpay/core/serialization"
+	"io"
+)
+
+// MaxCFilterDataSize is the maximum byte size of a committed filter.
+const MaxCFilterDataSize = 256 * 1024
+
+// MsgCFilter implements the Message interface and represents a cfilter
+// message.  It is used to deliver a committed filter in response to a
+// getcfilter (MsgGetCFilter) message.
+type MsgCFilter struct {
+	BlockHash  hash.Hash
+	FilterType FilterType
+	Data       []byte
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFilter) Decode(r io.Reader, pver uint32) error {
+	err := s.ReadElements(r, &msg.BlockHash, (*uint8)(&msg.FilterType))
+	if err != nil {
+		return err
+	}
+	msg.Data, err = s.ReadVarBytes(r, pver, MaxCFilterDataSize, "cfilter data")
+	return err
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFilter) Encode(w io.Writer, pver uint32) error {
+	size := len(msg.Data)
+	if size > MaxCFilterDataSize {
+		str := fmt.Sprintf("cfilter size too large for message "+
+			"[size %v, max %v]", size, MaxCFilterDataSize)
+		return messageError("MsgCFilter.Encode", str)
+	}
+
+	err := s.WriteElements(w, &msg.BlockHash, uint8(msg.FilterType))
+	if err != nil {
+		return err
+	}
+	return s.WriteVarBytes(w, pver, msg.Data)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFilter) Command() string {
+	return CmdCFilter
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFilter) MaxPayloadLength(pver uint32) uint32 {
+	// Block hash + filter type + num filter bytes (varInt) + filter bytes.
+	return hash.HashSize + 1 + MaxVarIntPayload + MaxCFilterDataSize
+}
+
+// NewMsgCFilter returns a new cfilter message that conforms to the Message
+// interface.  See MsgCFilter for details.
+func NewMsgCFilter(blockHash *hash.Hash, filterType FilterType, data []byte) *MsgCFilter {
+	return &MsgCFilter{
+		BlockHash:  *blockHash,
+		FilterType: filterType,
+		Data:       data,
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	s "github.com/btceasypay/bitcoinpay/core/serialization"
+	"io"
+)
+
+// MaxFilterTypesPerMsg is the maximum number of filter types allowed per
+// message.
+const MaxFilterTypesPerMsg = 256
+
+// MsgCFTypes is the cftypes message.  It is used to deliver the filter types
+// supported by a peer in response to a getcftypes message (MsgGetCFTypes).
+type MsgCFTypes struct {
+	SupportedFilters []FilterType
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFTypes) Decode(r io.Reader, pver uint32) error {
+	count, err := s.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max filter types per message.
+	if count > MaxFilterTypesPerMsg {
+		str := fmt.Sprintf("too many filter types for message "+
+			"[count %v, max %v]", count, MaxFilterTypesPerMsg)
+		return messageError("MsgCFTypes.Decode", str)
+	}
+
+	msg.SupportedFilters = make([]FilterType, count)
+	for i := range msg.SupportedFilters {
+		err := s.ReadElements(r, (*uint8)(&msg.SupportedFilters[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFTypes) Encode(w io.Writer, pver uint32) error {
+	count := len(msg.SupportedFilters)
+	if count > MaxFilterTypesPerMsg {
+		str := fmt.Sprintf("too many filter types for message "+
+			"[count %v, max %v]", count, MaxFilterTypesPerMsg)
+		return messageError("MsgCFTypes.Encode", str)
+	}
+
+	err := s.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, filterType := range msg.SupportedFilters {
+		err := s.WriteElements(w, uint8(filterType))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFTypes) Command() string {
+	return CmdCFTypes
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFTypes) MaxPayloadLength(pver uint32) uint32 {
+	// Num filter types (varInt) + max allowed filter types.
+	return MaxVarIntPayload + MaxFilterTypesPerMsg
+}
+
+// NewMsgCFTypes returns a new cftypes message that conforms to the Message
+// interface.  See MsgCFTypes for details.
+func NewMsgCFTypes(filterTypes []FilterType) *MsgCFTypes {
+	return &MsgCFTypes{
+		SupportedFilters: filterTypes,
+	}
+}
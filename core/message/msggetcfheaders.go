@@ -0,0 +1,119 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	s "github.com/btceasypay/bitcoinpay/core/serialization"
+	"io"
+)
+
+// MsgGetCFHeaders implements the Message interface and represents a
+// getcfheaders message.  It is used to request the committed filter hashes
+// of the blocks ordered after the last known hash in the slice of block
+// locator hashes.  The list is returned via a cfheaders message (MsgCFHeaders)
+// and is limited by a specific hash to stop at or the maximum number of
+// filter hashes per message.
+type MsgGetCFHeaders struct {
+	BlockLocatorHashes []*hash.Hash
+	HashStop           hash.Hash
+	FilterType         FilterType
+}
+
+// AddBlockLocatorHash adds a new block locator hash to the message.
+func (msg *MsgGetCFHeaders) AddBlockLocatorHash(hash *hash.Hash) error {
+	if len(msg.BlockLocatorHashes)+1 > MaxBlockLocatorsPerMsg {
+		str := fmt.Sprintf("too many block locator hashes for message [max %v]",
+			MaxBlockLocatorsPerMsg)
+		return messageError("MsgGetCFHeaders.AddBlockLocatorHash", str)
+	}
+
+	hashValue := *hash
+	msg.BlockLocatorHashes = append(msg.BlockLocatorHashes, &hashValue)
+	return nil
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) Decode(r io.Reader, pver uint32) error {
+	// Read num block locator hashes and limit to max.
+	count, err := s.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxBlockLocatorsPerMsg {
+		str := fmt.Sprintf("too many block locator hashes for message "+
+			"[count %v, max %v]", count, MaxBlockLocatorsPerMsg)
+		return messageError("MsgGetCFHeaders.Decode", str)
+	}
+
+	// Create a contiguous slice of hashes to deserialize into in order to
+	// reduce the number of allocations.
+	locatorHashes := make([]hash.Hash, count)
+	msg.BlockLocatorHashes = make([]*hash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		hash := &locatorHashes[i]
+		err := s.ReadElements(r, hash)
+		if err != nil {
+			return err
+		}
+		msg.AddBlockLocatorHash(hash)
+	}
+
+	return s.ReadElements(r, &msg.HashStop, (*uint8)(&msg.FilterType))
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) Encode(w io.Writer, pver uint32) error {
+	// Limit to max block locator hashes per message.
+	count := len(msg.BlockLocatorHashes)
+	if count > MaxBlockLocatorsPerMsg {
+		str := fmt.Sprintf("too many block locator hashes for message "+
+			"[count %v, max %v]", count, MaxBlockLocatorsPerMsg)
+		return messageError("MsgGetCFHeaders.Encode", str)
+	}
+
+	err := s.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range msg.BlockLocatorHashes {
+		err := s.WriteElements(w, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.WriteElements(w, &msg.HashStop, uint8(msg.FilterType))
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetCFHeaders) Command() string {
+	return CmdGetCFHeaders
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) MaxPayloadLength(pver uint32) uint32 {
+	// Num block locator hashes (varInt) + max allowed block locators +
+	// hash stop + filter type.
+	return MaxVarIntPayload + (MaxBlockLocatorsPerMsg * hash.HashSize) +
+		hash.HashSize + 1
+}
+
+// NewMsgGetCFHeaders returns a new getcfheaders message that conforms to the
+// Message interface.  See MsgGetCFHeaders for details.
+func NewMsgGetCFHeaders(filterType FilterType) *MsgGetCFHeaders {
+	return &MsgGetCFHeaders{
+		BlockLocatorHashes: make([]*hash.Hash, 0, MaxBlockLocatorsPerMsg),
+		FilterType:         filterType,
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	s "github.com/btceasypay/bitcoinpay/core/serialization"
+	"io"
+)
+
+// FilterType is used to represent a filter type.
+type FilterType uint8
+
+const (
+	// GCSFilterBasic is the basic filter type which commits to the output
+	// scripts of a block and to the scripts of the outputs it spends.
+	GCSFilterBasic FilterType = iota
+)
+
+// String returns the filter type in human-readable form.
+func (t FilterType) String() string {
+	switch t {
+	case GCSFilterBasic:
+		return "basic"
+	}
+	return fmt.Sprintf("unknown filter type (%d)", uint8(t))
+}
+
+// MsgGetCFilter implements the Message interface and represents a getcfilter
+// message.  It is used to request a committed filter for a block.
+type MsgGetCFilter struct {
+	BlockHash  hash.Hash
+	FilterType FilterType
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFilter) Decode(r io.Reader, pver uint32) error {
+	return s.ReadElements(r, &msg.BlockHash, (*uint8)(&msg.FilterType))
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFilter) Encode(w io.Writer, pver uint32) error {
+	return s.WriteElements(w, &msg.BlockHash, uint8(msg.FilterType))
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetCFilter) Command() string {
+	return CmdGetCFilter
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetCFilter) MaxPayloadLength(pver uint32) uint32 {
+	// Block hash + filter type.
+	return hash.HashSize + 1
+}
+
+// NewMsgGetCFilter returns a new getcfilter message that conforms to the
+// Message interface using the passed parameters and defaults for the remaining
+// fields.
+func NewMsgGetCFilter(blockHash *hash.Hash, filterType FilterType) *MsgGetCFilter {
+	return &MsgGetCFilter{
+		BlockHash:  *blockHash,
+		FilterType: filterType,
+	}
+}
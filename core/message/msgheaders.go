@@ -7,7 +7,9 @@ package message
 
 import (
 	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
 	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/protocol"
 	s "github.com/btceasypay/bitcoinpay/core/serialization"
 	"github.com/btceasypay/bitcoinpay/core/types"
 	"io"
@@ -22,8 +24,13 @@ const MaxBlockHeadersPerMsg = 2000
 // to a getheaders message (MsgGetHeaders).  The maximum number of block headers
 // per message is currently 2000.  See MsgGetHeaders for details on requesting
 // the headers.
+//
+// From protocol version HeaderParentsVersion on, the parents of each block
+// follow its header, so that the headers can be linked into the DAG without
+// the blocks.
 type MsgHeaders struct {
 	Headers []*types.BlockHeader
+	Parents [][]*hash.Hash
 	GS      *blockdag.GraphState
 }
 
@@ -39,6 +46,59 @@ func (msg *MsgHeaders) AddBlockHeader(bh *types.BlockHeader) error {
 	return nil
 }
 
+// AddBlockHeaderWithParents adds a new block header and the parents of the
+// block to the message.
+func (msg *MsgHeaders) AddBlockHeaderWithParents(bh *types.BlockHeader, parents []*hash.Hash) error {
+	if err := msg.AddBlockHeader(bh); err != nil {
+		return err
+	}
+	for len(msg.Parents) < len(msg.Headers)-1 {
+		msg.Parents = append(msg.Parents, nil)
+	}
+	msg.Parents = append(msg.Parents, parents)
+	return nil
+}
+
+// readParents reads the parents of a block following its header.
+func readParents(r io.Reader, pver uint32) ([]*hash.Hash, error) {
+	count, err := s.ReadVarInt(r, pver)
+	if err != nil {
+		return nil, err
+	}
+	if count > types.MaxParentsPerBlock {
+		str := fmt.Sprintf("too many block parents for message "+
+			"[count %v, max %v]", count, types.MaxParentsPerBlock)
+		return nil, messageError("MsgHeaders.Decode", str)
+	}
+	parents := make([]*hash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var h hash.Hash
+		if err := s.ReadElements(r, &h); err != nil {
+			return nil, err
+		}
+		parents = append(parents, &h)
+	}
+	return parents, nil
+}
+
+// writeParents writes the parents of a block following its header.
+func writeParents(w io.Writer, pver uint32, parents []*hash.Hash) error {
+	if len(parents) > types.MaxParentsPerBlock {
+		str := fmt.Sprintf("too many block parents for message "+
+			"[count %v, max %v]", len(parents), types.MaxParentsPerBlock)
+		return messageError("MsgHeaders.Encode", str)
+	}
+	if err := s.WriteVarInt(w, pver, uint64(len(parents))); err != nil {
+		return err
+	}
+	for _, h := range parents {
+		if err := s.WriteElements(w, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Decode decodes r using the protocol encoding into the receiver.
 // This is part of the Message interface implementation.
 func (msg *MsgHeaders) Decode(r io.Reader, pver uint32) error {
@@ -76,7 +136,15 @@ func (msg *MsgHeaders) Decode(r io.Reader, pver uint32) error {
 				"transactions [count %v]", txCount)
 			return messageError("MsgHeaders.BtcDecode", str)
 		}
-		msg.AddBlockHeader(bh)
+		if pver >= protocol.HeaderParentsVersion {
+			parents, err := readParents(r, pver)
+			if err != nil {
+				return err
+			}
+			msg.AddBlockHeaderWithParents(bh, parents)
+		} else {
+			msg.AddBlockHeader(bh)
+		}
 	}
 	msg.GS = blockdag.NewGraphState()
 	err = msg.GS.Decode(r, pver)
@@ -102,7 +170,7 @@ func (msg *MsgHeaders) Encode(w io.Writer, pver uint32) error {
 		return err
 	}
 
-	for _, bh := range msg.Headers {
+	for i, bh := range msg.Headers {
 		err := bh.Serialize(w)
 		if err != nil {
 			return err
@@ -115,6 +183,15 @@ func (msg *MsgHeaders) Encode(w io.Writer, pver uint32) error {
 		if err != nil {
 			return err
 		}
+		if pver >= protocol.HeaderParentsVersion {
+			var parents []*hash.Hash
+			if i < len(msg.Parents) {
+				parents = msg.Parents[i]
+			}
+			if err := writeParents(w, pver, parents); err != nil {
+				return err
+			}
+		}
 	}
 
 	err = msg.GS.Encode(w, pver)
@@ -135,8 +212,14 @@ func (msg *MsgHeaders) Command() string {
 func (msg *MsgHeaders) MaxPayloadLength(pver uint32) uint32 {
 	// Num headers (varInt) + max allowed headers (header length + 1 byte
 	// for the number of transactions which is always 0).
-	return MaxVarIntPayload + ((types.MaxBlockHeaderPayload+1)*
+	length := MaxVarIntPayload + ((types.MaxBlockHeaderPayload+1)*
 		MaxBlockHeadersPerMsg + msg.GS.MaxPayloadLength())
+	if pver >= protocol.HeaderParentsVersion {
+		// Num parents (varInt) + max allowed parents of each header.
+		length += (MaxVarIntPayload + types.MaxParentsPerBlock*
+			hash.HashSize) * MaxBlockHeadersPerMsg
+	}
+	return length
 }
 
 func (msg *MsgHeaders) String() string {
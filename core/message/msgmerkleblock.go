@@ -0,0 +1,157 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Copyright (c) 2014-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/protocol"
+	s "github.com/btceasypay/bitcoinpay/core/serialization"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"io"
+)
+
+// maxFlagsPerMerkleBlock returns the maximum number of flag bytes that could
+// possibly fit into a merkle block.  Since each transaction is represented by
+// a single bit, this is the max number of transactions per block divided by
+// 8 bits per byte.  Then an extra one to cover partials.
+func maxFlagsPerMerkleBlock(pver uint32) uint64 {
+	return types.MaxTxPerTxTree(pver)/8 + 1
+}
+
+// MsgMerkleBlock implements the Message interface and represents a merkleblock
+// message.
+//
+// It carries the header of the block and a partial merkle tree proving the
+// inclusion of the transactions matching the filter of the peer.
+type MsgMerkleBlock struct {
+	Header       types.BlockHeader
+	Transactions uint32
+	Hashes       []*hash.Hash
+	Flags        []byte
+}
+
+// AddTxHash adds a new transaction hash to the message.
+func (msg *MsgMerkleBlock) AddTxHash(hash *hash.Hash) error {
+	maxTx := types.MaxTxPerTxTree(protocol.ProtocolVersion)
+	if uint64(len(msg.Hashes)+1) > maxTx {
+		str := fmt.Sprintf("too many tx hashes for message [max %v]",
+			maxTx)
+		return messageError("MsgMerkleBlock.AddTxHash", str)
+	}
+
+	msg.Hashes = append(msg.Hashes, hash)
+	return nil
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgMerkleBlock) Decode(r io.Reader, pver uint32) error {
+	err := msg.Header.Deserialize(r)
+	if err != nil {
+		return err
+	}
+
+	err = s.ReadElements(r, &msg.Transactions)
+	if err != nil {
+		return err
+	}
+
+	// Read num transaction hashes and limit to max.
+	count, err := s.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	maxTx := types.MaxTxPerTxTree(pver)
+	if count > maxTx {
+		str := fmt.Sprintf("too many transaction hashes for message "+
+			"[count %v, max %v]", count, maxTx)
+		return messageError("MsgMerkleBlock.Decode", str)
+	}
+
+	// Create a contiguous slice of hashes to deserialize into in order to
+	// reduce the number of allocations.
+	hashes := make([]hash.Hash, count)
+	msg.Hashes = make([]*hash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		h := &hashes[i]
+		err := s.ReadElements(r, h)
+		if err != nil {
+			return err
+		}
+		msg.Hashes = append(msg.Hashes, h)
+	}
+
+	msg.Flags, err = s.ReadVarBytes(r, pver, uint32(maxFlagsPerMerkleBlock(pver)),
+		"merkle block flags size")
+	return err
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgMerkleBlock) Encode(w io.Writer, pver uint32) error {
+	// Limit the number of transaction hashes and flag bytes to max.
+	numHashes := len(msg.Hashes)
+	maxTx := types.MaxTxPerTxTree(pver)
+	if uint64(numHashes) > maxTx {
+		str := fmt.Sprintf("too many transaction hashes for message "+
+			"[count %v, max %v]", numHashes, maxTx)
+		return messageError("MsgMerkleBlock.Encode", str)
+	}
+	numFlagBytes := len(msg.Flags)
+	maxFlags := maxFlagsPerMerkleBlock(pver)
+	if uint64(numFlagBytes) > maxFlags {
+		str := fmt.Sprintf("too many flag bytes for message [count %v, "+
+			"max %v]", numFlagBytes, maxFlags)
+		return messageError("MsgMerkleBlock.Encode", str)
+	}
+
+	err := msg.Header.Serialize(w)
+	if err != nil {
+		return err
+	}
+
+	err = s.WriteElements(w, msg.Transactions)
+	if err != nil {
+		return err
+	}
+
+	err = s.WriteVarInt(w, pver, uint64(numHashes))
+	if err != nil {
+		return err
+	}
+	for _, hash := range msg.Hashes {
+		err = s.WriteElements(w, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.WriteVarBytes(w, pver, msg.Flags)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgMerkleBlock) Command() string {
+	return CmdMerkleBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgMerkleBlock) MaxPayloadLength(pver uint32) uint32 {
+	return types.MaxBlockPayload
+}
+
+// NewMsgMerkleBlock returns a new merkleblock message that conforms to
+// the Message interface.  See MsgMerkleBlock for details.
+func NewMsgMerkleBlock(bh *types.BlockHeader) *MsgMerkleBlock {
+	return &MsgMerkleBlock{
+		Header:       *bh,
+		Transactions: 0,
+		Hashes:       make([]*hash.Hash, 0),
+		Flags:        make([]byte, 0),
+	}
+}
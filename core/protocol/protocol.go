@@ -16,7 +16,11 @@ const (
 	InitialProcotolVersion uint32 = 20
 
 	// ProtocolVersion is the latest protocol version this package supports.
-	ProtocolVersion uint32 = 22
+	ProtocolVersion uint32 = 23
+
+	// HeaderParentsVersion is the protocol version which added the parents
+	// of the blocks to the headers message.
+	HeaderParentsVersion uint32 = 23
 )
 
 // Network represents which Bitcoinpay network a message belongs to.
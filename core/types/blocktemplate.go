@@ -10,6 +10,8 @@ type PowDiffStandard struct {
 	X16rv3DTarget          uint32
 	X8r16DTarget           uint32
 	BitcoinpayKeccak256Target uint32
+	ProgpowTarget          uint32
+	CryptonightTarget      uint32
 
 	//cuckoo base difficultuy
 	CuckarooBaseDiff  uint64
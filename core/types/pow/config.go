@@ -18,6 +18,8 @@ type Percent struct {
 	BitcoinpayKeccak256Percent int
 	X16rv3Percent           int
 	X8r16Percent            int
+	ProgpowPercent          int
+	CryptonightPercent      int
 	MainHeight              int64
 }
 
@@ -39,6 +41,12 @@ type PowConfig struct {
 	BitcoinpayKeccak256PowLimit     *big.Int
 	BitcoinpayKeccak256PowLimitBits uint32
 
+	ProgpowPowLimit     *big.Int
+	ProgpowPowLimitBits uint32
+
+	CryptonightPowLimit     *big.Int
+	CryptonightPowLimitBits uint32
+
 	// cuckoo difficulty calc params  min difficulty
 	CuckarooMinDifficulty  uint32
 	CuckaroomMinDifficulty uint32
@@ -102,11 +110,13 @@ func (this *PowConfig) Check() error {
 		if p.CuckarooPercent < 0 || p.Blake2bDPercent < 0 ||
 			p.CuckatooPercent < 0 || p.CuckaroomPercent < 0 ||
 			p.BitcoinpayKeccak256Percent < 0 ||
-			p.X16rv3Percent < 0 || p.X8r16Percent < 0 {
+			p.X16rv3Percent < 0 || p.X8r16Percent < 0 ||
+			p.ProgpowPercent < 0 || p.CryptonightPercent < 0 {
 			return errors.New("pow config error, all percent must greater than or equal to 0!")
 		}
 		allPercent = p.CuckarooPercent + p.Blake2bDPercent +
-			p.CuckatooPercent + p.CuckaroomPercent + p.X16rv3Percent + p.X8r16Percent + p.BitcoinpayKeccak256Percent
+			p.CuckatooPercent + p.CuckaroomPercent + p.X16rv3Percent + p.X8r16Percent + p.BitcoinpayKeccak256Percent +
+			p.ProgpowPercent + p.CryptonightPercent
 		if allPercent != 100 {
 			return errors.New("pow config error, all pow not equal 100%!actual is " + fmt.Sprintf("%d", allPercent))
 		}
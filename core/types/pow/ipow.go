@@ -28,6 +28,8 @@ const (
 	X16RV3           PowType = 4
 	X8R16            PowType = 5
 	BITCOINPAYKECCAK256 PowType = 6
+	PROGPOW          PowType = 7
+	CRYPTONIGHT      PowType = 8
 )
 
 var PowMapString = map[PowType]interface{}{
@@ -38,6 +40,8 @@ var PowMapString = map[PowType]interface{}{
 	X16RV3:           "x16rv3",
 	X8R16:            "x8r16",
 	BITCOINPAYKECCAK256: "bitcoinpay_keccak256",
+	PROGPOW:          "progpow",
+	CRYPTONIGHT:      "cryptonight",
 }
 
 type ProofDataType [PROOFDATA_LENGTH]byte
@@ -101,6 +105,10 @@ func GetInstance(powType PowType, nonce uint32, proofData []byte) IPow {
 		instance = &X8r16{}
 	case BITCOINPAYKECCAK256:
 		instance = &BitcoinpayKeccak256{}
+	case PROGPOW:
+		instance = &Progpow{}
+	case CRYPTONIGHT:
+		instance = &Cryptonight{}
 	case CUCKAROO:
 		instance = &Cuckaroo{}
 	case CUCKAROOM:
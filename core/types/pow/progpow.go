@@ -0,0 +1,145 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// license that can be found in the LICENSE file.
+// Reference resources of rust bitVector
+package pow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/json"
+	"github.com/btceasypay/bitcoinpay/crypto/ethash"
+	"math/big"
+)
+
+type Progpow struct {
+	Pow
+}
+
+func (this *Progpow) GetPowResult() json.PowResult {
+	return json.PowResult{
+		PowName:   PowMapString[this.GetPowType()].(string),
+		PowType:   uint8(this.GetPowType()),
+		Nonce:     this.GetNonce(),
+		ProofData: nil,
+	}
+}
+
+func (this *Progpow) Verify(headerData []byte, blockHash hash.Hash, targetDiffBits uint32) error {
+	target := CompactToBig(targetDiffBits)
+	if target.Sign() <= 0 {
+		str := fmt.Sprintf("block target difficulty of %064x is too "+
+			"low", target)
+		return errors.New(str)
+	}
+
+	//The target difficulty must be less than the maximum allowed.
+	if target.Cmp(this.params.ProgpowPowLimit) > 0 {
+		str := fmt.Sprintf("block target difficulty of %064x is "+
+			"higher than max of %064x", target, this.params.ProgpowPowLimit)
+		return errors.New(str)
+	}
+	if len(headerData) < progpowNonceStart {
+		return errors.New("block header data is too short")
+	}
+	h := ProgpowHash(ProgpowSealHash(headerData), this.Nonce, this.mainHeight)
+	hashNum := HashToBig(&h)
+	if hashNum.Cmp(target) > 0 {
+		str := fmt.Sprintf("block hash of %064x is higher than"+
+			" expected max of %064x", hashNum, target)
+		return errors.New(str)
+	}
+	return nil
+}
+
+// the progpow nonce 4 bytes and pow type 1 byte at the end of the header data
+const progpowNonceEnd = 1
+const progpowNonceStart = progpowNonceEnd + 4
+
+// ProgpowSealHash returns the keccak256 hash of the header data without the
+// nonce, which is the header hash sealed by the progpow nonce.
+func ProgpowSealHash(headerData []byte) []byte {
+	l := len(headerData)
+	return ethash.Keccak256(headerData[:l-progpowNonceStart], headerData[l-progpowNonceEnd:])
+}
+
+// ProgpowHash returns the progpow hash of the seal hash with the nonce at the
+// main height, which selects the epoch and the progpow period.
+func ProgpowHash(sealHash []byte, nonce uint32, mainHeight int64) hash.Hash {
+	_, final := ethash.ProgpowLight(sealHash, uint64(nonce), uint64(mainHeight))
+	h := hash.Hash{}
+	copy(h[:], final)
+	return h
+}
+
+func (this *Progpow) GetNextDiffBig(weightedSumDiv *big.Int, oldDiffBig *big.Int, currentPowPercent *big.Int) *big.Int {
+	nextDiffBig := weightedSumDiv.Mul(weightedSumDiv, oldDiffBig)
+	defer func() {
+		nextDiffBig = nextDiffBig.Rsh(nextDiffBig, 32)
+
+	}()
+	targetPercent := this.PowPercent()
+	if targetPercent.Cmp(big.NewInt(0)) <= 0 {
+		return nextDiffBig
+	}
+	currentPowPercent.Mul(currentPowPercent, big.NewInt(100))
+	nextDiffBig.Mul(nextDiffBig, targetPercent)
+	nextDiffBig.Div(nextDiffBig, currentPowPercent)
+	return nextDiffBig
+}
+
+func (this *Progpow) PowPercent() *big.Int {
+	targetPercent := big.NewInt(int64(this.params.GetPercentByHeight(this.mainHeight).ProgpowPercent))
+	targetPercent.Lsh(targetPercent, 32)
+	return targetPercent
+}
+
+func (this *Progpow) GetSafeDiff(cur_reduce_diff uint64) *big.Int {
+	limitBits := this.params.ProgpowPowLimitBits
+	limitBitsBig := CompactToBig(limitBits)
+	if cur_reduce_diff <= 0 {
+		return limitBitsBig
+	}
+	newTarget := &big.Int{}
+	newTarget = newTarget.SetUint64(cur_reduce_diff)
+	// Limit new value to the proof of work limit.
+	if newTarget.Cmp(this.params.ProgpowPowLimit) > 0 {
+		newTarget.Set(this.params.ProgpowPowLimit)
+	}
+	return newTarget
+}
+
+// compare the target
+// wether target match the target diff
+func (this *Progpow) CompareDiff(newTarget *big.Int, target *big.Int) bool {
+	return newTarget.Cmp(target) <= 0
+}
+
+// pow proof data
+func (this *Progpow) Bytes() PowBytes {
+	r := make(PowBytes, 0)
+	//write nonce 4 bytes
+	n := make([]byte, 4)
+	binary.LittleEndian.PutUint32(n, this.Nonce)
+	r = append(r, n...)
+
+	t := make([]byte, 1)
+	//write pow type 1 byte
+	t[0] = uint8(this.PowType)
+	r = append(r, t...)
+	//write ProofData 169 bytes
+	r = append(r, this.ProofData[:]...)
+	return PowBytes(r)
+}
+
+// pow proof data
+func (this *Progpow) BlockData() PowBytes {
+	l := len(this.Bytes())
+	return PowBytes(this.Bytes()[:l-PROOFDATA_LENGTH])
+}
+
+// check pow is available
+func (this *Progpow) CheckAvailable() bool {
+	return this.params.GetPercentByHeight(this.mainHeight).ProgpowPercent > 0
+}
@@ -0,0 +1,25 @@
+package pow
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProgpowSealHash(t *testing.T) {
+	instance := GetInstance(PROGPOW, 1, []byte{})
+	headerData := append(bytes.Repeat([]byte{1}, 108), instance.BlockData()...)
+	sealHash := ProgpowSealHash(headerData)
+
+	// The seal hash does not depend on the nonce.
+	instance.SetNonce(2)
+	otherData := append(bytes.Repeat([]byte{1}, 108), instance.BlockData()...)
+	assert.NotEqual(t, headerData, otherData)
+	assert.Equal(t, sealHash, ProgpowSealHash(otherData))
+
+	// But it depends on the rest of the header.
+	otherData[0] = 2
+	assert.NotEqual(t, sealHash, ProgpowSealHash(otherData))
+
+	assert.NotEqual(t, ProgpowHash(sealHash, 1, 0), ProgpowHash(sealHash, 2, 0))
+}
@@ -1,9 +0,0 @@
-module github.com/btceasypay/bitcoinpay/crypto/cryptonight
-
-go 1.14
-
-require (
-	github.com/aead/skein v0.0.0-20160722084837-9365ae6e95d2
-	github.com/dchest/blake256 v1.0.0
-	golang.org/x/sys v0.0.0-20180816055513-1c9583448a9c
-)
@@ -10,4 +10,4 @@ package sha3
 
 //go:noescape
 
-func keccakF1600(a *[25]uint64)
+func keccakF1600(state *[25]uint64)
@@ -0,0 +1,70 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package ethash
+
+import (
+	"sync"
+)
+
+// lightEpochs is the number of epochs whose verification cache is kept, so
+// that the blocks around an epoch change do not regenerate it.
+const lightEpochs = 2
+
+// lightEpoch houses the verification cache and the progpow cDag of an epoch.
+type lightEpoch struct {
+	epoch       uint64
+	cache       []uint32
+	cDag        []uint32
+	datasetSize uint64
+}
+
+// lightCache houses the verification caches of the recent epochs.
+type lightCache struct {
+	mtx    sync.Mutex
+	epochs []*lightEpoch
+}
+
+var light = &lightCache{}
+
+// get returns the verification cache of the epoch of the block, generating
+// it if needed.
+func (lc *lightCache) get(blockNumber uint64) *lightEpoch {
+	epoch := blockNumber / epochLength
+
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+
+	for _, le := range lc.epochs {
+		if le.epoch == epoch {
+			return le
+		}
+	}
+	le := &lightEpoch{
+		epoch:       epoch,
+		cache:       make([]uint32, cacheSize(blockNumber)/4),
+		cDag:        make([]uint32, progpowCacheWords),
+		datasetSize: datasetSize(blockNumber),
+	}
+	generateCache(le.cache, epoch, seedHash(blockNumber))
+	generateCDag(le.cDag, le.cache, epoch)
+
+	lc.epochs = append(lc.epochs, le)
+	if len(lc.epochs) > lightEpochs {
+		lc.epochs = lc.epochs[1:]
+	}
+	return le
+}
+
+// ProgpowLight returns the mix digest and the final hash of the progpow hash
+// of the header hash and the nonce at the block number.  It uses the
+// verification cache of the epoch rather than the full dataset, which is
+// enough to verify and to mine on a CPU.
+//
+// This function is safe for concurrent access.
+func ProgpowLight(headerHash []byte, nonce uint64, blockNumber uint64) ([]byte, []byte) {
+	le := light.get(blockNumber)
+	return progpowLight(le.datasetSize, le.cache, headerHash, nonce,
+		blockNumber, le.cDag)
+}
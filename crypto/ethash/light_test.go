@@ -0,0 +1,30 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package ethash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestProgpowLight(t *testing.T) {
+	headerHash := [32]byte{}
+	expMix, _ := hex.DecodeString("f4ac202715ded4136e72887c39e63a4738331c57fd9eb79f6ec421c281aa8743")
+	expHash, _ := hex.DecodeString("b3bad9ca6f7c566cf0377d1f8cce29d6516a96562c122d924626281ec948ef02")
+	// The second call uses the cached epoch.
+	for i := 0; i < 2; i++ {
+		mixHash, finalHash := ProgpowLight(headerHash[:], 0, 0)
+		if !bytes.Equal(mixHash, expMix) {
+			t.Errorf("mix hash %x, want %x", mixHash, expMix)
+		}
+		if !bytes.Equal(finalHash, expHash) {
+			t.Errorf("final hash %x, want %x", finalHash, expHash)
+		}
+	}
+	if len(light.epochs) != 1 {
+		t.Errorf("%d cached epochs, want 1", len(light.epochs))
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/btceasypay/bitcoinpay/common/hash"
 	"github.com/btceasypay/bitcoinpay/core/protocol"
 	"github.com/btceasypay/bitcoinpay/database"
+	"github.com/btceasypay/bitcoinpay/metrics"
 	"hash/crc32"
 	"io"
 	"os"
@@ -65,6 +66,11 @@ var (
 	// castagnoli houses the Castagnoli polynomial used for CRC-32
 	// checksums.
 	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	// bytesWrittenMeter and bytesReadMeter count the bytes written to and
+	// read from the block files.
+	bytesWrittenMeter = metrics.NewMeter("ffldb/bytes/written")
+	bytesReadMeter    = metrics.NewMeter("ffldb/bytes/read")
 )
 
 // filer is an interface which acts very similar to a *os.File and is typically
@@ -493,6 +499,7 @@ func (s *blockStore) writeBlock(rawBlock []byte) (blockLocation, error) {
 		fileOffset:   origOffset,
 		blockLen:     fullLen,
 	}
+	bytesWrittenMeter.Mark(int64(fullLen))
 	return loc, nil
 }
 
@@ -552,6 +559,8 @@ func (s *blockStore) readBlock(hash *hash.Hash, loc blockLocation) ([]byte, erro
 		return nil, makeDbErr(database.ErrDriverSpecific, str, nil)
 	}
 
+	bytesReadMeter.Mark(int64(n))
+
 	// The raw block excludes the network, length of the block, and
 	// checksum.
 	return serializedData[8 : n-4], nil
@@ -15,12 +15,14 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/btceasypay/bitcoinpay/common/hash"
 	"github.com/btceasypay/bitcoinpay/core/protocol"
 	"github.com/btceasypay/bitcoinpay/core/types"
 	"github.com/btceasypay/bitcoinpay/database"
 	"github.com/btceasypay/bitcoinpay/database/ffldb/treap"
+	"github.com/btceasypay/bitcoinpay/metrics"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/comparer"
 	ldberrors "github.com/syndtr/goleveldb/leveldb/errors"
@@ -48,6 +50,9 @@ const (
 )
 
 var (
+	// commitTimer measures the commits of the write transactions.
+	commitTimer = metrics.NewTimer("ffldb/commit")
+
 	// byteOrder is the preferred byte order used through the database and
 	// block files.  Sometimes big endian will be used to allow ordered byte
 	// sortable integer values.
@@ -1678,6 +1683,8 @@ func serializeBlockRow(blockLoc blockLocation, blockHdr []byte) []byte {
 //
 // This function MUST only be called when there is pending data to be written.
 func (tx *transaction) writePendingAndCommit() error {
+	defer commitTimer.UpdateSince(time.Now())
+
 	// Save the current block store write position for potential rollback.
 	// These variables are only updated here in this function and there can
 	// only be one write transaction active at a time, so it's safe to store
@@ -3,6 +3,7 @@ module github.com/btceasypay/bitcoinpay
 go 1.12
 
 require (
+	github.com/aead/skein v0.0.0-20160722084837-9365ae6e95d2
 	github.com/davecgh/go-spew v1.1.1
 	github.com/dchest/blake256 v1.0.0
 	github.com/deckarep/golang-set v1.7.1
@@ -0,0 +1,156 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+//
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// The parts code inspired & originated from
+// https://github.com/ethereum/go-ethereum/metrics/prometheus
+
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bpmetrics "github.com/btceasypay/bitcoinpay/metrics"
+	"github.com/rcrowley/go-metrics"
+)
+
+// quantiles are the quantiles given with the summaries of the timers and of
+// the histograms.
+var quantiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// collector writes metrics in the Prometheus text format.
+type collector struct {
+	buff *bytes.Buffer
+
+	// family is the name of the last metric whose type was written, the
+	// metrics differing only by their labels share the type line.
+	family string
+}
+
+// newCollector returns a collector with an empty buffer.
+func newCollector() *collector {
+	return &collector{buff: new(bytes.Buffer)}
+}
+
+// add writes a metric of the registry.  The durations of the timers are
+// given in seconds, and the counters and the meters are counters of the
+// marked events, their names ending with _total.
+//
+// The labels of a metric are given at the end of its name in braces, as in
+// rpc/duration{method="getBlock"}.
+func (c *collector) add(name string, i interface{}) {
+	name, labels := splitLabels(name)
+	name = mutateName(name)
+	switch m := i.(type) {
+	case metrics.Counter:
+		c.writeType(name+"_total", "counter")
+		c.writeValue(name+"_total", labels, float64(m.Count()))
+	case metrics.Gauge:
+		c.writeType(name, "gauge")
+		c.writeValue(name, labels, float64(m.Value()))
+	case metrics.GaugeFloat64:
+		c.writeType(name, "gauge")
+		c.writeValue(name, labels, m.Value())
+	case metrics.Meter:
+		c.writeType(name+"_total", "counter")
+		c.writeValue(name+"_total", labels, float64(m.Count()))
+	case metrics.Timer:
+		t := m.Snapshot()
+		ps := t.Percentiles(quantiles)
+		for i := range ps {
+			ps[i] = seconds(ps[i])
+		}
+		c.writeSummary(name, labels, ps, seconds(float64(t.Sum())), t.Count())
+	case metrics.Histogram:
+		h := m.Snapshot()
+		c.writeSummary(name, labels, h.Percentiles(quantiles), float64(h.Sum()),
+			h.Count())
+	case bpmetrics.ResettingTimer:
+		t := m.Snapshot()
+		percentiles := make([]float64, len(quantiles))
+		for i, q := range quantiles {
+			percentiles[i] = q * 100
+		}
+		ps := []float64{}
+		for _, p := range t.Percentiles(percentiles) {
+			ps = append(ps, seconds(float64(p)))
+		}
+		if len(ps) == 0 {
+			return
+		}
+		sum := int64(0)
+		for _, v := range t.Values() {
+			sum += v
+		}
+		c.writeSummary(name, labels, ps, seconds(float64(sum)),
+			int64(len(t.Values())))
+	}
+}
+
+// writeSummary writes a summary with the values of the quantiles.
+func (c *collector) writeSummary(name, labels string, ps []float64, sum float64, count int64) {
+	c.writeType(name, "summary")
+	for i, q := range quantiles {
+		quantile := fmt.Sprintf("quantile=\"%s\"",
+			strconv.FormatFloat(q, 'g', -1, 64))
+		if labels != "" {
+			quantile = labels + "," + quantile
+		}
+		c.writeValue(name, quantile, ps[i])
+	}
+	c.writeValue(name+"_sum", labels, sum)
+	c.writeValue(name+"_count", labels, float64(count))
+}
+
+// writeType writes the type line of a metric, unless it was written for the
+// previous metric.
+func (c *collector) writeType(name, typ string) {
+	if name == c.family {
+		return
+	}
+	c.family = name
+	fmt.Fprintf(c.buff, "# TYPE %s %s\n", name, typ)
+}
+
+// writeValue writes a sample of a metric with its labels.
+func (c *collector) writeValue(name, labels string, v float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(c.buff, "%s%s %s\n", name, labels,
+		strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// splitLabels returns the name of a metric and the labels given in braces at
+// the end of it.
+func splitLabels(name string) (string, string) {
+	i := strings.IndexByte(name, '{')
+	if i < 0 || !strings.HasSuffix(name, "}") {
+		return name, ""
+	}
+	return name[:i], name[i+1 : len(name)-1]
+}
+
+// mutateName returns the name of a metric in the namespace, with the
+// characters which are not allowed in the Prometheus names replaced by
+// underscores.
+func mutateName(name string) string {
+	name = Namespace + "_" + name
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// seconds converts nanoseconds to seconds.
+func seconds(ns float64) float64 {
+	return ns / float64(time.Second)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+//
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package prometheus
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestHandler(t *testing.T) {
+	reg := metrics.NewRegistry()
+	metrics.NewRegisteredCounter("p2p/bytes-sent", reg).Inc(12)
+	metrics.NewRegisteredGauge("mempool/size", reg).Update(3)
+	metrics.NewRegisteredMeter("rpc/failures", reg).Mark(2)
+	metrics.NewRegisteredTimer(`rpc/duration{method="getBlock"}`, reg).Update(2 * time.Second)
+	metrics.NewRegisteredTimer(`rpc/duration{method="getBlockCount"}`, reg).Update(time.Second)
+
+	w := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# TYPE bitcoinpay_mempool_size gauge
+bitcoinpay_mempool_size 3
+# TYPE bitcoinpay_p2p_bytes_sent_total counter
+bitcoinpay_p2p_bytes_sent_total 12
+# TYPE bitcoinpay_rpc_duration summary
+bitcoinpay_rpc_duration{method="getBlock",quantile="0.5"} 2
+bitcoinpay_rpc_duration{method="getBlock",quantile="0.75"} 2
+bitcoinpay_rpc_duration{method="getBlock",quantile="0.95"} 2
+bitcoinpay_rpc_duration{method="getBlock",quantile="0.99"} 2
+bitcoinpay_rpc_duration_sum{method="getBlock"} 2
+bitcoinpay_rpc_duration_count{method="getBlock"} 1
+bitcoinpay_rpc_duration{method="getBlockCount",quantile="0.5"} 1
+bitcoinpay_rpc_duration{method="getBlockCount",quantile="0.75"} 1
+bitcoinpay_rpc_duration{method="getBlockCount",quantile="0.95"} 1
+bitcoinpay_rpc_duration{method="getBlockCount",quantile="0.99"} 1
+bitcoinpay_rpc_duration_sum{method="getBlockCount"} 1
+bitcoinpay_rpc_duration_count{method="getBlockCount"} 1
+# TYPE bitcoinpay_rpc_failures_total counter
+bitcoinpay_rpc_failures_total 2
+`
+	if got := w.Body.String(); got != want {
+		t.Fatalf("got metrics\n%s\nwant\n%s", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != contentType {
+		t.Fatalf("content type %q, want %q", ct, contentType)
+	}
+}
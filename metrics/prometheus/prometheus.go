@@ -0,0 +1,46 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+//
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// The parts code inspired & originated from
+// https://github.com/ethereum/go-ethereum/metrics/prometheus
+
+// Package prometheus exposes a go-metrics registry in the Prometheus text
+// format.
+package prometheus
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/rcrowley/go-metrics"
+)
+
+// Namespace is the prefix of the names of the exported metrics.
+const Namespace = "bitcoinpay"
+
+// contentType is the content type of the Prometheus text format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an HTTP handler which writes the metrics of the registry in
+// the Prometheus text format, in the order of their names.
+func Handler(reg metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := []string{}
+		reg.Each(func(name string, i interface{}) {
+			names = append(names, name)
+		})
+		sort.Strings(names)
+
+		c := newCollector()
+		for _, name := range names {
+			c.add(name, reg.Get(name))
+		}
+		w.Header().Set("Content-Type", contentType)
+		if _, err := w.Write(c.buff.Bytes()); err != nil {
+			log.Debug("Failed to write the metrics", "error", err)
+		}
+	})
+}
@@ -7,6 +7,7 @@ package node
 
 import (
 	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
 	"github.com/btceasypay/bitcoinpay/core/blockdag"
 	"github.com/btceasypay/bitcoinpay/core/json"
 	"github.com/btceasypay/bitcoinpay/core/message"
@@ -221,6 +222,26 @@ func (api *PrivateBlockChainAPI) SetRpcMaxClients(max int) (interface{}, error)
 	return api.node.node.Config.RPCMaxClients, nil
 }
 
+// InvalidateBlock marks a block and its future set invalidated, leaving them
+// out of the order of the DAG until the block is reconsidered.
+func (api *PrivateBlockChainAPI) InvalidateBlock(h hash.Hash) (interface{}, error) {
+	err := api.node.blockManager.GetChain().InvalidateBlock(&h)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to invalidate the block")
+	}
+	return true, nil
+}
+
+// ReconsiderBlock undoes the invalidation of a block, of its ancestors and of
+// its future set.
+func (api *PrivateBlockChainAPI) ReconsiderBlock(h hash.Hash) (interface{}, error) {
+	err := api.node.blockManager.GetChain().ReconsiderBlock(&h)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to reconsider the block")
+	}
+	return true, nil
+}
+
 type PrivateLogAPI struct {
 	node *BitcoinpayFull
 }
@@ -5,19 +5,20 @@ import (
 	"github.com/btceasypay/bitcoinpay/core/blockchain"
 	"github.com/btceasypay/bitcoinpay/database"
 	"github.com/btceasypay/bitcoinpay/engine/txscript"
-	"github.com/btceasypay/bitcoinpay/node/notify"
 	"github.com/btceasypay/bitcoinpay/p2p/peerserver"
 	"github.com/btceasypay/bitcoinpay/params"
 	"github.com/btceasypay/bitcoinpay/rpc"
 	"github.com/btceasypay/bitcoinpay/services/acct"
 	"github.com/btceasypay/bitcoinpay/services/address"
 	"github.com/btceasypay/bitcoinpay/services/blkmgr"
+	"github.com/btceasypay/bitcoinpay/services/cf"
 	"github.com/btceasypay/bitcoinpay/services/common"
 	"github.com/btceasypay/bitcoinpay/services/index"
 	"github.com/btceasypay/bitcoinpay/services/mempool"
 	"github.com/btceasypay/bitcoinpay/services/miner"
 	"github.com/btceasypay/bitcoinpay/services/mining"
 	"github.com/btceasypay/bitcoinpay/services/notifymgr"
+	"github.com/btceasypay/bitcoinpay/services/stratum"
 	"github.com/btceasypay/bitcoinpay/services/tx"
 )
 
@@ -26,7 +27,7 @@ type BitcoinpayFull struct {
 	// under node
 	node *Node
 	// msg notifier
-	nfManager notify.Notify
+	nfManager *notifymgr.NotifyMgr
 	// database
 	db database.DB
 	// account/wallet service
@@ -35,10 +36,15 @@ type BitcoinpayFull struct {
 	blockManager *blkmgr.BlockManager
 	// tx manager
 	txManager *tx.TxManager
+	// committed filter index
+	cfIndex *cf.CFIndex
 
 	// miner service
 	cpuMiner *miner.CPUMiner
 
+	// stratum mining server
+	stratum *stratum.Server
+
 	// address service
 	addressApi *address.AddressApi
 
@@ -56,20 +62,34 @@ func (qm *BitcoinpayFull) Start(server *peerserver.PeerServer) error {
 		qm.cpuMiner.Start()
 	}
 
+	// The wallet has to follow the chain before the blocks are processed.
+	if err := qm.acctmanager.Start(); err != nil {
+		return err
+	}
 	qm.blockManager.Start()
 	qm.txManager.Start()
+	if qm.stratum != nil {
+		if err := qm.stratum.Start(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (qm *BitcoinpayFull) Stop() error {
 	log.Debug("Stopping Bitcoinpay full node service")
 
+	if qm.stratum != nil {
+		qm.stratum.Stop()
+	}
+
 	log.Info("try stop bm")
 
 	qm.blockManager.Stop()
 	qm.blockManager.WaitForStop()
 
 	qm.txManager.Stop()
+	qm.acctmanager.Stop()
 
 	log.Info("try stop cpu miner")
 	// Stop the CPU miner if needed.
@@ -86,22 +106,20 @@ func (qm *BitcoinpayFull) APIs() []rpc.API {
 	apis = append(apis, qm.cpuMiner.APIs()...)
 	apis = append(apis, qm.blockManager.API())
 	apis = append(apis, qm.txManager.APIs()...)
+	if qm.cfIndex != nil {
+		apis = append(apis, qm.cfIndex.APIs()...)
+	}
+	apis = append(apis, qm.nfManager.APIs()...)
 	apis = append(apis, qm.apis()...)
 	return apis
 }
 func newBitcoinpayFullNode(node *Node) (*BitcoinpayFull, error) {
 
-	// account manager
-	acctmgr, err := acct.New()
-	if err != nil {
-		return nil, err
-	}
 	qm := BitcoinpayFull{
-		node:        node,
-		db:          node.DB,
-		acctmanager: acctmgr,
-		timeSource:  blockchain.NewMedianTime(),
-		sigCache:    txscript.NewSigCache(node.Config.SigCacheMaxSize),
+		node:       node,
+		db:         node.DB,
+		timeSource: blockchain.NewMedianTime(),
+		sigCache:   txscript.NewSigCache(node.Config.SigCacheMaxSize),
 	}
 	// Create the transaction and address indexes if needed.
 	var indexes []index.Indexer
@@ -117,13 +135,18 @@ func newBitcoinpayFullNode(node *Node) (*BitcoinpayFull, error) {
 		addrIndex = index.NewAddrIndex(qm.db, node.Params)
 		indexes = append(indexes, addrIndex)
 	}
+	if !cfg.NoCFilters {
+		log.Info("Committed filter index is enabled")
+		qm.cfIndex = cf.NewCFIndex(qm.db)
+		indexes = append(indexes, qm.cfIndex)
+	}
 	// index-manager
 	var indexManager blockchain.IndexManager
 	if len(indexes) > 0 {
 		indexManager = index.NewManager(qm.db, indexes, node.Params)
 	}
 
-	qm.nfManager = &notifymgr.NotifyMgr{Server: node.peerServer, RpcServer: node.rpcServer}
+	qm.nfManager = &notifymgr.NotifyMgr{Server: node.peerServer, RpcServer: node.rpcServer, Params: node.Params}
 
 	// block-manager
 	bm, err := blkmgr.NewBlockManager(qm.nfManager, indexManager, node.DB, qm.timeSource, qm.sigCache, node.Config, node.Params,
@@ -140,10 +163,20 @@ func newBitcoinpayFullNode(node *Node) (*BitcoinpayFull, error) {
 	}
 	qm.txManager = tm
 	bm.SetTxManager(tm)
+	qm.nfManager.Chain = bm.GetChain()
+	qm.nfManager.TxPool = tm.MemPool().(*mempool.TxPool)
 	// prepare peerServer
 	node.peerServer.BlockManager = bm
 	node.peerServer.TimeSource = qm.timeSource
 	node.peerServer.TxMemPool = qm.txManager.MemPool().(*mempool.TxPool)
+	node.peerServer.CfIndex = qm.cfIndex
+
+	// account manager
+	acctmgr, err := acct.New(cfg, node.Params, node.DB, bm, qm.txManager.MemPool().(*mempool.TxPool), qm.nfManager)
+	if err != nil {
+		return nil, err
+	}
+	qm.acctmanager = acctmgr
 
 	// Cpu Miner
 	// Create the mining policy based on the configuration options.
@@ -165,6 +198,13 @@ func newBitcoinpayFullNode(node *Node) (*BitcoinpayFull, error) {
 
 	qm.cpuMiner = miner.NewCPUMiner(cfg, node.Params, &policy, qm.sigCache,
 		qm.txManager.MemPool().(*mempool.TxPool), qm.timeSource, qm.blockManager, defaultNumWorkers)
+	if cfg.StratumListen != "" {
+		qm.stratum, err = stratum.NewServer(cfg, node.Params, &policy, qm.sigCache,
+			qm.txManager.MemPool().(*mempool.TxPool), qm.timeSource, qm.blockManager)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// init address api
 	qm.addressApi = address.NewAddressApi(cfg, node.Params)
 	return &qm, nil
@@ -3,9 +3,11 @@ package node
 
 import (
 	"github.com/btceasypay/bitcoinpay/config"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
 	"github.com/btceasypay/bitcoinpay/database"
 	"github.com/btceasypay/bitcoinpay/p2p/peerserver"
 	"github.com/btceasypay/bitcoinpay/rpc"
+	"github.com/btceasypay/bitcoinpay/services/light"
 )
 
 // BitcoinpayLight implements the bitcoinpay light node service.
@@ -13,26 +15,37 @@ type BitcoinpayLight struct {
 	// database
 	db     database.DB
 	config *config.Config
+	// header sync service
+	syncManager *light.SyncManager
 }
 
 func (light *BitcoinpayLight) Start(server *peerserver.PeerServer) error {
 	log.Debug("Starting bitcoinpay light node service")
-	return nil
+	return light.syncManager.Start()
 }
 
 func (light *BitcoinpayLight) Stop() error {
 	log.Debug("Stopping bitcoinpay light node service")
-	return nil
+	return light.syncManager.Stop()
 }
 
 func (light *BitcoinpayLight) APIs() []rpc.API {
-	return []rpc.API{}
+	return light.syncManager.APIs()
 }
 
 func newBitcoinpayLight(n *Node) (*BitcoinpayLight, error) {
+	timeSource := blockchain.NewMedianTime()
+	sm, err := light.NewSyncManager(n.DB, n.Params, n.Config.DAGType, timeSource)
+	if err != nil {
+		return nil, err
+	}
+	n.peerServer.TimeSource = timeSource
+	n.peerServer.HeaderSync = sm
+
 	light := BitcoinpayLight{
-		config: n.Config,
-		db:     n.DB,
+		config:      n.Config,
+		db:          n.DB,
+		syncManager: sm,
 	}
 	return &light, nil
 }
@@ -1,6 +1,7 @@
 package notify
 
 import (
+	"github.com/btceasypay/bitcoinpay/core/json"
 	"github.com/btceasypay/bitcoinpay/core/message"
 	"github.com/btceasypay/bitcoinpay/core/types"
 )
@@ -11,4 +12,7 @@ type Notify interface {
 	AnnounceNewTransactions(newTxs []*types.TxDesc)
 	RelayInventory(invVect *message.InvVect, data interface{})
 	BroadcastMessage(msg message.Message)
+	NotifyBlockAccepted(block *types.SerializedBlock, status *json.BlockNotifyResult)
+	NotifyBlockConnected(block *types.SerializedBlock, status *json.BlockNotifyResult)
+	NotifyBlockDisconnected(block *types.SerializedBlock, status *json.BlockNotifyResult)
 }
@@ -0,0 +1,88 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package peer
+
+import (
+	"github.com/btceasypay/bitcoinpay/core/message"
+	"github.com/btceasypay/bitcoinpay/core/protocol"
+	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/services/bloom"
+)
+
+// Filter returns the bloom filter loaded by the remote peer.  The filter is
+// never nil but it is only loaded after the peer sent a filterload message.
+//
+// This function is safe for concurrent access.
+func (p *Peer) Filter() *bloom.Filter {
+	return p.filter
+}
+
+// isBloomServiceEnabled returns whether the bloom filtering service is
+// enabled for the peer and disconnects it otherwise, since the peer is not
+// allowed to send bloom filter messages without it.
+func (p *Peer) isBloomServiceEnabled(cmd string) bool {
+	if p.cfg.Services&protocol.Bloom != protocol.Bloom {
+		log.Debug("Peer sent bloom filter message while bloom filtering "+
+			"is disabled -- disconnecting", "command", cmd, "peer", p)
+		p.Disconnect()
+		return false
+	}
+	return true
+}
+
+// handleFilterAdd adds the data of a filteradd message to the loaded bloom
+// filter of the peer.
+func (p *Peer) handleFilterAdd(msg *message.MsgFilterAdd) {
+	if !p.isBloomServiceEnabled(msg.Command()) {
+		return
+	}
+
+	if !p.filter.IsLoaded() {
+		log.Debug("Peer sent a filteradd request with no filter loaded "+
+			"-- disconnecting", "peer", p)
+		p.Disconnect()
+		return
+	}
+
+	p.filter.Add(msg.Data)
+
+	if p.cfg.Listeners.OnFilterAdd != nil {
+		p.cfg.Listeners.OnFilterAdd(p, msg)
+	}
+}
+
+// handleFilterClear unloads the bloom filter of the peer.
+func (p *Peer) handleFilterClear(msg *message.MsgFilterClear) {
+	if !p.isBloomServiceEnabled(msg.Command()) {
+		return
+	}
+
+	if !p.filter.IsLoaded() {
+		log.Debug("Peer sent a filterclear request with no filter loaded "+
+			"-- disconnecting", "peer", p)
+		p.Disconnect()
+		return
+	}
+
+	p.filter.Unload()
+
+	if p.cfg.Listeners.OnFilterClear != nil {
+		p.cfg.Listeners.OnFilterClear(p, msg)
+	}
+}
+
+// handleFilterLoad replaces the bloom filter of the peer with the one of a
+// filterload message.
+func (p *Peer) handleFilterLoad(msg *message.MsgFilterLoad) {
+	if !p.isBloomServiceEnabled(msg.Command()) {
+		return
+	}
+
+	p.filter.Reload(msg)
+
+	if p.cfg.Listeners.OnFilterLoad != nil {
+		p.cfg.Listeners.OnFilterLoad(p, msg)
+	}
+}
@@ -104,34 +104,50 @@ type MessageListeners struct {
 
 	// OnFeeFilter
 	OnFeeFilter func(p *Peer, msg *message.MsgFeeFilter)
-	/*
-		// OnSendHeaders is invoked when a peer receives a sendheaders message.
-		OnSendHeaders func(p *Peer, msg *message.MsgSendHeaders)
 
-		// OnCFilter is invoked when a peer receives a cfilter wire message.
-		OnCFilter func(p *Peer, msg *message.MsgCFilter)
+	// OnHeaders is invoked when a peer receives a headers wire message.
+	OnHeaders func(p *Peer, msg *message.MsgHeaders)
+
+	// OnCFilter is invoked when a peer receives a cfilter wire message.
+	OnCFilter func(p *Peer, msg *message.MsgCFilter)
+
+	// OnCFHeaders is invoked when a peer receives a cfheaders wire
+	// message.
+	OnCFHeaders func(p *Peer, msg *message.MsgCFHeaders)
+
+	// OnCFTypes is invoked when a peer receives a cftypes wire message.
+	OnCFTypes func(p *Peer, msg *message.MsgCFTypes)
 
-		// OnCFHeaders is invoked when a peer receives a cfheaders wire
-		// message.
-		OnCFHeaders func(p *Peer, msg *message.MsgCFHeaders)
+	// OnGetCFilter is invoked when a peer receives a getcfilter wire
+	// message.
+	OnGetCFilter func(p *Peer, msg *message.MsgGetCFilter)
+
+	// OnGetCFHeaders is invoked when a peer receives a getcfheaders
+	// wire message.
+	OnGetCFHeaders func(p *Peer, msg *message.MsgGetCFHeaders)
 
-		// OnCFTypes is invoked when a peer receives a cftypes wire message.
-		OnCFTypes func(p *Peer, msg *message.MsgCFTypes)
+	// OnGetCFTypes is invoked when a peer receives a getcftypes wire
+	// message.
+	OnGetCFTypes func(p *Peer, msg *message.MsgGetCFTypes)
 
-		// OnHeaders is invoked when a peer receives a headers wire message.
-		OnHeaders func(p *Peer, msg *message.MsgHeaders)
+	// OnFilterAdd is invoked when a peer receives a filteradd wire message
+	// and the data was added to its bloom filter.
+	OnFilterAdd func(p *Peer, msg *message.MsgFilterAdd)
 
-		// OnGetCFilter is invoked when a peer receives a getcfilter wire
-		// message.
-		OnGetCFilter func(p *Peer, msg *message.MsgGetCFilter)
+	// OnFilterClear is invoked when a peer receives a filterclear wire
+	// message and its bloom filter was unloaded.
+	OnFilterClear func(p *Peer, msg *message.MsgFilterClear)
 
-		// OnGetCFHeaders is invoked when a peer receives a getcfheaders
-		// wire message.
-		OnGetCFHeaders func(p *Peer, msg *message.MsgGetCFHeaders)
+	// OnFilterLoad is invoked when a peer receives a filterload wire
+	// message and its bloom filter was loaded.
+	OnFilterLoad func(p *Peer, msg *message.MsgFilterLoad)
 
-		// OnGetCFTypes is invoked when a peer receives a getcftypes wire
-		// message.
-		OnGetCFTypes func(p *Peer, msg *message.MsgGetCFTypes)
+	// OnMerkleBlock is invoked when a peer receives a merkleblock wire
+	// message.
+	OnMerkleBlock func(p *Peer, msg *message.MsgMerkleBlock)
+	/*
+		// OnSendHeaders is invoked when a peer receives a sendheaders message.
+		OnSendHeaders func(p *Peer, msg *message.MsgSendHeaders)
 
 		// OnFeeFilter is invoked when a peer receives a feefilter wire message.
 		OnFeeFilter func(p *Peer, msg *message.MsgFeeFilter)
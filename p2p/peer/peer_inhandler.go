@@ -177,42 +177,56 @@ out:
 			if p.cfg.Listeners.OnFeeFilter != nil {
 				p.cfg.Listeners.OnFeeFilter(p, msg)
 			}
-		/*
-			case *message.MsgHeaders:
-				if p.cfg.Listeners.OnHeaders != nil {
-					p.cfg.Listeners.OnHeaders(p, msg)
-				}
 
-			case *message.MsgGetCFilter:
-				if p.cfg.Listeners.OnGetCFilter != nil {
-					p.cfg.Listeners.OnGetCFilter(p, msg)
-				}
+		case *message.MsgHeaders:
+			if p.cfg.Listeners.OnHeaders != nil {
+				p.cfg.Listeners.OnHeaders(p, msg)
+			}
 
-			case *message.MsgGetCFHeaders:
-				if p.cfg.Listeners.OnGetCFHeaders != nil {
-					p.cfg.Listeners.OnGetCFHeaders(p, msg)
-				}
+		case *message.MsgGetCFilter:
+			if p.cfg.Listeners.OnGetCFilter != nil {
+				p.cfg.Listeners.OnGetCFilter(p, msg)
+			}
 
-			case *message.MsgGetCFTypes:
-				if p.cfg.Listeners.OnGetCFTypes != nil {
-					p.cfg.Listeners.OnGetCFTypes(p, msg)
-				}
+		case *message.MsgGetCFHeaders:
+			if p.cfg.Listeners.OnGetCFHeaders != nil {
+				p.cfg.Listeners.OnGetCFHeaders(p, msg)
+			}
 
-			case *message.MsgCFilter:
-				if p.cfg.Listeners.OnCFilter != nil {
-					p.cfg.Listeners.OnCFilter(p, msg)
-				}
+		case *message.MsgGetCFTypes:
+			if p.cfg.Listeners.OnGetCFTypes != nil {
+				p.cfg.Listeners.OnGetCFTypes(p, msg)
+			}
 
-			case *message.MsgCFHeaders:
-				if p.cfg.Listeners.OnCFHeaders != nil {
-					p.cfg.Listeners.OnCFHeaders(p, msg)
-				}
+		case *message.MsgCFilter:
+			if p.cfg.Listeners.OnCFilter != nil {
+				p.cfg.Listeners.OnCFilter(p, msg)
+			}
 
-			case *message.MsgCFTypes:
-				if p.cfg.Listeners.OnCFTypes != nil {
-					p.cfg.Listeners.OnCFTypes(p, msg)
-				}
+		case *message.MsgCFHeaders:
+			if p.cfg.Listeners.OnCFHeaders != nil {
+				p.cfg.Listeners.OnCFHeaders(p, msg)
+			}
+
+		case *message.MsgCFTypes:
+			if p.cfg.Listeners.OnCFTypes != nil {
+				p.cfg.Listeners.OnCFTypes(p, msg)
+			}
 
+		case *message.MsgFilterAdd:
+			p.handleFilterAdd(msg)
+
+		case *message.MsgFilterClear:
+			p.handleFilterClear(msg)
+
+		case *message.MsgFilterLoad:
+			p.handleFilterLoad(msg)
+
+		case *message.MsgMerkleBlock:
+			if p.cfg.Listeners.OnMerkleBlock != nil {
+				p.cfg.Listeners.OnMerkleBlock(p, msg)
+			}
+		/*
 			case *message.MsgSendHeaders:
 				p.flagsMtx.Lock()
 				p.sendHeadersPreferred = true
@@ -19,6 +19,7 @@ import (
 	"github.com/btceasypay/bitcoinpay/p2p/peer/invcache"
 	"github.com/btceasypay/bitcoinpay/p2p/peer/nounce"
 	"github.com/btceasypay/bitcoinpay/params"
+	"github.com/btceasypay/bitcoinpay/services/bloom"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/satori/go.uuid"
 	"net"
@@ -82,6 +83,10 @@ type Peer struct {
 	// Inv
 	knownInventory *invcache.InventoryCache
 
+	// bloom filter loaded by the remote peer, used to filter the relayed
+	// transactions and blocks
+	filter *bloom.Filter
+
 	// prevget
 	PrevGet     PrevGet
 	prevGetHdrs PrevGet
@@ -494,6 +499,7 @@ func newPeerBase(cfg *Config, inbound bool) *Peer {
 		services:        cfg.Services,
 		protocolVersion: protocolVersion,
 		lastGS:          blockdag.NewGraphState(),
+		filter:          bloom.LoadFilter(nil),
 	}
 	p.PrevGet.Init(&p)
 	p.prevGetHdrs.Init(&p)
@@ -0,0 +1,15 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package peerserver
+
+import (
+	"github.com/btceasypay/bitcoinpay/core/message"
+	"github.com/btceasypay/bitcoinpay/p2p/peer"
+)
+
+// OnFilterLoad is invoked when a peer loaded a bloom filter.  The peer asked
+// for the transactions matching the filter, so the relaying of the
+// transactions is enabled again for it.
+func (sp *serverPeer) OnFilterLoad(_ *peer.Peer, msg *message.MsgFilterLoad) {
+	sp.setDisableRelayTx(false)
+}
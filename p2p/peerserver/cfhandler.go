@@ -0,0 +1,103 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package peerserver
+
+import (
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/core/message"
+	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/p2p/peer"
+)
+
+// OnGetCFilter is invoked when a peer receives a getcfilter message.
+func (sp *serverPeer) OnGetCFilter(p *peer.Peer, msg *message.MsgGetCFilter) {
+	// Ignore getcfilter requests if the filters aren't indexed or the node
+	// is not in sync.
+	if sp.server.CfIndex == nil || !sp.server.BlockManager.IsCurrent() {
+		return
+	}
+	if msg.FilterType != message.GCSFilterBasic {
+		log.Debug(fmt.Sprintf("Unsupported filter type %v from %s", msg.FilterType, p))
+		return
+	}
+
+	filter, err := sp.server.CfIndex.FilterByBlockHash(&msg.BlockHash)
+	if err != nil || filter == nil {
+		log.Trace(fmt.Sprintf("Sorry, there is no filter of block %s for %s", msg.BlockHash, p))
+		return
+	}
+	p.QueueMessage(message.NewMsgCFilter(&msg.BlockHash, msg.FilterType, filter), nil)
+}
+
+// OnGetCFHeaders is invoked when a peer receives a getcfheaders message.  The
+// filter hashes are sent for the blocks ordered after the latest locator
+// block, up to the stop block or the maximum number of filter hashes per
+// message.
+func (sp *serverPeer) OnGetCFHeaders(p *peer.Peer, msg *message.MsgGetCFHeaders) {
+	// Ignore getcfheaders requests if the filters aren't indexed or the
+	// node is not in sync.
+	if sp.server.CfIndex == nil || !sp.server.BlockManager.IsCurrent() {
+		return
+	}
+	if msg.FilterType != message.GCSFilterBasic {
+		log.Debug(fmt.Sprintf("Unsupported filter type %v from %s", msg.FilterType, p))
+		return
+	}
+
+	chain := sp.server.BlockManager.GetChain()
+	bd := chain.BlockDAG()
+	start := uint64(0)
+	for _, h := range msg.BlockLocatorHashes {
+		block := bd.GetBlock(h)
+		if block == nil || !block.IsOrdered() {
+			continue
+		}
+		if order := uint64(block.GetOrder()) + 1; order > start {
+			start = order
+		}
+	}
+	end := uint64(chain.BestSnapshot().GraphState.GetMainOrder())
+	if block := bd.GetBlock(&msg.HashStop); block != nil && block.IsOrdered() &&
+		uint64(block.GetOrder()) < end {
+		end = uint64(block.GetOrder())
+	}
+	if start > end {
+		return
+	}
+	if end-start >= message.MaxCFHeadersPerMsg {
+		end = start + message.MaxCFHeadersPerMsg - 1
+	}
+
+	cfHeadersMsg := message.NewMsgCFHeaders()
+	cfHeadersMsg.FilterType = msg.FilterType
+	if start > 0 {
+		prevHeader, err := sp.server.CfIndex.FilterHeaderByOrder(start - 1)
+		if err != nil || prevHeader == nil {
+			log.Trace(fmt.Sprintf("Sorry, there is no filter header at order %d for %s", start-1, p))
+			return
+		}
+		cfHeadersMsg.PrevFilterHeader = *prevHeader
+	}
+	for order := start; order <= end; order++ {
+		blockHash, err := chain.BlockHashByOrder(order)
+		if err != nil {
+			return
+		}
+		filterHash, _, err := sp.server.CfIndex.FilterHashByBlockHash(blockHash)
+		if err != nil || filterHash == nil {
+			log.Trace(fmt.Sprintf("Sorry, there is no filter of block %s for %s", blockHash, p))
+			return
+		}
+		cfHeadersMsg.AddCFHash(filterHash)
+		cfHeadersMsg.StopHash = *blockHash
+	}
+	p.QueueMessage(cfHeadersMsg, nil)
+}
+
+// OnGetCFTypes is invoked when a peer receives a getcftypes message.
+func (sp *serverPeer) OnGetCFTypes(p *peer.Peer, msg *message.MsgGetCFTypes) {
+	if sp.server.CfIndex == nil {
+		return
+	}
+	p.QueueMessage(message.NewMsgCFTypes([]message.FilterType{message.GCSFilterBasic}), nil)
+}
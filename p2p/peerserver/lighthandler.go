@@ -0,0 +1,83 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package peerserver
+
+import (
+	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/message"
+	"github.com/btceasypay/bitcoinpay/p2p/peer"
+)
+
+// HeaderSyncer is the sync service of a light node.  It replaces the block
+// manager when the node only follows the block headers and the graph state
+// of its peers.
+type HeaderSyncer interface {
+	// GraphState returns the graph state of the synced headers.
+	GraphState() *blockdag.GraphState
+
+	// IsCurrent returns whether the headers are synced with the peers.
+	IsCurrent() bool
+
+	// NewPeer informs the syncer of a newly negotiated peer.
+	NewPeer(sp *peer.ServerPeer)
+
+	// DonePeer informs the syncer that a peer has disconnected.
+	DonePeer(sp *peer.ServerPeer)
+
+	// QueueInv passes the blocks announced by a peer to the syncer.
+	QueueInv(inv *message.MsgInv, sp *peer.ServerPeer)
+
+	// QueueHeaders passes the headers received from a peer to the syncer.
+	QueueHeaders(headers *message.MsgHeaders, sp *peer.ServerPeer)
+}
+
+// newLightListeners returns the message listeners of a light node, which
+// neither serves nor relays blocks and transactions.
+func newLightListeners(sp *serverPeer) peer.MessageListeners {
+	return peer.MessageListeners{
+		OnVersion:    sp.OnVersion,
+		OnGetAddr:    sp.OnGetAddr,
+		OnAddr:       sp.OnAddr,
+		OnRead:       sp.OnRead,
+		OnWrite:      sp.OnWrite,
+		OnInv:        sp.OnLightInv,
+		OnHeaders:    sp.OnLightHeaders,
+		OnGraphState: sp.OnLightGraphState,
+		OnSyncResult: sp.OnLightSyncResult,
+		OnSyncPoint:  sp.OnLightSyncPoint,
+		OnFeeFilter:  sp.OnFeeFilter,
+	}
+}
+
+// OnLightInv passes the announced blocks to the header syncer.
+func (sp *serverPeer) OnLightInv(p *peer.Peer, msg *message.MsgInv) {
+	if msg.GS != nil {
+		p.UpdateLastGS(msg.GS)
+	}
+	if len(msg.InvList) > 0 {
+		sp.server.HeaderSync.QueueInv(msg, sp.syncPeer)
+	}
+}
+
+// OnLightHeaders passes the received headers to the header syncer.
+func (sp *serverPeer) OnLightHeaders(p *peer.Peer, msg *message.MsgHeaders) {
+	if msg.GS != nil {
+		p.UpdateLastGS(msg.GS)
+	}
+	sp.server.HeaderSync.QueueHeaders(msg, sp.syncPeer)
+}
+
+// OnLightGraphState records the graph state of the peer.
+func (sp *serverPeer) OnLightGraphState(p *peer.Peer, msg *message.MsgGraphState) {
+	p.UpdateLastGS(msg.GS)
+}
+
+// OnLightSyncResult records the graph state of the peer.
+func (sp *serverPeer) OnLightSyncResult(p *peer.Peer, msg *message.MsgSyncResult) {
+	p.UpdateLastGS(msg.GS)
+}
+
+// OnLightSyncPoint records the graph state of the peer.
+func (sp *serverPeer) OnLightSyncPoint(p *peer.Peer, msg *message.MsgSyncPoint) {
+	p.UpdateLastGS(msg.GS)
+}
@@ -19,6 +19,15 @@ import (
 func NewPeerServer(cfg *config.Config, chainParams *params.Params) (*PeerServer, error) {
 
 	services := defaultServices
+	if cfg.NoCFilters {
+		services &^= protocol.CF
+	}
+	if cfg.PeerBloomFilters {
+		services |= protocol.Bloom
+	}
+	if cfg.LightNode {
+		services = protocol.Light
+	}
 
 	s := PeerServer{
 		services:    services,
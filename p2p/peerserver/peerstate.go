@@ -22,6 +22,13 @@ func (ps *peerState) Count() int {
 		len(ps.persistentPeers)
 }
 
+// updateMetrics updates the metrics of the number of connected peers.
+func (ps *peerState) updateMetrics() {
+	inboundPeersGauge.Update(int64(len(ps.inboundPeers)))
+	outboundPeersGauge.Update(int64(len(ps.outboundPeers) +
+		len(ps.persistentPeers)))
+}
+
 // forAllPeers is a helper function that runs closure on all peers known to
 // peerState.
 func (ps *peerState) forAllPeers(closure func(sp *serverPeer)) {
@@ -77,7 +77,7 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *message.MsgVersion) *message.
 		// Advertise the local address when the server accepts incoming
 		// connections and it believes itself to be close to the best
 		// known tip.
-		if !sp.server.cfg.DisableListen && sp.server.BlockManager.IsCurrent() {
+		if !sp.server.cfg.DisableListen && sp.server.isCurrent() {
 			// Get address that best matches.
 			lna := addrManager.GetBestLocalAddress(remoteAddr)
 			if addmgr.IsRoutable(lna) {
@@ -106,7 +106,7 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *message.MsgVersion) *message.
 
 	// Signal the block manager this peer is a new sync candidate.
 	log.Trace("OnVersion -> NewPeer send to blkMgr msgChan", "peer", sp.syncPeer)
-	sp.server.BlockManager.NewPeer(sp.syncPeer)
+	sp.server.newSyncPeer(sp)
 
 	// Add valid peer to the server.
 	sp.server.AddPeer(sp)
@@ -300,14 +300,16 @@ func (sp *serverPeer) OnGetHeaders(p *peer.Peer, msg *message.MsgGetHeaders) {
 		return
 	}
 
+	// The parents let light nodes link the headers into their DAG.
 	headersMsg := message.NewMsgHeaders(chain.BestSnapshot().GraphState)
 	for i := 0; i < hsLen; i++ {
-		blockHead, err := chain.HeaderByHash(hashSlice[i])
+		block, err := chain.FetchBlockByHash(hashSlice[i])
 		if err != nil {
 			log.Trace(fmt.Sprintf("Sorry, there are not these blocks %s for %s", hashSlice[i].String(), p.String()))
 			return
 		}
-		headersMsg.AddBlockHeader(&blockHead)
+		headersMsg.AddBlockHeaderWithParents(&block.Block().Header,
+			block.Block().Parents)
 	}
 	if len(headersMsg.Headers) > 0 {
 		p.QueueMessage(headersMsg, nil)
@@ -389,6 +391,8 @@ func (sp *serverPeer) OnGetData(p *peer.Peer, msg *message.MsgGetData) {
 			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan)
 		case message.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan)
+		case message.InvTypeFilteredBlock:
+			err = sp.server.pushMerkleBlockMsg(sp, &iv.Hash, c, waitChan)
 		default:
 			log.Warn("Unknown type in inventory request", "type", iv.Type)
 			continue
@@ -512,6 +516,13 @@ func (sp *serverPeer) OnMemPool(_ *peer.Peer, msg *message.MsgMemPool) {
 	invMsg := message.NewMsgInvSizeHint(uint(len(txDescs)))
 
 	for _, txDesc := range txDescs {
+		// Either add all transactions when there is no bloom filter,
+		// or only the transactions that match the filter when there is
+		// one.
+		filter := sp.Filter()
+		if filter.IsLoaded() && !filter.MatchTxAndUpdate(txDesc.Tx) {
+			continue
+		}
 		iv := message.NewInvVect(message.InvTypeTx, txDesc.Tx.Hash())
 		invMsg.AddInvVect(iv)
 		if len(invMsg.InvList)+1 > message.MaxInvPerMsg {
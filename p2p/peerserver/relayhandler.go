@@ -50,6 +50,13 @@ func (s *PeerServer) handleRelayInvMsg(state *peerState, msg relayMsg) {
 				if feeFilter > 0 && txD.FeePerKB < feeFilter {
 					return
 				}
+
+				// Don't relay the transaction if there is a bloom
+				// filter loaded and the transaction doesn't match it.
+				filter := sp.Filter()
+				if filter.IsLoaded() && !filter.MatchTxAndUpdate(txD.Tx) {
+					return
+				}
 			}
 		} else if msg.invVect.Type == message.InvTypeBlock {
 			gs = s.BlockManager.GetChain().BestSnapshot().GraphState
@@ -15,11 +15,13 @@ import (
 	"github.com/btceasypay/bitcoinpay/core/protocol"
 	"github.com/btceasypay/bitcoinpay/core/types"
 	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/metrics"
 	"github.com/btceasypay/bitcoinpay/p2p/addmgr"
 	"github.com/btceasypay/bitcoinpay/p2p/connmgr"
 	"github.com/btceasypay/bitcoinpay/p2p/peer"
 	"github.com/btceasypay/bitcoinpay/params"
 	"github.com/btceasypay/bitcoinpay/services/blkmgr"
+	"github.com/btceasypay/bitcoinpay/services/cf"
 	"github.com/btceasypay/bitcoinpay/services/mempool"
 	"github.com/btceasypay/bitcoinpay/version"
 	"github.com/satori/go.uuid"
@@ -62,6 +64,11 @@ var (
 	// identify ourselves to other peers.
 	userAgentVersion = fmt.Sprintf("%d.%d.%d", version.Major, version.Minor,
 		version.Patch)
+
+	bytesReceivedCounter = metrics.NewCounter("p2p/bytes/received")
+	bytesSentCounter     = metrics.NewCounter("p2p/bytes/sent")
+	inboundPeersGauge    = metrics.NewGauge("p2p/peers/inbound")
+	outboundPeersGauge   = metrics.NewGauge("p2p/peers/outbound")
 )
 
 // Use start to begin accepting connections from peers.
@@ -99,6 +106,10 @@ type PeerServer struct {
 	TimeSource   blockchain.MedianTimeSource
 	BlockManager *blkmgr.BlockManager
 	TxMemPool    *mempool.TxPool
+	CfIndex      *cf.CFIndex
+
+	// HeaderSync replaces the block manager when running as a light node.
+	HeaderSync HeaderSyncer
 
 	services protocol.ServiceFlag
 
@@ -150,8 +161,7 @@ func (s *PeerServer) outboundPeerConnected(c *connmgr.ConnReq) {
 
 // newPeerConfig returns the configuration for the given serverPeer.
 func newPeerConfig(sp *serverPeer) *peer.Config {
-
-	return &peer.Config{
+	config := &peer.Config{
 		Listeners: peer.MessageListeners{
 			OnVersion:        sp.OnVersion,
 			OnGetAddr:        sp.OnGetAddr,
@@ -173,9 +183,10 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnSyncPoint:      sp.OnSyncPoint,
 			OnFeeFilter:      sp.OnFeeFilter,
 			//OnHeaders:        sp.OnHeaders,
-			//OnGetCFilter:     sp.OnGetCFilter,
-			//OnGetCFHeaders:   sp.OnGetCFHeaders,
-			//OnGetCFTypes:     sp.OnGetCFTypes,
+			OnGetCFilter:     sp.OnGetCFilter,
+			OnGetCFHeaders:   sp.OnGetCFHeaders,
+			OnGetCFTypes:     sp.OnGetCFTypes,
+			OnFilterLoad:     sp.OnFilterLoad,
 		},
 		NewestGS:         sp.newestGS,
 		HostToNetAddress: sp.server.addrManager.HostToNetAddress,
@@ -183,10 +194,14 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		UserAgentVersion: userAgentVersion,
 		ChainParams:      sp.server.chainParams,
 		Services:         sp.server.services,
-		DisableRelayTx:   sp.server.cfg.BlocksOnly,
+		DisableRelayTx:   sp.server.cfg.BlocksOnly || sp.server.HeaderSync != nil,
 		ProtocolVersion:  maxProtocolVersion,
 		TrickleInterval:  sp.server.cfg.TrickleInterval,
 	}
+	if sp.server.HeaderSync != nil {
+		config.Listeners = newLightListeners(sp)
+	}
+	return config
 }
 
 // isWhitelisted returns whether the IP address is included in the whitelisted
@@ -291,10 +306,30 @@ func (p *PeerServer) Stop() error {
 // newestBlock returns the current best block hash and height using the format
 // required by the configuration for the peer package.
 func (sp *serverPeer) newestGS() (*blockdag.GraphState, error) {
+	if sp.server.HeaderSync != nil {
+		return sp.server.HeaderSync.GraphState(), nil
+	}
 	best := sp.server.BlockManager.GetChain().BestSnapshot()
 	return best.GraphState, nil
 }
 
+// isCurrent returns whether the node believes it is synced with its peers.
+func (s *PeerServer) isCurrent() bool {
+	if s.HeaderSync != nil {
+		return s.HeaderSync.IsCurrent()
+	}
+	return s.BlockManager.IsCurrent()
+}
+
+// newSyncPeer signals the sync manager that the peer is a new sync candidate.
+func (s *PeerServer) newSyncPeer(sp *serverPeer) {
+	if s.HeaderSync != nil {
+		s.HeaderSync.NewPeer(sp.syncPeer)
+		return
+	}
+	s.BlockManager.NewPeer(sp.syncPeer)
+}
+
 // AddPeer adds a new peer that has already been connected to the server.
 func (s *PeerServer) AddPeer(sp *serverPeer) {
 	s.newPeers <- sp
@@ -304,12 +339,14 @@ func (s *PeerServer) AddPeer(sp *serverPeer) {
 // counter for the server.  It is safe for concurrent access.
 func (s *PeerServer) AddBytesReceived(bytesReceived uint64) {
 	atomic.AddUint64(&s.bytesReceived, bytesReceived)
+	bytesReceivedCounter.Inc(int64(bytesReceived))
 }
 
 // AddBytesSent adds the passed number of bytes to the total bytes sent counter
 // for the server.  It is safe for concurrent access.
 func (s *PeerServer) AddBytesSent(bytesSent uint64) {
 	atomic.AddUint64(&s.bytesSent, bytesSent)
+	bytesSentCounter.Inc(int64(bytesSent))
 }
 
 // peerDoneHandler handles peer disconnects by notifiying the server that it's
@@ -322,7 +359,11 @@ func (s *PeerServer) peerDoneHandler(sp *serverPeer) {
 	// Only tell block manager we are gone if we ever told it we existed.
 	if sp.VersionKnown() && !sp.connReq.Ban {
 		log.Trace("peerDoneHandler send blkmgr donePeerMsg ")
-		s.BlockManager.DonePeer(sp.syncPeer)
+		if s.HeaderSync != nil {
+			s.HeaderSync.DonePeer(sp.syncPeer)
+		} else {
+			s.BlockManager.DonePeer(sp.syncPeer)
+		}
 	}
 	close(sp.quit)
 	log.Trace("stop peerDoneHandler")
@@ -365,11 +406,13 @@ out:
 		// New peers connected to the server.
 		case p := <-s.newPeers:
 			s.handleAddPeerMsg(state, p)
+			state.updateMetrics()
 
 		// Disconnected peers.
 		case p := <-s.donePeers:
 			log.Trace("read peer from donePeers and do handleDonePeerMsg")
 			s.handleDonePeerMsg(state, p)
+			state.updateMetrics()
 
 		// Peer to ban.
 		case p := <-s.banPeers:
@@ -49,6 +49,22 @@ type Checkpoint struct {
 	Hash  *hash.Hash
 }
 
+// AssumeUtxo identifies a trusted snapshot of the utxo set, from which a node
+// can start before having validated the blocks up to it.
+type AssumeUtxo struct {
+	// Order is the DAG order of the last block of the snapshot.
+	Order uint64
+
+	// Hash is the hash of the last block of the snapshot.
+	Hash hash.Hash
+
+	// Count is the number of utxos of the snapshot.
+	Count uint64
+
+	// ContentHash is the hash of the utxos of the snapshot.
+	ContentHash hash.Hash
+}
+
 // DNSSeed identifies a DNS seed.
 type DNSSeed struct {
 	// Host defines the hostname of the seed.
@@ -62,6 +78,9 @@ type DNSSeed struct {
 // ConsensusDeployment defines details related to a specific consensus rule
 // change that is voted in.  This is part of BIP0009.
 type ConsensusDeployment struct {
+	// Name identifies the deployment.
+	Name string
+
 	// BitNumber defines the specific bit number within the block version
 	// this particular soft-fork deployment refers to.
 	BitNumber uint8
@@ -75,6 +94,11 @@ type ConsensusDeployment struct {
 	ExpireTime uint64
 }
 
+// DeploymentUtxoCommitment is the name of the deployment after which the
+// miners commit the UTXO set they see in the state root of the block headers.
+// The state root is not a consensus rule.
+const DeploymentUtxoCommitment = "utxocommitment"
+
 // Params defines a bitcoinpay network by its parameters.  These parameters may be
 // used by bitcoinpay applications to differentiate networks as well as addresses
 // and keys for one network from those intended for use on another network.
@@ -198,6 +222,9 @@ type Params struct {
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
+	// AssumeUtxos are the utxo set snapshots a node can be started from.
+	AssumeUtxos []AssumeUtxo
+
 	// These fields are related to voting on consensus rule changes as
 	// defined by BIP0009.
 	//
@@ -210,7 +237,7 @@ type Params struct {
 	// state retarget window.
 	//
 	// Deployments define the specific consensus rule changes to be voted
-	// on.
+	// on, keyed by the block version of the network they are voted on with.
 	RuleChangeActivationThreshold uint32
 	MinerConfirmationWindow       uint32
 	Deployments                   map[uint32][]ConsensusDeployment
@@ -41,6 +41,10 @@ var MainNetParams = Params{
 		X8r16PowLimitBits:            0x1d00ffff,
 		BitcoinpayKeccak256PowLimit:     mainPowLimit,
 		BitcoinpayKeccak256PowLimitBits: 0x1d00ffff,
+		ProgpowPowLimit:                 mainPowLimit,
+		ProgpowPowLimitBits:             0x1d00ffff,
+		CryptonightPowLimit:             mainPowLimit,
+		CryptonightPowLimitBits:         0x1d00ffff,
 		//hash ffffffffffffffff000000000000000000000000000000000000000000000000 corresponding difficulty is 48 for edge bits 24
 		// Uniform field type uint64 value is 48 . bigToCompact the uint32 value
 		// 24 edge_bits only need hash 1*4 times use for privnet if GPS is 2. need 50 /2 * 4 find once
@@ -84,7 +88,10 @@ var MainNetParams = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: []Checkpoint{},
 
-	Deployments: map[uint32][]ConsensusDeployment{},
+	// Consensus rule change deployments.
+	RuleChangeActivationThreshold: 1916, // 95% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016, // a week of main chain blocks
+	Deployments:                   map[uint32][]ConsensusDeployment{},
 
 	// Address encoding magics
 	NetworkAddressPrefix: "N",
@@ -47,6 +47,10 @@ var MixNetParams = Params{
 		X8r16PowLimitBits:            0x1e00ffff,
 		BitcoinpayKeccak256PowLimit:     testMixNetPowLimit,
 		BitcoinpayKeccak256PowLimitBits: 0x1e00ffff,
+		ProgpowPowLimit:                 testMixNetPowLimit,
+		ProgpowPowLimitBits:             0x1e00ffff,
+		CryptonightPowLimit:             testMixNetPowLimit,
+		CryptonightPowLimitBits:         0x1e00ffff,
 		//hash ffffffffffffffff000000000000000000000000000000000000000000000000 corresponding difficulty is 48 for edge bits 24
 		// Uniform field type uint64 value is 48 . bigToCompact the uint32 value
 		// 24 edge_bits only need hash 1*4 times use for privnet if GPS is 2. need 50 /2 * 2 ≈ 1min find once
@@ -89,8 +93,9 @@ var MixNetParams = Params{
 	Checkpoints: []Checkpoint{},
 
 	// Consensus rule change deployments.
-	//
-	Deployments: map[uint32][]ConsensusDeployment{},
+	RuleChangeActivationThreshold: 1512, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016,
+	Deployments:                   map[uint32][]ConsensusDeployment{},
 
 	// Address encoding magics
 	NetworkAddressPrefix: "X",
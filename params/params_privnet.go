@@ -10,6 +10,7 @@ import (
 	"github.com/btceasypay/bitcoinpay/common"
 	"github.com/btceasypay/bitcoinpay/core/protocol"
 	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"math"
 	"math/big"
 	"time"
 )
@@ -46,6 +47,10 @@ var PrivNetParams = Params{
 		X16rv3PowLimitBits:           0x207fffff,
 		BitcoinpayKeccak256PowLimit:     privNetPowLimit,
 		BitcoinpayKeccak256PowLimitBits: 0x207fffff,
+		ProgpowPowLimit:                 privNetPowLimit,
+		ProgpowPowLimitBits:             0x207fffff,
+		CryptonightPowLimit:             privNetPowLimit,
+		CryptonightPowLimitBits:         0x207fffff,
 		//hash ffffffffffffffff000000000000000000000000000000000000000000000000 corresponding difficulty is 48 for edge bits 24
 		// Uniform field type uint64 value is 48 . bigToCompact the uint32 value
 		// 24 edge_bits only need hash 1 times use for privnet if GPS is 2. need 50 /2 = 25s find once
@@ -84,6 +89,18 @@ var PrivNetParams = Params{
 				BitcoinpayKeccak256Percent: 30,
 				MainHeight:              100,
 			},
+			{
+				Blake2bDPercent:         0,
+				CuckarooPercent:         0,
+				CuckatooPercent:         0,
+				CuckaroomPercent:        40,
+				X16rv3Percent:           0,
+				X8r16Percent:            0,
+				BitcoinpayKeccak256Percent: 30,
+				ProgpowPercent:          15,
+				CryptonightPercent:      15,
+				MainHeight:              150,
+			},
 		},
 		// after this height the big graph will be the main pow graph
 		AdjustmentStartMainHeight: 45 * 1440 * 60 / privTargetTimePerBlock,
@@ -113,7 +130,22 @@ var PrivNetParams = Params{
 	Checkpoints: nil,
 
 	// Consensus rule change deployments.
-	Deployments: map[uint32][]ConsensusDeployment{},
+	RuleChangeActivationThreshold: 108, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       144,
+	Deployments: map[uint32][]ConsensusDeployment{
+		// The block version of the test networks.
+		12: {{
+			Name:       "testdummy",
+			BitNumber:  0,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		}, {
+			Name:       DeploymentUtxoCommitment,
+			BitNumber:  1,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		}},
+	},
 
 	// Address encoding magics
 	NetworkAddressPrefix: "R",
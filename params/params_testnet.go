@@ -45,6 +45,10 @@ var TestNetParams = Params{
 		X8r16PowLimitBits:               0x1b7fffff, // compact from of testNetPowLimit (2^215-1)
 		BitcoinpayKeccak256PowLimit:     testNetPowLimit,
 		BitcoinpayKeccak256PowLimitBits: 0x207fffff, // compact from of testNetPowLimit (2^208-1) 453050367
+		ProgpowPowLimit:                 testNetPowLimit,
+		ProgpowPowLimitBits:             0x207fffff,
+		CryptonightPowLimit:             testNetPowLimit,
+		CryptonightPowLimitBits:         0x207fffff,
 		//hash ffffffffffffffff000000000000000000000000000000000000000000000000 corresponding difficulty is 48 for edge bits 24
 		// Uniform field type uint64 value is 48 . bigToCompact the uint32 value
 		// 24 edge_bits only need hash 1*4 times use for privnet if GPS is 2. need 50 /2 * 4 = 1min find once
@@ -61,6 +65,14 @@ var TestNetParams = Params{
 				CuckarooPercent:            99,
 				MainHeight:                 0,
 			},
+			// evaluate the progpow and cryptonight pow
+			{
+				BitcoinpayKeccak256Percent: 1,
+				CuckarooPercent:            89,
+				ProgpowPercent:             5,
+				CryptonightPercent:         5,
+				MainHeight:                 200000,
+			},
 		},
 		// after this height the big graph will be the main pow graph
 		AdjustmentStartMainHeight: 365 * 1440 * 60 / testTargetTimePerBlock,
@@ -94,8 +106,9 @@ var TestNetParams = Params{
 	Checkpoints: []Checkpoint{},
 
 	// Consensus rule change deployments.
-	//
-	Deployments: map[uint32][]ConsensusDeployment{},
+	RuleChangeActivationThreshold: 1512, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016,
+	Deployments:                   map[uint32][]ConsensusDeployment{},
 
 	// Address encoding magics
 	NetworkAddressPrefix: "T",
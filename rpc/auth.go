@@ -0,0 +1,175 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+//
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/btceasypay/bitcoinpay/config"
+	"github.com/btceasypay/bitcoinpay/log"
+)
+
+const (
+	// authContextKey is the key of the credential of the client in the
+	// context of its requests.
+	authContextKey = "auth"
+
+	// allowAll is the allowlist entry which allows every method.
+	allowAll = "*"
+
+	// apiKeySeparator separates an API key from its allowlist.
+	apiKeySeparator = ":"
+)
+
+// DefaultLimitedAllowlist is the allowlist of the limited user and of the API
+// keys when none is given.  It only allows the default namespace, which leaves
+// out the node control, signing, logging, wallet and mining methods.
+var DefaultLimitedAllowlist = []string{DefaultServiceNameSpace}
+
+// rpcAuth is a set of credentials accepted by the RPC server along with the
+// methods it may call.
+type rpcAuth struct {
+	// name identifies the credentials in the logs.
+	name string
+
+	// authsha is the hash of the Authorization header of the credentials.
+	authsha [sha256.Size]byte
+
+	// allowlist has the namespaces and the namespace_method names which
+	// may be called, or allowAll.
+	allowlist map[string]struct{}
+}
+
+// newRPCAuth returns the credentials of the given Authorization header which
+// may call the methods of the allowlist.
+func newRPCAuth(name, header string, allowlist []string) *rpcAuth {
+	auth := &rpcAuth{
+		name:      name,
+		authsha:   sha256.Sum256([]byte(header)),
+		allowlist: make(map[string]struct{}, len(allowlist)),
+	}
+	for _, entry := range allowlist {
+		auth.allowlist[strings.TrimSpace(entry)] = struct{}{}
+	}
+	return auth
+}
+
+// allows returns whether the credentials may call the method of the namespace.
+func (a *rpcAuth) allows(namespace, method string) bool {
+	if _, ok := a.allowlist[allowAll]; ok {
+		return true
+	}
+	if _, ok := a.allowlist[namespace]; ok {
+		return true
+	}
+	_, ok := a.allowlist[namespace+serviceMethodSeparator+method]
+	return ok
+}
+
+// basicAuthHeader returns the Authorization header of the HTTP Basic
+// authentication with the user and password.
+func basicAuthHeader(user, pass string) string {
+	login := user + ":" + pass
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
+}
+
+// parseRPCAuths returns the credentials of the configuration: the admin user,
+// which may call every method, the limited user and the API keys, given as
+// bearer tokens.
+func parseRPCAuths(cfg *config.Config) ([]*rpcAuth, error) {
+	auths := []*rpcAuth{}
+	if cfg.RPCUser != "" && cfg.RPCPass != "" {
+		auths = append(auths, newRPCAuth(cfg.RPCUser,
+			basicAuthHeader(cfg.RPCUser, cfg.RPCPass), []string{allowAll}))
+	}
+	if cfg.RPCLimitUser != "" && cfg.RPCLimitPass != "" {
+		if cfg.RPCLimitUser == cfg.RPCUser {
+			return nil, fmt.Errorf("the limited user must not be the " +
+				"RPC user")
+		}
+		allowlist := cfg.RPCLimitAllow
+		if len(allowlist) == 0 {
+			allowlist = DefaultLimitedAllowlist
+		}
+		auths = append(auths, newRPCAuth(cfg.RPCLimitUser,
+			basicAuthHeader(cfg.RPCLimitUser, cfg.RPCLimitPass), allowlist))
+	}
+	for i, apiKey := range cfg.RPCAPIKeys {
+		key, allowlist := apiKey, DefaultLimitedAllowlist
+		if j := strings.Index(apiKey, apiKeySeparator); j >= 0 {
+			key = apiKey[:j]
+			allowlist = strings.Split(apiKey[j+1:], ",")
+		}
+		if key == "" {
+			return nil, fmt.Errorf("the API key %d is empty", i)
+		}
+		auths = append(auths, newRPCAuth(fmt.Sprintf("apikey%d", i),
+			"Bearer "+key, allowlist))
+	}
+	return auths, nil
+}
+
+// checkAuth checks the HTTP Basic authentication or the bearer API key
+// supplied by a wallet or RPC client in the HTTP request r, and returns the
+// matching credentials.  If the supplied authentication does not match any
+// of the credentials, a non-nil error is returned.
+//
+// This check is time-constant.
+func (s *RpcServer) checkAuth(r *http.Request, require bool) (*rpcAuth, error) {
+	authhdr := r.Header["Authorization"]
+	if len(authhdr) <= 0 {
+		if require {
+			log.Warn("RPC authentication failure", "from", r.RemoteAddr,
+				"error", "no authorization header")
+			return nil, fmt.Errorf("auth failure")
+		}
+
+		return nil, nil
+	}
+
+	authsha := sha256.Sum256([]byte(authhdr[0]))
+
+	// Check every credential so that the time does not tell which one
+	// matched.
+	var match *rpcAuth
+	for _, auth := range s.auths {
+		cmp := subtle.ConstantTimeCompare(authsha[:], auth.authsha[:])
+		if cmp == 1 && match == nil {
+			match = auth
+		}
+	}
+	if match != nil {
+		return match, nil
+	}
+
+	// Request's auth doesn't match any credentials
+	log.Warn("RPC authentication failure", "from", r.RemoteAddr)
+	return nil, fmt.Errorf("auth failure")
+}
+
+// checkAllowed returns an error if the credentials of the client may not call
+// the method of the request, and logs the denied call.  The requests which do
+// not come from an authenticated client are allowed.
+func checkAllowed(ctx context.Context, req *serverRequest) Error {
+	auth, ok := ctx.Value(authContextKey).(*rpcAuth)
+	if !ok || req.callb == nil {
+		return nil
+	}
+	method := formatName(req.callb.method.Name)
+	if auth.allows(req.svcname, method) {
+		return nil
+	}
+	log.Warn("RPC call denied", "user", auth.name, "method",
+		req.svcname+serviceMethodSeparator+method, "from",
+		ctx.Value("remote"))
+	return &methodNotAllowedError{req.svcname, method}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+//
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/config"
+)
+
+func TestCheckAuth(t *testing.T) {
+	cfg := &config.Config{
+		RPCUser:      "admin",
+		RPCPass:      "secret",
+		RPCLimitUser: "explorer",
+		RPCLimitPass: "readonly",
+		RPCAPIKeys:   []string{"k1", "k2:miner,test_getNodeInfo"},
+	}
+	auths, err := parseRPCAuths(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RpcServer{auths: auths}
+
+	tests := []struct {
+		header  string
+		name    string
+		allowed []string
+		denied  []string
+	}{
+		{basicAuthHeader("admin", "secret"), "admin",
+			[]string{"bitcoinpay_getBlock", "test_stop", "log_setLogLevel"}, nil},
+		{basicAuthHeader("explorer", "readonly"), "explorer",
+			[]string{"bitcoinpay_getBlock"},
+			[]string{"test_stop", "test_txSign", "log_setLogLevel"}},
+		{"Bearer k1", "apikey0", []string{"bitcoinpay_getBlockCount"},
+			[]string{"miner_getBlockTemplate"}},
+		{"Bearer k2", "apikey1",
+			[]string{"miner_getBlockTemplate", "test_getNodeInfo"},
+			[]string{"bitcoinpay_getBlock", "test_stop"}},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Authorization", test.header)
+		auth, err := s.checkAuth(r, true)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if auth.name != test.name {
+			t.Fatalf("got credentials %s, want %s", auth.name, test.name)
+		}
+		for _, m := range test.allowed {
+			svc, method := splitMethod(m)
+			if !auth.allows(svc, method) {
+				t.Errorf("%s may not call %s", test.name, m)
+			}
+		}
+		for _, m := range test.denied {
+			svc, method := splitMethod(m)
+			if auth.allows(svc, method) {
+				t.Errorf("%s may call %s", test.name, m)
+			}
+		}
+	}
+
+	for _, header := range []string{"", basicAuthHeader("explorer", "secret"),
+		"Bearer k3"} {
+		r := httptest.NewRequest("POST", "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if _, err := s.checkAuth(r, true); err == nil {
+			t.Fatalf("authorization %q was accepted", header)
+		}
+	}
+}
+
+// splitMethod splits a namespace_method name.
+func splitMethod(m string) (string, string) {
+	elem := strings.SplitN(m, serviceMethodSeparator, 2)
+	return elem[0], elem[1]
+}
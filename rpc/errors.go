@@ -25,6 +25,18 @@ func (e *methodNotFoundError) Error() string {
 	return fmt.Sprintf("The method %s%s%s does not exist/is not available", e.service, serviceMethodSeparator, e.method)
 }
 
+// request is for a method the credentials of the client may not call
+type methodNotAllowedError struct {
+	service string
+	method  string
+}
+
+func (e *methodNotAllowedError) ErrorCode() int { return -32001 }
+
+func (e *methodNotAllowedError) Error() string {
+	return fmt.Sprintf("The method %s%s%s is not allowed", e.service, serviceMethodSeparator, e.method)
+}
+
 // received message isn't a valid request
 type invalidRequestError struct{ message string }
 
@@ -3,13 +3,11 @@
 package rpc
 
 import (
-	"crypto/sha256"
-	"crypto/subtle"
-	"encoding/base64"
 	"fmt"
 	"github.com/btceasypay/bitcoinpay/common/util"
 	"github.com/btceasypay/bitcoinpay/config"
 	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/metrics"
 	"github.com/deckarep/golang-set"
 	"golang.org/x/net/context"
 	"io"
@@ -23,6 +21,9 @@ import (
 	"time"
 )
 
+// rpcFailureMeter counts the calls returning an error.
+var rpcFailureMeter = metrics.NewMeter("rpc/failures")
+
 // API describes the set of methods offered over the RPC interface
 type API struct {
 	NameSpace string      // namespace under which the rpc methods of Service are exposed
@@ -44,8 +45,9 @@ type RpcServer struct {
 	codecsMu sync.Mutex
 	codecs   mapset.Set
 
-	authsha                [sha256.Size]byte
+	auths                  []*rpcAuth
 	numClients             int32
+	numWebsockets          int32
 	statusLines            map[int]string
 	requestProcessShutdown chan struct{}
 
@@ -116,12 +118,11 @@ func NewRPCServer(cfg *config.Config) (*RpcServer, error) {
 		ReqStatus:              map[string]*RequestStatus{},
 	}
 
-	if cfg.RPCUser != "" && cfg.RPCPass != "" {
-		login := cfg.RPCUser + ":" + cfg.RPCPass
-		auth := "Basic " +
-			base64.StdEncoding.EncodeToString([]byte(login))
-		rpc.authsha = sha256.Sum256([]byte(auth))
+	auths, err := parseRPCAuths(cfg)
+	if err != nil {
+		return nil, err
 	}
+	rpc.auths = auths
 	return &rpc, nil
 }
 
@@ -178,14 +179,17 @@ func (s *RpcServer) startHTTP(listenAddrs []string) error {
 		// Keep track of the number of connected clients.
 		s.incrementClients()
 		defer s.decrementClients()
-		_, err := s.checkAuth(r, true)
+		auth, err := s.checkAuth(r, true)
 		if err != nil {
 			jsonAuthFail(w)
 			return
 		}
 		// Read and respond to the request.
-		s.jsonRPCRead(w, r)
+		s.jsonRPCRead(w, r.WithContext(context.WithValue(r.Context(),
+			authContextKey, auth)))
 	})
+	// Websocket endpoint for long-lived connections and subscriptions.
+	rpcServeMux.Handle(websocketPath, s.handleWebsocket(s.websocketHandler()))
 	listeners, err := parseListeners(s.config, listenAddrs)
 	if err != nil {
 		return err
@@ -235,37 +239,6 @@ func (s *RpcServer) decrementClients() {
 	atomic.AddInt32(&s.numClients, -1)
 }
 
-// TODO, repalace Basic Authentication
-// checkAuth checks the HTTP Basic authentication supplied by a wallet or RPC
-// client in the HTTP request r.  If the supplied authentication does not match
-// the username and password expected, a non-nil error is returned.
-//
-// This check is time-constant.
-func (s *RpcServer) checkAuth(r *http.Request, require bool) (bool, error) {
-	authhdr := r.Header["Authorization"]
-	if len(authhdr) <= 0 {
-		if require {
-			log.Warn("RPC authentication failure", "from", r.RemoteAddr,
-				"error", "no authorization header")
-			return false, fmt.Errorf("auth failure")
-		}
-
-		return false, nil
-	}
-
-	authsha := sha256.Sum256([]byte(authhdr[0]))
-
-	// Check for auth
-	cmp := subtle.ConstantTimeCompare(authsha[:], s.authsha[:])
-	if cmp == 1 {
-		return true, nil
-	}
-
-	// Request's auth doesn't match either user
-	log.Warn("RPC authentication failure", "from", r.RemoteAddr)
-	return false, fmt.Errorf("auth failure")
-}
-
 // jsonAuthFail sends a message back to the client if the http auth is rejected.
 func jsonAuthFail(w http.ResponseWriter) {
 	w.Header().Add("WWW-Authenticate", `Basic realm="bitcoinpay RPC"`)
@@ -505,13 +478,9 @@ func (s *RpcServer) execBatch(ctx context.Context, codec ServerCodec, requests [
 	responses := make([]interface{}, len(requests))
 	var callbacks []func()
 	for i, req := range requests {
-		if req.err != nil {
-			responses[i] = codec.CreateErrorResponse(&req.id, req.err)
-		} else {
-			var callback func()
-			if responses[i], callback = s.handle(ctx, codec, req); callback != nil {
-				callbacks = append(callbacks, callback)
-			}
+		var callback func()
+		if responses[i], callback = s.handle(ctx, codec, req); callback != nil {
+			callbacks = append(callbacks, callback)
 		}
 	}
 
@@ -528,13 +497,7 @@ func (s *RpcServer) execBatch(ctx context.Context, codec ServerCodec, requests [
 
 // exec executes the given request and writes the result back using the codec.
 func (s *RpcServer) exec(ctx context.Context, codec ServerCodec, req *serverRequest) {
-	var response interface{}
-	var callback func()
-	if req.err != nil {
-		response = codec.CreateErrorResponse(&req.id, req.err)
-	} else {
-		response, callback = s.handle(ctx, codec, req)
-	}
+	response, callback := s.handle(ctx, codec, req)
 
 	if err := codec.Write(response); err != nil {
 		log.Error(fmt.Sprintf("%v\n", err))
@@ -549,6 +512,9 @@ func (s *RpcServer) exec(ctx context.Context, codec ServerCodec, req *serverRequ
 
 // handle executes a request and returns the response from the callback.
 func (s *RpcServer) handle(ctx context.Context, codec ServerCodec, req *serverRequest) (interface{}, func()) {
+	if err := checkAllowed(ctx, req); err != nil {
+		return codec.CreateErrorResponse(&req.id, err), nil
+	}
 	if req.err != nil {
 		return codec.CreateErrorResponse(&req.id, req.err), nil
 	}
@@ -603,13 +569,17 @@ func (s *RpcServer) handle(ctx context.Context, codec ServerCodec, req *serverRe
 
 	s.AddRequstStatus(req)
 	// execute RPC method and return result
+	start := time.Now()
 	reply := req.callb.method.Func.Call(arguments)
+	metrics.NewTimer(fmt.Sprintf("rpc/duration{namespace=%q,method=%q}",
+		req.svcname, formatName(req.callb.method.Name))).UpdateSince(start)
 	s.RemoveRequstStatus(req)
 	if len(reply) == 0 {
 		return codec.CreateResponse(req.id, nil), nil
 	}
 	if req.callb.errPos >= 0 { // test if method returned an error
 		if !reply[req.callb.errPos].IsNil() {
+			rpcFailureMeter.Mark(1)
 			e := reply[req.callb.errPos].Interface().(error)
 			res := codec.CreateErrorResponse(&req.id, &callbackError{e.Error()})
 			return res, nil
@@ -0,0 +1,183 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+//
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// The parts code inspired by
+// https://github.com/ethereum/go-ethereum/rpc
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/log"
+	"golang.org/x/net/context"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// websocketPath is the path of the RPC listener which is upgraded to a
+	// websocket connection.
+	websocketPath = "/ws"
+)
+
+// websocketJSONCodec is a custom JSON codec with payload size enforcement and
+// special number parsing.
+var websocketJSONCodec = websocket.Codec{
+	// Marshal is the stock JSON marshaller used by the websocket library too.
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		msg, err := json.Marshal(v)
+		if msg != nil {
+			msg = append(msg, '\n')
+		}
+		return msg, websocket.TextFrame, err
+	},
+	// Unmarshal is a specialized unmarshaller to properly convert numbers.
+	Unmarshal: func(msg []byte, payloadType byte, v interface{}) error {
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		dec.UseNumber()
+
+		return dec.Decode(v)
+	},
+}
+
+// websocketHandler returns a handler that serves JSON-RPC to websocket
+// connections. The connection lives until the client or the server closes it
+// and supports both method invocations and subscriptions.
+func (s *RpcServer) websocketHandler() http.Handler {
+	return websocket.Server{
+		// Authentication and connection limits are checked on the http
+		// upgrade request.  Browsers send the credentials of the node
+		// along with cross-site requests, so the origin must be allowed.
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			return checkWebsocketOrigin(r, s.config.RPCWSOrigins)
+		},
+		Handler: func(conn *websocket.Conn) {
+			// The read deadline of the http server only protects the
+			// upgrade request, long-lived connections must not inherit it.
+			conn.SetReadDeadline(time.Time{})
+
+			// Create a custom encode/decode pair to enforce payload size
+			// and number encoding
+			conn.MaxPayloadBytes = maxRequestContentLength
+
+			encoder := func(v interface{}) error {
+				return websocketJSONCodec.Send(conn, v)
+			}
+			decoder := func(v interface{}) error {
+				return websocketJSONCodec.Receive(conn, v)
+			}
+
+			// The request context is tied to the http handler of the
+			// upgrade request, so the connection gets a fresh one.
+			r := conn.Request()
+			ctx := context.WithValue(context.Background(), "remote", r.RemoteAddr)
+			ctx = context.WithValue(ctx, "scheme", "ws")
+			ctx = context.WithValue(ctx, "local", r.Host)
+			ctx = context.WithValue(ctx, authContextKey,
+				r.Context().Value(authContextKey))
+
+			log.Debug("New websocket client", "from", r.RemoteAddr)
+			s.ServeCodec(ctx, NewCodec(conn, encoder, decoder),
+				OptionMethodInvocation|OptionSubscriptions)
+			log.Debug("Websocket client disconnected", "from", r.RemoteAddr)
+		},
+	}
+}
+
+// checkWebsocketOrigin returns an error when the Origin header of the
+// websocket upgrade request is neither the host of the request nor one of the
+// allowed origins.  Requests without an Origin header do not come from a
+// browser and are accepted.
+func checkWebsocketOrigin(r *http.Request, allowed []string) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("invalid websocket origin %q: %v", origin, err)
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("websocket origin %q not allowed", origin)
+}
+
+// handleWebsocket authenticates the websocket upgrade request and hands the
+// connection over to the websocket handler.
+func (s *RpcServer) handleWebsocket(ws http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&s.run) != 1 { // server stopped
+			http.Error(w, "503 Server is shutting down.",
+				http.StatusServiceUnavailable)
+			return
+		}
+		auth, err := s.checkAuth(r, true)
+		if err != nil {
+			jsonAuthFail(w)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey, auth))
+
+		// Limit the number of websocket connections to max allowed.
+		if s.limitWebsockets(w, r.RemoteAddr) {
+			return
+		}
+		s.incrementWebsockets()
+		defer s.decrementWebsockets()
+
+		ws.ServeHTTP(w, r)
+	}
+}
+
+// ServeCodec reads incoming requests from codec, calls the appropriate callback and writes
+// the response back using the given codec. It will block until the codec is closed or the
+// server is stopped. In either case the codec is closed.
+func (s *RpcServer) ServeCodec(ctx context.Context, codec ServerCodec, options CodecOption) {
+	defer codec.Close()
+	s.serveRequest(ctx, codec, false, options)
+}
+
+// limitWebsockets responds with a 503 service unavailable and returns true if
+// adding another websocket client would exceed the maximum allowed websocket
+// clients.
+//
+// This function is safe for concurrent access.
+func (s *RpcServer) limitWebsockets(w http.ResponseWriter, remoteAddr string) bool {
+	if int(atomic.LoadInt32(&s.numWebsockets)+1) > s.config.RPCMaxWebsockets {
+		log.Info("RPC websocket clients exceeded", "max", s.config.RPCMaxWebsockets,
+			"client", remoteAddr)
+		http.Error(w, "503 Too busy.  Try again later.",
+			http.StatusServiceUnavailable)
+		return true
+	}
+	return false
+}
+
+// incrementWebsockets adds one to the number of connected websocket clients.
+//
+// This function is safe for concurrent access.
+func (s *RpcServer) incrementWebsockets() {
+	atomic.AddInt32(&s.numWebsockets, 1)
+}
+
+// decrementWebsockets subtracts one from the number of connected websocket
+// clients.
+//
+// This function is safe for concurrent access.
+func (s *RpcServer) decrementWebsockets() {
+	atomic.AddInt32(&s.numWebsockets, -1)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+//
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckWebsocketOrigin(t *testing.T) {
+	tests := []struct {
+		origin  string
+		allowed []string
+		ok      bool
+	}{
+		// Not a browser.
+		{origin: "", ok: true},
+		// Same host.
+		{origin: "http://127.0.0.1:11234", ok: true},
+		{origin: "https://evil.example", ok: false},
+		{origin: "https://wallet.example", allowed: []string{"https://wallet.example/"}, ok: true},
+		{origin: "https://evil.example", allowed: []string{"https://wallet.example"}, ok: false},
+		{origin: "https://evil.example", allowed: []string{"*"}, ok: true},
+		{origin: "null", ok: false},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "http://127.0.0.1:11234/ws", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		err := checkWebsocketOrigin(r, test.allowed)
+		if (err == nil) != test.ok {
+			t.Errorf("test %d: origin %q, err %v, want allowed %v", i,
+				test.origin, err, test.ok)
+		}
+	}
+}
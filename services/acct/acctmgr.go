@@ -1,35 +1,421 @@
 package acct
 
 import (
+	"crypto/rand"
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/config"
+	"github.com/btceasypay/bitcoinpay/core/address"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/crypto/bip32"
+	"github.com/btceasypay/bitcoinpay/crypto/ecc"
+	"github.com/btceasypay/bitcoinpay/database"
 	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/node/notify"
+	"github.com/btceasypay/bitcoinpay/params"
 	"github.com/btceasypay/bitcoinpay/rpc"
+	"github.com/btceasypay/bitcoinpay/services/blkmgr"
+	"github.com/btceasypay/bitcoinpay/services/mempool"
+	"github.com/btceasypay/bitcoinpay/wallet"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+const (
+	// defaultAccountName is the name of the account created with the wallet.
+	defaultAccountName = "default"
+
+	// walletDirname is the name of the wallet directory inside the data
+	// directory.
+	walletDirname = "wallet"
+
+	// seedLen is the length in bytes of the generated wallet seeds.
+	seedLen = 32
+
+	// branches of an HD account as per BIP44.
+	externalBranch = 0
+	internalBranch = 1
+
+	// addrGapLimit is the number of unused addresses watched past the last
+	// used one of each account branch, so the addresses handed out by a
+	// restored wallet are found in the blocks.
+	addrGapLimit = 20
+)
+
+// addrInfo locates an address inside the HD wallet.
+type addrInfo struct {
+	account uint32
+	branch  uint32
+	index   uint32
+}
+
 // account manager communicate with various backends for signing transactions.
 type AccountManager struct {
+	mtx sync.RWMutex
+
+	params *params.Params
+	db     database.DB
+	bm     *blkmgr.BlockManager
+	chain  *blockchain.BlockChain
+	txPool *mempool.TxPool
+	ntmgr  notify.Notify
+	dir    string
+
+	// ks is nil until the wallet is created.
+	ks *keyStore
+
+	// addrs maps the encoded addresses of the wallet to their location,
+	// including the addrGapLimit addresses watched past each branch.
+	addrs map[string]*addrInfo
+
+	// seed is only set while the wallet is unlocked.
+	seed      []byte
+	lockTimer *time.Timer
 }
 
 func (a *AccountManager) Start() error {
 	log.Debug("Starting account manager")
+	if err := createUtxoBuckets(a.db); err != nil {
+		return err
+	}
+	ks, err := loadKeyStore(keyStorePath(a.dir))
+	if err != nil && err != ErrNoWallet {
+		return err
+	}
+	if ks != nil {
+		a.mtx.Lock()
+		err = a.setKeyStore(ks)
+		a.mtx.Unlock()
+		if err != nil {
+			return err
+		}
+		log.Info("Wallet loaded", "path", ks.path, "accounts", len(ks.Accounts))
+	}
+	a.chain.Subscribe(a.handleChainNotification)
 	return nil
 }
 
 func (a *AccountManager) Stop() error {
 	log.Debug("Stopping account manager")
+	a.Lock()
 	return nil
 }
 
-func (a AccountManager) APIs() []rpc.API {
+func (a *AccountManager) APIs() []rpc.API {
 	return []rpc.API{
 		{
 			NameSpace: rpc.DefaultServiceNameSpace,
-			Service:   NewPublicAccountManagerAPI(&a),
+			Service:   NewPublicAccountManagerAPI(a),
 			Public:    true,
 		},
+		{
+			NameSpace: rpc.WalletNameSpace,
+			Service:   NewPrivateWalletAPI(a),
+			Public:    false,
+		},
+	}
+}
+
+// CreateWallet creates the keystore encrypted with passphrase and its default
+// account.  A random seed is generated when none is given.
+func (a *AccountManager) CreateWallet(passphrase string, seed []byte) error {
+	if len(passphrase) == 0 {
+		return fmt.Errorf("empty passphrase")
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.ks != nil {
+		return ErrWalletExists
+	}
+	if seed == nil {
+		seed = make([]byte, seedLen)
+		if _, err := rand.Read(seed); err != nil {
+			return err
+		}
+	}
+	ks, err := newKeyStore(keyStorePath(a.dir), seed, passphrase)
+	if err != nil {
+		return err
+	}
+	acct, err := a.deriveAccount(seed, defaultAccountName, 0)
+	if err != nil {
+		return err
+	}
+	ks.Accounts = append(ks.Accounts, acct)
+	if err := ks.save(); err != nil {
+		return err
+	}
+	return a.setKeyStore(ks)
+}
+
+// Unlock decrypts the wallet seed and keeps it in memory for timeout, zero
+// keeps the wallet unlocked until Lock is called.
+func (a *AccountManager) Unlock(passphrase string, timeout time.Duration) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.ks == nil {
+		return ErrNoWallet
+	}
+	seed, err := a.ks.decryptSeed(passphrase)
+	if err != nil {
+		return err
+	}
+	a.seed = seed
+	if a.lockTimer != nil {
+		a.lockTimer.Stop()
+		a.lockTimer = nil
+	}
+	if timeout > 0 {
+		a.lockTimer = time.AfterFunc(timeout, a.Lock)
+	}
+	return nil
+}
+
+// Lock removes the wallet seed from memory.
+func (a *AccountManager) Lock() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for i := range a.seed {
+		a.seed[i] = 0
+	}
+	a.seed = nil
+	if a.lockTimer != nil {
+		a.lockTimer.Stop()
+		a.lockTimer = nil
+	}
+}
+
+// CreateAccount adds a new HD account, the wallet must be unlocked since the
+// account keys are hardened.
+func (a *AccountManager) CreateAccount(name string) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.ks == nil {
+		return ErrNoWallet
+	}
+	if a.seed == nil {
+		return fmt.Errorf("wallet is locked")
+	}
+	if a.ks.account(name) != nil {
+		return fmt.Errorf("account %s already exists", name)
+	}
+	acct, err := a.deriveAccount(a.seed, name, uint32(len(a.ks.Accounts)))
+	if err != nil {
+		return err
+	}
+	a.ks.Accounts = append(a.ks.Accounts, acct)
+	if err := a.ks.save(); err != nil {
+		a.ks.Accounts = a.ks.Accounts[:len(a.ks.Accounts)-1]
+		return err
+	}
+	return nil
+}
+
+// NewAddress returns the next external address of the account.
+func (a *AccountManager) NewAddress(name string) (string, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.nextAddress(name, externalBranch)
+}
+
+// nextAddress derives and records the next address of the account branch.
+//
+// This function MUST be called with the account manager lock held.
+func (a *AccountManager) nextAddress(name string, branch uint32) (string, error) {
+	acct, err := a.account(name)
+	if err != nil {
+		return "", err
+	}
+	next := &acct.NextExternal
+	if branch == internalBranch {
+		next = &acct.NextInternal
+	}
+	addr, err := a.deriveAddress(acct, branch, *next)
+	if err != nil {
+		return "", err
+	}
+	*next++
+	if err := a.ks.save(); err != nil {
+		*next--
+		return "", err
+	}
+	err = a.indexAddresses(a.addrs, acct, branch, *next-1+addrGapLimit, *next+addrGapLimit)
+	if err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// markUsed advances the next address of the account branch past the used
+// address, watching addrGapLimit unused addresses after it.  It returns
+// whether the keystore has to be saved.
+//
+// This function MUST be called with the account manager lock held.
+func (a *AccountManager) markUsed(info *addrInfo) (bool, error) {
+	acct := a.ks.Accounts[info.account]
+	next := &acct.NextExternal
+	if info.branch == internalBranch {
+		next = &acct.NextInternal
+	}
+	if info.index < *next {
+		return false, nil
+	}
+	from := *next + addrGapLimit
+	*next = info.index + 1
+	return true, a.indexAddresses(a.addrs, acct, info.branch, from, *next+addrGapLimit)
+}
+
+// account returns the account with the given name.
+//
+// This function MUST be called with the account manager lock held.
+func (a *AccountManager) account(name string) (*accountInfo, error) {
+	if a.ks == nil {
+		return nil, ErrNoWallet
+	}
+	acct := a.ks.account(name)
+	if acct == nil {
+		return nil, fmt.Errorf("account %s not found", name)
+	}
+	return acct, nil
+}
+
+// setKeyStore installs the keystore and indexes the addresses which were
+// already handed out along with the watched ones.
+//
+// This function MUST be called with the account manager lock held.
+func (a *AccountManager) setKeyStore(ks *keyStore) error {
+	addrs := make(map[string]*addrInfo)
+	for _, acct := range ks.Accounts {
+		for _, branch := range []uint32{externalBranch, internalBranch} {
+			next := acct.NextExternal
+			if branch == internalBranch {
+				next = acct.NextInternal
+			}
+			if err := a.indexAddresses(addrs, acct, branch, 0, next+addrGapLimit); err != nil {
+				return err
+			}
+		}
+	}
+	a.ks = ks
+	a.addrs = addrs
+	return nil
+}
+
+// indexAddresses derives the addresses of the account branch from index from
+// up to index to, excluded, and adds them to addrs.
+func (a *AccountManager) indexAddresses(addrs map[string]*addrInfo, acct *accountInfo, branch, from, to uint32) error {
+	for i := from; i < to; i++ {
+		addr, err := a.deriveAddress(acct, branch, i)
+		if err != nil {
+			return err
+		}
+		addrs[addr] = &addrInfo{account: acct.Index, branch: branch, index: i}
+	}
+	return nil
+}
+
+// bip32Version returns the extended key versions of the network.
+func (a *AccountManager) bip32Version() bip32.Bip32Version {
+	return bip32.Bip32Version{
+		PrivKeyVersion: a.params.HDPrivateKeyID[:],
+		PubKeyVersion:  a.params.HDPublicKeyID[:],
+	}
+}
+
+// accountKey derives the private key of the account, following
+// wallet.BitcoinpayBaseDerivationPath with the account index added to the
+// hardened account level: m/44'/223'/account'.
+func (a *AccountManager) accountKey(seed []byte, index uint32) (*bip32.Key, error) {
+	key, err := bip32.NewMasterKey2(seed, a.bip32Version())
+	if err != nil {
+		return nil, err
+	}
+	path := wallet.BitcoinpayBaseDerivationPath
+	for _, child := range []uint32{path[0], path[1], path[2] + index} {
+		key, err = key.NewChildKey(child)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// deriveAccount returns the account with the extended public keys of its
+// external and internal branches.
+func (a *AccountManager) deriveAccount(seed []byte, name string, index uint32) (*accountInfo, error) {
+	key, err := a.accountKey(seed, index)
+	if err != nil {
+		return nil, err
+	}
+	external, err := key.NewChildKey(externalBranch)
+	if err != nil {
+		return nil, err
+	}
+	internal, err := key.NewChildKey(internalBranch)
+	if err != nil {
+		return nil, err
 	}
+	return &accountInfo{
+		Name:        name,
+		Index:       index,
+		ExternalKey: external.PublicKey().B58Serialize(),
+		InternalKey: internal.PublicKey().B58Serialize(),
+	}, nil
 }
 
-func New() (*AccountManager, error) {
-	a := AccountManager{}
+// deriveAddress returns the encoded address at the index of the account
+// branch, derived from the branch extended public key.
+func (a *AccountManager) deriveAddress(acct *accountInfo, branch, index uint32) (string, error) {
+	xpub := acct.ExternalKey
+	if branch == internalBranch {
+		xpub = acct.InternalKey
+	}
+	key, err := bip32.B58Deserialize(xpub, a.bip32Version())
+	if err != nil {
+		return "", err
+	}
+	child, err := key.NewChildKey(index)
+	if err != nil {
+		return "", err
+	}
+	addr, err := address.NewPubKeyHashAddress(hash.Hash160(child.Key), a.params, ecc.ECDSA_Secp256k1)
+	if err != nil {
+		return "", err
+	}
+	return addr.Encode(), nil
+}
+
+// privateKey derives the private key of a wallet address.
+//
+// This function MUST be called with the account manager lock held and the
+// wallet unlocked.
+func (a *AccountManager) privateKey(info *addrInfo) (ecc.PrivateKey, error) {
+	key, err := a.accountKey(a.seed, info.account)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range []uint32{info.branch, info.index} {
+		key, err = key.NewChildKey(child)
+		if err != nil {
+			return nil, err
+		}
+	}
+	priv, _ := ecc.Secp256k1.PrivKeyFromBytes(key.Key)
+	return priv, nil
+}
+
+func New(cfg *config.Config, par *params.Params, db database.DB, bm *blkmgr.BlockManager,
+	txPool *mempool.TxPool, ntmgr notify.Notify) (*AccountManager, error) {
+	a := AccountManager{
+		params: par,
+		db:     db,
+		bm:     bm,
+		chain:  bm.GetChain(),
+		txPool: txPool,
+		ntmgr:  ntmgr,
+		dir:    filepath.Join(cfg.DataDir, walletDirname),
+		addrs:  make(map[string]*addrInfo),
+	}
 	return &a, nil
 }
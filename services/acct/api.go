@@ -1,5 +1,18 @@
 package acct
 
+import (
+	"encoding/hex"
+	"github.com/btceasypay/bitcoinpay/core/json"
+	"github.com/btceasypay/bitcoinpay/rpc"
+	"time"
+)
+
+const (
+	// defaultMinConf is the number of confirmations required when the
+	// caller doesn't give one.
+	defaultMinConf = 1
+)
+
 // PublicEthereumAPI provides an API to access Ethereum full node-related
 // information.
 type PublicAccountManagerAPI struct {
@@ -11,6 +24,134 @@ func NewPublicAccountManagerAPI(a *AccountManager) *PublicAccountManagerAPI {
 	return &PublicAccountManagerAPI{a}
 }
 
-func (api *PublicAccountManagerAPI) GetBalance() int32 {
-	return 0
+// GetBalance returns the spendable balance in atoms of the wallet account
+// with at least minConf confirmations.
+func (api *PublicAccountManagerAPI) GetBalance(account *string, minConf *uint) (uint64, error) {
+	balance, err := api.a.Balance(accountName(account), confirmations(minConf))
+	if err != nil {
+		return 0, rpc.RpcInvalidError("%v", err)
+	}
+	return balance, nil
+}
+
+// PrivateWalletAPI manages the node wallet.
+type PrivateWalletAPI struct {
+	a *AccountManager
+}
+
+func NewPrivateWalletAPI(a *AccountManager) *PrivateWalletAPI {
+	return &PrivateWalletAPI{a}
+}
+
+// CreateWallet creates the wallet encrypted with the passphrase, an hex
+// encoded seed can be given to restore a wallet, whose coins are then found
+// by rescanning the blocks.
+func (api *PrivateWalletAPI) CreateWallet(passphrase string, seedHex *string) (interface{}, error) {
+	var seed []byte
+	if seedHex != nil {
+		var err error
+		seed, err = hex.DecodeString(*seedHex)
+		if err != nil {
+			return nil, rpc.RpcDecodeHexError(*seedHex)
+		}
+	}
+	if err := api.a.CreateWallet(passphrase, seed); err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	if seed != nil {
+		// The restored wallet may already own coins in the blocks.
+		if err := api.a.Rescan(); err != nil {
+			return nil, rpc.RpcInternalError(err.Error(), "Rescan")
+		}
+	}
+	return nil, nil
+}
+
+// Unlock decrypts the wallet keys for timeout seconds, zero keeps the wallet
+// unlocked until lock is called.
+func (api *PrivateWalletAPI) Unlock(passphrase string, timeout uint64) (interface{}, error) {
+	err := api.a.Unlock(passphrase, time.Duration(timeout)*time.Second)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return nil, nil
+}
+
+// Lock removes the wallet keys from memory.
+func (api *PrivateWalletAPI) Lock() (interface{}, error) {
+	api.a.Lock()
+	return nil, nil
+}
+
+// CreateAccount adds a new account to the unlocked wallet.
+func (api *PrivateWalletAPI) CreateAccount(name string) (interface{}, error) {
+	if err := api.a.CreateAccount(name); err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return nil, nil
+}
+
+// ListAccounts returns the accounts of the wallet with their balance.
+func (api *PrivateWalletAPI) ListAccounts(minConf *uint) ([]json.AccountResult, error) {
+	api.a.mtx.RLock()
+	if api.a.ks == nil {
+		api.a.mtx.RUnlock()
+		return nil, rpc.RpcInvalidError("%v", ErrNoWallet)
+	}
+	results := make([]json.AccountResult, 0, len(api.a.ks.Accounts))
+	for _, acct := range api.a.ks.Accounts {
+		results = append(results, json.AccountResult{Name: acct.Name, Index: acct.Index})
+	}
+	api.a.mtx.RUnlock()
+
+	for i := range results {
+		balance, err := api.a.Balance(results[i].Name, confirmations(minConf))
+		if err != nil {
+			return nil, rpc.RpcInternalError(err.Error(), "Balance")
+		}
+		results[i].Balance = balance
+	}
+	return results, nil
+}
+
+// GetNewAddress returns a new receiving address of the account.
+func (api *PrivateWalletAPI) GetNewAddress(account *string) (string, error) {
+	addr, err := api.a.NewAddress(accountName(account))
+	if err != nil {
+		return "", rpc.RpcInvalidError("%v", err)
+	}
+	return addr, nil
+}
+
+// ListUnspent returns the unspent outputs of the account.
+func (api *PrivateWalletAPI) ListUnspent(account *string, minConf *uint) ([]json.ListUnspentResult, error) {
+	results, err := api.a.ListUnspent(accountName(account), confirmations(minConf))
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return results, nil
+}
+
+// SendToAddress pays amount atoms to the address from the account, the wallet
+// must be unlocked.
+func (api *PrivateWalletAPI) SendToAddress(address string, amount uint64, account *string, minConf *uint) (string, error) {
+	txHash, err := api.a.SendToAddress(accountName(account), address, amount, confirmations(minConf))
+	if err != nil {
+		return "", rpc.RpcInvalidError("%v", err)
+	}
+	return txHash.String(), nil
+}
+
+func accountName(account *string) string {
+	if account == nil || len(*account) == 0 {
+		return defaultAccountName
+	}
+	return *account
+}
+
+func confirmations(minConf *uint) uint {
+	if minConf == nil {
+		return defaultMinConf
+	}
+	return *minConf
 }
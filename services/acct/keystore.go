@@ -0,0 +1,224 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package acct
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/util"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// keyStoreVersion is the current version of the keystore file format.
+	keyStoreVersion = 1
+
+	// keyStoreFilename is the name of the keystore file inside the wallet
+	// directory.
+	keyStoreFilename = "keystore.json"
+
+	// scrypt parameters used to derive the encryption key from the
+	// passphrase.
+	scryptN      = 1 << 18
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	scryptSalt   = 32
+)
+
+var (
+	// ErrWalletExists is returned when creating a wallet which already
+	// exists on disk.
+	ErrWalletExists = errors.New("wallet already exists")
+
+	// ErrNoWallet is returned when the wallet has not been created yet.
+	ErrNoWallet = errors.New("wallet not found, create it first")
+
+	// ErrWrongPassphrase is returned when the seed can't be decrypted with
+	// the given passphrase.
+	ErrWrongPassphrase = errors.New("could not decrypt key with given passphrase")
+)
+
+// cryptoJSON holds the encrypted seed with the parameters of the key
+// derivation function.
+type cryptoJSON struct {
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	CipherText string `json:"ciphertext"`
+}
+
+// accountInfo is an HD account of the wallet.  Only the extended public keys
+// of the external and internal (change) branches are stored, so addresses can
+// be derived while the wallet is locked.
+type accountInfo struct {
+	Name         string `json:"name"`
+	Index        uint32 `json:"index"`
+	ExternalKey  string `json:"externalkey"`
+	InternalKey  string `json:"internalkey"`
+	NextExternal uint32 `json:"nextexternal"`
+	NextInternal uint32 `json:"nextinternal"`
+}
+
+// keyStore is the wallet file on disk.
+type keyStore struct {
+	path string
+
+	Version  int            `json:"version"`
+	Crypto   cryptoJSON     `json:"crypto"`
+	Accounts []*accountInfo `json:"accounts"`
+}
+
+// keyStorePath returns the path of the keystore inside the wallet directory.
+func keyStorePath(dir string) string {
+	return filepath.Join(dir, keyStoreFilename)
+}
+
+// newKeyStore encrypts the seed with the passphrase and returns a keystore
+// which is not yet written to disk.
+func newKeyStore(path string, seed []byte, passphrase string) (*keyStore, error) {
+	crypto, err := encryptSeed(seed, []byte(passphrase), scryptN, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	return &keyStore{
+		path:    path,
+		Version: keyStoreVersion,
+		Crypto:  *crypto,
+	}, nil
+}
+
+// loadKeyStore reads the keystore from disk.
+func loadKeyStore(path string) (*keyStore, error) {
+	if !util.FileExists(path) {
+		return nil, ErrNoWallet
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks := &keyStore{}
+	if err := json.Unmarshal(data, ks); err != nil {
+		return nil, err
+	}
+	if ks.Version != keyStoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+	ks.path = path
+	return ks, nil
+}
+
+// save atomically writes the keystore to disk.
+func (ks *keyStore) save() error {
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0700); err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+// decryptSeed returns the seed of the wallet.
+func (ks *keyStore) decryptSeed(passphrase string) ([]byte, error) {
+	return decryptSeed(&ks.Crypto, []byte(passphrase))
+}
+
+// account returns the account with the given name.
+func (ks *keyStore) account(name string) *accountInfo {
+	for _, acct := range ks.Accounts {
+		if acct.Name == name {
+			return acct
+		}
+	}
+	return nil
+}
+
+// encryptSeed encrypts the seed with AES-256-GCM using a key derived from the
+// passphrase by scrypt.
+func encryptSeed(seed, passphrase []byte, scryptN, scryptP int) (*cryptoJSON, error) {
+	salt := make([]byte, scryptSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &cryptoJSON{
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       hex.EncodeToString(salt),
+		Nonce:      hex.EncodeToString(nonce),
+		CipherText: hex.EncodeToString(gcm.Seal(nil, nonce, seed, nil)),
+	}, nil
+}
+
+// decryptSeed reverses encryptSeed.
+func decryptSeed(c *cryptoJSON, passphrase []byte) ([]byte, error) {
+	if c.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported kdf %s", c.KDF)
+	}
+	salt, err := hex.DecodeString(c.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(c.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(c.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, salt, c.N, c.R, c.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length %d", len(nonce))
+	}
+	seed, err := gcm.Open(nil, nonce, cipherText, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return seed, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
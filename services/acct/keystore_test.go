@@ -0,0 +1,110 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package acct
+
+import (
+	"bytes"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/address"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/crypto/ecc"
+	"github.com/btceasypay/bitcoinpay/params"
+	"path/filepath"
+	"testing"
+)
+
+// testScryptN keeps the tests fast, the keystore uses scryptN.
+const testScryptN = 1 << 4
+
+func TestSeedEncryption(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x5a}, seedLen)
+	c, err := encryptSeed(seed, []byte("passphrase"), testScryptN, scryptP)
+	if err != nil {
+		t.Fatalf("encryptSeed: %v", err)
+	}
+	got, err := decryptSeed(c, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("decryptSeed: %v", err)
+	}
+	if !bytes.Equal(got, seed) {
+		t.Fatalf("decrypted seed mismatch: got %x, want %x", got, seed)
+	}
+	if _, err := decryptSeed(c, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Fatalf("decryptSeed with wrong passphrase: got %v, want %v", err, ErrWrongPassphrase)
+	}
+}
+
+func TestKeyStoreSaveLoad(t *testing.T) {
+	path := keyStorePath(t.TempDir())
+	crypto, err := encryptSeed([]byte{1, 2, 3}, []byte("pass"), testScryptN, scryptP)
+	if err != nil {
+		t.Fatalf("encryptSeed: %v", err)
+	}
+	ks := &keyStore{path: path, Version: keyStoreVersion, Crypto: *crypto}
+	ks.Accounts = append(ks.Accounts, &accountInfo{Name: defaultAccountName, NextExternal: 3})
+	if err := ks.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := loadKeyStore(path)
+	if err != nil {
+		t.Fatalf("loadKeyStore: %v", err)
+	}
+	acct := loaded.account(defaultAccountName)
+	if acct == nil || acct.NextExternal != 3 {
+		t.Fatalf("unexpected account %+v", acct)
+	}
+	if _, err := loadKeyStore(filepath.Join(filepath.Dir(path), "missing.json")); err != ErrNoWallet {
+		t.Fatalf("loadKeyStore missing file: got %v, want %v", err, ErrNoWallet)
+	}
+}
+
+// TestAddressDerivation checks that the addresses derived from the account
+// extended public keys match the private keys used for signing.
+func TestAddressDerivation(t *testing.T) {
+	a := &AccountManager{params: &params.PrivNetParams}
+	seed := bytes.Repeat([]byte{0x11}, seedLen)
+	acct, err := a.deriveAccount(seed, defaultAccountName, 1)
+	if err != nil {
+		t.Fatalf("deriveAccount: %v", err)
+	}
+	a.seed = seed
+	for _, branch := range []uint32{externalBranch, internalBranch} {
+		addr, err := a.deriveAddress(acct, branch, 2)
+		if err != nil {
+			t.Fatalf("deriveAddress: %v", err)
+		}
+		priv, err := a.privateKey(&addrInfo{account: 1, branch: branch, index: 2})
+		if err != nil {
+			t.Fatalf("privateKey: %v", err)
+		}
+		_, pub := ecc.Secp256k1.PrivKeyFromBytes(priv.Serialize())
+		want, err := address.NewPubKeyHashAddress(hash.Hash160(pub.SerializeCompressed()),
+			a.params, ecc.ECDSA_Secp256k1)
+		if err != nil {
+			t.Fatalf("NewPubKeyHashAddress: %v", err)
+		}
+		if addr != want.Encode() {
+			t.Fatalf("branch %d: got address %s, want %s", branch, addr, want.Encode())
+		}
+	}
+}
+
+func TestWalletUtxoSerialization(t *testing.T) {
+	u := &walletUtxo{
+		outPoint:  *types.NewOutPoint(&hash.Hash{0x01}, 7),
+		account:   2,
+		amount:    12345,
+		coinbase:  true,
+		blockHash: hash.Hash{0x02},
+		pkScript:  []byte{0x76, 0xa9},
+	}
+	got, err := deserializeWalletUtxo(outpointKey(&u.outPoint), u.serialize())
+	if err != nil {
+		t.Fatalf("deserializeWalletUtxo: %v", err)
+	}
+	if got.outPoint != u.outPoint || got.account != u.account || got.amount != u.amount ||
+		got.coinbase != u.coinbase || got.blockHash != u.blockHash ||
+		!bytes.Equal(got.pkScript, u.pkScript) {
+		t.Fatalf("utxo mismatch: got %+v, want %+v", got, u)
+	}
+}
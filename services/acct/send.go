@@ -0,0 +1,166 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package acct
+
+import (
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/address"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/crypto/ecc"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+	"sort"
+)
+
+// SendToAddress pays amount atoms to the address from the coins of the
+// account, returning the hash of the transaction submitted to the mempool.
+// The change goes to a new internal address of the account.
+func (a *AccountManager) SendToAddress(account string, addrStr string, amount uint64, minConf uint) (*hash.Hash, error) {
+	if amount == 0 {
+		return nil, fmt.Errorf("invalid amount")
+	}
+	addr, err := address.DecodeAddress(addrStr)
+	if err != nil {
+		return nil, err
+	}
+	if !address.IsForNetwork(addr, a.params) {
+		return nil, fmt.Errorf("wrong network: %v", addrStr)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mtx.Lock()
+	tx, err := a.createTx(account, types.NewTxOutput(amount, pkScript), minConf)
+	a.mtx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	acceptedTxs, err := a.bm.ProcessTransaction(tx, false, false, true)
+	if err != nil {
+		return nil, err
+	}
+	a.ntmgr.AnnounceNewTransactions(acceptedTxs)
+	return tx.Hash(), nil
+}
+
+// createTx selects the coins of the account paying for the output and its
+// fee, then signs the transaction.
+//
+// This function MUST be called with the account manager lock held.
+func (a *AccountManager) createTx(account string, output *types.TxOutput, minConf uint) (*types.Tx, error) {
+	acct, err := a.account(account)
+	if err != nil {
+		return nil, err
+	}
+	if a.seed == nil {
+		return nil, fmt.Errorf("wallet is locked")
+	}
+	utxos, err := a.fetchUtxos(acct.Index)
+	if err != nil {
+		return nil, err
+	}
+	spendable := utxos[:0]
+	for _, u := range utxos {
+		if a.isSpendable(u, minConf) {
+			spendable = append(spendable, u)
+		}
+	}
+	// Spend the largest coins first to keep the transaction small.
+	sort.Slice(spendable, func(i, j int) bool {
+		return spendable[i].amount > spendable[j].amount
+	})
+
+	// The fee depends on the size of the signed transaction, so start
+	// without fee and rebuild the transaction until the fee covers it.
+	var changeAddr string
+	fee := uint64(0)
+	for {
+		var selected []*walletUtxo
+		total := uint64(0)
+		for _, u := range spendable {
+			if total >= output.Amount+fee {
+				break
+			}
+			selected = append(selected, u)
+			total += u.amount
+		}
+		if total < output.Amount+fee {
+			return nil, fmt.Errorf("insufficient funds: available %d, needed %d",
+				total, output.Amount+fee)
+		}
+
+		mtx := types.NewTransaction()
+		for _, u := range selected {
+			op := u.outPoint
+			mtx.AddTxIn(types.NewTxInput(&op, nil))
+		}
+		mtx.AddTxOut(output)
+		if change := total - output.Amount - fee; change > 0 {
+			if changeAddr == "" {
+				changeAddr, err = a.nextAddress(account, internalBranch)
+				if err != nil {
+					return nil, err
+				}
+			}
+			changeOut, err := a.payToEncodedAddr(changeAddr, change)
+			if err != nil {
+				return nil, err
+			}
+			if !a.txPool.IsDust(changeOut) {
+				mtx.AddTxOut(changeOut)
+			}
+		}
+		if err := a.signTx(mtx, selected); err != nil {
+			return nil, err
+		}
+
+		required := a.txPool.MinRequiredTxRelayFee(int64(mtx.SerializeSize()))
+		if uint64(required) <= fee {
+			return types.NewTx(mtx), nil
+		}
+		fee = uint64(required)
+	}
+}
+
+// signTx signs every input of the transaction with the wallet keys.
+//
+// This function MUST be called with the account manager lock held and the
+// wallet unlocked.
+func (a *AccountManager) signTx(mtx *types.Transaction, utxos []*walletUtxo) error {
+	var kdb txscript.KeyClosure = func(addr types.Address) (ecc.PrivateKey, bool, error) {
+		info, ok := a.addrs[addr.Encode()]
+		if !ok {
+			return nil, false, fmt.Errorf("no key for address %s", addr.Encode())
+		}
+		key, err := a.privateKey(info)
+		if err != nil {
+			return nil, false, err
+		}
+		return key, true, nil // compressed is true
+	}
+	for i, u := range utxos {
+		sigScript, err := txscript.SignTxOutput(a.params, mtx, i, u.pkScript,
+			txscript.SigHashAll, kdb, nil, nil, ecc.ECDSA_Secp256k1)
+		if err != nil {
+			return err
+		}
+		mtx.TxIn[i].SignScript = sigScript
+	}
+	return nil
+}
+
+// payToEncodedAddr returns an output paying amount to the encoded address.
+func (a *AccountManager) payToEncodedAddr(addrStr string, amount uint64) (*types.TxOutput, error) {
+	addr, err := address.DecodeAddress(addrStr)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewTxOutput(amount, pkScript), nil
+}
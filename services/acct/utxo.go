@@ -0,0 +1,404 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package acct
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	"github.com/btceasypay/bitcoinpay/core/json"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+	"github.com/btceasypay/bitcoinpay/log"
+)
+
+var (
+	// acctUtxoBucketName is the name of the db bucket used to house the
+	// unspent outputs paying to the wallet addresses.
+	acctUtxoBucketName = []byte("acctutxo")
+
+	// acctSpentBucketName is the name of the db bucket used to house the
+	// wallet outputs spent by each block, so they can be restored when the
+	// block is disconnected.
+	acctSpentBucketName = []byte("acctspent")
+)
+
+const (
+	// outpointKeySize is the size of a serialized outpoint key.
+	outpointKeySize = hash.HashSize + 4
+
+	// utxoHeaderSize is the size of the fixed part of a serialized wallet
+	// utxo: account, amount, coinbase flag, block hash and script length.
+	utxoHeaderSize = 4 + 8 + 1 + hash.HashSize + 2
+)
+
+// walletUtxo is an unspent output paying to an address of the wallet.
+type walletUtxo struct {
+	outPoint  types.TxOutPoint
+	account   uint32
+	amount    uint64
+	coinbase  bool
+	blockHash hash.Hash
+	pkScript  []byte
+}
+
+// outpointKey returns the key of the outpoint in the wallet buckets.
+func outpointKey(op *types.TxOutPoint) []byte {
+	key := make([]byte, outpointKeySize)
+	copy(key, op.Hash[:])
+	dbnamespace.ByteOrder.PutUint32(key[hash.HashSize:], op.OutIndex)
+	return key
+}
+
+// serialize returns the value of the utxo in the wallet buckets.
+func (u *walletUtxo) serialize() []byte {
+	buf := make([]byte, utxoHeaderSize+len(u.pkScript))
+	offset := 0
+	dbnamespace.ByteOrder.PutUint32(buf[offset:], u.account)
+	offset += 4
+	dbnamespace.ByteOrder.PutUint64(buf[offset:], u.amount)
+	offset += 8
+	if u.coinbase {
+		buf[offset] = 1
+	}
+	offset++
+	copy(buf[offset:], u.blockHash[:])
+	offset += hash.HashSize
+	dbnamespace.ByteOrder.PutUint16(buf[offset:], uint16(len(u.pkScript)))
+	offset += 2
+	copy(buf[offset:], u.pkScript)
+	return buf
+}
+
+// deserializeWalletUtxo decodes a wallet utxo stored under key.
+func deserializeWalletUtxo(key, value []byte) (*walletUtxo, error) {
+	if len(key) != outpointKeySize || len(value) < utxoHeaderSize {
+		return nil, fmt.Errorf("corrupt wallet utxo entry")
+	}
+	u := &walletUtxo{}
+	copy(u.outPoint.Hash[:], key[:hash.HashSize])
+	u.outPoint.OutIndex = dbnamespace.ByteOrder.Uint32(key[hash.HashSize:])
+
+	offset := 0
+	u.account = dbnamespace.ByteOrder.Uint32(value[offset:])
+	offset += 4
+	u.amount = dbnamespace.ByteOrder.Uint64(value[offset:])
+	offset += 8
+	u.coinbase = value[offset] == 1
+	offset++
+	copy(u.blockHash[:], value[offset:offset+hash.HashSize])
+	offset += hash.HashSize
+	scriptLen := int(dbnamespace.ByteOrder.Uint16(value[offset:]))
+	offset += 2
+	if len(value) != offset+scriptLen {
+		return nil, fmt.Errorf("corrupt wallet utxo script")
+	}
+	u.pkScript = make([]byte, scriptLen)
+	copy(u.pkScript, value[offset:])
+	return u, nil
+}
+
+// createUtxoBuckets creates the wallet buckets if needed.
+func createUtxoBuckets(db database.DB) error {
+	return db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if _, err := meta.CreateBucketIfNotExists(acctUtxoBucketName); err != nil {
+			return err
+		}
+		_, err := meta.CreateBucketIfNotExists(acctSpentBucketName)
+		return err
+	})
+}
+
+// handleChainNotification keeps the wallet utxos in sync with the blocks
+// connected to and disconnected from the DAG order.
+func (a *AccountManager) handleChainNotification(n *blockchain.Notification) {
+	switch n.Type {
+	case blockchain.BlockConnected:
+		blocks, ok := n.Data.([]*types.SerializedBlock)
+		if !ok {
+			return
+		}
+		for _, block := range blocks {
+			if err := a.connectBlock(block); err != nil {
+				log.Error("Wallet failed to connect block", "block", block.Hash(), "error", err)
+			}
+		}
+	case blockchain.BlockDisconnected:
+		block, ok := n.Data.(*types.SerializedBlock)
+		if !ok {
+			return
+		}
+		if err := a.disconnectBlock(block); err != nil {
+			log.Error("Wallet failed to disconnect block", "block", block.Hash(), "error", err)
+		}
+	}
+}
+
+// connectBlock removes the wallet utxos spent by the block and adds the
+// outputs of the block paying to the wallet.
+func (a *AccountManager) connectBlock(block *types.SerializedBlock) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if len(a.addrs) == 0 {
+		return nil
+	}
+
+	used := false
+	err := a.db.Update(func(dbTx database.Tx) error {
+		utxos := dbTx.Metadata().Bucket(acctUtxoBucketName)
+		var journal bytes.Buffer
+		for _, tx := range block.Transactions() {
+			if !tx.Tx.IsCoinBase() {
+				for _, txIn := range tx.Tx.TxIn {
+					key := outpointKey(&txIn.PreviousOut)
+					value := utxos.Get(key)
+					if value == nil {
+						continue
+					}
+					journal.Write(key)
+					var size [2]byte
+					dbnamespace.ByteOrder.PutUint16(size[:], uint16(len(value)))
+					journal.Write(size[:])
+					journal.Write(value)
+					if err := utxos.Delete(key); err != nil {
+						return err
+					}
+				}
+			}
+			for i, txOut := range tx.Tx.TxOut {
+				info := a.matchScript(txOut.PkScript)
+				if info == nil {
+					continue
+				}
+				changed, err := a.markUsed(info)
+				if err != nil {
+					return err
+				}
+				used = used || changed
+				u := walletUtxo{
+					outPoint:  *types.NewOutPoint(tx.Hash(), uint32(i)),
+					account:   info.account,
+					amount:    txOut.Amount,
+					coinbase:  tx.Tx.IsCoinBase(),
+					blockHash: *block.Hash(),
+					pkScript:  txOut.PkScript,
+				}
+				if err := utxos.Put(outpointKey(&u.outPoint), u.serialize()); err != nil {
+					return err
+				}
+			}
+		}
+		if journal.Len() == 0 {
+			return nil
+		}
+		return dbTx.Metadata().Bucket(acctSpentBucketName).Put(block.Hash()[:], journal.Bytes())
+	})
+	if err != nil || !used {
+		return err
+	}
+	return a.ks.save()
+}
+
+// disconnectBlock reverses connectBlock.
+func (a *AccountManager) disconnectBlock(block *types.SerializedBlock) error {
+	return a.db.Update(func(dbTx database.Tx) error {
+		utxos := dbTx.Metadata().Bucket(acctUtxoBucketName)
+		for _, tx := range block.Transactions() {
+			for i := range tx.Tx.TxOut {
+				key := outpointKey(types.NewOutPoint(tx.Hash(), uint32(i)))
+				if utxos.Get(key) == nil {
+					continue
+				}
+				if err := utxos.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+
+		spent := dbTx.Metadata().Bucket(acctSpentBucketName)
+		journal := spent.Get(block.Hash()[:])
+		for len(journal) > 0 {
+			if len(journal) < outpointKeySize+2 {
+				return fmt.Errorf("corrupt wallet spend journal")
+			}
+			key := journal[:outpointKeySize]
+			size := int(dbnamespace.ByteOrder.Uint16(journal[outpointKeySize:]))
+			journal = journal[outpointKeySize+2:]
+			if len(journal) < size {
+				return fmt.Errorf("corrupt wallet spend journal")
+			}
+			if err := utxos.Put(key, journal[:size]); err != nil {
+				return err
+			}
+			journal = journal[size:]
+		}
+		return spent.Delete(block.Hash()[:])
+	})
+}
+
+// Rescan rebuilds the wallet utxos from the blocks of the DAG order, so a
+// wallet restored from its seed finds the coins of its addresses.
+func (a *AccountManager) Rescan() error {
+	// Hold the chain lock so no block is connected or disconnected while
+	// the utxos are rebuilt.
+	a.chain.ChainRLock()
+	defer a.chain.ChainRUnlock()
+
+	err := a.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		for _, name := range [][]byte{acctUtxoBucketName, acctSpentBucketName} {
+			if err := meta.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := meta.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bd := a.chain.BlockDAG()
+	mainOrder := uint64(a.chain.BestSnapshot().GraphState.GetMainOrder())
+	for order := uint64(0); order <= mainOrder; order++ {
+		block, err := a.chain.BlockByOrder(order)
+		if err != nil {
+			return err
+		}
+		ib := bd.GetBlock(block.Hash())
+		if ib != nil && blockchain.BlockStatus(ib.GetStatus()).KnownInvalid() {
+			continue
+		}
+		if err := a.connectBlock(block); err != nil {
+			return err
+		}
+	}
+	log.Info("Wallet rescan done", "blocks", mainOrder+1)
+	return nil
+}
+
+// matchScript returns the wallet address paid by the script.
+//
+// This function MUST be called with the account manager lock held.
+func (a *AccountManager) matchScript(pkScript []byte) *addrInfo {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, a.params)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if info, ok := a.addrs[addr.Encode()]; ok {
+			return info
+		}
+	}
+	return nil
+}
+
+// fetchUtxos returns the wallet utxos of the account.
+func (a *AccountManager) fetchUtxos(account uint32) ([]*walletUtxo, error) {
+	var utxos []*walletUtxo
+	err := a.db.View(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Bucket(acctUtxoBucketName).ForEach(func(k, v []byte) error {
+			u, err := deserializeWalletUtxo(k, v)
+			if err != nil {
+				return err
+			}
+			if u.account == account {
+				utxos = append(utxos, u)
+			}
+			return nil
+		})
+	})
+	return utxos, err
+}
+
+// confirmations returns the number of confirmations of the utxo, unordered
+// or unknown blocks have no confirmation.
+func (a *AccountManager) confirmations(u *walletUtxo) uint {
+	bd := a.chain.BlockDAG()
+	ib := bd.GetBlock(&u.blockHash)
+	if ib == nil {
+		return 0
+	}
+	return bd.GetConfirmations(ib.GetID())
+}
+
+// isSpendable returns whether the utxo has enough confirmations and is not
+// already spent by a mempool transaction.
+func (a *AccountManager) isSpendable(u *walletUtxo, minConf uint) bool {
+	confs := a.confirmations(u)
+	if confs < minConf {
+		return false
+	}
+	if u.coinbase && confs < uint(a.params.CoinbaseMaturity) {
+		return false
+	}
+	return a.txPool.CheckSpend(u.outPoint) == nil
+}
+
+// Balance returns the spendable amount of the account with at least minConf
+// confirmations.
+func (a *AccountManager) Balance(account string, minConf uint) (uint64, error) {
+	a.mtx.RLock()
+	acct, err := a.account(account)
+	a.mtx.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	utxos, err := a.fetchUtxos(acct.Index)
+	if err != nil {
+		return 0, err
+	}
+	balance := uint64(0)
+	for _, u := range utxos {
+		if a.isSpendable(u, minConf) {
+			balance += u.amount
+		}
+	}
+	return balance, nil
+}
+
+// ListUnspent returns the unspent outputs of the account with at least
+// minConf confirmations.
+func (a *AccountManager) ListUnspent(account string, minConf uint) ([]json.ListUnspentResult, error) {
+	a.mtx.RLock()
+	acct, err := a.account(account)
+	a.mtx.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := a.fetchUtxos(acct.Index)
+	if err != nil {
+		return nil, err
+	}
+	results := []json.ListUnspentResult{}
+	for _, u := range utxos {
+		confs := a.confirmations(u)
+		if confs < minConf {
+			continue
+		}
+		addr := ""
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(u.pkScript, a.params)
+		if err == nil && len(addrs) > 0 {
+			addr = addrs[0].Encode()
+		}
+		results = append(results, json.ListUnspentResult{
+			Txid:          u.outPoint.Hash.String(),
+			Vout:          u.outPoint.OutIndex,
+			Address:       addr,
+			Account:       acct.Name,
+			Amount:        u.amount,
+			ScriptPubKey:  hex.EncodeToString(u.pkScript),
+			Confirmations: confs,
+			Coinbase:      u.coinbase,
+			Spendable:     a.isSpendable(u, minConf),
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package acct
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/address"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/crypto/ecc"
+	"github.com/btceasypay/bitcoinpay/database"
+	_ "github.com/btceasypay/bitcoinpay/database/ffldb"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+	"github.com/btceasypay/bitcoinpay/params"
+	"github.com/btceasypay/bitcoinpay/services/mempool"
+)
+
+// newTestAccountManager returns an account manager on a new privnet chain
+// with an unlocked wallet holding the default account.
+func newTestAccountManager(t *testing.T) *AccountManager {
+	dir := t.TempDir()
+	db, err := database.Create("ffldb", filepath.Join(dir, "ffldb"), params.PrivNetParams.Net)
+	if err != nil {
+		t.Fatalf("database.Create: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: &params.PrivNetParams,
+		TimeSource:  blockchain.NewMedianTime(),
+		DAGType:     "phantom",
+	})
+	if err != nil {
+		t.Fatalf("blockchain.New: %v", err)
+	}
+	a := &AccountManager{
+		params: &params.PrivNetParams,
+		db:     db,
+		chain:  chain,
+		txPool: mempool.New(&mempool.Config{Policy: mempool.Policy{MinRelayTxFee: 1000}}),
+		dir:    dir,
+		addrs:  make(map[string]*addrInfo),
+	}
+	if err := createUtxoBuckets(db); err != nil {
+		t.Fatalf("createUtxoBuckets: %v", err)
+	}
+
+	seed := bytes.Repeat([]byte{0x5a}, seedLen)
+	crypto, err := encryptSeed(seed, []byte("pass"), testScryptN, scryptP)
+	if err != nil {
+		t.Fatalf("encryptSeed: %v", err)
+	}
+	ks := &keyStore{path: keyStorePath(dir), Version: keyStoreVersion, Crypto: *crypto}
+	acct, err := a.deriveAccount(seed, defaultAccountName, 0)
+	if err != nil {
+		t.Fatalf("deriveAccount: %v", err)
+	}
+	ks.Accounts = append(ks.Accounts, acct)
+	if err := a.setKeyStore(ks); err != nil {
+		t.Fatalf("setKeyStore: %v", err)
+	}
+	a.seed = seed
+	return a
+}
+
+// testBlock returns a block made of the transactions, id makes its hash
+// unique.
+func testBlock(id byte, txs ...*types.Transaction) *types.SerializedBlock {
+	return types.NewBlock(&types.Block{
+		Header: types.BlockHeader{
+			ParentRoot: hash.Hash{id},
+			Pow:        pow.GetInstance(pow.BLAKE2BD, 0, []byte{}),
+		},
+		Transactions: txs,
+	})
+}
+
+// testPayment returns a transaction paying amount to the encoded address.
+func testPayment(t *testing.T, a *AccountManager, addr string, amount uint64) *types.Transaction {
+	out, err := a.payToEncodedAddr(addr, amount)
+	if err != nil {
+		t.Fatalf("payToEncodedAddr: %v", err)
+	}
+	tx := types.NewTransaction()
+	tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{0xee}, 0), nil))
+	tx.AddTxOut(out)
+	return tx
+}
+
+// testScript returns a pay to pubkey hash script outside of the wallet.
+func testScript(t *testing.T, id byte) []byte {
+	addr, err := address.NewPubKeyHashAddress(bytes.Repeat([]byte{id}, 20),
+		&params.PrivNetParams, ecc.ECDSA_Secp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkScript
+}
+
+// checkBalance fails the test if the balance of the default account is not
+// want.
+func checkBalance(t *testing.T, a *AccountManager, want uint64) {
+	t.Helper()
+	balance, err := a.Balance(defaultAccountName, 0)
+	if err != nil {
+		t.Fatalf("Balance: %v", err)
+	}
+	if balance != want {
+		t.Fatalf("balance %d, want %d", balance, want)
+	}
+}
+
+// TestWalletUtxos ensures the wallet utxos follow the connected and
+// disconnected blocks and are spent by the wallet transactions.
+func TestWalletUtxos(t *testing.T) {
+	a := newTestAccountManager(t)
+	addr, err := a.NewAddress(defaultAccountName)
+	if err != nil {
+		t.Fatalf("NewAddress: %v", err)
+	}
+
+	payment := testPayment(t, a, addr, 5e8)
+	block1 := testBlock(1, payment)
+	if err := a.connectBlock(block1); err != nil {
+		t.Fatalf("connectBlock: %v", err)
+	}
+	checkBalance(t, a, 5e8)
+
+	// Spend part of the coin to an address outside of the wallet.
+	otherScript := testScript(t, 0x77)
+	a.mtx.Lock()
+	tx, err := a.createTx(defaultAccountName, types.NewTxOutput(1e8, otherScript), 0)
+	a.mtx.Unlock()
+	if err != nil {
+		t.Fatalf("createTx: %v", err)
+	}
+	paymentHash := payment.TxHash()
+	if len(tx.Tx.TxIn) != 1 || tx.Tx.TxIn[0].PreviousOut != *types.NewOutPoint(&paymentHash, 0) {
+		t.Fatalf("unexpected inputs %+v", tx.Tx.TxIn)
+	}
+	if len(tx.Tx.TxOut) != 2 || tx.Tx.TxOut[0].Amount != 1e8 {
+		t.Fatalf("unexpected outputs %+v", tx.Tx.TxOut)
+	}
+	change := tx.Tx.TxOut[1].Amount
+	fee := int64(5e8 - 1e8 - change)
+	if required := a.txPool.MinRequiredTxRelayFee(int64(tx.Tx.SerializeSize())); fee < required {
+		t.Fatalf("fee %d below the required %d", fee, required)
+	}
+	vm, err := txscript.NewEngine(payment.TxOut[0].PkScript, tx.Tx, 0,
+		mempool.BaseStandardVerifyFlags, txscript.DefaultScriptVersion, nil)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	if err := vm.Execute(); err != nil {
+		t.Fatalf("the spend is not signed: %v", err)
+	}
+
+	// The change is the balance once the spend is connected.
+	block2 := testBlock(2, tx.Tx)
+	if err := a.connectBlock(block2); err != nil {
+		t.Fatalf("connectBlock: %v", err)
+	}
+	checkBalance(t, a, change)
+
+	// Disconnecting the blocks restores the spent coin, then removes it.
+	if err := a.disconnectBlock(block2); err != nil {
+		t.Fatalf("disconnectBlock: %v", err)
+	}
+	checkBalance(t, a, 5e8)
+	if err := a.disconnectBlock(block1); err != nil {
+		t.Fatalf("disconnectBlock: %v", err)
+	}
+	checkBalance(t, a, 0)
+}
+
+// TestAddressDiscovery ensures a payment to a watched address which was not
+// handed out is found and advances the next address of the account.
+func TestAddressDiscovery(t *testing.T) {
+	a := newTestAccountManager(t)
+	acct := a.ks.account(defaultAccountName)
+	last, err := a.deriveAddress(acct, externalBranch, addrGapLimit-1)
+	if err != nil {
+		t.Fatalf("deriveAddress: %v", err)
+	}
+	if err := a.connectBlock(testBlock(1, testPayment(t, a, last, 5e8))); err != nil {
+		t.Fatalf("connectBlock: %v", err)
+	}
+	checkBalance(t, a, 5e8)
+	if acct.NextExternal != addrGapLimit {
+		t.Fatalf("next external address %d, want %d", acct.NextExternal, addrGapLimit)
+	}
+
+	// The addresses past the used one are watched in turn.
+	next, err := a.deriveAddress(acct, externalBranch, 2*addrGapLimit-1)
+	if err != nil {
+		t.Fatalf("deriveAddress: %v", err)
+	}
+	if err := a.connectBlock(testBlock(2, testPayment(t, a, next, 1e8))); err != nil {
+		t.Fatalf("connectBlock: %v", err)
+	}
+	checkBalance(t, a, 6e8)
+
+	// The rescan rebuilds the utxos from the blocks of the chain, which
+	// pay nothing to the wallet.
+	if err := a.Rescan(); err != nil {
+		t.Fatalf("Rescan: %v", err)
+	}
+	checkBalance(t, a, 0)
+}
@@ -12,8 +12,10 @@ import (
 	"github.com/btceasypay/bitcoinpay/core/blockdag"
 	"github.com/btceasypay/bitcoinpay/core/json"
 	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
 	"github.com/btceasypay/bitcoinpay/engine/txscript"
 	"github.com/btceasypay/bitcoinpay/rpc"
+	"os"
 	"strconv"
 )
 
@@ -106,10 +108,10 @@ func (api *PublicBlockAPI) GetBlockByOrder(order uint64, verbose *bool, inclTx *
 	if fullTx != nil {
 		fTx = *fullTx
 	}
-	return api.GetBlock(*blockHash, &vb, &iTx, &fTx)
+	return api.GetBlock(*blockHash, &vb, &iTx, &fTx, nil)
 }
 
-func (api *PublicBlockAPI) GetBlock(h hash.Hash, verbose *bool, inclTx *bool, fullTx *bool) (interface{}, error) {
+func (api *PublicBlockAPI) GetBlock(h hash.Hash, verbose *bool, inclTx *bool, fullTx *bool, risk *bool) (interface{}, error) {
 
 	vb := false
 	if verbose != nil {
@@ -167,10 +169,13 @@ func (api *PublicBlockAPI) GetBlock(h hash.Hash, verbose *bool, inclTx *bool, fu
 	if err != nil {
 		return nil, err
 	}
+	if risk != nil && *risk {
+		return api.appendBlockRisk(fields, &h)
+	}
 	return fields, nil
 }
 
-func (api *PublicBlockAPI) GetBlockV2(h hash.Hash, verbose *bool, inclTx *bool, fullTx *bool) (interface{}, error) {
+func (api *PublicBlockAPI) GetBlockV2(h hash.Hash, verbose *bool, inclTx *bool, fullTx *bool, risk *bool) (interface{}, error) {
 
 	vb := false
 	if verbose != nil {
@@ -229,10 +234,24 @@ func (api *PublicBlockAPI) GetBlockV2(h hash.Hash, verbose *bool, inclTx *bool,
 	if err != nil {
 		return nil, err
 	}
+	if risk != nil && *risk {
+		return api.appendBlockRisk(fields, &h)
+	}
 	return fields, nil
 
 }
 
+// appendBlockRisk adds the risk that the block is reversed to the verbose
+// block fields.  It walks the DAG around the block, so it is only done on
+// request.
+func (api *PublicBlockAPI) appendBlockRisk(fields json.OrderedResult, h *hash.Hash) (interface{}, error) {
+	risk, err := api.bm.chain.CalcBlockRisk(h, blockchain.DefaultRiskAlpha)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("Failed to compute the block risk: %v", err)
+	}
+	return append(fields, json.KV{Key: "risk", Val: risk.Risk}), nil
+}
+
 func (api *PublicBlockAPI) GetBestBlockHash() (interface{}, error) {
 	best := api.bm.chain.BestSnapshot()
 	return best.Hash.String(), nil
@@ -350,7 +369,7 @@ func (api *PublicBlockAPI) GetBlockByNum(num uint64, verbose *bool, inclTx *bool
 	if fullTx != nil {
 		fTx = *fullTx
 	}
-	return api.GetBlock(*blockHash, &vb, &iTx, &fTx)
+	return api.GetBlock(*blockHash, &vb, &iTx, &fTx, nil)
 }
 
 // IsBlue:0:not blue;  1：blue  2：Cannot confirm
@@ -387,6 +406,163 @@ func (api *PublicBlockAPI) Tips() (interface{}, error) {
 	return tips, nil
 }
 
+// GetDagTips returns the tips of the DAG with their status, so that the
+// branches which are stalled or withheld can be spotted.
+func (api *PublicBlockAPI) GetDagTips() (interface{}, error) {
+	chain := api.bm.GetChain()
+	miningTips := blockdag.NewHashSet()
+	miningTips.AddList(chain.GetMiningTips())
+	mainTip := chain.BlockDAG().GetMainChainTip()
+	result := json.GetDagTipsResult{
+		MainOrder:     mainTip.GetOrder(),
+		MainLayer:     mainTip.GetLayer(),
+		Tips:          []json.DagTip{},
+		OrphanParents: []string{},
+	}
+	for _, info := range chain.BlockDAG().GetTipsInfo() {
+		tip := json.DagTip{
+			Hash:      info.Hash.String(),
+			Layer:     info.Layer,
+			Blues:     info.BlueNum,
+			MainChain: info.MainChain,
+			ValidTip:  info.ValidTip,
+			MiningTip: miningTips.Has(info.Hash),
+			Orphans:   []string{},
+		}
+		if info.Order != blockdag.MaxBlockOrder {
+			order := uint64(info.Order)
+			tip.Order = &order
+		}
+		node := chain.BlockIndex().LookupNode(info.Hash)
+		if node != nil {
+			tip.HeadersOnly = !node.GetStatus().HaveData()
+		}
+		for _, h := range chain.GetOrphanChildren(info.Hash) {
+			tip.Orphans = append(tip.Orphans, h.String())
+		}
+		result.Tips = append(result.Tips, tip)
+	}
+	for _, h := range chain.GetOrphansParents() {
+		result.OrphanParents = append(result.OrphanParents, h.String())
+	}
+	return result, nil
+}
+
+// GetBlockRisk returns the risk that a block is reversed by an attacker with
+// the relative computational power alpha, 0.1 by default, given the anticone
+// of the block and the time elapsed since it was mined.
+func (api *PublicBlockAPI) GetBlockRisk(h hash.Hash, alpha *float64) (interface{}, error) {
+	a := blockchain.DefaultRiskAlpha
+	if alpha != nil {
+		a = *alpha
+	}
+	risk, err := api.bm.chain.CalcBlockRisk(&h, a)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("Failed to compute the block risk: %v", err)
+	}
+	return json.GetBlockRiskResult{
+		Hash:        h.String(),
+		Alpha:       risk.Alpha,
+		AntiPast:    risk.AntiPast,
+		Anticone:    risk.Anticone,
+		WaitingTime: risk.WaitingTime,
+		Risk:        risk.Risk,
+	}, nil
+}
+
+// GetDifficulty returns the difficulty of the latest main chain block of the
+// proof of work, and the difficulty required of the next one.  The difficulties
+// are relative to the limit of the proof of work.
+func (api *PublicBlockAPI) GetDifficulty(powType pow.PowType) (interface{}, error) {
+	chain := api.bm.chain
+	diff, err := chain.CalcPowDifficulty(powType, chain.TimeSource().AdjustedTime())
+	if err != nil {
+		return nil, rpc.RpcInvalidError("Failed to compute the difficulty: %v", err)
+	}
+	return json.GetDifficultyResult{
+		PowType:        uint8(powType),
+		PowName:        powName(powType),
+		Bits:           fmt.Sprintf("%08x", diff.Bits),
+		Target:         fmt.Sprintf("%064x", pow.CompactToBig(diff.Bits)),
+		Difficulty:     diff.Difficulty,
+		NextBits:       fmt.Sprintf("%08x", diff.NextBits),
+		NextTarget:     fmt.Sprintf("%064x", pow.CompactToBig(diff.NextBits)),
+		NextDifficulty: diff.NextDifficulty,
+		TargetPercent:  diff.TargetPercent,
+		Available:      diff.Available,
+	}, nil
+}
+
+// GetNetworkHashrate returns the hash rate of the proof of work estimated from
+// its blocks among the window of main chain blocks, by default the window of
+// the difficulty adjustment and at most four times it.  The cuckoo proofs of
+// work give a graph rate.
+func (api *PublicBlockAPI) GetNetworkHashrate(powType pow.PowType, window *int64) (interface{}, error) {
+	chain := api.bm.chain
+	w := chain.DefaultPowStatsWindow()
+	if window != nil {
+		w = *window
+	}
+	stats, dist, err := chain.CalcPowStats(powType, w)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("Failed to compute the hash rate: %v", err)
+	}
+	return json.GetNetworkHashrateResult{
+		Window:         dist.Window,
+		Timespan:       dist.Timespan,
+		PowStatsResult: powStatsResult(stats),
+	}, nil
+}
+
+// GetPowDistribution returns the share of each proof of work among the window
+// of main chain blocks, by default the window of the difficulty adjustment and
+// at most four times it, along with the share aimed at by the difficulty adjustment and the estimated
+// hash rate.
+func (api *PublicBlockAPI) GetPowDistribution(window *int64) (interface{}, error) {
+	chain := api.bm.chain
+	w := chain.DefaultPowStatsWindow()
+	if window != nil {
+		w = *window
+	}
+	dist, err := chain.CalcPowDistribution(w)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("Failed to compute the pow distribution: %v", err)
+	}
+	pows := make([]json.PowStatsResult, 0, len(dist.Pows))
+	for _, stats := range dist.Pows {
+		pows = append(pows, powStatsResult(stats))
+	}
+	return json.GetPowDistributionResult{
+		Window:   dist.Window,
+		Blocks:   dist.Blocks,
+		Timespan: dist.Timespan,
+		Pows:     pows,
+	}, nil
+}
+
+// powName returns the name of the proof of work.
+func powName(powType pow.PowType) string {
+	name, _ := pow.PowMapString[powType].(string)
+	return name
+}
+
+// powStatsResult returns the JSON result of the statistics of a proof of work.
+func powStatsResult(stats *blockchain.PowStats) json.PowStatsResult {
+	unit := "H/s"
+	if stats.Graphs {
+		unit = "GPS"
+	}
+	return json.PowStatsResult{
+		PowType:       uint8(stats.PowType),
+		PowName:       powName(stats.PowType),
+		Blocks:        stats.Blocks,
+		Percent:       stats.Percent,
+		TargetPercent: stats.TargetPercent,
+		HashRate:      stats.HashRate,
+		Unit:          unit,
+	}
+}
+
 // GetCoinbase
 func (api *PublicBlockAPI) GetCoinbase(h hash.Hash, verbose *bool) (interface{}, error) {
 	vb := false
@@ -415,3 +591,85 @@ func (api *PublicBlockAPI) GetCoinbase(h hash.Hash, verbose *bool) (interface{},
 func (api *PublicBlockAPI) GetFees(h hash.Hash) (interface{}, error) {
 	return api.bm.chain.GetFees(&h), nil
 }
+
+// GetDeploymentInfo returns the states of the consensus deployments voted on
+// by the main chain blocks for the block after the main chain tip.
+func (api *PublicBlockAPI) GetDeploymentInfo() (interface{}, error) {
+	chain := api.bm.GetChain()
+	par := api.bm.ChainParams()
+	mainTip := chain.BlockDAG().GetMainChainTip()
+	result := json.GetDeploymentInfoResult{
+		Hash:                          mainTip.GetHash().String(),
+		Height:                        mainTip.GetHeight(),
+		NextBlockVersion:              chain.CalcNextBlockVersion(),
+		RuleChangeActivationThreshold: par.RuleChangeActivationThreshold,
+		MinerConfirmationWindow:       par.MinerConfirmationWindow,
+		Deployments:                   []json.DeploymentInfo{},
+	}
+	for _, state := range chain.DeploymentStates() {
+		result.Deployments = append(result.Deployments, json.DeploymentInfo{
+			Name:       state.Name,
+			Bit:        state.Bit,
+			StartTime:  state.StartTime,
+			ExpireTime: state.ExpireTime,
+			Status:     state.State.String(),
+			Since:      state.Since,
+		})
+	}
+	return result, nil
+}
+
+// GetUtxoProof returns a merkle proof of the presence or absence of an
+// outpoint in the UTXO trie of the current utxo set.  The proof nodes are
+// given from the root to the outpoint.
+func (api *PublicBlockAPI) GetUtxoProof(txid hash.Hash, vout uint32) (interface{}, error) {
+	outpoint := types.TxOutPoint{Hash: txid, OutIndex: vout}
+	proof, err := api.bm.GetChain().FetchUtxoProof(outpoint)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to prove the outpoint")
+	}
+	result := json.GetUtxoProofResult{
+		StateRoot: proof.Root.String(),
+		Txid:      txid.String(),
+		Vout:      vout,
+		Key:       hex.EncodeToString(proof.Key),
+		Unspent:   proof.Value != nil,
+		Value:     hex.EncodeToString(proof.Value),
+		Proof:     make([]string, 0, len(proof.Nodes)),
+	}
+	for _, node := range proof.Nodes {
+		result.Proof = append(result.Proof, hex.EncodeToString(node))
+	}
+	return result, nil
+}
+
+// DumpUtxoSet writes a snapshot of the utxo set after the block of the given
+// DAG order, the main chain tip by default, to a new file of the node.  The
+// snapshot can be pinned in the chain parameters to start nodes from it.
+func (api *PublicBlockAPI) DumpUtxoSet(path string, order *uint64) (interface{}, error) {
+	chain := api.bm.GetChain()
+	dumpOrder := uint64(chain.BlockDAG().GetMainChainTip().GetOrder())
+	if order != nil {
+		dumpOrder = *order
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("Failed to create the snapshot file: %v", err)
+	}
+	info, err := chain.DumpUtxoSet(file, dumpOrder)
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(path)
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to dump the utxo set")
+	}
+	return json.DumpUtxoSetResult{
+		Path:        path,
+		Order:       info.Order,
+		Hash:        info.Hash.String(),
+		Count:       info.Count,
+		ContentHash: info.ContentHash.String(),
+	}, nil
+}
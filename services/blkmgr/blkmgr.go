@@ -9,10 +9,12 @@ import (
 	"github.com/btceasypay/bitcoinpay/config"
 	"github.com/btceasypay/bitcoinpay/core/blockchain"
 	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/json"
 	"github.com/btceasypay/bitcoinpay/core/message"
 	"github.com/btceasypay/bitcoinpay/core/types"
 	"github.com/btceasypay/bitcoinpay/database"
 	"github.com/btceasypay/bitcoinpay/engine/txscript"
+	"github.com/btceasypay/bitcoinpay/metrics"
 	"github.com/btceasypay/bitcoinpay/node/notify"
 	"github.com/btceasypay/bitcoinpay/p2p/connmgr"
 	"github.com/btceasypay/bitcoinpay/p2p/peer"
@@ -38,6 +40,13 @@ const (
 	MaxBlockStallDuration = 3 * time.Second
 )
 
+var (
+	blockProcessTimer = metrics.NewTimer("blkmgr/block/process")
+	blockRejectMeter  = metrics.NewMeter("blkmgr/block/rejected")
+	dagBlocksGauge    = metrics.NewGauge("blkmgr/dag/blocks")
+	dagOrphansGauge   = metrics.NewGauge("blkmgr/dag/orphans")
+)
+
 // BlockManager provides a concurrency safe block manager for handling all
 // incoming blocks.
 type BlockManager struct {
@@ -112,16 +121,18 @@ func NewBlockManager(ntmgr notify.Notify, indexManager blockchain.IndexManager,
 	// Create a new block chain instance with the appropriate configuration.
 	var err error
 	bm.chain, err = blockchain.New(&blockchain.Config{
-		DB:             db,
-		Interrupt:      interrupt,
-		ChainParams:    par,
-		TimeSource:     timeSource,
-		Notifications:  bm.handleNotifyMsg,
-		SigCache:       sigCache,
-		IndexManager:   indexManager,
-		DAGType:        cfg.DAGType,
-		BlockVersion:   blockVersion,
-		CacheInvalidTx: cfg.CacheInvalidTx,
+		DB:               db,
+		Interrupt:        interrupt,
+		ChainParams:      par,
+		TimeSource:       timeSource,
+		Notifications:    bm.handleNotifyMsg,
+		SigCache:         sigCache,
+		IndexManager:     indexManager,
+		DAGType:          cfg.DAGType,
+		BlockVersion:     blockVersion,
+		CacheInvalidTx:   cfg.CacheInvalidTx,
+		UtxoCacheMaxSize: uint64(cfg.UtxoCacheMaxSize) * 1024 * 1024,
+		UtxoSnapshot:     cfg.UtxoSnapshot,
 	})
 	if err != nil {
 		return nil, err
@@ -176,6 +187,7 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 			b.lastProgressTime = time.Now()
 		}
 		b.zmqNotify.BlockAccepted(block)
+		b.notify.NotifyBlockAccepted(block, b.blockNotifyResult(block, false))
 		// Don't relay if we are not current. Other peers that are current
 		// should already know about it
 		if !b.current() {
@@ -230,6 +242,15 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 		}
 
 		block := blockSlice[0]
+
+		// Register the block with the fee estimator, only the blue blocks
+		// count as confirmations of the observed transactions.
+		if fe := b.GetTxManager().FeeEstimator(); fe != nil {
+			bd := b.chain.BlockDAG()
+			ib := bd.GetBlock(block.Hash())
+			fe.RegisterBlock(block, ib != nil && bd.IsBlue(ib.GetID()))
+		}
+
 		// Remove all of the transactions (except the coinbase) in the
 		// connected block from the transaction pool.  Secondly, remove any
 		// transactions which are now double spends as a result of these
@@ -253,6 +274,7 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 		*/
 
 		b.zmqNotify.BlockConnected(block)
+		b.notify.NotifyBlockConnected(block, b.blockNotifyResult(block, true))
 
 	// A block has been disconnected from the main block chain.
 	case blockchain.BlockDisconnected:
@@ -263,6 +285,7 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 			break
 		}
 		b.zmqNotify.BlockDisconnected(block)
+		b.notify.NotifyBlockDisconnected(block, b.blockNotifyResult(block, false))
 	// The blockchain is reorganizing.
 	case blockchain.Reorganization:
 		log.Trace("Chain reorganization notification")
@@ -285,6 +308,30 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 	}
 }
 
+// blockNotifyResult returns the position of the block in the DAG which is
+// pushed to the rpc subscribers.
+func (b *BlockManager) blockNotifyResult(block *types.SerializedBlock, connected bool) *json.BlockNotifyResult {
+	result := &json.BlockNotifyResult{
+		Hash:      block.Hash().String(),
+		Txs:       len(block.Transactions()),
+		Connected: connected,
+	}
+	bd := b.chain.BlockDAG()
+	ib := bd.GetBlock(block.Hash())
+	if ib == nil {
+		return result
+	}
+	result.IsOrdered = ib.IsOrdered()
+	if result.IsOrdered {
+		result.Order = uint64(ib.GetOrder())
+	}
+	result.Height = uint64(ib.GetHeight())
+	result.Layer = uint64(ib.GetLayer())
+	result.IsBlue = bd.IsBlue(ib.GetID())
+	result.IsOnMainChain = bd.IsOnMainChain(ib.GetID())
+	return result
+}
+
 // current returns true if we believe we are synced with our peers, false if we
 // still have blocks to check
 func (b *BlockManager) current() bool {
@@ -322,6 +369,7 @@ func (b *BlockManager) Start() {
 		return
 	}
 	b.lastProgressTime = time.Now()
+	b.updateDAGMetrics()
 	log.Trace("Starting block manager")
 	b.wg.Add(1)
 	go b.blockHandler()
@@ -554,8 +602,7 @@ out:
 
 			case processBlockMsg:
 				log.Trace("blkmgr msgChan processBlockMsg", "msg", msg)
-				isOrphan, err := b.chain.ProcessBlock(
-					msg.block, msg.flags)
+				isOrphan, err := b.processBlock(msg.block, msg.flags)
 				if err != nil {
 					msg.reply <- processBlockResponse{
 						isOrphan: isOrphan,
@@ -638,6 +685,12 @@ out:
 			break out
 		}
 	}
+	// Write the utxo set back to the database now that no more blocks are
+	// processed.
+	b.chain.Stop()
+	if err := b.chain.FlushUtxoCache(); err != nil {
+		log.Error("Failed to flush the utxo cache", "error", err)
+	}
 	b.wg.Done()
 	log.Trace("Block handler done")
 }
@@ -670,6 +723,26 @@ func (b *BlockManager) ProcessBlock(block *types.SerializedBlock, flags blockcha
 	return response.isOrphan, response.err
 }
 
+// processBlock processes a block with the block chain and updates the metrics
+// of the block processing and of the DAG.
+func (b *BlockManager) processBlock(block *types.SerializedBlock, flags blockchain.BehaviorFlags) (bool, error) {
+	start := time.Now()
+	isOrphan, err := b.chain.ProcessBlock(block, flags)
+	blockProcessTimer.UpdateSince(start)
+	if err != nil {
+		blockRejectMeter.Mark(1)
+	}
+	b.updateDAGMetrics()
+	return isOrphan, err
+}
+
+// updateDAGMetrics updates the metrics of the number of blocks in the DAG and
+// of orphan blocks.
+func (b *BlockManager) updateDAGMetrics() {
+	dagBlocksGauge.Update(int64(b.chain.BlockDAG().GetBlockTotal()))
+	dagOrphansGauge.Update(int64(b.chain.GetOrphansTotal()))
+}
+
 // processTransactionResponse is a response sent to the reply channel of a
 // processTransactionMsg.
 type processTransactionResponse struct {
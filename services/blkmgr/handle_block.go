@@ -70,8 +70,7 @@ func (b *BlockManager) handleBlockMsg(bmsg *blockMsg) connmgr.BanScore {
 	delete(b.requestedBlocks, *blockHash)
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
-	isOrphan, err := b.chain.ProcessBlock(bmsg.block,
-		behaviorFlags)
+	isOrphan, err := b.processBlock(bmsg.block, behaviorFlags)
 
 	if err != nil {
 		// When the error is a rule error, it means the block was simply
@@ -33,8 +33,8 @@ func (b *BlockManager) handleNewPeerMsg(sp *peer.ServerPeer) {
 	if sp.SyncCandidate && b.syncPeer == nil {
 		b.startSync()
 	}
-	// Grab the mining state from this peer after we're synced.
-	if b.config.MiningStateSync {
+	// Grab the mining state from this full peer after we're synced.
+	if b.config.MiningStateSync && sp.SyncCandidate {
 		b.syncMiningStateAfterSync(sp)
 	}
 }
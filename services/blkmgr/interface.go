@@ -8,10 +8,13 @@ import (
 	"github.com/btceasypay/bitcoinpay/common/hash"
 	"github.com/btceasypay/bitcoinpay/core/blockchain"
 	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/services/mempool"
 )
 
 type TxManager interface {
 	MemPool() TxPool
+
+	FeeEstimator() *mempool.FeeEstimator
 }
 
 type TxPool interface {
@@ -1 +1,6 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+// Package bloom implements the BIP37 bloom filters used by the light clients
+// to ask their peers for the transactions relevant to them, and the partial
+// merkle trees of the filtered blocks proving the inclusion of the matches.
 package bloom
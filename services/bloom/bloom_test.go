@@ -0,0 +1,101 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package bloom
+
+import (
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/merkle"
+	"github.com/btceasypay/bitcoinpay/core/message"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+)
+
+// TestMurmurHash3 checks the reference vectors of the bloom filter hash.
+func TestMurmurHash3(t *testing.T) {
+	tests := []struct {
+		seed uint32
+		data []byte
+		want uint32
+	}{
+		{0x00000000, []byte{}, 0x00000000},
+		{0xfba4c795, []byte{}, 0x6a396f08},
+		{0xffffffff, []byte{}, 0x81f16f39},
+		{0x00000000, []byte{0x00}, 0x514e28b7},
+		{0xfba4c795, []byte{0x00}, 0xea3f0b17},
+		{0x00000000, []byte{0xff}, 0xfd6cf10d},
+		{0x00000000, []byte{0x00, 0x11}, 0x16c6b7ab},
+		{0x00000000, []byte{0x00, 0x11, 0x22}, 0x8eb51c3d},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33}, 0xb4471bf8},
+		{0x00000000, []byte{0x00, 0x11, 0x22, 0x33, 0x44}, 0xe2301fa8},
+	}
+	for _, test := range tests {
+		if got := MurmurHash3(test.seed, test.data); got != test.want {
+			t.Errorf("MurmurHash3(%x, %x): got %x, want %x", test.seed,
+				test.data, got, test.want)
+		}
+	}
+}
+
+func TestFilterMatchTxAndUpdate(t *testing.T) {
+	pkScript := []byte{0x14}
+	pkScript = append(pkScript, make([]byte, 20)...)
+	pkScript[1] = 0xaa
+
+	tx := types.NewTransaction()
+	tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{0x01}, 0), nil))
+	tx.AddTxOut(types.NewTxOutput(1000, pkScript))
+	spend := types.NewTransaction()
+	txHash := tx.TxHash()
+	spend.AddTxIn(types.NewTxInput(types.NewOutPoint(&txHash, 0), nil))
+
+	filter := NewFilter(10, 0, 0.0001, message.BloomUpdateAll)
+	if filter.MatchTxAndUpdate(types.NewTx(tx)) {
+		t.Fatalf("empty filter matches a transaction")
+	}
+	filter.Add(pkScript[1:])
+	if !filter.MatchTxAndUpdate(types.NewTx(tx)) {
+		t.Fatalf("filter doesn't match the output data")
+	}
+	if !filter.MatchesOutPoint(types.NewOutPoint(&txHash, 0)) {
+		t.Fatalf("matched outpoint wasn't added to the filter")
+	}
+	if !filter.MatchTxAndUpdate(types.NewTx(spend)) {
+		t.Fatalf("filter doesn't match the spending transaction")
+	}
+
+	filter.Unload()
+	if filter.IsLoaded() || filter.Matches(pkScript[1:]) {
+		t.Fatalf("unloaded filter still matches")
+	}
+}
+
+func TestNewMerkleBlock(t *testing.T) {
+	block := &types.Block{}
+	block.Header.Pow = pow.GetInstance(pow.BLAKE2BD, 0, []byte{})
+	for i := 0; i < 5; i++ {
+		tx := types.NewTransaction()
+		tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{byte(i)}, 0), nil))
+		block.AddTransaction(tx)
+	}
+	sb := types.NewBlock(block)
+	merkles := merkle.BuildMerkleTreeStore(sb.Transactions(), false)
+	root := merkles[len(merkles)-1]
+
+	filter := NewFilter(10, 0, 0.0001, message.BloomUpdateNone)
+	msg, matched := NewMerkleBlock(sb, filter)
+	if len(matched) != 0 || len(msg.Hashes) != 1 || !msg.Hashes[0].IsEqual(root) {
+		t.Fatalf("unmatched merkle block doesn't commit to the root")
+	}
+
+	filter.AddHash(sb.Transactions()[3].Hash())
+	msg, matched = NewMerkleBlock(sb, filter)
+	if len(matched) != 1 || matched[0] != 3 {
+		t.Fatalf("got matched %v, want [3]", matched)
+	}
+	if msg.Transactions != 5 || len(msg.Hashes) != 4 {
+		t.Fatalf("got %d txs and %d hashes, want 5 and 4",
+			msg.Transactions, len(msg.Hashes))
+	}
+}
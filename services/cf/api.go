@@ -0,0 +1,51 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package cf
+
+import (
+	"encoding/hex"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/rpc"
+)
+
+func (idx *CFIndex) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			NameSpace: rpc.DefaultServiceNameSpace,
+			Service:   NewPublicCFAPI(idx),
+			Public:    true,
+		},
+	}
+}
+
+type PublicCFAPI struct {
+	idx *CFIndex
+}
+
+func NewPublicCFAPI(idx *CFIndex) *PublicCFAPI {
+	return &PublicCFAPI{idx}
+}
+
+// GetCFilter returns the hex-encoded basic filter of the block.
+func (api *PublicCFAPI) GetCFilter(blockHash hash.Hash) (interface{}, error) {
+	filter, err := api.idx.FilterByBlockHash(&blockHash)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to load filter")
+	}
+	if filter == nil {
+		return nil, rpc.RpcInvalidError("no filter for block %v", blockHash)
+	}
+	return hex.EncodeToString(filter), nil
+}
+
+// GetCFilterHeader returns the filter header of the block.
+func (api *PublicCFAPI) GetCFilterHeader(blockHash hash.Hash) (interface{}, error) {
+	_, header, err := api.idx.FilterHashByBlockHash(&blockHash)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to load filter header")
+	}
+	if header == nil {
+		return nil, rpc.RpcInvalidError("no filter header for block %v", blockHash)
+	}
+	return header.String(), nil
+}
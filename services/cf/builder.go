@@ -0,0 +1,69 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package cf
+
+import (
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+)
+
+const (
+	// DefaultP is the collision probability parameter of the basic filter,
+	// used as the number of bits of the Golomb-Rice remainders.
+	DefaultP = 19
+
+	// DefaultM is the inverse of the false positive rate of the basic
+	// filter.
+	DefaultM uint64 = 784931
+)
+
+// Key returns the key used to hash the items of the filter of the block.
+func Key(blockHash *hash.Hash) [KeySize]byte {
+	var key [KeySize]byte
+	copy(key[:], blockHash[:KeySize])
+	return key
+}
+
+// BuildBasicFilter builds the basic filter of the block.  It holds every
+// output script of the block, except the data-only ones, and the scripts of
+// the outputs spent by the block.
+func BuildBasicFilter(block *types.SerializedBlock, stxos []blockchain.SpentTxOut) (*Filter, error) {
+	seen := make(map[string]struct{})
+	var data [][]byte
+	add := func(script []byte) {
+		if len(script) == 0 || script[0] == txscript.OP_RETURN {
+			return
+		}
+		if _, ok := seen[string(script)]; ok {
+			return
+		}
+		seen[string(script)] = struct{}{}
+		data = append(data, script)
+	}
+
+	for _, tx := range block.Transactions() {
+		for _, txOut := range tx.Transaction().TxOut {
+			add(txOut.PkScript)
+		}
+	}
+	for _, stxo := range stxos {
+		add(stxo.PkScript)
+	}
+	return BuildGCSFilter(DefaultP, DefaultM, Key(block.Hash()), data)
+}
+
+// FilterHash returns the hash of the serialized filter.
+func FilterHash(filter []byte) hash.Hash {
+	return hash.DoubleHashH(filter)
+}
+
+// MakeHeader returns the filter header committing to the filter hash and to
+// the header of the filter of the block ordered before.
+func MakeHeader(filterHash *hash.Hash, prevHeader *hash.Hash) hash.Hash {
+	var data [hash.HashSize * 2]byte
+	copy(data[:], filterHash[:])
+	copy(data[hash.HashSize:], prevHeader[:])
+	return hash.DoubleHashH(data[:])
+}
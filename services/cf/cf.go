@@ -1 +1,7 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+// Package cf implements the committed block filters of the light clients:
+// Golomb-coded set filters over the output scripts of the blocks and the
+// scripts they spend, in the style of BIP157/158, with the index storing the
+// filters and their filter headers.
 package cf
@@ -0,0 +1,224 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package cf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	"github.com/btceasypay/bitcoinpay/services/index"
+)
+
+var (
+	// cfIndexName is the human-readable name for the index.
+	cfIndexName = "committed filter index"
+
+	// cfIndexKey is the key of the committed filter index and the db
+	// bucket used to house it.
+	cfIndexKey = []byte("cfindex")
+
+	// cfFilterBucketName is the name of the bucket housing the serialized
+	// filters by block hash.
+	cfFilterBucketName = []byte("cffilters")
+
+	// cfHeaderBucketName is the name of the bucket housing the filter hash
+	// and the filter header by block hash.
+	cfHeaderBucketName = []byte("cfheaders")
+
+	// cfOrderBucketName is the name of the bucket housing the filter
+	// header by block order, used to chain the filter headers.
+	cfOrderBucketName = []byte("cforders")
+
+	// byteOrder is the preferred byte order used for serializing numeric
+	// fields for storage in the database.
+	byteOrder = binary.LittleEndian
+)
+
+// CFIndex implements a committed filter index.  It stores the basic filter of
+// every ordered block with the filter header chaining it to the filters of the
+// blocks ordered before, which lets the light clients check the filters they
+// fetch from the peers.
+type CFIndex struct {
+	db database.DB
+}
+
+// Ensure the CFIndex type implements the Indexer and NeedsInputser interfaces.
+var _ index.Indexer = (*CFIndex)(nil)
+var _ index.NeedsInputser = (*CFIndex)(nil)
+
+// NewCFIndex returns a new instance of an indexer that is used to create a
+// mapping of the hashes of all blocks to their committed filters.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewCFIndex(db database.DB) *CFIndex {
+	return &CFIndex{db: db}
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *CFIndex) Init() error {
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *CFIndex) Key() []byte {
+	return cfIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *CFIndex) Name() string {
+	return cfIndexName
+}
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index, since the filters commit to the spent scripts.
+//
+// This implements the NeedsInputser interface.
+func (idx *CFIndex) NeedsInputs() bool {
+	return true
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the buckets for the filters
+// and the filter headers.
+//
+// This is part of the Indexer interface.
+func (idx *CFIndex) Create(dbTx database.Tx) error {
+	bucket, err := dbTx.Metadata().CreateBucket(cfIndexKey)
+	if err != nil {
+		return err
+	}
+	for _, name := range [][]byte{cfFilterBucketName, cfHeaderBucketName, cfOrderBucketName} {
+		if _, err := bucket.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer stores the filter of the block
+// and its filter header.
+//
+// This is part of the Indexer interface.
+func (idx *CFIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	filter, err := BuildBasicFilter(block, stxos)
+	if err != nil {
+		return err
+	}
+	data, err := filter.NBytes()
+	if err != nil {
+		return err
+	}
+
+	bucket := dbTx.Metadata().Bucket(cfIndexKey)
+	orders := bucket.Bucket(cfOrderBucketName)
+	var prevHeader hash.Hash
+	if block.Order() > 0 {
+		v := orders.Get(orderKey(block.Order() - 1))
+		if v == nil {
+			return fmt.Errorf("no filter header at order %d", block.Order()-1)
+		}
+		copy(prevHeader[:], v)
+	}
+	filterHash := FilterHash(data)
+	header := MakeHeader(&filterHash, &prevHeader)
+
+	blockHash := block.Hash()
+	if err := bucket.Bucket(cfFilterBucketName).Put(blockHash[:], data); err != nil {
+		return err
+	}
+	var entry [hash.HashSize * 2]byte
+	copy(entry[:], filterHash[:])
+	copy(entry[hash.HashSize:], header[:])
+	if err := bucket.Bucket(cfHeaderBucketName).Put(blockHash[:], entry[:]); err != nil {
+		return err
+	}
+	return orders.Put(orderKey(block.Order()), header[:])
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the filter of the
+// block and its filter header.
+//
+// This is part of the Indexer interface.
+func (idx *CFIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	bucket := dbTx.Metadata().Bucket(cfIndexKey)
+	blockHash := block.Hash()
+	if err := bucket.Bucket(cfFilterBucketName).Delete(blockHash[:]); err != nil {
+		return err
+	}
+	if err := bucket.Bucket(cfHeaderBucketName).Delete(blockHash[:]); err != nil {
+		return err
+	}
+	return bucket.Bucket(cfOrderBucketName).Delete(orderKey(block.Order()))
+}
+
+// FilterByBlockHash returns the serialized basic filter of the block, nil when
+// the block isn't indexed.
+func (idx *CFIndex) FilterByBlockHash(h *hash.Hash) ([]byte, error) {
+	var filter []byte
+	err := idx.db.View(func(dbTx database.Tx) error {
+		v := dbTx.Metadata().Bucket(cfIndexKey).Bucket(cfFilterBucketName).Get(h[:])
+		if v != nil {
+			filter = make([]byte, len(v))
+			copy(filter, v)
+		}
+		return nil
+	})
+	return filter, err
+}
+
+// FilterHashByBlockHash returns the hash of the basic filter of the block and
+// its filter header, nil when the block isn't indexed.
+func (idx *CFIndex) FilterHashByBlockHash(h *hash.Hash) (*hash.Hash, *hash.Hash, error) {
+	var filterHash, header *hash.Hash
+	err := idx.db.View(func(dbTx database.Tx) error {
+		v := dbTx.Metadata().Bucket(cfIndexKey).Bucket(cfHeaderBucketName).Get(h[:])
+		if v == nil {
+			return nil
+		}
+		if len(v) != hash.HashSize*2 {
+			return fmt.Errorf("corrupt filter header entry for block %s", h)
+		}
+		filterHash = &hash.Hash{}
+		header = &hash.Hash{}
+		copy(filterHash[:], v)
+		copy(header[:], v[hash.HashSize:])
+		return nil
+	})
+	return filterHash, header, err
+}
+
+// FilterHeaderByOrder returns the filter header of the block with the order,
+// nil when the block isn't indexed.
+func (idx *CFIndex) FilterHeaderByOrder(order uint64) (*hash.Hash, error) {
+	var header *hash.Hash
+	err := idx.db.View(func(dbTx database.Tx) error {
+		v := dbTx.Metadata().Bucket(cfIndexKey).Bucket(cfOrderBucketName).Get(orderKey(order))
+		if v != nil {
+			header = &hash.Hash{}
+			copy(header[:], v)
+		}
+		return nil
+	})
+	return header, err
+}
+
+// orderKey returns the key of the block order in the order bucket.
+func orderKey(order uint64) []byte {
+	var key [8]byte
+	byteOrder.PutUint64(key[:], order)
+	return key[:]
+}
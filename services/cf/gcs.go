@@ -0,0 +1,259 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package cf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	s "github.com/btceasypay/bitcoinpay/core/serialization"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// KeySize is the size of the key used to hash the filter items.
+	KeySize = 16
+
+	// maxFilterItems is the maximum number of items a filter can hold.
+	maxFilterItems = 1<<32 - 1
+)
+
+var (
+	// ErrNTooBig signifies that the filter can't handle N items.
+	ErrNTooBig = errors.New("N is too big to fit in uint32")
+
+	// ErrPTooBig signifies that the filter can't handle `1/2**P`
+	// collision probability.
+	ErrPTooBig = errors.New("P is too big to fit in uint32 deltas")
+
+	// ErrMisserialized signifies a filter was misserialized and is missing
+	// the N and/or P parameters of a serialized filter.
+	ErrMisserialized = errors.New("misserialized filter")
+)
+
+// Filter is a Golomb-coded set: the sorted hashes of the items, mapped to the
+// range [0, N*M), are stored as Golomb-Rice coded deltas with parameter P.
+// The false positive rate of a match is about 1/M.
+type Filter struct {
+	n         uint32
+	p         uint8
+	modulusNM uint64
+	data      []byte
+}
+
+// BuildGCSFilter builds a filter with the collision parameters P and M over
+// the data, hashed with the key.  The data should not hold duplicated items.
+func BuildGCSFilter(P uint8, M uint64, key [KeySize]byte, data [][]byte) (*Filter, error) {
+	if uint64(len(data)) > maxFilterItems {
+		return nil, ErrNTooBig
+	}
+	if P > 32 {
+		return nil, ErrPTooBig
+	}
+
+	f := &Filter{
+		n: uint32(len(data)),
+		p: P,
+	}
+	f.modulusNM = uint64(f.n) * M
+	if f.n == 0 {
+		return f, nil
+	}
+
+	values := hashValues(key, f.modulusNM, data)
+	var w bitWriter
+	var last uint64
+	for _, v := range values {
+		delta := v - last
+		last = v
+
+		// Write the quotient in unary, then the remainder in P bits.
+		for q := delta >> P; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, P)
+	}
+	f.data = w.bytes
+	return f, nil
+}
+
+// FromNBytes deserializes a filter serialized with NBytes.
+func FromNBytes(P uint8, M uint64, d []byte) (*Filter, error) {
+	r := bytes.NewReader(d)
+	n, err := s.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, ErrMisserialized
+	}
+	if n > maxFilterItems {
+		return nil, ErrNTooBig
+	}
+	if P > 32 {
+		return nil, ErrPTooBig
+	}
+	f := &Filter{
+		n:         uint32(n),
+		p:         P,
+		modulusNM: n * M,
+		data:      d[len(d)-r.Len():],
+	}
+	return f, nil
+}
+
+// N returns the number of items of the filter.
+func (f *Filter) N() uint32 {
+	return f.n
+}
+
+// NBytes serializes the filter prefixed with its number of items.
+func (f *Filter) NBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(s.VarIntSerializeSize(uint64(f.n)) + len(f.data))
+	if err := s.WriteVarInt(&buf, 0, uint64(f.n)); err != nil {
+		return nil, err
+	}
+	buf.Write(f.data)
+	return buf.Bytes(), nil
+}
+
+// Match returns whether the item is likely in the filter.
+func (f *Filter) Match(key [KeySize]byte, item []byte) bool {
+	if f.n == 0 {
+		return false
+	}
+	target := hashValues(key, f.modulusNM, [][]byte{item})[0]
+	r := bitReader{bytes: f.data}
+	var value uint64
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := r.readDelta(f.p)
+		if err != nil {
+			return false
+		}
+		value += delta
+		if value == target {
+			return true
+		}
+		if value > target {
+			return false
+		}
+	}
+	return false
+}
+
+// MatchAny returns whether any of the items is likely in the filter.
+func (f *Filter) MatchAny(key [KeySize]byte, items [][]byte) bool {
+	if f.n == 0 || len(items) == 0 {
+		return false
+	}
+	targets := hashValues(key, f.modulusNM, items)
+	r := bitReader{bytes: f.data}
+	var value uint64
+	next := 0
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := r.readDelta(f.p)
+		if err != nil {
+			return false
+		}
+		value += delta
+		for targets[next] < value {
+			next++
+			if next == len(targets) {
+				return false
+			}
+		}
+		if targets[next] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// hashValues returns the sorted hashes of the items mapped to the range
+// [0, modulusNM).
+func hashValues(key [KeySize]byte, modulusNM uint64, items [][]byte) []uint64 {
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		// Map the hash uniformly to the range with a multiply and shift
+		// instead of a modulo.
+		v, _ := bits.Mul64(sipHash(k0, k1, item), modulusNM)
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+// bitWriter writes bits to a byte slice, from the most significant bit.
+type bitWriter struct {
+	bytes []byte
+	used  uint8
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.used == 0 {
+		w.bytes = append(w.bytes, 0)
+	}
+	if bit {
+		w.bytes[len(w.bytes)-1] |= 0x80 >> w.used
+	}
+	w.used = (w.used + 1) % 8
+}
+
+func (w *bitWriter) writeBits(value uint64, n uint8) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.writeBit(value&(1<<uint(i)) != 0)
+	}
+}
+
+// bitReader reads the bits written by a bitWriter.
+type bitReader struct {
+	bytes []byte
+	pos   uint64
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= uint64(len(r.bytes))*8 {
+		return false, fmt.Errorf("unexpected end of filter")
+	}
+	bit := r.bytes[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n uint8) (uint64, error) {
+	var value uint64
+	for i := uint8(0); i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value <<= 1
+		if bit {
+			value |= 1
+		}
+	}
+	return value, nil
+}
+
+// readDelta reads a Golomb-Rice coded delta.
+func (r *bitReader) readDelta(p uint8) (uint64, error) {
+	var q uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		q++
+	}
+	rem, err := r.readBits(p)
+	if err != nil {
+		return 0, err
+	}
+	return q<<p | rem, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package cf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// TestSipHash checks the reference vectors of the SipHash-2-4 paper.
+func TestSipHash(t *testing.T) {
+	var key [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	tests := []struct {
+		data []byte
+		want uint64
+	}{
+		{[]byte{}, 0x726fdb47dd0e0e31},
+		{msg[:8], 0x93f5f5799a932462},
+		{msg, 0xa129ca6149be45e5},
+	}
+	for _, test := range tests {
+		if got := sipHash(k0, k1, test.data); got != test.want {
+			t.Errorf("sipHash(%x): got %x, want %x", test.data, got, test.want)
+		}
+	}
+}
+
+func TestGCSFilterMatch(t *testing.T) {
+	var key [KeySize]byte
+	copy(key[:], "bitcoinpay key!!")
+	var items [][]byte
+	for i := 0; i < 200; i++ {
+		items = append(items, []byte(fmt.Sprintf("item %d", i)))
+	}
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, key, items)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	data, err := filter.NBytes()
+	if err != nil {
+		t.Fatalf("NBytes: %v", err)
+	}
+	filter, err = FromNBytes(DefaultP, DefaultM, data)
+	if err != nil {
+		t.Fatalf("FromNBytes: %v", err)
+	}
+	if filter.N() != uint32(len(items)) {
+		t.Fatalf("got N %d, want %d", filter.N(), len(items))
+	}
+
+	for _, item := range items {
+		if !filter.Match(key, item) {
+			t.Fatalf("filter doesn't match %s", item)
+		}
+	}
+	if filter.Match(key, []byte("missing item")) {
+		t.Fatalf("filter matches a missing item")
+	}
+	if !filter.MatchAny(key, [][]byte{[]byte("missing"), items[42]}) {
+		t.Fatalf("MatchAny doesn't match the filter items")
+	}
+	if filter.MatchAny(key, [][]byte{[]byte("missing"), []byte("absent")}) {
+		t.Fatalf("MatchAny matches missing items")
+	}
+
+	empty, err := BuildGCSFilter(DefaultP, DefaultM, key, nil)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	if empty.Match(key, items[0]) {
+		t.Fatalf("empty filter matches an item")
+	}
+}
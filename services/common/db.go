@@ -15,6 +15,10 @@ const (
 	// database type is appended to this value to form the full block
 	// database name.
 	blockDbNamePrefix = "blocks"
+
+	// lightDbNamePrefix is the prefix for the header database of a light
+	// node, which is kept apart from the block database of a full node.
+	lightDbNamePrefix = "headers"
 )
 
 // loadBlockDB loads (or creates when needed) the block database taking into
@@ -53,7 +57,11 @@ func LoadBlockDB(cfg *config.Config) (database.DB, error) {
 // blockDbPath returns the path to the block database given a database type.
 func blockDbPath(dbType string, cfg *config.Config) string {
 	// The database name is based on the database type.
-	dbName := blockDbNamePrefix + "_" + dbType
+	prefix := blockDbNamePrefix
+	if cfg.LightNode {
+		prefix = lightDbNamePrefix
+	}
+	dbName := prefix + "_" + dbType
 	dbPath := filepath.Join(cfg.DataDir, dbName)
 	return dbPath
 }
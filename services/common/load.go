@@ -10,6 +10,7 @@ import (
 	"github.com/btceasypay/bitcoinpay/config"
 	"github.com/btceasypay/bitcoinpay/core/address"
 	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/metrics"
 	"github.com/btceasypay/bitcoinpay/p2p/peer"
 	"github.com/btceasypay/bitcoinpay/params"
 	"github.com/btceasypay/bitcoinpay/services/mempool"
@@ -33,14 +34,21 @@ const (
 	defaultBlockMinSize           = 0
 	defaultBlockMaxSize           = 375000
 	defaultMaxRPCClients          = 10
+	defaultMaxRPCWebsockets       = 25
 	defaultMaxPeers               = 125
 	defaultMiningStateSync        = false
 	defaultMaxInboundPeersPerHost = 10 // The default max total of inbound peer for host
 	defaultTrickleInterval        = peer.TrickleTimeout
 	defaultCacheInvalidTx         = false
+	defaultMaxMempool             = mempool.DefaultMaxPoolSize / 1000000
+	defaultMetricsListen          = "127.0.0.1:19100"
+	defaultStratumPow             = "bitcoinpay_keccak256"
+	defaultStratumDiff            = 1
+	defaultStratumMaxClients      = 100
 )
 const (
-	defaultSigCacheMaxSize = 100000
+	defaultSigCacheMaxSize  = 100000
+	defaultUtxoCacheMaxSize = 150
 )
 const (
 	defaultMaxOrphanTxSize = 5000
@@ -73,12 +81,18 @@ func LoadConfig() (*config.Config, []string, error) {
 		RPCKey:            defaultRPCKeyFile,
 		RPCCert:           defaultRPCCertFile,
 		RPCMaxClients:     defaultMaxRPCClients,
+		RPCMaxWebsockets:  defaultMaxRPCWebsockets,
 		Generate:          defaultGenerate,
+		StratumPow:        defaultStratumPow,
+		StratumDiff:       defaultStratumDiff,
+		StratumMaxClients: defaultStratumMaxClients,
 		MaxPeers:          defaultMaxPeers,
 		MinTxFee:          mempool.DefaultMinRelayTxFee,
+		MaxMempool:        defaultMaxMempool,
 		BlockMinSize:      defaultBlockMinSize,
 		BlockMaxSize:      defaultBlockMaxSize,
 		SigCacheMaxSize:   defaultSigCacheMaxSize,
+		UtxoCacheMaxSize:  defaultUtxoCacheMaxSize,
 		MiningStateSync:   defaultMiningStateSync,
 		DAGType:           defaultDAGType,
 		Banning:           false,
@@ -373,6 +387,26 @@ func LoadConfig() (*config.Config, []string, error) {
 		}
 	}
 
+	// The metrics are created when the packages are initialized, before the
+	// configuration file is read.
+	if cfg.Metrics && !metrics.Enabled {
+		str := "%s: the --metrics option must be given on the command line"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.MetricsListen != "" && !cfg.Metrics {
+		str := "%s: the --metricslisten option requires --metrics"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.Metrics && cfg.MetricsListen == "" {
+		cfg.MetricsListen = defaultMetricsListen
+	}
+
 	// Ensure there is at least one mining address when the generate flag is
 	// set.
 	if cfg.Generate && len(cfg.MiningAddrs) == 0 {
@@ -384,6 +418,17 @@ func LoadConfig() (*config.Config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Ensure there is at least one mining address when the Stratum server
+	// is enabled.
+	if cfg.StratumListen != "" && len(cfg.MiningAddrs) == 0 {
+		str := "%s: the stratumlisten option is set, but there are no " +
+			"mining addresses specified "
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Warn about missing config file only after all other configuration is
 	// done.  This prevents the warning on help messages and invalid
 	// options.  Note this should go directly before the return.
@@ -0,0 +1,101 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package light
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/json"
+	"github.com/btceasypay/bitcoinpay/rpc"
+)
+
+func (s *SyncManager) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			NameSpace: rpc.DefaultServiceNameSpace,
+			Service:   NewPublicLightAPI(s),
+			Public:    true,
+		},
+	}
+}
+
+// PublicLightAPI serves the synced headers of a light node.
+type PublicLightAPI struct {
+	sm *SyncManager
+}
+
+func NewPublicLightAPI(sm *SyncManager) *PublicLightAPI {
+	return &PublicLightAPI{sm}
+}
+
+// GetBlockhash returns the hash of the header with the given order.
+func (api *PublicLightAPI) GetBlockhash(order uint) (string, error) {
+	h, err := api.sm.store.hashByOrder(uint64(order))
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+// GetBestBlockHash returns the hash of the main chain tip of the synced
+// headers.
+func (api *PublicLightAPI) GetBestBlockHash() (interface{}, error) {
+	_, tip := api.sm.store.state()
+	return tip.String(), nil
+}
+
+// The synced header count
+func (api *PublicLightAPI) GetBlockCount() (interface{}, error) {
+	count, _ := api.sm.store.state()
+	return count, nil
+}
+
+// GetBlockHeader implements the getblockheader command from the synced
+// headers.
+func (api *PublicLightAPI) GetBlockHeader(hash hash.Hash, verbose bool) (interface{}, error) {
+	blockHeader, ib, err := api.sm.store.header(&hash)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), fmt.Sprintf("Block not found: %v", hash))
+	}
+	if !verbose {
+		var headerBuf bytes.Buffer
+		err := blockHeader.Serialize(&headerBuf)
+		if err != nil {
+			context := "Failed to serialize block header"
+			return nil, rpc.RpcInternalError(err.Error(), context)
+		}
+		return hex.EncodeToString(headerBuf.Bytes()), nil
+	}
+	return json.GetBlockHeaderVerboseResult{
+		Hash:          hash.String(),
+		Confirmations: int64(api.sm.store.bd.GetConfirmations(ib.GetID())),
+		Version:       int32(blockHeader.Version),
+		ParentRoot:    blockHeader.ParentRoot.String(),
+		TxRoot:        blockHeader.TxRoot.String(),
+		StateRoot:     blockHeader.StateRoot.String(),
+		Difficulty:    blockHeader.Difficulty,
+		Layer:         uint32(ib.GetLayer()),
+		Time:          blockHeader.Timestamp.Unix(),
+		PowResult:     blockHeader.Pow.GetPowResult(),
+	}, nil
+}
+
+// IsCurrent returns whether the headers are synced with the sync peer.
+func (api *PublicLightAPI) IsCurrent() (interface{}, error) {
+	return api.sm.IsCurrent(), nil
+}
+
+// Tips returns the tips announced by the sync peer.
+func (api *PublicLightAPI) Tips() (interface{}, error) {
+	gs := api.sm.SyncGraphState()
+	if gs == nil {
+		return nil, fmt.Errorf("No sync peer")
+	}
+	tips := []string{}
+	for _, h := range gs.GetTips().SortList(false) {
+		tips = append(tips, h.String())
+	}
+	return tips, nil
+}
@@ -0,0 +1,459 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package light
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
+	s "github.com/btceasypay/bitcoinpay/core/serialization"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/database"
+	"github.com/btceasypay/bitcoinpay/params"
+	"sync"
+	"time"
+)
+
+var (
+	// headerBucketName is the name of the db bucket used to house the
+	// synced headers by hash.  Every value is the parents of the block
+	// followed by its header.
+	headerBucketName = []byte("lightdagheaders")
+
+	// headerStateKeyName is the name of the db key used to store the
+	// number of synced headers, which are the blocks of the DAG.
+	headerStateKeyName = []byte("lightdagstate")
+)
+
+const (
+	// maxLocatorHashes is the maximum number of hashes in the locator sent
+	// with the syncdag messages.
+	maxLocatorHashes = blockdag.MaxMainLocatorNum
+
+	// denseLocatorHashes is the number of latest headers which are all
+	// included in the locator before doubling the distance between them.
+	denseLocatorHashes = 10
+)
+
+// headerNode is a synced header linked to its parents in the DAG.  It is the
+// block data of the DAG and the node the difficulty retarget rules follow.
+type headerNode struct {
+	hs      *headerStore
+	hash    hash.Hash
+	header  *types.BlockHeader
+	parents []*hash.Hash
+	dagID   uint
+
+	// parentIDs are the DAG ids of the parents.
+	parentIDs []uint
+}
+
+// GetHash returns the hash of the block.
+func (n *headerNode) GetHash() *hash.Hash {
+	return &n.hash
+}
+
+// GetParents returns the DAG ids of the parents.
+func (n *headerNode) GetParents() []uint {
+	return n.parentIDs
+}
+
+// GetTimestamp returns the time of the block as a unix timestamp.
+func (n *headerNode) GetTimestamp() int64 {
+	return n.header.Timestamp.Unix()
+}
+
+// GetWeight returns no weight, the work of a header isn't accumulated.
+func (n *headerNode) GetWeight() uint64 {
+	return 0
+}
+
+// GetBits returns the difficulty of the block in compact form.
+func (n *headerNode) GetBits() uint32 {
+	return n.header.Difficulty
+}
+
+// GetPowType returns the proof of work of the block.
+func (n *headerNode) GetPowType() pow.PowType {
+	return n.header.Pow.GetPowType()
+}
+
+// GetOrder returns the order of the block in the DAG.
+func (n *headerNode) GetOrder() uint64 {
+	return uint64(n.hs.bd.GetBlockById(n.dagID).GetOrder())
+}
+
+// GetHeight returns the main height of the block in the DAG.
+func (n *headerNode) GetHeight() uint {
+	return n.hs.bd.GetBlockById(n.dagID).GetHeight()
+}
+
+// MainParentNode returns the main parent of the block in the DAG.
+func (n *headerNode) MainParentNode() blockchain.DifficultyNode {
+	ib := n.hs.bd.GetBlockById(n.dagID)
+	if ib == nil || ib.GetMainParent() == blockdag.MaxId {
+		return nil
+	}
+	parent := n.hs.nodeByID(ib.GetMainParent())
+	if parent == nil {
+		return nil
+	}
+	return parent
+}
+
+// headerStore keeps the headers synced by a light node and links them into a
+// DAG by their parents, which gives them their order and main height as on the
+// full nodes.
+type headerStore struct {
+	db           database.DB
+	params       *params.Params
+	dagType      string
+	bd           *blockdag.BlockDAG
+	subsidyCache *blockchain.SubsidyCache
+
+	// mtx serializes the additions of headers.
+	mtx sync.Mutex
+
+	// nodesMtx protects the index of the nodes, which the DAG looks blocks
+	// up in, so it is never held while calling into the DAG.
+	nodesMtx sync.RWMutex
+	nodes    map[hash.Hash]*headerNode
+	byID     []*headerNode
+}
+
+// newHeaderStore loads the header store from the database, or creates it
+// with the genesis header.
+func newHeaderStore(db database.DB, par *params.Params, dagType string) (*headerStore, error) {
+	hs := &headerStore{
+		db:           db,
+		params:       par,
+		dagType:      dagType,
+		subsidyCache: blockchain.NewSubsidyCache(0, par),
+		nodes:        make(map[hash.Hash]*headerNode),
+	}
+	var count uint64
+	err := db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if _, err := meta.CreateBucketIfNotExists(headerBucketName); err != nil {
+			return err
+		}
+		if _, err := meta.CreateBucketIfNotExists(dbnamespace.BlockIndexBucketName); err != nil {
+			return err
+		}
+		state := meta.Get(headerStateKeyName)
+		if state == nil {
+			return nil
+		}
+		if len(state) != 8 {
+			return fmt.Errorf("corrupt light header state")
+		}
+		count = dbnamespace.ByteOrder.Uint64(state)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	hs.bd, err = hs.loadDAG(count)
+	if err != nil {
+		return nil, fmt.Errorf("the light header DAG is damaged (%s), "+
+			"the headers must be synced again", err)
+	}
+
+	if count == 0 {
+		genesis := &par.GenesisBlock.Header
+		if _, err := hs.addHeader(genesis, nil); err != nil {
+			return nil, err
+		}
+		return hs, nil
+	}
+	err = db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(headerBucketName)
+		for id := uint(0); id < uint(count); id++ {
+			h := hs.bd.GetBlockHash(id)
+			if h == nil {
+				return fmt.Errorf("no header in the DAG at %d", id)
+			}
+			header, parents, err := deserializeHeader(bucket.Get(h[:]))
+			if err != nil {
+				return fmt.Errorf("header %s: %v", h, err)
+			}
+			node := hs.newNode(header, parents)
+			node.dagID = id
+			hs.addNode(node)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hs, nil
+}
+
+// loadDAG returns the DAG of the first count headers stored in the database.
+func (hs *headerStore) loadDAG(count uint64) (*blockdag.BlockDAG, error) {
+	bd := &blockdag.BlockDAG{}
+	bd.Init(hs.dagType, hs.calcWeight,
+		1.0/float64(hs.params.TargetTimePerBlock/time.Second), hs.dagBlockID, hs.db)
+	if count == 0 {
+		return bd, nil
+	}
+	err := hs.db.View(func(dbTx database.Tx) error {
+		return bd.Load(dbTx, uint(count), hs.params.GenesisHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bd, nil
+}
+
+// calcWeight returns the weight of a block in the DAG, which is its subsidy
+// as on the full nodes.
+func (hs *headerStore) calcWeight(blocks int64, h *hash.Hash, state byte) int64 {
+	return hs.subsidyCache.CalcBlockSubsidy(blocks)
+}
+
+// dagBlockID returns the DAG id of the block, blockdag.MaxId if it is
+// unknown.
+func (hs *headerStore) dagBlockID(h *hash.Hash) uint {
+	if node := hs.node(h); node != nil {
+		return node.dagID
+	}
+	return blockdag.MaxId
+}
+
+// newNode returns the node of the header.  The parents must be known.
+func (hs *headerStore) newNode(header *types.BlockHeader, parents []*hash.Hash) *headerNode {
+	node := &headerNode{
+		hs:      hs,
+		hash:    header.BlockHash(),
+		header:  header,
+		parents: parents,
+	}
+	for _, parent := range parents {
+		node.parentIDs = append(node.parentIDs, hs.dagBlockID(parent))
+	}
+	return node
+}
+
+// addNode adds the node to the index.
+func (hs *headerStore) addNode(node *headerNode) {
+	hs.nodesMtx.Lock()
+	hs.nodes[node.hash] = node
+	hs.byID = append(hs.byID, node)
+	hs.nodesMtx.Unlock()
+}
+
+// removeNode removes the latest node from the index.
+func (hs *headerStore) removeNode(node *headerNode) {
+	hs.nodesMtx.Lock()
+	delete(hs.nodes, node.hash)
+	hs.byID = hs.byID[:node.dagID]
+	hs.nodesMtx.Unlock()
+}
+
+// node returns the node of the block, nil if it is unknown.
+func (hs *headerStore) node(h *hash.Hash) *headerNode {
+	hs.nodesMtx.RLock()
+	defer hs.nodesMtx.RUnlock()
+	return hs.nodes[*h]
+}
+
+// nodeByID returns the node with the DAG id, nil if there is none.
+func (hs *headerStore) nodeByID(id uint) *headerNode {
+	hs.nodesMtx.RLock()
+	defer hs.nodesMtx.RUnlock()
+	if id >= uint(len(hs.byID)) {
+		return nil
+	}
+	return hs.byID[id]
+}
+
+// mainParent returns the main parent among the parents, which must be known.
+func (hs *headerStore) mainParent(parents []*hash.Hash) *headerNode {
+	ids := blockdag.NewIdSet()
+	for _, parent := range parents {
+		ids.Add(hs.dagBlockID(parent))
+	}
+	ib := hs.bd.GetMainParent(ids)
+	if ib == nil {
+		return nil
+	}
+	return hs.nodeByID(ib.GetID())
+}
+
+// addHeader links the header to its parents in the DAG and stores it.  The
+// parents must be known.
+func (hs *headerStore) addHeader(header *types.BlockHeader, parents []*hash.Hash) (*headerNode, error) {
+	hs.mtx.Lock()
+	defer hs.mtx.Unlock()
+
+	for _, parent := range parents {
+		if hs.node(parent) == nil {
+			return nil, fmt.Errorf("parent %s is unknown", parent)
+		}
+	}
+	node := hs.newNode(header, parents)
+	if hs.node(&node.hash) != nil {
+		return nil, fmt.Errorf("header %s is already known", node.hash)
+	}
+	value, err := serializeHeader(header, parents)
+	if err != nil {
+		return nil, err
+	}
+
+	// The DAG looks the block up by its id while adding it.
+	node.dagID = hs.bd.GetBlockTotal()
+	hs.addNode(node)
+	newOrders, ib := hs.bd.AddBlock(node)
+	if newOrders == nil || newOrders.Len() == 0 || ib == nil {
+		hs.removeNode(node)
+		return nil, fmt.Errorf("header %s can't be added to the DAG",
+			node.hash)
+	}
+
+	err = hs.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if err := meta.Bucket(headerBucketName).Put(node.hash[:], value); err != nil {
+			return err
+		}
+		if err := blockdag.DBPutDAGBlock(dbTx, ib); err != nil {
+			return err
+		}
+		// The blocks whose order changed.
+		for e := newOrders.Front(); e != nil; e = e.Next() {
+			if err := blockdag.DBPutDAGBlock(dbTx, e.Value.(blockdag.IBlock)); err != nil {
+				return err
+			}
+		}
+		if ib.GetID() == 0 {
+			if err := blockdag.DBPutDAGInfo(dbTx, hs.bd); err != nil {
+				return err
+			}
+		}
+		var state [8]byte
+		dbnamespace.ByteOrder.PutUint64(state[:], uint64(hs.bd.GetBlockTotal()))
+		return meta.Put(headerStateKeyName, state[:])
+	})
+	if err != nil {
+		// The DAG can't remove the block, so it is loaded back from the
+		// database which doesn't have the header.
+		hs.removeNode(node)
+		bd, loadErr := hs.loadDAG(uint64(node.dagID))
+		if loadErr != nil {
+			return nil, fmt.Errorf("%v, then failed to reload the DAG: %v", err, loadErr)
+		}
+		hs.bd = bd
+		return nil, err
+	}
+	return node, nil
+}
+
+// serializeHeader returns the value stored for the header.
+func serializeHeader(header *types.BlockHeader, parents []*hash.Hash) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.WriteVarInt(&buf, 0, uint64(len(parents))); err != nil {
+		return nil, err
+	}
+	for _, parent := range parents {
+		if err := s.WriteElements(&buf, parent); err != nil {
+			return nil, err
+		}
+	}
+	if err := header.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deserializeHeader returns the header and the parents of a stored value.
+func deserializeHeader(value []byte) (*types.BlockHeader, []*hash.Hash, error) {
+	if value == nil {
+		return nil, nil, fmt.Errorf("not found")
+	}
+	r := bytes.NewReader(value)
+	count, err := s.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if count > types.MaxParentsPerBlock {
+		return nil, nil, fmt.Errorf("too many parents %d", count)
+	}
+	parents := make([]*hash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var h hash.Hash
+		if err := s.ReadElements(r, &h); err != nil {
+			return nil, nil, err
+		}
+		parents = append(parents, &h)
+	}
+	var header types.BlockHeader
+	if err := header.Deserialize(r); err != nil {
+		return nil, nil, err
+	}
+	return &header, parents, nil
+}
+
+// has returns whether the header of the block is in the store.
+func (hs *headerStore) has(h *hash.Hash) bool {
+	return hs.node(h) != nil
+}
+
+// header returns the header of the block with the block of the DAG.
+func (hs *headerStore) header(h *hash.Hash) (*types.BlockHeader, blockdag.IBlock, error) {
+	node := hs.node(h)
+	if node == nil {
+		return nil, nil, fmt.Errorf("header %s not found", h)
+	}
+	return node.header, hs.bd.GetBlockById(node.dagID), nil
+}
+
+// hashByOrder returns the hash of the header with the given order.
+func (hs *headerStore) hashByOrder(order uint64) (*hash.Hash, error) {
+	h := hs.bd.GetBlockByOrder(uint(order))
+	if h == nil {
+		return nil, fmt.Errorf("no header at order %d", order)
+	}
+	return h, nil
+}
+
+// state returns the number of headers and the hash of the main chain tip.
+func (hs *headerStore) state() (uint64, hash.Hash) {
+	return uint64(hs.bd.GetBlockTotal()), *hs.bd.GetMainChainTip().GetHash()
+}
+
+// graphState returns the graph state of the header DAG announced to the
+// peers.
+func (hs *headerStore) graphState() *blockdag.GraphState {
+	return hs.bd.GetGraphState()
+}
+
+// locator returns the hashes used by the peers to find where the syncing
+// starts: the latest headers of the main order, then exponentially older ones
+// down to the genesis, from the oldest to the newest.
+func (hs *headerStore) locator() []*hash.Hash {
+	mainOrder := hs.bd.GetMainChainTip().GetOrder()
+	orders := []uint64{}
+	step := uint64(1)
+	for order := int64(mainOrder); order > 0 && len(orders) < maxLocatorHashes-1; order -= int64(step) {
+		orders = append(orders, uint64(order))
+		if len(orders) >= denseLocatorHashes {
+			step *= 2
+		}
+	}
+	orders = append(orders, 0)
+
+	locator := make([]*hash.Hash, 0, len(orders))
+	for i := len(orders) - 1; i >= 0; i-- {
+		h, err := hs.hashByOrder(orders[i])
+		if err != nil {
+			log.Error("Failed to build locator", "order", orders[i], "error", err)
+			continue
+		}
+		locator = append(locator, h)
+	}
+	return locator
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package light
+
+import (
+	"errors"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/merkle"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/database"
+	_ "github.com/btceasypay/bitcoinpay/database/ffldb"
+	"github.com/btceasypay/bitcoinpay/params"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// failUpdateDB is a database whose updates fail.
+type failUpdateDB struct {
+	database.DB
+}
+
+func (db *failUpdateDB) Update(fn func(tx database.Tx) error) error {
+	return errors.New("update failed")
+}
+
+// childHeader returns a header on top of the parents.
+func childHeader(genesis *types.BlockHeader, parents []*hash.Hash, i int) *types.BlockHeader {
+	header := *genesis
+	header.Timestamp = genesis.Timestamp.Add(time.Duration(i+1) * time.Second)
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	header.ParentRoot = *paMerkles[len(paMerkles)-1]
+	return &header
+}
+
+func TestHeaderStore(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "headers_ffldb")
+	db, err := database.Create("ffldb", dbPath, params.PrivNetParams.Net)
+	if err != nil {
+		t.Fatalf("database.Create: %v", err)
+	}
+	defer db.Close()
+
+	genesis := &params.PrivNetParams.GenesisBlock.Header
+	hs, err := newHeaderStore(db, &params.PrivNetParams, "phantom")
+	if err != nil {
+		t.Fatalf("newHeaderStore: %v", err)
+	}
+
+	// A chain of 40 headers, then a side block merged by the last one.
+	genesisHash := genesis.BlockHash()
+	parents := []*hash.Hash{&genesisHash}
+	var tip, fork hash.Hash
+	for i := 0; i < 40; i++ {
+		header := childHeader(genesis, parents, i)
+		if _, err := hs.addHeader(header, parents); err != nil {
+			t.Fatalf("addHeader %d: %v", i, err)
+		}
+		tip = header.BlockHash()
+		parents = []*hash.Hash{&tip}
+		if i == 37 {
+			fork = tip
+		}
+	}
+	side := childHeader(genesis, []*hash.Hash{&fork}, 200)
+	if _, err := hs.addHeader(side, []*hash.Hash{&fork}); err != nil {
+		t.Fatalf("addHeader side: %v", err)
+	}
+	chainTip, sideHash := tip, side.BlockHash()
+	parents = []*hash.Hash{&chainTip, &sideHash}
+	merge := childHeader(genesis, parents, 201)
+	if _, err := hs.addHeader(merge, parents); err != nil {
+		t.Fatalf("addHeader merge: %v", err)
+	}
+	tip = merge.BlockHash()
+
+	count, mainTip := hs.state()
+	if count != 43 || mainTip != tip {
+		t.Fatalf("unexpected state: count %d, tip %s", count, mainTip)
+	}
+	node := hs.node(&tip)
+	if node.GetHeight() != 41 || node.GetOrder() != 42 {
+		t.Fatalf("tip height %d, order %d", node.GetHeight(), node.GetOrder())
+	}
+	if mp := hs.mainParent(parents); mp == nil || mp.GetHeight() != 40 {
+		t.Fatalf("the main parent must be the tip of the chain")
+	}
+	if got, err := hs.hashByOrder(42); err != nil || *got != tip {
+		t.Fatalf("hashByOrder: got %v, err %v", got, err)
+	}
+	if gs := hs.graphState(); gs.GetTotal() != 43 || gs.GetMainHeight() != 41 {
+		t.Fatalf("unexpected graph state %s", gs.String())
+	}
+
+	// The headers with unknown parents are rejected.
+	unknown := hash.MustHexToDecodedHash("0000000000000000000000000000000000000000000000000000000000000001")
+	orphan := childHeader(genesis, []*hash.Hash{&unknown}, 100)
+	if _, err := hs.addHeader(orphan, []*hash.Hash{&unknown}); err == nil {
+		t.Fatalf("addHeader accepted a header with unknown parents")
+	}
+
+	locator := hs.locator()
+	if len(locator) > maxLocatorHashes {
+		t.Fatalf("locator too long: %d", len(locator))
+	}
+	if *locator[0] != genesisHash || *locator[len(locator)-1] != tip {
+		t.Fatalf("locator must go from the genesis to the tip")
+	}
+
+	// The DAG is restored from the database.
+	hs, err = newHeaderStore(db, &params.PrivNetParams, "phantom")
+	if err != nil {
+		t.Fatalf("newHeaderStore: %v", err)
+	}
+	if c, tp := hs.state(); c != count || tp != tip {
+		t.Fatalf("reloaded state mismatch: count %d, tip %s", c, tp)
+	}
+	if node := hs.node(&tip); node == nil || node.GetHeight() != 41 {
+		t.Fatalf("reloaded tip not found")
+	}
+
+	// A header which fails to be stored is removed from the store and the
+	// DAG, so it can be added again.
+	prev := tip
+	failed := childHeader(genesis, []*hash.Hash{&prev}, 300)
+	failedHash := failed.BlockHash()
+	hs.db = &failUpdateDB{db}
+	if _, err := hs.addHeader(failed, []*hash.Hash{&prev}); err == nil {
+		t.Fatalf("addHeader succeeded without storing the header")
+	}
+	hs.db = db
+	if hs.has(&failedHash) || hs.bd.HasBlock(&failedHash) {
+		t.Fatalf("the header which failed to be stored is still known")
+	}
+	if c, tp := hs.state(); c != count || tp != tip {
+		t.Fatalf("state changed by the failed header: count %d, tip %s", c, tp)
+	}
+	if _, err := hs.addHeader(failed, []*hash.Hash{&prev}); err != nil {
+		t.Fatalf("addHeader after the failure: %v", err)
+	}
+	count, tip = hs.state()
+	if count != 44 || tip != failedHash {
+		t.Fatalf("unexpected state: count %d, tip %s", count, tip)
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package light
+
+import (
+	"fmt"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/merkle"
+	"github.com/btceasypay/bitcoinpay/core/message"
+	"github.com/btceasypay/bitcoinpay/core/protocol"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/database"
+	"github.com/btceasypay/bitcoinpay/p2p/peer"
+	"github.com/btceasypay/bitcoinpay/params"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// syncInterval is the interval of the checks that the headers are
+	// still in sync with the sync peer.
+	syncInterval = 30 * time.Second
+)
+
+// newPeerMsg signifies a newly connected peer to the sync handler.
+type newPeerMsg struct {
+	peer *peer.ServerPeer
+}
+
+// donePeerMsg signifies a disconnected peer to the sync handler.
+type donePeerMsg struct {
+	peer *peer.ServerPeer
+}
+
+// invMsg packages an inv message and the peer it came from.
+type invMsg struct {
+	inv  *message.MsgInv
+	peer *peer.ServerPeer
+}
+
+// headersMsg packages a headers message and the peer it came from.
+type headersMsg struct {
+	headers *message.MsgHeaders
+	peer    *peer.ServerPeer
+}
+
+// isCurrentMsg requests whether the headers are synced with the sync peer.
+type isCurrentMsg struct {
+	reply chan bool
+}
+
+// syncGSMsg requests the graph state announced by the sync peer.
+type syncGSMsg struct {
+	reply chan *blockdag.GraphState
+}
+
+// SyncManager syncs the block headers of a light node from its full peers.
+// It asks the sync peer for the blocks following the synced headers with the
+// syncdag message, fetches the headers of the announced blocks along with
+// their parents with the getheaders message and links them into the header
+// DAG once their difficulty and proof of work are verified.
+type SyncManager struct {
+	started  int32
+	shutdown int32
+
+	params     *params.Params
+	timeSource blockchain.MedianTimeSource
+	retarget   *blockchain.Retarget
+	store      *headerStore
+
+	msgChan chan interface{}
+	wg      sync.WaitGroup
+	quit    chan struct{}
+
+	// The following fields are only accessed by the sync handler.
+	peers     map[*peer.Peer]*peer.ServerPeer
+	syncPeer  *peer.ServerPeer
+	requested map[hash.Hash]struct{}
+}
+
+// NewSyncManager returns a sync manager storing the headers in db, in a DAG
+// of the given type.
+func NewSyncManager(db database.DB, par *params.Params, dagType string, timeSource blockchain.MedianTimeSource) (*SyncManager, error) {
+	store, err := newHeaderStore(db, par, dagType)
+	if err != nil {
+		return nil, err
+	}
+	count, tip := store.state()
+	log.Info("Light header store loaded", "headers", count, "tip", tip)
+	return &SyncManager{
+		params:     par,
+		timeSource: timeSource,
+		retarget:   blockchain.NewRetarget(par),
+		store:      store,
+		msgChan:    make(chan interface{}, 100),
+		quit:       make(chan struct{}),
+		peers:      make(map[*peer.Peer]*peer.ServerPeer),
+		requested:  make(map[hash.Hash]struct{}),
+	}, nil
+}
+
+// Start begins the sync handler.
+func (s *SyncManager) Start() error {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return nil
+	}
+	log.Trace("Starting light sync manager")
+	s.wg.Add(1)
+	go s.syncHandler()
+	return nil
+}
+
+// Stop stops the sync handler and waits for it to finish.
+func (s *SyncManager) Stop() error {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		log.Warn("Light sync manager is already in the process of shutting down")
+		return nil
+	}
+	log.Info("Light sync manager shutting down")
+	close(s.quit)
+	s.wg.Wait()
+	return nil
+}
+
+// GraphState returns the graph state of the synced headers.
+func (s *SyncManager) GraphState() *blockdag.GraphState {
+	return s.store.graphState()
+}
+
+// IsCurrent returns whether the headers are synced with the sync peer.
+func (s *SyncManager) IsCurrent() bool {
+	reply := make(chan bool)
+	if !s.send(isCurrentMsg{reply: reply}) {
+		return false
+	}
+	return <-reply
+}
+
+// SyncGraphState returns the graph state announced by the sync peer, nil when
+// there is no sync peer.
+func (s *SyncManager) SyncGraphState() *blockdag.GraphState {
+	reply := make(chan *blockdag.GraphState)
+	if !s.send(syncGSMsg{reply: reply}) {
+		return nil
+	}
+	return <-reply
+}
+
+// NewPeer informs the sync manager of a newly negotiated peer.
+func (s *SyncManager) NewPeer(sp *peer.ServerPeer) {
+	s.send(&newPeerMsg{peer: sp})
+}
+
+// DonePeer informs the sync manager that a peer has disconnected.
+func (s *SyncManager) DonePeer(sp *peer.ServerPeer) {
+	s.send(&donePeerMsg{peer: sp})
+}
+
+// QueueInv passes the blocks announced by a peer to the sync manager.
+func (s *SyncManager) QueueInv(inv *message.MsgInv, sp *peer.ServerPeer) {
+	s.send(&invMsg{inv: inv, peer: sp})
+}
+
+// QueueHeaders passes the headers received from a peer to the sync manager.
+func (s *SyncManager) QueueHeaders(headers *message.MsgHeaders, sp *peer.ServerPeer) {
+	s.send(&headersMsg{headers: headers, peer: sp})
+}
+
+// send delivers the message to the sync handler unless it is shutting down.
+func (s *SyncManager) send(msg interface{}) bool {
+	if atomic.LoadInt32(&s.shutdown) != 0 {
+		return false
+	}
+	select {
+	case s.msgChan <- msg:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
+
+// syncHandler is the main handler of the sync manager.  It must be run as a
+// goroutine.
+func (s *SyncManager) syncHandler() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+out:
+	for {
+		select {
+		case m := <-s.msgChan:
+			switch msg := m.(type) {
+			case *newPeerMsg:
+				s.handleNewPeerMsg(msg.peer)
+			case *donePeerMsg:
+				s.handleDonePeerMsg(msg.peer)
+			case *invMsg:
+				s.handleInvMsg(msg)
+			case *headersMsg:
+				s.handleHeadersMsg(msg)
+			case isCurrentMsg:
+				msg.reply <- s.isCurrent()
+			case syncGSMsg:
+				if s.syncPeer == nil {
+					msg.reply <- nil
+				} else {
+					msg.reply <- s.syncPeer.LastGS()
+				}
+			default:
+				log.Warn(fmt.Sprintf("Invalid message type in light sync handler: %T", msg))
+			}
+		case <-ticker.C:
+			if s.syncPeer == nil {
+				s.startSync()
+			} else if !s.isCurrent() && len(s.requested) == 0 {
+				s.pushSyncDAG(s.syncPeer)
+			}
+		case <-s.quit:
+			break out
+		}
+	}
+	log.Trace("Light sync handler done")
+}
+
+func (s *SyncManager) handleNewPeerMsg(sp *peer.ServerPeer) {
+	log.Info(fmt.Sprintf("New valid peer: %s,user-agent:%s", sp, sp.UserAgent()))
+	// Only the peers sending the parents with the headers can be synced
+	// from.
+	sp.SyncCandidate = protocol.HasServices(sp.Services(), protocol.Full) &&
+		sp.ProtocolVersion() >= protocol.HeaderParentsVersion
+	s.peers[sp.Peer] = sp
+	if sp.SyncCandidate && s.syncPeer == nil {
+		s.startSync()
+	}
+}
+
+func (s *SyncManager) handleDonePeerMsg(sp *peer.ServerPeer) {
+	if _, ok := s.peers[sp.Peer]; !ok {
+		return
+	}
+	delete(s.peers, sp.Peer)
+	log.Info("Lost peer", "peer", sp)
+	if s.syncPeer == sp {
+		s.syncPeer = nil
+		s.requested = make(map[hash.Hash]struct{})
+		s.startSync()
+	}
+}
+
+// startSync chooses the candidate announcing the best graph state as the
+// sync peer and asks it for the blocks following the synced headers.
+func (s *SyncManager) startSync() {
+	var best *peer.ServerPeer
+	for _, sp := range s.peers {
+		if !sp.SyncCandidate || !sp.Connected() {
+			continue
+		}
+		if best == nil || sp.LastGS().IsExcellent(best.LastGS()) {
+			best = sp
+		}
+	}
+	if best == nil {
+		log.Trace("No sync peer candidates available")
+		return
+	}
+	log.Info("Syncing headers", "peer", best, "graph state", best.LastGS())
+	s.syncPeer = best
+	s.pushSyncDAG(best)
+}
+
+// pushSyncDAG asks the peer for the blocks following the synced headers.
+func (s *SyncManager) pushSyncDAG(sp *peer.ServerPeer) {
+	sp.PushSyncDAGMsg(s.store.graphState(), s.store.locator())
+}
+
+// isCurrent returns whether every tip announced by the sync peer is synced.
+func (s *SyncManager) isCurrent() bool {
+	if s.syncPeer == nil {
+		return false
+	}
+	gs := s.syncPeer.LastGS()
+	if gs == nil {
+		return false
+	}
+	for tip := range gs.GetTips().GetMap() {
+		h := tip
+		if !s.store.has(&h) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleInvMsg requests the headers of the announced blocks which are not
+// synced yet.
+func (s *SyncManager) handleInvMsg(msg *invMsg) {
+	hashes := []*hash.Hash{}
+	for _, iv := range msg.inv.InvList {
+		if iv.Type != message.InvTypeBlock {
+			continue
+		}
+		if _, ok := s.requested[iv.Hash]; ok {
+			continue
+		}
+		if s.store.has(&iv.Hash) {
+			continue
+		}
+		h := iv.Hash
+		hashes = append(hashes, &h)
+		if len(hashes) >= message.MaxBlockLocatorsPerMsg {
+			break
+		}
+	}
+	if len(hashes) == 0 {
+		return
+	}
+	for _, h := range hashes {
+		s.requested[*h] = struct{}{}
+	}
+	msg.peer.PushGetHeadersMsg(s.store.graphState(), hashes)
+}
+
+// handleHeadersMsg verifies the received headers and links them into the
+// header DAG, then continues syncing from the sync peer.  The headers whose
+// parents are unknown are dropped, they are requested again once the parents
+// are synced.
+func (s *SyncManager) handleHeadersMsg(msg *headersMsg) {
+	added := 0
+	for i, header := range msg.headers.Headers {
+		h := header.BlockHash()
+		delete(s.requested, h)
+		if s.store.has(&h) {
+			continue
+		}
+		var parents []*hash.Hash
+		if i < len(msg.headers.Parents) {
+			parents = msg.headers.Parents[i]
+		}
+		mainParent, err := s.checkHeader(header, parents)
+		if err == errUnknownParents {
+			log.Debug("Header with unknown parents", "hash", h,
+				"peer", msg.peer)
+			continue
+		}
+		if err != nil {
+			log.Warn("Rejected header", "hash", h, "peer", msg.peer, "error", err)
+			msg.peer.Disconnect()
+			return
+		}
+		if _, err := s.store.addHeader(header, parents); err != nil {
+			log.Warn("Rejected header", "hash", h, "main parent",
+				mainParent.GetHash(), "peer", msg.peer, "error", err)
+			msg.peer.Disconnect()
+			return
+		}
+		added++
+	}
+	if added > 0 {
+		count, tip := s.store.state()
+		log.Info("Synced headers", "count", added, "total", count, "tip", tip)
+	}
+
+	if msg.peer == s.syncPeer && len(s.requested) == 0 && !s.isCurrent() {
+		s.pushSyncDAG(msg.peer)
+	}
+}
+
+// errUnknownParents is returned for the headers whose parents are not synced.
+var errUnknownParents = fmt.Errorf("unknown parents")
+
+// checkHeader verifies the header against its parents: the parents root, the
+// difficulty required by the retarget rules at its main parent, the timestamp
+// and the proof of work.  It returns the main parent of the header.
+func (s *SyncManager) checkHeader(header *types.BlockHeader, parents []*hash.Hash) (*headerNode, error) {
+	if len(parents) == 0 {
+		return nil, fmt.Errorf("header without parents")
+	}
+	if len(parents) > types.MaxParentsPerBlock {
+		return nil, fmt.Errorf("header with %d parents, max %d",
+			len(parents), types.MaxParentsPerBlock)
+	}
+	parentsSet := blockdag.NewHashSet()
+	parentsSet.AddList(parents)
+	if parentsSet.Size() != len(parents) {
+		return nil, fmt.Errorf("duplicate parents %v", parents)
+	}
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	if !header.ParentRoot.IsEqual(paMerkles[len(paMerkles)-1]) {
+		return nil, fmt.Errorf("parents merkle root %v, but calculated "+
+			"value is %v", header.ParentRoot, paMerkles[len(paMerkles)-1])
+	}
+	for _, parent := range parents {
+		if !s.store.has(parent) {
+			return nil, errUnknownParents
+		}
+	}
+	mainParent := s.store.mainParent(parents)
+	if mainParent == nil {
+		return nil, fmt.Errorf("no main parent")
+	}
+	mainHeight := int64(mainParent.GetHeight() + 1)
+
+	// The difficulty must be the one required by the retarget rules.
+	instance := pow.GetInstance(header.Pow.GetPowType(), 0, []byte{})
+	instance.SetMainHeight(mainHeight)
+	instance.SetParams(s.params.PowConfig)
+	if !instance.CheckAvailable() {
+		return nil, fmt.Errorf("pow type %d is not available",
+			header.Pow.GetPowType())
+	}
+	required, err := s.retarget.NextRequiredDifficulty(mainParent,
+		header.Timestamp, instance)
+	if err != nil {
+		return nil, err
+	}
+	if header.Difficulty != required {
+		return nil, fmt.Errorf("block difficulty of %d is not the "+
+			"expected value of %d", header.Difficulty, required)
+	}
+
+	medianTime := blockchain.PastMedianTime(mainParent)
+	if !header.Timestamp.After(medianTime) {
+		return nil, fmt.Errorf("block timestamp of %v is not after "+
+			"expected %v", header.Timestamp, medianTime)
+	}
+	maxTimestamp := s.timeSource.AdjustedTime().Add(time.Second *
+		blockchain.MaxTimeOffsetSeconds)
+	if header.Timestamp.After(maxTimestamp) {
+		return nil, fmt.Errorf("block timestamp of %v is too far in the future", header.Timestamp)
+	}
+
+	header.Pow.SetParams(s.params.PowConfig)
+	header.Pow.SetMainHeight(mainHeight)
+	if err := header.Pow.Verify(header.BlockData(), header.BlockHash(), header.Difficulty); err != nil {
+		return nil, err
+	}
+	return mainParent, nil
+}
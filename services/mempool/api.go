@@ -1,6 +1,11 @@
 package mempool
 
 import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/json"
+	"github.com/btceasypay/bitcoinpay/core/types"
 	"github.com/btceasypay/bitcoinpay/log"
 	"github.com/btceasypay/bitcoinpay/rpc"
 	"sort"
@@ -24,10 +29,16 @@ func NewPublicMempoolAPI(txPool *TxPool) *PublicMempoolAPI {
 
 func (api *PublicMempoolAPI) GetMempool(txType *string, verbose bool) (interface{}, error) {
 	log.Trace("GetMempool called")
-	// TODO verbose
 	// The response is simply an array of the transaction hashes if the
 	// verbose flag is not set.
 	descs := api.txPool.TxDescs()
+	if verbose {
+		hashes := make([]hash.Hash, 0, len(descs))
+		for i := range descs {
+			hashes = append(hashes, *descs[i].Tx.Hash())
+		}
+		return api.mempoolEntries(hashes, true), nil
+	}
 	hashStrings := make([]string, 0, len(descs))
 	for i := range descs {
 		hashStrings = append(hashStrings, descs[i].Tx.Hash().String())
@@ -35,3 +46,141 @@ func (api *PublicMempoolAPI) GetMempool(txType *string, verbose bool) (interface
 	sort.Strings(hashStrings)
 	return hashStrings, nil
 }
+
+// GetMempoolInfo returns the number of transactions in the mempool, their size
+// and approximate memory usage, and the fee rates in atoms/kB a transaction
+// must pay to enter it.
+func (api *PublicMempoolAPI) GetMempoolInfo() (interface{}, error) {
+	info := api.txPool.Info()
+	return json.GetMempoolInfoResult{
+		Size:          info.Count,
+		Bytes:         info.Size,
+		Usage:         info.Usage,
+		MaxMempool:    info.MaxSize,
+		MempoolMinFee: info.MinFeeRate,
+		MinRelayTxFee: info.MinRelayFeeRate,
+		Orphans:       info.Orphans,
+	}, nil
+}
+
+// GetMempoolEntry returns the fee, priority, size and time added of a
+// transaction of the mempool, along with the transactions of the mempool it
+// depends on or which depend on it.
+func (api *PublicMempoolAPI) GetMempoolEntry(txHash hash.Hash) (interface{}, error) {
+	entry, err := api.txPool.FetchTxEntry(&txHash)
+	if err != nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	return marshalMempoolEntry(entry), nil
+}
+
+// GetMempoolAncestors returns the transactions of the mempool spent by a
+// transaction of the mempool, directly or not.  The entries of the
+// transactions are returned by hash when verbose is set.
+func (api *PublicMempoolAPI) GetMempoolAncestors(txHash hash.Hash, verbose *bool) (interface{}, error) {
+	ancestors, err := api.txPool.FetchAncestors(&txHash)
+	if err != nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	return api.mempoolEntries(ancestors, verbose != nil && *verbose), nil
+}
+
+// GetMempoolDescendants returns the transactions of the mempool spending a
+// transaction of the mempool, directly or not.  The entries of the
+// transactions are returned by hash when verbose is set.
+func (api *PublicMempoolAPI) GetMempoolDescendants(txHash hash.Hash, verbose *bool) (interface{}, error) {
+	descendants, err := api.txPool.FetchDescendants(&txHash)
+	if err != nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	return api.mempoolEntries(descendants, verbose != nil && *verbose), nil
+}
+
+// TestMempoolAccept checks whether a raw transaction would be accepted to the
+// mempool, without adding nor relaying it, and returns the reason it is
+// rejected or the fee and priority it would have.
+func (api *PublicMempoolAPI) TestMempoolAccept(hexTx string, allowHighFees *bool) (interface{}, error) {
+	hexStr := hexTx
+	if len(hexStr)%2 != 0 {
+		hexStr = "0" + hexStr
+	}
+	serializedTx, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, rpc.RpcDecodeHexError(hexStr)
+	}
+	msgtx := types.NewTransaction()
+	err = msgtx.Deserialize(bytes.NewReader(serializedTx))
+	if err != nil {
+		return nil, rpc.RpcDeserializationError("Could not decode Tx: %v",
+			err)
+	}
+	tx := types.NewTx(msgtx)
+	result := json.TestMempoolAcceptResult{
+		Txid: tx.Hash().String(),
+	}
+	missingParents, txD, err := api.txPool.TestAcceptTransaction(tx,
+		allowHighFees != nil && *allowHighFees)
+	if err != nil {
+		result.RejectReason = err.Error()
+		return result, nil
+	}
+	if len(missingParents) > 0 {
+		result.RejectReason = "missing inputs"
+		for _, h := range missingParents {
+			result.MissingParents = append(result.MissingParents, h.String())
+		}
+		return result, nil
+	}
+	result.Allowed = true
+	result.Size = int32(msgtx.SerializeSize())
+	result.Fee = txD.Fee
+	result.FeePerKB = txD.FeePerKB
+	result.Priority = txD.StartingPriority
+	return result, nil
+}
+
+// mempoolEntries returns the hashes of transactions of the mempool, or their
+// entries by hash when verbose is set.
+func (api *PublicMempoolAPI) mempoolEntries(hashes []hash.Hash, verbose bool) interface{} {
+	if !verbose {
+		hashStrings := make([]string, 0, len(hashes))
+		for _, h := range hashes {
+			hashStrings = append(hashStrings, h.String())
+		}
+		return hashStrings
+	}
+	// The transactions which left the mempool meanwhile are skipped.
+	entries := make(map[string]json.GetMempoolEntryResult, len(hashes))
+	for h, entry := range api.txPool.FetchTxEntries(hashes) {
+		entries[h.String()] = marshalMempoolEntry(entry)
+	}
+	return entries
+}
+
+// marshalMempoolEntry returns the json result of an entry of the mempool.
+func marshalMempoolEntry(entry *TxEntry) json.GetMempoolEntryResult {
+	result := json.GetMempoolEntryResult{
+		Size:             int32(entry.Tx.Transaction().SerializeSize()),
+		Fee:              entry.Fee,
+		FeePerKB:         entry.FeePerKB,
+		Time:             entry.Added.Unix(),
+		Height:           entry.Height,
+		StartingPriority: entry.StartingPriority,
+		CurrentPriority:  entry.CurrentPriority,
+		AncestorCount:    entry.AncestorCount,
+		AncestorSize:     entry.AncestorSize,
+		AncestorFees:     entry.AncestorFees,
+		DescendantCount:  entry.DescendantCount,
+		DescendantSize:   entry.DescendantSize,
+		DescendantFees:   entry.DescendantFees,
+		Depends:          make([]string, 0, len(entry.Depends)),
+		SpentBy:          make([]string, 0, len(entry.SpentBy)),
+	}
+	for _, h := range entry.Depends {
+		result.Depends = append(result.Depends, h.String())
+	}
+	for _, h := range entry.SpentBy {
+		result.SpentBy = append(result.SpentBy, h.String())
+	}
+	return result
+}
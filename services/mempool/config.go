@@ -74,6 +74,11 @@ type Config struct {
 	// This can be nil if the address index is not enabled.
 	ExistsAddrIndex *index.ExistsAddrIndex
 
+	// FeeEstimator defines the optional fee estimator observing the
+	// transactions accepted by the memory pool.
+	// This can be nil if the fee estimation is not enabled.
+	FeeEstimator *FeeEstimator
+
 	// block dag
 	BD *blockdag.BlockDAG
 
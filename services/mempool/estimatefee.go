@@ -0,0 +1,375 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/types"
+)
+
+const (
+	// DefaultEstimateFeeMaxTarget is the largest number of blue blocks a
+	// fee estimate can be asked for.  Transactions which are still not in
+	// a blue block after that many blue blocks are counted as failures of
+	// their fee-rate bucket.
+	DefaultEstimateFeeMaxTarget = 25
+
+	// estimateFeeDecay is the factor the statistics of every bucket are
+	// multiplied by for each new blue block, so the older observations
+	// slowly lose their weight.  It gives a half-life of about 350 blocks.
+	estimateFeeDecay = 0.998
+
+	// estimateFeeSuccessPct is the share of the transactions of a group of
+	// buckets which must have reached a blue block within the target for
+	// the fee rate of the group to be an acceptable estimate.
+	estimateFeeSuccessPct = 0.85
+
+	// estimateFeeMinTxs is the decayed number of transactions a group of
+	// buckets must hold before its success rate is trusted.
+	estimateFeeMinTxs = 2.0
+
+	// feeBucketMin is the upper limit of the lowest fee-rate bucket in
+	// atoms/kB, a tenth of the default minimum relay fee.
+	feeBucketMin = 1e3
+
+	// feeBucketMax is the upper limit of the highest bounded fee-rate
+	// bucket in atoms/kB, the highest fee the pool accepts by default.
+	feeBucketMax = 1e8
+
+	// feeBucketSpacing is the ratio between the limits of two consecutive
+	// fee-rate buckets.
+	feeBucketSpacing = 1.1
+
+	// feeEstimatorVersion is the version of the serialized fee estimator.
+	feeEstimatorVersion = 1
+)
+
+// ErrInsufficientFeeData is returned by the fee estimator when it has not
+// observed enough transactions to answer for the requested target.
+var ErrInsufficientFeeData = errors.New("insufficient data to estimate the fee")
+
+// observedTx is a transaction of the memory pool the fee estimator waits for
+// in a blue block.
+type observedTx struct {
+	feeRate float64
+	bucket  int
+	height  uint64
+}
+
+// FeeEstimator tracks how many blue blocks the transactions of the memory pool
+// take to appear in a blue block, grouped by fee-rate buckets, and estimates
+// the fee rate a transaction must pay to reach a blue block within a number
+// of blue blocks.
+//
+// The fee estimator is safe for concurrent access.
+type FeeEstimator struct {
+	mtx sync.Mutex
+
+	// maxTarget is the number of blue blocks the confirmations are
+	// tracked for.
+	maxTarget uint32
+
+	// bucketLimits are the inclusive upper limits of the fee-rate buckets
+	// in atoms/kB.  The last bucket is unbounded.
+	bucketLimits []float64
+
+	// confirmed holds, for each target and bucket, the decayed number of
+	// transactions which reached a blue block within the target.
+	confirmed [][]float64
+
+	// total holds, for each bucket, the decayed number of transactions
+	// which either reached a blue block or waited longer than maxTarget.
+	total []float64
+
+	// feeSum holds, for each bucket, the decayed sum of the fee rates of
+	// the transactions counted in total.
+	feeSum []float64
+
+	// blueHeight is the number of blue blocks registered so far.
+	blueHeight uint64
+
+	observed map[hash.Hash]*observedTx
+}
+
+// NewFeeEstimator returns a new fee estimator tracking the confirmations up
+// to the given number of blue blocks.
+func NewFeeEstimator(maxTarget uint32) *FeeEstimator {
+	limits := feeBucketLimits()
+	confirmed := make([][]float64, maxTarget)
+	for i := range confirmed {
+		confirmed[i] = make([]float64, len(limits))
+	}
+	return &FeeEstimator{
+		maxTarget:    maxTarget,
+		bucketLimits: limits,
+		confirmed:    confirmed,
+		total:        make([]float64, len(limits)),
+		feeSum:       make([]float64, len(limits)),
+		observed:     make(map[hash.Hash]*observedTx),
+	}
+}
+
+// feeBucketLimits returns the upper limits of the fee-rate buckets.
+func feeBucketLimits() []float64 {
+	limits := make([]float64, 0)
+	for limit := feeBucketMin; limit < feeBucketMax; limit *= feeBucketSpacing {
+		limits = append(limits, limit)
+	}
+	return append(limits, feeBucketMax, math.Inf(1))
+}
+
+// bucketIndex returns the index of the bucket of the given fee rate.
+func (fe *FeeEstimator) bucketIndex(feeRate float64) int {
+	return sort.SearchFloat64s(fe.bucketLimits, feeRate)
+}
+
+// ObserveTransaction starts waiting for a transaction accepted by the memory
+// pool to appear in a blue block.
+func (fe *FeeEstimator) ObserveTransaction(txD *TxDesc) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	txHash := *txD.Tx.Hash()
+	if _, ok := fe.observed[txHash]; ok {
+		return
+	}
+	feeRate := float64(txD.FeePerKB)
+	fe.observed[txHash] = &observedTx{
+		feeRate: feeRate,
+		bucket:  fe.bucketIndex(feeRate),
+		height:  fe.blueHeight,
+	}
+}
+
+// RemoveTransaction stops waiting for a transaction which left the memory
+// pool without reaching a block, replaced, evicted or expired, so it is not
+// counted as a failure to confirm.
+func (fe *FeeEstimator) RemoveTransaction(txHash *hash.Hash) {
+	fe.mtx.Lock()
+	delete(fe.observed, *txHash)
+	fe.mtx.Unlock()
+}
+
+// record counts an observed transaction which reached a blue block after the
+// given number of blue blocks, or which failed to when blocks exceeds the
+// max target.
+func (fe *FeeEstimator) record(o *observedTx, blocks uint64) {
+	fe.total[o.bucket]++
+	fe.feeSum[o.bucket] += o.feeRate
+	for t := blocks; t <= uint64(fe.maxTarget); t++ {
+		fe.confirmed[t-1][o.bucket]++
+	}
+}
+
+// RegisterBlock updates the statistics with the transactions of a block
+// connected to the DAG.  Only the blue blocks count as confirmations, the
+// observed transactions of a red block are dropped since they left the memory
+// pool without the delay saying anything about their fee rate.  The block must
+// be registered before its transactions are removed from the memory pool.
+func (fe *FeeEstimator) RegisterBlock(block *types.SerializedBlock, blue bool) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	txs := block.Transactions()
+	if len(txs) == 0 {
+		return
+	}
+	if !blue {
+		for _, tx := range txs[1:] {
+			delete(fe.observed, *tx.Hash())
+		}
+		return
+	}
+
+	fe.blueHeight++
+	for b := range fe.total {
+		fe.total[b] *= estimateFeeDecay
+		fe.feeSum[b] *= estimateFeeDecay
+		for t := range fe.confirmed {
+			fe.confirmed[t][b] *= estimateFeeDecay
+		}
+	}
+
+	for _, tx := range txs[1:] {
+		o, ok := fe.observed[*tx.Hash()]
+		if !ok {
+			continue
+		}
+		delete(fe.observed, *tx.Hash())
+		fe.record(o, fe.blueHeight-o.height)
+	}
+
+	// The transactions which waited for longer than the max target failed
+	// to reach a blue block in time.
+	for txHash, o := range fe.observed {
+		if fe.blueHeight-o.height > uint64(fe.maxTarget) {
+			fe.record(o, fe.blueHeight-o.height)
+			delete(fe.observed, txHash)
+		}
+	}
+}
+
+// estimateFee returns the lowest fee rate reaching a blue block within the
+// target with the required success rate.
+//
+// This function MUST be called with the fee estimator lock held.
+func (fe *FeeEstimator) estimateFee(target uint32) (float64, error) {
+	// Walk the buckets from the highest fee rate down, grouping them until
+	// the group holds enough transactions, and stop at the first group
+	// which doesn't reach a blue block fast enough.
+	var groupConfirmed, groupTotal, groupFeeSum float64
+	feeRate := -1.0
+	for b := len(fe.bucketLimits) - 1; b >= 0; b-- {
+		groupConfirmed += fe.confirmed[target-1][b]
+		groupTotal += fe.total[b]
+		groupFeeSum += fe.feeSum[b]
+		if groupTotal < estimateFeeMinTxs {
+			continue
+		}
+		if groupConfirmed/groupTotal < estimateFeeSuccessPct {
+			break
+		}
+		feeRate = groupFeeSum / groupTotal
+		groupConfirmed, groupTotal, groupFeeSum = 0, 0, 0
+	}
+	if feeRate < 0 {
+		return 0, ErrInsufficientFeeData
+	}
+	return feeRate, nil
+}
+
+// EstimateFee returns the fee rate in atoms/kB a transaction must pay to reach
+// a blue block within the given number of blue blocks.
+// ErrInsufficientFeeData is returned when not enough transactions have been
+// observed for the target.
+func (fe *FeeEstimator) EstimateFee(target uint32) (int64, error) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	if target < 1 || target > fe.maxTarget {
+		return 0, fmt.Errorf("target must be between 1 and %d blocks",
+			fe.maxTarget)
+	}
+	feeRate, err := fe.estimateFee(target)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Round(feeRate)), nil
+}
+
+// EstimateSmartFee returns the fee rate in atoms/kB a transaction must pay to
+// reach a blue block within the given number of blue blocks, along with the
+// target the estimate is for.  When there is not enough data for the target
+// the longer targets are tried in turn, and a target beyond the max target
+// is answered for the max target.
+func (fe *FeeEstimator) EstimateSmartFee(target uint32) (int64, uint32, error) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	if target < 1 {
+		return 0, 0, fmt.Errorf("target must be at least 1 block")
+	}
+	if target > fe.maxTarget {
+		target = fe.maxTarget
+	}
+	for t := target; t <= fe.maxTarget; t++ {
+		feeRate, err := fe.estimateFee(t)
+		if err == nil {
+			return int64(math.Round(feeRate)), t, nil
+		}
+	}
+	return 0, fe.maxTarget, ErrInsufficientFeeData
+}
+
+// MaxTarget returns the largest number of blue blocks a fee estimate can be
+// asked for.
+func (fe *FeeEstimator) MaxTarget() uint32 {
+	return fe.maxTarget
+}
+
+// Save serializes the statistics of the fee estimator so they survive a
+// restart.  The observed transactions are not saved since the memory pool is
+// not either.
+func (fe *FeeEstimator) Save() []byte {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	w := bytes.NewBuffer(make([]byte, 0))
+	binary.Write(w, binary.LittleEndian, uint32(feeEstimatorVersion))
+	binary.Write(w, binary.LittleEndian, fe.maxTarget)
+	binary.Write(w, binary.LittleEndian, uint32(len(fe.bucketLimits)))
+	binary.Write(w, binary.LittleEndian, fe.blueHeight)
+	binary.Write(w, binary.LittleEndian, fe.total)
+	binary.Write(w, binary.LittleEndian, fe.feeSum)
+	for _, confirmed := range fe.confirmed {
+		binary.Write(w, binary.LittleEndian, confirmed)
+	}
+	return w.Bytes()
+}
+
+// RestoreFeeEstimator restores a fee estimator from the statistics serialized
+// by Save.  The saved statistics are rejected when they were gathered for
+// another number of blocks or other fee-rate buckets than the compiled ones.
+func RestoreFeeEstimator(data []byte) (*FeeEstimator, error) {
+	r := bytes.NewReader(data)
+
+	var version, maxTarget, numBuckets uint32
+	err := binary.Read(r, binary.LittleEndian, &version)
+	if err != nil {
+		return nil, err
+	}
+	if version != feeEstimatorVersion {
+		return nil, fmt.Errorf("unsupported fee estimator version %d",
+			version)
+	}
+	err = binary.Read(r, binary.LittleEndian, &maxTarget)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(r, binary.LittleEndian, &numBuckets)
+	if err != nil {
+		return nil, err
+	}
+	if maxTarget != DefaultEstimateFeeMaxTarget {
+		return nil, fmt.Errorf("fee estimator tracks %d blocks, expected %d",
+			maxTarget, DefaultEstimateFeeMaxTarget)
+	}
+	if numBuckets != uint32(len(feeBucketLimits())) {
+		return nil, fmt.Errorf("fee estimator has %d buckets, expected %d",
+			numBuckets, len(feeBucketLimits()))
+	}
+
+	fe := NewFeeEstimator(maxTarget)
+	err = binary.Read(r, binary.LittleEndian, &fe.blueHeight)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(r, binary.LittleEndian, fe.total)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(r, binary.LittleEndian, fe.feeSum)
+	if err != nil {
+		return nil, err
+	}
+	for _, confirmed := range fe.confirmed {
+		err = binary.Read(r, binary.LittleEndian, confirmed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("fee estimator has %d trailing bytes", r.Len())
+	}
+	return fe, nil
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+)
+
+// newTestTxDesc returns a mempool descriptor of a unique transaction paying
+// the given fee rate.
+func newTestTxDesc(id uint32, feePerKB int64) *TxDesc {
+	tx := types.NewTransaction()
+	tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{}, id), nil))
+	return &TxDesc{TxDesc: types.TxDesc{Tx: types.NewTx(tx), FeePerKB: feePerKB}}
+}
+
+// newTestBlock returns a block holding a coinbase and the given transactions.
+func newTestBlock(txDs []*TxDesc) *types.SerializedBlock {
+	block := &types.Block{}
+	block.Header.Pow = pow.GetInstance(pow.BLAKE2BD, 0, []byte{})
+	block.AddTransaction(types.NewTransaction())
+	for _, txD := range txDs {
+		block.AddTransaction(txD.Tx.Transaction())
+	}
+	return types.NewBlock(block)
+}
+
+func TestFeeEstimator(t *testing.T) {
+	fe := NewFeeEstimator(DefaultEstimateFeeMaxTarget)
+	if _, err := fe.EstimateFee(1); err != ErrInsufficientFeeData {
+		t.Fatalf("empty estimator: got %v, want ErrInsufficientFeeData", err)
+	}
+	if _, err := fe.EstimateFee(0); err == nil {
+		t.Fatalf("target 0 was accepted")
+	}
+
+	// The transactions paying 50000 atoms/kB reach the next blue block,
+	// the ones paying 20000 atoms/kB take 5 blue blocks.
+	id := uint32(0)
+	var pending [][]*TxDesc
+	for i := 0; i < 50; i++ {
+		high := newTestTxDesc(id, 50000)
+		low := newTestTxDesc(id+1, 20000)
+		id += 2
+		fe.ObserveTransaction(high)
+		fe.ObserveTransaction(low)
+		pending = append(pending, []*TxDesc{low})
+
+		txDs := []*TxDesc{high}
+		if len(pending) > 4 {
+			txDs = append(txDs, pending[0]...)
+			pending = pending[1:]
+		}
+		fe.RegisterBlock(newTestBlock(txDs), true)
+	}
+
+	feeRate, err := fe.EstimateFee(1)
+	if err != nil || feeRate != 50000 {
+		t.Fatalf("EstimateFee(1): got %d, %v, want 50000", feeRate, err)
+	}
+	feeRate, err = fe.EstimateFee(5)
+	if err != nil || feeRate != 20000 {
+		t.Fatalf("EstimateFee(5): got %d, %v, want 20000", feeRate, err)
+	}
+	feeRate, blocks, err := fe.EstimateSmartFee(100)
+	if err != nil || feeRate != 20000 || blocks != DefaultEstimateFeeMaxTarget {
+		t.Fatalf("EstimateSmartFee(100): got %d, %d, %v", feeRate, blocks, err)
+	}
+
+	restored, err := RestoreFeeEstimator(fe.Save())
+	if err != nil {
+		t.Fatalf("RestoreFeeEstimator: %v", err)
+	}
+	feeRate, err = restored.EstimateFee(1)
+	if err != nil || feeRate != 50000 {
+		t.Fatalf("restored EstimateFee(1): got %d, %v, want 50000", feeRate, err)
+	}
+
+	// The statistics gathered with other parameters are rejected.
+	saved := fe.Save()
+	for name, offset := range map[string]int{"max target": 4, "buckets": 8} {
+		data := append([]byte{}, saved...)
+		data[offset]++
+		if _, err := RestoreFeeEstimator(data); err == nil {
+			t.Fatalf("RestoreFeeEstimator accepted a mismatched %s", name)
+		}
+	}
+	if _, err := RestoreFeeEstimator(append(saved, 0)); err == nil {
+		t.Fatalf("RestoreFeeEstimator accepted trailing bytes")
+	}
+}
+
+// TestFeeEstimatorEviction ensures a transaction evicted from the pool is not
+// counted as a failure to confirm.
+func TestFeeEstimatorEviction(t *testing.T) {
+	fe := NewFeeEstimator(DefaultEstimateFeeMaxTarget)
+	mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}, FeeEstimator: fe})
+	cheap := addTestTx(mp, types.NewOutPoint(&hash.Hash{1}, 0), 500)
+	addTestTx(mp, types.NewOutPoint(&hash.Hash{2}, 0), 50000)
+	fe.ObserveTransaction(mp.pool[*cheap.Hash()])
+
+	mp.cfg.Policy.MaxPoolSize = mp.totalSize - 1
+	mp.trimToSize()
+	if mp.haveTransaction(cheap.Hash()) {
+		t.Fatalf("cheap transaction was not evicted")
+	}
+	if _, ok := fe.observed[*cheap.Hash()]; ok {
+		t.Fatalf("the evicted transaction is still observed")
+	}
+
+	for i := 0; i <= DefaultEstimateFeeMaxTarget; i++ {
+		fe.RegisterBlock(newTestBlock(nil), true)
+	}
+	for b, total := range fe.total {
+		if total != 0 {
+			t.Fatalf("bucket %d counts %f transactions", b, total)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/types"
+)
+
+const (
+	// txEntryOverhead is the approximate memory used by a transaction of
+	// the pool besides its serialized size, which is its descriptor, its
+	// entry in the pool and the entries of its inputs in the outpoints.
+	txEntryOverhead = int64(unsafe.Sizeof(TxDesc{}) + unsafe.Sizeof(types.Tx{}) +
+		unsafe.Sizeof(hash.Hash{}) + unsafe.Sizeof(&TxDesc{}))
+
+	// txInEntryOverhead is the approximate memory used by an input of a
+	// transaction of the pool in the outpoints.
+	txInEntryOverhead = int64(unsafe.Sizeof(types.TxOutPoint{}) +
+		unsafe.Sizeof(&types.Tx{}))
+)
+
+// PoolInfo houses the state of the memory pool.
+type PoolInfo struct {
+	// Count is the number of transactions and Orphans the number of orphan
+	// transactions.
+	Count   int
+	Orphans int
+
+	// Size is the total serialized size of the transactions, and Usage
+	// the approximate memory they use.
+	Size  int64
+	Usage int64
+
+	// MaxSize is the size above which the transactions paying the lowest
+	// fee rates are evicted.
+	MaxSize int64
+
+	// MinFeeRate is the fee rate in atoms/kB a transaction must pay to be
+	// accepted, and MinRelayFeeRate the one of the policy.
+	MinFeeRate      int64
+	MinRelayFeeRate int64
+}
+
+// Info returns the state of the memory pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Info() *PoolInfo {
+	minFeeRate := mp.MinFeeRate()
+
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	usage := mp.totalSize + int64(len(mp.pool))*txEntryOverhead +
+		int64(len(mp.outpoints))*txInEntryOverhead
+	return &PoolInfo{
+		Count:           len(mp.pool),
+		Orphans:         len(mp.orphans),
+		Size:            mp.totalSize,
+		Usage:           usage,
+		MaxSize:         mp.cfg.Policy.MaxPoolSize,
+		MinFeeRate:      minFeeRate,
+		MinRelayFeeRate: int64(mp.cfg.Policy.MinRelayTxFee),
+	}
+}
+
+// TxEntry houses a transaction of the memory pool along with its current
+// priority and the transactions of the pool it depends on or which depend on
+// it.
+type TxEntry struct {
+	TxDesc
+
+	// CurrentPriority is the priority of the transaction for the next
+	// block.
+	CurrentPriority float64
+
+	// Depends are the transactions of the pool spent by the transaction,
+	// and SpentBy the ones spending it.
+	Depends []hash.Hash
+	SpentBy []hash.Hash
+
+	// The number, total size and total fees of the ancestors and of the
+	// descendants of the transaction in the pool, including itself.
+	AncestorCount   int
+	AncestorSize    int64
+	AncestorFees    int64
+	DescendantCount int
+	DescendantSize  int64
+	DescendantFees  int64
+}
+
+// FetchTxEntry returns the transaction of the memory pool with the given hash
+// along with its dependencies.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTxEntry(txHash *hash.Hash) (*TxEntry, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.txEntry(txHash, nil, nil)
+}
+
+// FetchTxEntries returns the transactions of the memory pool with the given
+// hashes along with their dependencies, by hash.  The ancestors and the
+// descendants of the transactions are computed once for all of them.  The
+// hashes of the transactions which are not in the pool are skipped.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTxEntries(txHashes []hash.Hash) map[hash.Hash]*TxEntry {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	ancestorCache := make(map[hash.Hash]map[hash.Hash]*types.Tx)
+	descendantCache := make(map[hash.Hash]map[hash.Hash]*types.Tx)
+	entries := make(map[hash.Hash]*TxEntry, len(txHashes))
+	for i := range txHashes {
+		entry, err := mp.txEntry(&txHashes[i], ancestorCache, descendantCache)
+		if err != nil {
+			continue
+		}
+		entries[txHashes[i]] = entry
+	}
+	return entries
+}
+
+// txEntry returns the transaction of the memory pool with the given hash along
+// with its dependencies.  The caches of the ancestors and of the descendants
+// are shared by the calls for several transactions.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txEntry(txHash *hash.Hash, ancestorCache,
+	descendantCache map[hash.Hash]map[hash.Hash]*types.Tx) (*TxEntry, error) {
+
+	txD, ok := mp.pool[*txHash]
+	if !ok {
+		return nil, fmt.Errorf("transaction %v is not in the pool", txHash)
+	}
+	entry := &TxEntry{
+		TxDesc:  *txD,
+		Depends: []hash.Hash{},
+		SpentBy: []hash.Hash{},
+	}
+	utxoView, err := mp.fetchInputUtxos(txD.Tx)
+	if err != nil {
+		return nil, err
+	}
+	nextBlockHeight := mp.cfg.BestHeight() + 1
+	entry.CurrentPriority = CalcPriority(txD.Tx.Transaction(), utxoView,
+		nextBlockHeight, mp.cfg.BD)
+
+	msgTx := txD.Tx.Transaction()
+	depends := make(map[hash.Hash]struct{})
+	for _, txIn := range msgTx.TxIn {
+		if _, ok := mp.pool[txIn.PreviousOut.Hash]; ok {
+			depends[txIn.PreviousOut.Hash] = struct{}{}
+		}
+	}
+	spentBy := make(map[hash.Hash]struct{})
+	op := types.TxOutPoint{Hash: *txHash}
+	for i := range msgTx.TxOut {
+		op.OutIndex = uint32(i)
+		if spender, ok := mp.outpoints[op]; ok {
+			spentBy[*spender.Hash()] = struct{}{}
+		}
+	}
+	entry.Depends = sortedHashes(depends)
+	entry.SpentBy = sortedHashes(spentBy)
+
+	size := int64(msgTx.SerializeSize())
+	entry.AncestorCount, entry.AncestorSize, entry.AncestorFees = 1, size, txD.Fee
+	for ancestorHash := range mp.txAncestors(txD.Tx, ancestorCache) {
+		ancestor := mp.pool[ancestorHash]
+		entry.AncestorCount++
+		entry.AncestorSize += int64(ancestor.Tx.Transaction().SerializeSize())
+		entry.AncestorFees += ancestor.Fee
+	}
+	entry.DescendantCount, entry.DescendantSize, entry.DescendantFees = 1, size, txD.Fee
+	for descendantHash := range mp.txDescendants(txD.Tx, descendantCache) {
+		descendant := mp.pool[descendantHash]
+		entry.DescendantCount++
+		entry.DescendantSize += int64(descendant.Tx.Transaction().SerializeSize())
+		entry.DescendantFees += descendant.Fee
+	}
+	return entry, nil
+}
+
+// FetchAncestors returns the hashes of the unconfirmed ancestors in the pool of
+// the transaction with the given hash.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchAncestors(txHash *hash.Hash) ([]hash.Hash, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txD, ok := mp.pool[*txHash]
+	if !ok {
+		return nil, fmt.Errorf("transaction %v is not in the pool", txHash)
+	}
+	ancestors := make(map[hash.Hash]struct{})
+	for ancestorHash := range mp.txAncestors(txD.Tx, nil) {
+		ancestors[ancestorHash] = struct{}{}
+	}
+	return sortedHashes(ancestors), nil
+}
+
+// FetchDescendants returns the hashes of the transactions in the pool which
+// spend the transaction with the given hash, directly or not.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchDescendants(txHash *hash.Hash) ([]hash.Hash, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txD, ok := mp.pool[*txHash]
+	if !ok {
+		return nil, fmt.Errorf("transaction %v is not in the pool", txHash)
+	}
+	descendants := make(map[hash.Hash]struct{})
+	for descendantHash := range mp.txDescendants(txD.Tx, nil) {
+		descendants[descendantHash] = struct{}{}
+	}
+	return sortedHashes(descendants), nil
+}
+
+// TestAcceptTransaction checks whether a transaction would be accepted to the
+// memory pool without adding it.  It returns the missing parents of an orphan
+// transaction, or the descriptor the transaction would have in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) TestAcceptTransaction(tx *types.Tx, allowHighFees bool) ([]*hash.Hash, *TxDesc, error) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	return mp.maybeAcceptTransaction(tx, true, false, allowHighFees, true)
+}
+
+// sortedHashes returns the hashes of a set in increasing order.
+func sortedHashes(set map[hash.Hash]struct{}) []hash.Hash {
+	hashes := make([]hash.Hash, 0, len(set))
+	for h := range set {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i].String() < hashes[j].String()
+	})
+	return hashes
+}
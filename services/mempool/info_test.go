@@ -0,0 +1,92 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/types"
+)
+
+func TestFetchTxEntry(t *testing.T) {
+	mp := New(&Config{
+		Policy: Policy{MinRelayTxFee: 1000},
+		FetchUtxoView: func(*types.Tx) (*blockchain.UtxoViewpoint, error) {
+			return blockchain.NewUtxoViewpoint(), nil
+		},
+		BestHeight: func() uint64 { return 10 },
+	})
+
+	parent := addTestTx(mp, types.NewOutPoint(&hash.Hash{1}, 0), 100)
+	child := addTestTx(mp, types.NewOutPoint(parent.Hash(), 0), 200)
+	grandChild := addTestTx(mp, types.NewOutPoint(child.Hash(), 0), 300)
+	txSize := int64(parent.Transaction().SerializeSize())
+
+	entry, err := mp.FetchTxEntry(child.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Depends) != 1 || entry.Depends[0] != *parent.Hash() {
+		t.Fatalf("depends %v, want %v", entry.Depends, parent.Hash())
+	}
+	if len(entry.SpentBy) != 1 || entry.SpentBy[0] != *grandChild.Hash() {
+		t.Fatalf("spent by %v, want %v", entry.SpentBy, grandChild.Hash())
+	}
+	if entry.AncestorCount != 2 || entry.AncestorSize != 2*txSize ||
+		entry.AncestorFees != 300 {
+		t.Fatalf("unexpected ancestors %d %d %d", entry.AncestorCount,
+			entry.AncestorSize, entry.AncestorFees)
+	}
+	if entry.DescendantCount != 2 || entry.DescendantSize != 2*txSize ||
+		entry.DescendantFees != 500 {
+		t.Fatalf("unexpected descendants %d %d %d", entry.DescendantCount,
+			entry.DescendantSize, entry.DescendantFees)
+	}
+
+	ancestors, err := mp.FetchAncestors(grandChild.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ancestors) != 2 {
+		t.Fatalf("got %d ancestors, want 2", len(ancestors))
+	}
+	descendants, err := mp.FetchDescendants(parent.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descendants) != 2 {
+		t.Fatalf("got %d descendants, want 2", len(descendants))
+	}
+	if _, err := mp.FetchTxEntry(&hash.Hash{2}); err == nil {
+		t.Fatalf("fetched a transaction not in the pool")
+	}
+
+	// The entries fetched at once match the ones fetched one by one.
+	hashes := []hash.Hash{*grandChild.Hash(), *parent.Hash(), {2}, *child.Hash()}
+	entries := mp.FetchTxEntries(hashes)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	for _, tx := range []*types.Tx{parent, child, grandChild} {
+		want, err := mp.FetchTxEntry(tx.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := entries[*tx.Hash()]
+		if got == nil || got.AncestorCount != want.AncestorCount ||
+			got.AncestorFees != want.AncestorFees ||
+			got.DescendantCount != want.DescendantCount ||
+			got.DescendantFees != want.DescendantFees {
+			t.Fatalf("entry of %v is %+v, want %+v", tx.Hash(), got, want)
+		}
+	}
+
+	info := mp.Info()
+	if info.Count != 3 || info.Size != 3*txSize || info.Usage <= info.Size {
+		t.Fatalf("unexpected pool info %+v", info)
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/message"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/log"
+)
+
+const (
+	// DefaultMaxPoolSize is the default maximum total serialized size in
+	// bytes of the transactions of the pool.
+	DefaultMaxPoolSize = 300 * 1000 * 1000
+
+	// rollingFeeHalfLife is the time it takes the rolling minimum fee to
+	// halve while the pool stays at least half full.
+	rollingFeeHalfLife = 12 * time.Hour
+)
+
+// minFeeRate returns the rolling minimum fee rate in atoms/kB, decaying it
+// since the last update.  The decay speeds up when the pool is well below its
+// size limit.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) minFeeRate() int64 {
+	if mp.rollingMinFee == 0 {
+		return 0
+	}
+
+	now := time.Now().Unix()
+	if elapsed := now - mp.lastRollingFeeUnix; elapsed > 0 {
+		halfLife := rollingFeeHalfLife.Seconds()
+		maxSize := mp.cfg.Policy.MaxPoolSize
+		if mp.totalSize < maxSize/4 {
+			halfLife /= 4
+		} else if mp.totalSize < maxSize/2 {
+			halfLife /= 2
+		}
+		mp.rollingMinFee /= math.Pow(2, float64(elapsed)/halfLife)
+		mp.lastRollingFeeUnix = now
+
+		// Drop the rolling fee once it no longer matters next to the
+		// relay fee.
+		if mp.rollingMinFee < float64(mp.cfg.Policy.MinRelayTxFee)/2 {
+			mp.rollingMinFee = 0
+		}
+	}
+	return int64(math.Ceil(mp.rollingMinFee))
+}
+
+// MinFeeRate returns the fee rate in atoms/kB a transaction must pay to enter
+// the pool, which is the highest of the relay fee and the rolling minimum fee
+// raised when the pool evicts transactions.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinFeeRate() int64 {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	minFee := int64(mp.cfg.Policy.MinRelayTxFee)
+	if rollingMinFee := mp.minFeeRate(); rollingMinFee > minFee {
+		minFee = rollingMinFee
+	}
+	return minFee
+}
+
+// Size returns the total serialized size in bytes of the transactions of the
+// pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Size() int64 {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.totalSize
+}
+
+// evictionHeap is a min-heap of the transactions of the pool ordered by the
+// fee rate of their descendant packages, so the pool finds the package to
+// evict without scanning all of its transactions.  It implements
+// heap.Interface.
+type evictionHeap []*TxDesc
+
+func (h evictionHeap) Len() int {
+	return len(h)
+}
+
+func (h evictionHeap) Less(i, j int) bool {
+	return h[i].descendantFeeRate() < h[j].descendantFeeRate()
+}
+
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].evictIndex = i
+	h[j].evictIndex = j
+}
+
+func (h *evictionHeap) Push(x interface{}) {
+	txD := x.(*TxDesc)
+	txD.evictIndex = len(*h)
+	*h = append(*h, txD)
+}
+
+func (h *evictionHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	txD := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return txD
+}
+
+// descendantFeeRate returns the fee rate in atoms/kB of the transaction along
+// with all of its descendants in the pool, which are evicted with it.
+func (txD *TxDesc) descendantFeeRate() int64 {
+	return txD.descendantFee * 1000 / txD.descendantSize
+}
+
+// hasRedeemers returns whether a transaction of the pool spends an output of
+// the transaction.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) hasRedeemers(tx *types.Tx) bool {
+	op := types.TxOutPoint{Hash: *tx.Hash()}
+	for i := range tx.Transaction().TxOut {
+		op.OutIndex = uint32(i)
+		if _, ok := mp.outpoints[op]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// calcDescendantStats computes the fees and the size of the transaction along
+// with its descendants in the pool and updates its place in the eviction heap.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) calcDescendantStats(txD *TxDesc,
+	cache map[hash.Hash]map[hash.Hash]*types.Tx) {
+
+	txD.descendantFee = txD.Fee
+	txD.descendantSize = int64(txD.Tx.Transaction().SerializeSize())
+	for descendantHash, descendant := range mp.txDescendants(txD.Tx, cache) {
+		txD.descendantFee += mp.pool[descendantHash].Fee
+		txD.descendantSize += int64(descendant.Transaction().SerializeSize())
+	}
+	heap.Fix(&mp.evictions, txD.evictIndex)
+}
+
+// indexTransaction adds a transaction just added to the pool to the eviction
+// heap and to the descendant packages of its ancestors.  A transaction without
+// descendants is simply added to the packages of its ancestors, otherwise they
+// are computed again.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) indexTransaction(txD *TxDesc) {
+	txD.descendantFee = txD.Fee
+	txD.descendantSize = int64(txD.Tx.Transaction().SerializeSize())
+	heap.Push(&mp.evictions, txD)
+
+	ancestors := mp.txAncestors(txD.Tx, nil)
+	if mp.hasRedeemers(txD.Tx) {
+		cache := make(map[hash.Hash]map[hash.Hash]*types.Tx)
+		mp.calcDescendantStats(txD, cache)
+		for ancestorHash := range ancestors {
+			mp.calcDescendantStats(mp.pool[ancestorHash], cache)
+		}
+		return
+	}
+	for ancestorHash := range ancestors {
+		ancestor := mp.pool[ancestorHash]
+		ancestor.descendantFee += txD.Fee
+		ancestor.descendantSize += int64(txD.Tx.Transaction().SerializeSize())
+		heap.Fix(&mp.evictions, ancestor.evictIndex)
+	}
+}
+
+// unindexTransaction removes a transaction just removed from the pool from the
+// eviction heap and from the descendant packages of its ancestors.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) unindexTransaction(txD *TxDesc) {
+	heap.Remove(&mp.evictions, txD.evictIndex)
+
+	ancestors := mp.txAncestors(txD.Tx, nil)
+	if mp.hasRedeemers(txD.Tx) {
+		cache := make(map[hash.Hash]map[hash.Hash]*types.Tx)
+		for ancestorHash := range ancestors {
+			mp.calcDescendantStats(mp.pool[ancestorHash], cache)
+		}
+		return
+	}
+	for ancestorHash := range ancestors {
+		ancestor := mp.pool[ancestorHash]
+		ancestor.descendantFee -= txD.Fee
+		ancestor.descendantSize -= int64(txD.Tx.Transaction().SerializeSize())
+		heap.Fix(&mp.evictions, ancestor.evictIndex)
+	}
+}
+
+// evictionWalk visits the transactions of the eviction heap from the lowest
+// descendant fee rate up without modifying the heap.  It is a heap of the
+// indexes of the eviction heap to visit next, which starts with the root and
+// gets the children of each visited transaction.  It implements
+// heap.Interface.
+type evictionWalk struct {
+	evictions evictionHeap
+	next      []int
+}
+
+func newEvictionWalk(evictions evictionHeap) *evictionWalk {
+	w := &evictionWalk{evictions: evictions}
+	if len(evictions) > 0 {
+		w.next = append(w.next, 0)
+	}
+	return w
+}
+
+func (w *evictionWalk) Len() int {
+	return len(w.next)
+}
+
+func (w *evictionWalk) Less(i, j int) bool {
+	return w.evictions.Less(w.next[i], w.next[j])
+}
+
+func (w *evictionWalk) Swap(i, j int) {
+	w.next[i], w.next[j] = w.next[j], w.next[i]
+}
+
+func (w *evictionWalk) Push(x interface{}) {
+	w.next = append(w.next, x.(int))
+}
+
+func (w *evictionWalk) Pop() interface{} {
+	n := len(w.next)
+	i := w.next[n-1]
+	w.next = w.next[:n-1]
+	return i
+}
+
+// visit returns the next transaction of the walk.
+func (w *evictionWalk) visit() *TxDesc {
+	i := heap.Pop(w).(int)
+	for _, child := range []int{2*i + 1, 2*i + 2} {
+		if child < len(w.evictions) {
+			heap.Push(w, child)
+		}
+	}
+	return w.evictions[i]
+}
+
+// checkPoolSize returns an error when the pool would evict the transaction
+// right away to honour its size limit, once the conflicts it replaces are
+// removed.  The packages paying a lower fee rate than the transaction are
+// evicted before it, except the ones holding its ancestors since it raises
+// their fee rate.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPoolSize(tx *types.Tx, txFee int64,
+	conflicts map[hash.Hash]*types.Tx) error {
+
+	maxSize := mp.cfg.Policy.MaxPoolSize
+	if maxSize <= 0 {
+		return nil
+	}
+	txSize := int64(tx.Transaction().SerializeSize())
+	size := mp.totalSize + txSize
+	for _, conflict := range conflicts {
+		size -= int64(conflict.Transaction().SerializeSize())
+	}
+
+	feeRate := txFee * 1000 / txSize
+	ancestors := mp.txAncestors(tx, nil)
+	evicted := make(map[hash.Hash]struct{})
+	cache := make(map[hash.Hash]map[hash.Hash]*types.Tx)
+	walk := newEvictionWalk(mp.evictions)
+	for size > maxSize && walk.Len() > 0 {
+		txD := walk.visit()
+		if txD.descendantFeeRate() >= feeRate {
+			break
+		}
+		pkg := mp.txDescendants(txD.Tx, cache)
+		pkg[*txD.Tx.Hash()] = txD.Tx
+		holdsAncestor := false
+		for pkgHash := range pkg {
+			if _, ok := ancestors[pkgHash]; ok {
+				holdsAncestor = true
+				break
+			}
+		}
+		if holdsAncestor {
+			continue
+		}
+		for pkgHash, pkgTx := range pkg {
+			if _, ok := evicted[pkgHash]; ok {
+				continue
+			}
+			if _, ok := conflicts[pkgHash]; ok {
+				continue
+			}
+			evicted[pkgHash] = struct{}{}
+			size -= int64(pkgTx.Transaction().SerializeSize())
+		}
+	}
+	if size > maxSize {
+		str := fmt.Sprintf("transaction %v would be evicted since the "+
+			"mempool is full", tx.Hash())
+		return txRuleError(message.RejectInsufficientFee, str)
+	}
+	return nil
+}
+
+// trimToSize evicts the transactions with the lowest descendant fee rate, along
+// with their descendants, until the pool fits within its size limit.  The
+// rolling minimum fee is raised above the fee rate of each evicted package so
+// the pool doesn't accept transactions it would evict right away.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) trimToSize() {
+	maxSize := mp.cfg.Policy.MaxPoolSize
+	if maxSize <= 0 {
+		return
+	}
+
+	for mp.totalSize > maxSize && len(mp.evictions) > 0 {
+		worst := mp.evictions[0]
+		worstRate := worst.descendantFeeRate()
+
+		rollingMinFee := float64(worstRate + int64(mp.cfg.Policy.MinRelayTxFee))
+		if rollingMinFee > mp.rollingMinFee {
+			mp.rollingMinFee = rollingMinFee
+		}
+		mp.lastRollingFeeUnix = time.Now().Unix()
+
+		log.Debug("Evicting transaction from the full mempool",
+			"txHash", worst.Tx.Hash(), "feePerKB", worstRate,
+			"pool size", mp.totalSize)
+		mp.removeTransaction(worst.Tx, true)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/types"
+)
+
+// addTestTx adds a transaction spending the given outpoint and paying the
+// given fee to the pool, bypassing the validation.
+func addTestTx(mp *TxPool, prevOut *types.TxOutPoint, fee int64) *types.Tx {
+	return addTestTxSeq(mp, prevOut, types.MaxTxInSequenceNum, fee)
+}
+
+// addTestTxSeq is addTestTx with the sequence of the input.
+func addTestTxSeq(mp *TxPool, prevOut *types.TxOutPoint, sequence uint32, fee int64) *types.Tx {
+	msgTx := types.NewTransaction()
+	txIn := types.NewTxInput(prevOut, nil)
+	txIn.Sequence = sequence
+	msgTx.AddTxIn(txIn)
+	msgTx.AddTxOut(types.NewTxOutput(1000, make([]byte, 25)))
+	tx := types.NewTx(msgTx)
+	size := int64(msgTx.SerializeSize())
+	txD := &TxDesc{TxDesc: types.TxDesc{Tx: tx, Fee: fee,
+		FeePerKB: fee * 1000 / size}}
+	mp.pool[*tx.Hash()] = txD
+	mp.outpoints[*prevOut] = tx
+	mp.indexTransaction(txD)
+	mp.totalSize += size
+	return tx
+}
+
+func TestTrimToSize(t *testing.T) {
+	mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+
+	// The low fee parent is carried by its high fee child, so the cheap
+	// independent transaction is evicted first.
+	parent := addTestTx(mp, types.NewOutPoint(&hash.Hash{1}, 0), 10)
+	child := addTestTx(mp, types.NewOutPoint(parent.Hash(), 0), 80000)
+	cheap := addTestTx(mp, types.NewOutPoint(&hash.Hash{2}, 0), 500)
+	rich := addTestTx(mp, types.NewOutPoint(&hash.Hash{3}, 0), 50000)
+	txSize := int64(cheap.Transaction().SerializeSize())
+
+	mp.cfg.Policy.MaxPoolSize = mp.totalSize - 1
+	mp.trimToSize()
+	if mp.haveTransaction(cheap.Hash()) {
+		t.Fatalf("cheap transaction was not evicted")
+	}
+	for _, tx := range []*types.Tx{parent, child, rich} {
+		if !mp.haveTransaction(tx.Hash()) {
+			t.Fatalf("transaction %v was evicted", tx.Hash())
+		}
+	}
+	if mp.totalSize != 3*txSize {
+		t.Fatalf("pool size %d, want %d", mp.totalSize, 3*txSize)
+	}
+	wantMinFee := 500*1000/txSize + 1000
+	if got := mp.MinFeeRate(); got != wantMinFee {
+		t.Fatalf("min fee rate %d, want %d", got, wantMinFee)
+	}
+
+	// Evicting the parent takes the child with it.
+	mp.cfg.Policy.MaxPoolSize = 2 * txSize
+	mp.trimToSize()
+	if mp.haveTransaction(parent.Hash()) || mp.haveTransaction(child.Hash()) {
+		t.Fatalf("parent package was not evicted")
+	}
+	if !mp.haveTransaction(rich.Hash()) || mp.totalSize != txSize {
+		t.Fatalf("unexpected pool after evicting the parent package")
+	}
+}
+
+func TestEvictionIndex(t *testing.T) {
+	mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+
+	parent := addTestTx(mp, types.NewOutPoint(&hash.Hash{1}, 0), 100)
+	child := addTestTx(mp, types.NewOutPoint(parent.Hash(), 0), 200)
+	grandChild := addTestTx(mp, types.NewOutPoint(child.Hash(), 0), 300)
+	txSize := int64(parent.Transaction().SerializeSize())
+
+	parentD := mp.pool[*parent.Hash()]
+	if parentD.descendantFee != 600 || parentD.descendantSize != 3*txSize {
+		t.Fatalf("parent package %d %d, want 600 %d", parentD.descendantFee,
+			parentD.descendantSize, 3*txSize)
+	}
+	if mp.evictions[0] != parentD {
+		t.Fatalf("the lowest package is not the one of the parent")
+	}
+	if grandChildD := mp.pool[*grandChild.Hash()]; grandChildD.descendantFee != 300 {
+		t.Fatalf("grand child package fee %d, want 300", grandChildD.descendantFee)
+	}
+
+	// Removing the child without its redeemers leaves the grand child out
+	// of the package of the parent.
+	mp.removeTransaction(child, false)
+	if parentD.descendantFee != 100 || parentD.descendantSize != txSize {
+		t.Fatalf("parent package %d %d, want 100 %d", parentD.descendantFee,
+			parentD.descendantSize, txSize)
+	}
+	if mp.evictions[0] != parentD || len(mp.evictions) != 2 {
+		t.Fatalf("unexpected eviction heap after removing the child")
+	}
+}
+
+func TestCheckPoolSize(t *testing.T) {
+	mp := New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+
+	cheap := addTestTx(mp, types.NewOutPoint(&hash.Hash{1}, 0), 500)
+	rich := addTestTx(mp, types.NewOutPoint(&hash.Hash{2}, 0), 50000)
+	mp.cfg.Policy.MaxPoolSize = mp.totalSize
+
+	newTx := func(fee int64, prevOut *types.TxOutPoint) (*types.Tx, int64) {
+		msgTx := types.NewTransaction()
+		msgTx.AddTxIn(types.NewTxInput(prevOut, nil))
+		msgTx.AddTxOut(types.NewTxOutput(1000, make([]byte, 25)))
+		return types.NewTx(msgTx), fee
+	}
+
+	// A transaction paying more than the cheap one evicts it.
+	tx, fee := newTx(20000, types.NewOutPoint(&hash.Hash{3}, 0))
+	if err := mp.checkPoolSize(tx, fee, nil); err != nil {
+		t.Fatalf("checkPoolSize: %v", err)
+	}
+
+	// A transaction paying less than every package would be evicted.
+	tx, fee = newTx(100, types.NewOutPoint(&hash.Hash{3}, 0))
+	if err := mp.checkPoolSize(tx, fee, nil); err == nil {
+		t.Fatalf("checkPoolSize accepted a transaction which would be evicted")
+	}
+
+	// A child of the cheap transaction doesn't evict its own parent.
+	tx, fee = newTx(20000, types.NewOutPoint(cheap.Hash(), 0))
+	if err := mp.checkPoolSize(tx, fee, nil); err == nil {
+		t.Fatalf("checkPoolSize accepted a child evicted with its parent")
+	}
+
+	// A replacement fits in the room of the transaction it replaces.
+	mp.cfg.Policy.MaxPoolSize = mp.totalSize
+	tx, fee = newTx(100, types.NewOutPoint(&hash.Hash{2}, 0))
+	conflicts := map[hash.Hash]*types.Tx{*rich.Hash(): rich}
+	if err := mp.checkPoolSize(tx, fee, conflicts); err != nil {
+		t.Fatalf("checkPoolSize: %v", err)
+	}
+}
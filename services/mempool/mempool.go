@@ -13,12 +13,19 @@ import (
 	"github.com/btceasypay/bitcoinpay/core/message"
 	"github.com/btceasypay/bitcoinpay/core/types"
 	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/metrics"
 	"math"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var (
+	poolTxsGauge     = metrics.NewGauge("mempool/txs")
+	poolBytesGauge   = metrics.NewGauge("mempool/bytes")
+	poolOrphansGauge = metrics.NewGauge("mempool/orphans")
+)
+
 // TxPool is used as a source of transactions that need to be mined into blocks
 // and relayed to other peers.  It is safe for concurrent access from multiple
 // peers.
@@ -35,6 +42,20 @@ type TxPool struct {
 
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
+
+	// totalSize is the total serialized size of the transactions of the
+	// pool.
+	totalSize int64
+
+	// rollingMinFee is the fee rate in atoms/kB a transaction must pay on
+	// top of the relay fee after the pool evicted transactions to honour
+	// its size limit.  It decays back to zero once the pool shrinks.
+	rollingMinFee      float64
+	lastRollingFeeUnix int64
+
+	// evictions orders the transactions by the fee rate of their
+	// descendant packages for the evictions.
+	evictions evictionHeap
 }
 
 // New returns a new memory pool for validating and storing standalone
@@ -57,6 +78,13 @@ type TxDesc struct {
 	// StartingPriority is the priority of the transaction when it was added
 	// to the pool.
 	StartingPriority float64
+
+	// descendantFee and descendantSize are the fees and the serialized
+	// size of the transaction along with its descendants in the pool, and
+	// evictIndex its index in the eviction heap of the pool.
+	descendantFee  int64
+	descendantSize int64
+	evictIndex     int
 }
 
 // TxDescs returns a slice of descriptors for all the transactions in the pool.
@@ -107,7 +135,17 @@ func (mp *TxPool) removeTransaction(theTx *types.Tx, removeRedeemers bool) {
 			delete(mp.outpoints, txIn.PreviousOut)
 		}
 		delete(mp.pool, *txHash)
+		mp.unindexTransaction(txDesc)
+
+		// The blocks are registered with the fee estimator before their
+		// transactions leave the pool, so only the transactions which
+		// will never be confirmed are still observed here.
+		if mp.cfg.FeeEstimator != nil {
+			mp.cfg.FeeEstimator.RemoveTransaction(txHash)
+		}
+		mp.totalSize -= int64(tx.SerializeSize())
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+		mp.updateMetrics()
 	}
 }
 
@@ -144,6 +182,15 @@ func (mp *TxPool) RemoveDoubleSpends(tx *types.Tx) {
 	mp.mtx.Unlock()
 }
 
+// updateMetrics updates the metrics of the size of the pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) updateMetrics() {
+	poolTxsGauge.Update(int64(len(mp.pool)))
+	poolBytesGauge.Update(mp.totalSize)
+	poolOrphansGauge.Update(int64(len(mp.orphans)))
+}
+
 // addTransaction adds the passed transaction to the memory pool.  It should
 // not be called directly as it doesn't perform any validation.  This is a
 // helper for maybeAcceptTransaction.
@@ -168,7 +215,10 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 	for _, txIn := range msgTx.TxIn {
 		mp.outpoints[txIn.PreviousOut] = tx
 	}
+	mp.indexTransaction(txD)
+	mp.totalSize += int64(msgTx.SerializeSize())
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+	mp.updateMetrics()
 
 	// Add unconfirmed address index entries associated with the transaction
 	// if enabled.
@@ -189,10 +239,11 @@ func (mp *TxPool) AddTransaction(utxoView *blockchain.UtxoViewpoint,
 
 // maybeAcceptTransaction is the internal function which implements the public
 // MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
-// more details.
+// more details.  When testOnly is set, the transaction is checked but not
+// added to the pool, and the returned descriptor is the one it would have.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHighFees bool) ([]*hash.Hash, *TxDesc, error) {
+func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHighFees, testOnly bool) ([]*hash.Hash, *TxDesc, error) {
 	msgTx := tx.Transaction()
 	txHash := tx.Hash()
 
@@ -272,7 +323,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 	// at this point.  There is a more in-depth check that happens later
 	// after fetching the referenced transaction inputs from the main chain
 	// which examines the actual spend data and prevents double spends.
-	err = mp.checkPoolDoubleSpend(tx)
+	isReplacement, err := mp.checkPoolDoubleSpend(tx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -404,6 +455,19 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		return nil, nil, txRuleError(message.RejectInsufficientFee, str)
 	}
 
+	// Don't allow transactions paying less than the rolling minimum fee
+	// while the pool is full or was recently.
+	if rollingMinFee := mp.minFeeRate(); rollingMinFee > 0 {
+		minPoolFee := calcMinRequiredTxRelayFee(serializedSize,
+			types.Amount(rollingMinFee))
+		if txFee < minPoolFee {
+			str := fmt.Sprintf("transaction %v has %v fees which "+
+				"is under the mempool minimum fee of %v", txHash,
+				txFee, minPoolFee)
+			return nil, nil, txRuleError(message.RejectInsufficientFee, str)
+		}
+	}
+
 	// Require that free transactions have sufficient priority to be mined
 	// in the next block.  Transactions which are being added back to the
 	// memory pool from blocks that have been disconnected during a reorg
@@ -461,6 +525,16 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		}
 	}
 
+	// If the transaction has any conflicts and we've made it this far, then
+	// we're processing a potential replacement.
+	var conflicts map[hash.Hash]*types.Tx
+	if isReplacement {
+		conflicts, err = mp.validateReplacement(tx, txFee)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Verify crypto signatures for each input and reject the transaction if
 	// any don't verify.
 	flags, err := mp.cfg.Policy.StandardVerifyFlags()
@@ -476,9 +550,48 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		return nil, nil, err
 	}
 
-	// Add to transaction pool.
+	// Reject the transaction the pool would evict right away because it
+	// is full, before the conflicts it replaces are removed.
+	if err := mp.checkPoolSize(tx, txFee, conflicts); err != nil {
+		return nil, nil, err
+	}
+
+	if testOnly {
+		txD := &TxDesc{
+			TxDesc: types.TxDesc{
+				Tx:       tx,
+				Added:    time.Now(),
+				Height:   int64(nextBlockHeight),
+				Fee:      txFee,
+				FeePerKB: txFee * 1000 / serializedSize,
+			},
+			StartingPriority: CalcPriority(msgTx, utxoView,
+				nextBlockHeight, mp.cfg.BD),
+		}
+		return nil, txD, nil
+	}
+
+	// Now that we've deemed the transaction as valid, we can add it to the
+	// mempool. If it ended up replacing any transactions, we'll remove them
+	// first.
+	mp.removeConflicts(conflicts, txHash)
 	txD := mp.addTransaction(utxoView, tx, nextBlockHeight, txFee)
 
+	// Evict the transactions paying the lowest fee rates when the pool
+	// exceeds its size limit.  The new transaction is only evicted when the
+	// fee rates of the packages changed with it.
+	mp.trimToSize()
+	if _, ok := mp.pool[*txHash]; !ok {
+		str := fmt.Sprintf("transaction %v was evicted since the "+
+			"mempool is full", txHash)
+		return nil, nil, txRuleError(message.RejectInsufficientFee, str)
+	}
+
+	// Wait for the transaction in a blue block to estimate the fees.
+	if mp.cfg.FeeEstimator != nil {
+		mp.cfg.FeeEstimator.ObserveTransaction(txD)
+	}
+
 	log.Debug("Accepted transaction", "txHash", txHash, "pool size", len(mp.pool))
 
 	return nil, txD, nil
@@ -539,7 +652,7 @@ func (mp *TxPool) ProcessTransaction(tx *types.Tx, allowOrphan, rateLimit, allow
 	// Potentially accept the transaction to the memory pool.
 	var missingParents []*hash.Hash
 	missingParents, txD, err := mp.maybeAcceptTransaction(tx, true, rateLimit,
-		allowHighFees)
+		allowHighFees, false)
 	if err != nil {
 		return nil, err
 	}
@@ -625,7 +738,7 @@ func (mp *TxPool) maybeAddOrphan(tx *types.Tx) error {
 func (mp *TxPool) MaybeAcceptTransaction(tx *types.Tx, isNew, rateLimit bool) ([]*hash.Hash, error) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	hashes, _, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true)
+	hashes, _, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true, false)
 	mp.mtx.Unlock()
 
 	return hashes, err
@@ -660,6 +773,7 @@ func (mp *TxPool) removeOrphan(txHash *hash.Hash) {
 
 	// Remove the transaction from the orphan pool.
 	delete(mp.orphans, *txHash)
+	mp.updateMetrics()
 }
 
 // RemoveOrphan removes the passed orphan transaction from the orphan pool and
@@ -716,7 +830,7 @@ func (mp *TxPool) processOrphans(h *hash.Hash) []*TxDesc {
 			// Potentially accept the transaction into the
 			// transaction pool.
 			missingParents, txD, err := mp.maybeAcceptTransaction(tx,
-				true, true, true)
+				true, true, true, false)
 			if err != nil {
 				// TODO: Remove orphans that depend on this
 				// failed transaction.
@@ -772,6 +886,7 @@ func (mp *TxPool) addOrphan(tx *types.Tx) {
 		}
 		mp.orphansByPrev[originTxHash][*tx.Hash()] = tx
 	}
+	mp.updateMetrics()
 
 	log.Debug(fmt.Sprintf("Stored orphan transaction %v (total: %d)", tx.Hash(),
 		len(mp.orphans)))
@@ -904,6 +1019,31 @@ func (mp *TxPool) IsOrphanInPool(hash *hash.Hash) bool {
 	return inPool
 }
 
+// CheckSpend checks whether the passed outpoint is already spent by a
+// transaction in the mempool.  If that's the case the spending transaction
+// will be returned, if not nil will be returned.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CheckSpend(op types.TxOutPoint) *types.Tx {
+	mp.mtx.RLock()
+	txR := mp.outpoints[op]
+	mp.mtx.RUnlock()
+
+	return txR
+}
+
+// MinRequiredTxRelayFee returns the minimum fee a transaction with the passed
+// serialized size must pay to be accepted into the mempool and relayed.
+func (mp *TxPool) MinRequiredTxRelayFee(serializedSize int64) int64 {
+	return calcMinRequiredTxRelayFee(serializedSize, mp.cfg.Policy.MinRelayTxFee)
+}
+
+// IsDust returns whether the output is considered dust by the relay policy
+// of the mempool.
+func (mp *TxPool) IsDust(txOut *types.TxOutput) bool {
+	return isDust(txOut, mp.cfg.Policy.MinRelayTxFee)
+}
+
 // LastUpdated returns the last time a transaction was added to or removed from
 // the main pool.  It does not include the orphan pool.
 //
@@ -0,0 +1,156 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/log"
+)
+
+const (
+	// MempoolDumpFilename is the name of the file the transactions of the
+	// pool are dumped to on shutdown.
+	MempoolDumpFilename = "mempool.dat"
+
+	// mempoolDumpVersion is the version of the mempool dump format.
+	mempoolDumpVersion = 1
+)
+
+// Dump writes the transactions of the pool to the file at the given path so
+// they can be loaded back on the next start.  The transactions are written
+// ahead of their descendants.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Dump(path string) error {
+	mp.mtx.RLock()
+	cache := make(map[hash.Hash]map[hash.Hash]*types.Tx)
+	descs := make([]*TxDesc, 0, len(mp.pool))
+	numAncestors := make(map[hash.Hash]int, len(mp.pool))
+	for txHash, txD := range mp.pool {
+		descs = append(descs, txD)
+		numAncestors[txHash] = len(mp.txAncestors(txD.Tx, cache))
+	}
+	mp.mtx.RUnlock()
+
+	sort.Slice(descs, func(i, j int) bool {
+		return numAncestors[*descs[i].Tx.Hash()] <
+			numAncestors[*descs[j].Tx.Hash()]
+	})
+
+	// Write a temporary file and then move it into place.
+	tmpFile := path + ".new"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	err = writeMempoolDump(f, descs)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, path)
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
+
+// writeMempoolDump writes the version of the dump and the transactions with
+// the time they entered the pool.
+func writeMempoolDump(f io.Writer, descs []*TxDesc) error {
+	w := bufio.NewWriter(f)
+	err := binary.Write(w, binary.LittleEndian, uint32(mempoolDumpVersion))
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.LittleEndian, uint32(len(descs)))
+	if err != nil {
+		return err
+	}
+	for _, txD := range descs {
+		err = binary.Write(w, binary.LittleEndian, txD.Added.Unix())
+		if err != nil {
+			return err
+		}
+		err = txD.Tx.Transaction().Encode(w, 0, types.TxSerializeFull)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Load reads the transactions dumped to the file at the given path and
+// processes them again, so the ones which are still valid return to the pool.
+// It returns the number of transactions accepted.  A missing file is not an
+// error.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var version, count uint32
+	err = binary.Read(r, binary.LittleEndian, &version)
+	if err != nil {
+		return 0, err
+	}
+	if version != mempoolDumpVersion {
+		return 0, fmt.Errorf("unsupported mempool dump version %d", version)
+	}
+	err = binary.Read(r, binary.LittleEndian, &count)
+	if err != nil {
+		return 0, err
+	}
+
+	accepted := 0
+	for i := uint32(0); i < count; i++ {
+		var added int64
+		err = binary.Read(r, binary.LittleEndian, &added)
+		if err != nil {
+			return accepted, err
+		}
+		var msgTx types.Transaction
+		err = msgTx.Deserialize(r)
+		if err != nil {
+			return accepted, err
+		}
+
+		tx := types.NewTx(&msgTx)
+		_, err = mp.ProcessTransaction(tx, false, false, true)
+		if err != nil {
+			log.Debug("Dropping dumped transaction", "txHash", tx.Hash(),
+				"error", err)
+			continue
+		}
+		accepted++
+
+		// Keep the time the transaction first entered the pool.
+		mp.mtx.Lock()
+		if txD, ok := mp.pool[*tx.Hash()]; ok {
+			txD.Added = time.Unix(added, 0)
+		}
+		mp.mtx.Unlock()
+	}
+	return accepted, nil
+}
@@ -134,6 +134,17 @@ type Policy struct {
 	// MinRelayTxFee defines the minimum transaction fee in AtomBitcoinpay/kB
 	MinRelayTxFee types.Amount
 
+	// MaxPoolSize is the maximum total serialized size in bytes of the
+	// transactions of the pool.  The transactions paying the lowest fee
+	// rate along with their descendants are evicted above it.  Zero means
+	// no limit.
+	MaxPoolSize int64
+
+	// RejectReplacement, if true, rejects accepting replacement
+	// transactions using the Replace-By-Fee (RBF) signaling policy into
+	// the mempool.
+	RejectReplacement bool
+
 	// StandardVerifyFlags defines the function to retrieve the flags to
 	// use for verifying scripts for the block after the current best block.
 	// It must set the verification flags properly depending on the result
@@ -0,0 +1,155 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/types"
+)
+
+// newReplacement returns a transaction spending the outpoints, its fee is the
+// one given to acceptReplacement.
+func newReplacement(prevOuts ...*types.TxOutPoint) *types.Tx {
+	msgTx := types.NewTransaction()
+	for _, prevOut := range prevOuts {
+		msgTx.AddTxIn(types.NewTxInput(prevOut, nil))
+	}
+	msgTx.AddTxOut(types.NewTxOutput(2000, make([]byte, 25)))
+	return types.NewTx(msgTx)
+}
+
+// acceptReplacement runs the replacement checks of maybeAcceptTransaction on
+// the transaction paying the fee and removes the transactions it replaces.
+func acceptReplacement(mp *TxPool, tx *types.Tx, fee int64) error {
+	isReplacement, err := mp.checkPoolDoubleSpend(tx)
+	if err != nil {
+		return err
+	}
+	if !isReplacement {
+		return nil
+	}
+	conflicts, err := mp.validateReplacement(tx, fee)
+	if err != nil {
+		return err
+	}
+	mp.removeConflicts(conflicts, tx.Hash())
+	return nil
+}
+
+func TestReplacement(t *testing.T) {
+	newPool := func() *TxPool {
+		return New(&Config{Policy: Policy{MinRelayTxFee: 1000}})
+	}
+	op := types.NewOutPoint(&hash.Hash{1}, 0)
+
+	// The replacement of a signalling transaction evicts it along with its
+	// descendants.
+	mp := newPool()
+	orig := addTestTxSeq(mp, op, MaxRBFSequence, 1000)
+	child := addTestTx(mp, types.NewOutPoint(orig.Hash(), 0), 1000)
+	grandChild := addTestTx(mp, types.NewOutPoint(child.Hash(), 0), 1000)
+	if err := acceptReplacement(mp, newReplacement(op), 10000); err != nil {
+		t.Fatalf("replacement rejected: %v", err)
+	}
+	for _, tx := range []*types.Tx{orig, child, grandChild} {
+		if mp.haveTransaction(tx.Hash()) {
+			t.Fatalf("replaced transaction %v is still in the pool", tx.Hash())
+		}
+	}
+	if len(mp.outpoints) != 0 || mp.totalSize != 0 {
+		t.Fatalf("the replaced transactions still spend %d outpoints, "+
+			"size %d", len(mp.outpoints), mp.totalSize)
+	}
+
+	// A child inherits the signal of its unconfirmed parent.
+	mp = newPool()
+	orig = addTestTxSeq(mp, op, MaxRBFSequence, 1000)
+	childOp := types.NewOutPoint(orig.Hash(), 0)
+	child = addTestTx(mp, childOp, 1000)
+	if err := acceptReplacement(mp, newReplacement(childOp), 10000); err != nil {
+		t.Fatalf("replacement of an inherited signal rejected: %v", err)
+	}
+	if mp.haveTransaction(child.Hash()) || !mp.haveTransaction(orig.Hash()) {
+		t.Fatalf("only the child must be replaced")
+	}
+
+	tests := []struct {
+		name              string
+		rejectReplacement bool
+		setup             func(mp *TxPool) *types.Tx
+		fee               int64
+		reject            string
+	}{{
+		name: "fee rate not above the original",
+		setup: func(mp *TxPool) *types.Tx {
+			addTestTxSeq(mp, op, MaxRBFSequence, 1000)
+			return newReplacement(op)
+		},
+		fee:    1000,
+		reject: "insufficient fee rate",
+	}, {
+		name: "fee not above the conflicts",
+		setup: func(mp *TxPool) *types.Tx {
+			orig := addTestTxSeq(mp, op, MaxRBFSequence, 1000)
+			addTestTx(mp, types.NewOutPoint(orig.Hash(), 0), 1000)
+			return newReplacement(op)
+		},
+		fee:    1500,
+		reject: "insufficient absolute fee",
+	}, {
+		name: "too many evictions",
+		setup: func(mp *TxPool) *types.Tx {
+			tx := addTestTxSeq(mp, op, MaxRBFSequence, 1000)
+			for i := 0; i < MaxReplacementEvictions; i++ {
+				tx = addTestTx(mp, types.NewOutPoint(tx.Hash(), 0), 1000)
+			}
+			return newReplacement(op)
+		},
+		fee:    1e6,
+		reject: "evicts more transactions than permitted",
+	}, {
+		name: "new unconfirmed input",
+		setup: func(mp *TxPool) *types.Tx {
+			addTestTxSeq(mp, op, MaxRBFSequence, 1000)
+			other := addTestTx(mp, types.NewOutPoint(&hash.Hash{2}, 0), 1000)
+			return newReplacement(op, types.NewOutPoint(other.Hash(), 0))
+		},
+		fee:    10000,
+		reject: "new unconfirmed input",
+	}, {
+		name: "original not signalling",
+		setup: func(mp *TxPool) *types.Tx {
+			addTestTx(mp, op, 1000)
+			return newReplacement(op)
+		},
+		fee:    10000,
+		reject: "already spends the same coins",
+	}, {
+		name:              "replacements rejected",
+		rejectReplacement: true,
+		setup: func(mp *TxPool) *types.Tx {
+			addTestTxSeq(mp, op, MaxRBFSequence, 1000)
+			return newReplacement(op)
+		},
+		fee:    10000,
+		reject: "already spends the same coins",
+	}}
+	for _, test := range tests {
+		mp := newPool()
+		mp.cfg.Policy.RejectReplacement = test.rejectReplacement
+		tx := test.setup(mp)
+		size, count := mp.totalSize, len(mp.pool)
+		err := acceptReplacement(mp, tx, test.fee)
+		if err == nil || !strings.Contains(err.Error(), test.reject) {
+			t.Fatalf("%s: got %v, want %q", test.name, err, test.reject)
+		}
+		if mp.totalSize != size || len(mp.pool) != count {
+			t.Fatalf("%s: the rejected replacement evicted transactions", test.name)
+		}
+	}
+}
@@ -3,17 +3,21 @@
 package miner
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/btceasypay/bitcoinpay/core/blockdag"
 	"github.com/btceasypay/bitcoinpay/core/types/pow"
 	"github.com/btceasypay/bitcoinpay/engine/txscript"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/address"
 	"github.com/btceasypay/bitcoinpay/core/blockchain"
 	"github.com/btceasypay/bitcoinpay/core/json"
 	"github.com/btceasypay/bitcoinpay/core/types"
@@ -33,6 +37,15 @@ const gbtNonceRange = "00000000ffffffff"
 // in the memory pool.
 const gbtRegenerateSeconds = 60
 
+// gbtLongPollTimeoutSeconds is the most number of seconds a long polling
+// getblocktemplate request waits for the block template to change before the
+// current one is returned.
+const gbtLongPollTimeoutSeconds = 120
+
+// gbtLongPollCheckSeconds is the number of seconds between the checks of the
+// memory pool by the long polling getblocktemplate requests.
+const gbtLongPollCheckSeconds = 1
+
 func (c *CPUMiner) APIs() []rpc.API {
 	return []rpc.API{
 		{
@@ -56,7 +69,11 @@ type PublicMinerAPI struct {
 
 func NewPublicMinerAPI(c *CPUMiner) *PublicMinerAPI {
 	pmAPI := &PublicMinerAPI{miner: c}
-	pmAPI.gbtWorkState = &gbtWorkState{timeSource: c.timeSource}
+	pmAPI.gbtWorkState = &gbtWorkState{
+		notifyMap:  make(map[hash.Hash]map[int64]chan struct{}),
+		timeSource: c.timeSource,
+	}
+	c.blockManager.GetChain().Subscribe(pmAPI.gbtWorkState.handleChainNotification)
 
 	pmAPI.gbtCoinbaseAux = &json.GetBlockTemplateResultAux{
 		Flags: hex.EncodeToString(builderScript(txscript.NewScriptBuilder().
@@ -65,14 +82,19 @@ func NewPublicMinerAPI(c *CPUMiner) *PublicMinerAPI {
 	return pmAPI
 }
 
-//func (api *PublicMinerAPI) GetBlockTemplate(request *mining.TemplateRequest) (interface{}, error){
-func (api *PublicMinerAPI) GetBlockTemplate(capabilities []string) (interface{}, error) {
+// When the longpollid of a previous template is given, the request blocks
+// until the tips change, the memory pool has been updated long enough after the
+// template was generated, or gbtLongPollTimeoutSeconds have passed.
+func (api *PublicMinerAPI) GetBlockTemplate(ctx context.Context, capabilities []string, longPollID *string) (interface{}, error) {
 	// Set the default mode and override it if supplied.
 	mode := "template"
 	request := json.TemplateRequest{Mode: mode, Capabilities: capabilities}
+	if longPollID != nil {
+		request.LongPollID = *longPollID
+	}
 	switch mode {
 	case "template":
-		return handleGetBlockTemplateRequest(api, &request)
+		return handleGetBlockTemplateRequest(ctx, api, &request)
 	case "proposal":
 		//TODO LL, will be added
 		//return handleGetBlockTemplateProposal(s, request)
@@ -162,7 +184,7 @@ func (api *PublicMinerAPI) SubmitBlock(hexBlock string) (interface{}, error) {
 // in regards to whether or not it supports creating its own coinbase (the
 // coinbasetxn and coinbasevalue capabilities) and modifies the returned block
 // template accordingly.
-func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRequest) (interface{}, error) {
+func handleGetBlockTemplateRequest(ctx context.Context, api *PublicMinerAPI, request *json.TemplateRequest) (interface{}, error) {
 	// Extract the relevant passed capabilities and restrict the result to
 	// either a coinbase value or a coinbase transaction object depending on
 	// the request.  Default to only providing a coinbase value.
@@ -199,6 +221,14 @@ func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRe
 			"bitcoinpay is downloading blocks...")
 	}
 
+	// When a long poll ID was provided, this is a long poll request by the
+	// client to be notified when block template referenced by the ID should
+	// be replaced with a new one.
+	if request != nil && request.LongPollID != "" {
+		return handleGetBlockTemplateLongPoll(ctx, api, request.LongPollID,
+			useCoinbaseValue)
+	}
+
 	// Protect concurrent access when updating block templates.
 	state := api.gbtWorkState
 	state.Lock()
@@ -215,6 +245,105 @@ func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRe
 	return state.blockTemplateResult(api, useCoinbaseValue, nil)
 }
 
+// handleGetBlockTemplateLongPoll is a helper for handleGetBlockTemplateRequest
+// which deals with handling long polling for block templates.  When a caller
+// sends a request with a long poll ID that was previously returned, a response
+// is not sent until the caller should stop working on the previous block
+// template in favor of the new one.  In particular, this is the case when the
+// tips of the DAG have changed, or when the transactions in the memory pool
+// have been updated and it has been long enough since the last template was
+// generated.  The current template is also returned after
+// gbtLongPollTimeoutSeconds.
+func handleGetBlockTemplateLongPoll(ctx context.Context, api *PublicMinerAPI, longPollID string, useCoinbaseValue bool) (interface{}, error) {
+	state := api.gbtWorkState
+	state.Lock()
+	// The state unlock is intentionally not deferred here since it needs to
+	// be manually unlocked before waiting for a notification about block
+	// template changes.
+
+	if err := state.updateBlockTemplate(api, useCoinbaseValue); err != nil {
+		state.Unlock()
+		return nil, err
+	}
+
+	// Just return the current block template if the long poll ID provided
+	// by the caller is invalid.
+	parentRoot, lastGenerated, err := decodeTemplateID(longPollID)
+	if err != nil {
+		result, err := state.blockTemplateResult(api, useCoinbaseValue, nil)
+		state.Unlock()
+		return result, err
+	}
+
+	// Return the block template now if the specific block template
+	// identified by the long poll ID no longer matches the current block
+	// template as this means the provided template is stale.
+	header := &state.template.Block.Header
+	if !parentRoot.IsEqual(&header.ParentRoot) ||
+		lastGenerated != state.lastGenerated.Unix() {
+
+		// Include whether or not it is valid to submit work against the
+		// old block template depending on whether or not the tips have
+		// changed.
+		submitOld := parentRoot.IsEqual(&header.ParentRoot)
+		result, err := state.blockTemplateResult(api, useCoinbaseValue,
+			&submitOld)
+		state.Unlock()
+		return result, err
+	}
+
+	// Get a channel that will be notified when the template associated with
+	// the provided ID is stale and a new block template should be returned to
+	// the caller.
+	longPollChan := state.templateUpdateChan(parentRoot, lastGenerated)
+	state.Unlock()
+
+	timeout := time.NewTimer(time.Second * gbtLongPollTimeoutSeconds)
+	defer timeout.Stop()
+	ticker := time.NewTicker(time.Second * gbtLongPollCheckSeconds)
+	defer ticker.Stop()
+out:
+	for {
+		select {
+		// Long poll timeout or the caller went away.
+		case <-timeout.C:
+			break out
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		// Wait until signal received to send the reply.
+		case <-longPollChan:
+			break out
+
+		// Check whether the memory pool has been updated long enough
+		// after the template was generated.
+		case <-ticker.C:
+			lastTxUpdate := api.miner.txSource.LastUpdated()
+			state.Lock()
+			stale := lastTxUpdate.After(state.lastTxUpdate) &&
+				time.Now().After(state.lastGenerated.Add(time.Second*
+					gbtRegenerateSeconds))
+			state.Unlock()
+			if stale {
+				break out
+			}
+		}
+	}
+
+	// Get the latest block template.
+	state.Lock()
+	defer state.Unlock()
+
+	if err := state.updateBlockTemplate(api, useCoinbaseValue); err != nil {
+		return nil, err
+	}
+
+	// Include whether or not it is valid to submit work against the old
+	// block template depending on whether or not the tips have changed.
+	submitOld := parentRoot.IsEqual(&state.template.Block.Header.ParentRoot)
+	return state.blockTemplateResult(api, useCoinbaseValue, &submitOld)
+}
+
 //LL
 // encodeTemplateID encodes the passed details into an ID that can be used to
 // uniquely identify a block template.
@@ -222,6 +351,28 @@ func encodeTemplateID(prevHash hash.Hash, lastGenerated time.Time) string {
 	return fmt.Sprintf("%s-%d", prevHash.String(), lastGenerated.Unix())
 }
 
+// decodeTemplateID decodes an ID that is used to uniquely identify a block
+// template.  This is mainly used as a mechanism to track when to update clients
+// that are using long polling for block templates.  The ID consists of the
+// parent root of the template and the time the template was generated.
+func decodeTemplateID(templateID string) (*hash.Hash, int64, error) {
+	fields := strings.Split(templateID, "-")
+	if len(fields) != 2 {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+
+	prevHash, err := hash.NewHashFromStr(fields[0])
+	if err != nil {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+	lastGenerated, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+
+	return prevHash, lastGenerated, nil
+}
+
 // gbtWorkState houses state that is used in between multiple RPC invocations to
 // getblocktemplate.
 type gbtWorkState struct {
@@ -231,9 +382,67 @@ type gbtWorkState struct {
 	parentsSet    *blockdag.HashSet
 	minTimestamp  time.Time
 	template      *types.BlockTemplate
+	notifyMap     map[hash.Hash]map[int64]chan struct{}
 	timeSource    blockchain.MedianTimeSource
 }
 
+// handleChainNotification notifies the long polling clients when a block is
+// connected, since the tips of their templates have changed.
+func (state *gbtWorkState) handleChainNotification(notification *blockchain.Notification) {
+	if notification.Type != blockchain.BlockConnected {
+		return
+	}
+	// The notification is sent with the chain locked, while the state is
+	// locked around the creation of the templates, which locks the chain.
+	go func() {
+		state.Lock()
+		defer state.Unlock()
+
+		state.notifyLongPollers()
+	}()
+}
+
+// notifyLongPollers notifies all the clients waiting for a new block template,
+// and removes them from the notification map.
+//
+// This function MUST be called with the state locked.
+func (state *gbtWorkState) notifyLongPollers() {
+	for parentRoot, channels := range state.notifyMap {
+		for _, c := range channels {
+			close(c)
+		}
+		delete(state.notifyMap, parentRoot)
+	}
+}
+
+// templateUpdateChan returns a channel that will be closed once the block
+// template associated with the passed parent root and last generated time is
+// stale.  The function will return existing channels for duplicate
+// parameters which allows multiple clients to wait for the same block template
+// without requiring a different channel for each client.
+//
+// This function MUST be called with the state locked.
+func (state *gbtWorkState) templateUpdateChan(parentRoot *hash.Hash, lastGenerated int64) chan struct{} {
+	// Either get the current list of channels waiting for updates about
+	// changes to block template for the parent root or create a new one.
+	channels, ok := state.notifyMap[*parentRoot]
+	if !ok {
+		m := make(map[int64]chan struct{})
+		state.notifyMap[*parentRoot] = m
+		channels = m
+	}
+
+	// Get the current channel associated with the time the block template
+	// was last generated or create a new one.
+	c, ok := channels[lastGenerated]
+	if !ok {
+		c = make(chan struct{})
+		channels[lastGenerated] = c
+	}
+
+	return c
+}
+
 // updateBlockTemplate creates or updates a block template for the work state.
 // A new block template will be generated when the current best block has
 // changed or the transactions in the memory pool have been updated and it has
@@ -434,6 +643,8 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 	x16rv3iDifficulty := fmt.Sprintf("%064x", x16rv3big)
 	x8r16Difficulty := fmt.Sprintf("%064x", x8r16big)
 	keccak256Difficulty := fmt.Sprintf("%064x", keccak256big)
+	progpowDifficulty := fmt.Sprintf("%064x", pow.CompactToBig(template.PowDiffData.ProgpowTarget))
+	cryptonightDifficulty := fmt.Sprintf("%064x", pow.CompactToBig(template.PowDiffData.CryptonightTarget))
 	targetCuckarooDDifficulty := template.PowDiffData.CuckarooBaseDiff
 	targetCuckaroomDifficulty := template.PowDiffData.CuckaroomBaseDiff
 	targetCuckatooDDifficulty := template.PowDiffData.CuckatooBaseDiff
@@ -465,6 +676,10 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 			X8r16Target:            x8r16Difficulty,
 			BitcoinpayKeccak256Bits:   strconv.FormatInt(int64(template.PowDiffData.BitcoinpayKeccak256Target), 16),
 			BitcoinpayKeccak256Target: keccak256Difficulty,
+			ProgpowBits:            strconv.FormatInt(int64(template.PowDiffData.ProgpowTarget), 16),
+			ProgpowTarget:          progpowDifficulty,
+			CryptonightBits:        strconv.FormatInt(int64(template.PowDiffData.CryptonightTarget), 16),
+			CryptonightTarget:      cryptonightDifficulty,
 			//cuckoo mining min diff
 			CuckarooMinDiff:  targetCuckarooDDifficulty,
 			CuckaroomMinDiff: targetCuckaroomDifficulty,
@@ -556,6 +771,106 @@ func (api *PrivateMinerAPI) Generate(numBlocks uint32, powType pow.PowType) ([]s
 	return reply, nil
 }
 
+// SetGenerate starts or stops the CPU miner.  The optional number of workers
+// replaces the current one, a negative number meaning the default number.  The
+// optional pow types are the proofs of work assigned to the workers in turn,
+// and the optional addresses the addresses the blocks pay to in turn, instead
+// of the mining addresses of the configuration when empty.
+func (api *PrivateMinerAPI) SetGenerate(enabled bool, workers *int32, powTypes *[]pow.PowType, addresses *[]string) (interface{}, error) {
+	m := api.miner
+	if !enabled {
+		m.Stop()
+		return nil, nil
+	}
+
+	if addresses != nil {
+		addrs := make([]types.Address, 0, len(*addresses))
+		for _, a := range *addresses {
+			addr, err := address.DecodeAddress(a)
+			if err != nil {
+				return nil, rpc.RpcInvalidError("Invalid address %s: %v", a, err)
+			}
+			if !address.IsForNetwork(addr, m.params) {
+				return nil, rpc.RpcInvalidError("Address %s is on the wrong network", a)
+			}
+			addrs = append(addrs, addr)
+		}
+		m.SetPayAddrs(addrs)
+	}
+	if len(m.PayAddrs()) == 0 {
+		return nil, rpc.RpcInternalError("No payment addresses specified "+
+			"via --miningaddr", "Configuration")
+	}
+	if powTypes != nil {
+		if err := m.SetPowTypes(*powTypes); err != nil {
+			return nil, rpc.RpcInvalidError(err.Error())
+		}
+	}
+	if workers != nil {
+		m.SetNumWorkers(*workers)
+		if *workers == 0 {
+			return nil, nil
+		}
+	}
+	m.Start()
+	if !m.IsMining() {
+		return nil, rpc.RpcInternalError("The CPU miner did not start", "miner")
+	}
+	return nil, nil
+}
+
+// GetMiningInfo returns the state of the CPU miner, with the workers and the
+// number of hashes per second of each proof of work, or of graphs for the
+// cuckoo proofs of work.  The total number of hashes per second leaves out the
+// graphs, which are not hashes.
+func (api *PublicMinerAPI) GetMiningInfo() (interface{}, error) {
+	m := api.miner
+	best := m.blockManager.GetChain().BestSnapshot()
+	powTypes := m.PowTypes()
+	numWorkers := m.NumWorkers()
+	generate := m.IsMining()
+	rates := m.PowHashesPerSecond()
+
+	// The workers are assigned the proofs of work in turn.
+	workers := make(map[pow.PowType]int32, len(powTypes))
+	for i := int32(0); i < numWorkers; i++ {
+		workers[powTypes[int(i)%len(powTypes)]]++
+	}
+	pows := make([]json.MiningPowResult, 0, len(powTypes))
+	var hashesPerSec float64
+	for _, powType := range powTypes {
+		if _, ok := workers[powType]; !ok {
+			continue
+		}
+		name, _ := pow.PowMapString[powType].(string)
+		pows = append(pows, json.MiningPowResult{
+			PowType:      uint8(powType),
+			PowName:      name,
+			Workers:      workers[powType],
+			HashesPerSec: rates[powType],
+		})
+		switch powType {
+		case pow.CUCKAROO, pow.CUCKATOO, pow.CUCKAROOM:
+		default:
+			hashesPerSec += rates[powType]
+		}
+		delete(workers, powType)
+	}
+	addrs := m.PayAddrs()
+	miningAddrs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		miningAddrs = append(miningAddrs, addr.String())
+	}
+	return json.GetMiningInfoResult{
+		Blocks:       best.GraphState.GetMainOrder() + 1,
+		Generate:     generate,
+		Workers:      numWorkers,
+		HashesPerSec: hashesPerSec,
+		Pows:         pows,
+		MiningAddrs:  miningAddrs,
+	}, nil
+}
+
 func builderScript(builder *txscript.ScriptBuilder) []byte {
 	script, err := builder.Script()
 	if err != nil {
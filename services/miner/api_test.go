@@ -0,0 +1,57 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+)
+
+func TestTemplateID(t *testing.T) {
+	parentRoot := hash.Hash{1, 2, 3}
+	generated := time.Unix(1600000000, 0)
+
+	gotRoot, gotGenerated, err := decodeTemplateID(encodeTemplateID(parentRoot,
+		generated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *gotRoot != parentRoot || gotGenerated != generated.Unix() {
+		t.Fatalf("decoded %v-%d, want %v-%d", gotRoot, gotGenerated,
+			parentRoot, generated.Unix())
+	}
+
+	for _, id := range []string{"", "bad", parentRoot.String(),
+		parentRoot.String() + "-x", "zz-1600000000"} {
+		if _, _, err := decodeTemplateID(id); err == nil {
+			t.Errorf("decoded invalid id %q", id)
+		}
+	}
+}
+
+func TestNotifyLongPollers(t *testing.T) {
+	state := &gbtWorkState{
+		notifyMap: make(map[hash.Hash]map[int64]chan struct{}),
+	}
+	c1 := state.templateUpdateChan(&hash.Hash{1}, 10)
+	if c := state.templateUpdateChan(&hash.Hash{1}, 10); c != c1 {
+		t.Fatal("the same template has different channels")
+	}
+	c2 := state.templateUpdateChan(&hash.Hash{2}, 10)
+
+	state.notifyLongPollers()
+	for i, c := range []chan struct{}{c1, c2} {
+		select {
+		case <-c:
+		default:
+			t.Fatalf("channel %d was not closed", i)
+		}
+	}
+	if len(state.notifyMap) != 0 {
+		t.Fatalf("%d templates still waited for", len(state.notifyMap))
+	}
+}
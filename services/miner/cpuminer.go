@@ -53,6 +53,31 @@ const (
 	maxSimnetToMine uint8 = 4
 )
 
+// defaultPowTypes are the proofs of work mined by default.
+var defaultPowTypes = []pow.PowType{pow.BITCOINPAYKECCAK256}
+
+// cpuPowTypes are the proofs of work the CPU miner is able to solve.
+var cpuPowTypes = []pow.PowType{pow.BLAKE2BD, pow.CUCKAROO, pow.X16RV3,
+	pow.X8R16, pow.BITCOINPAYKECCAK256, pow.PROGPOW, pow.CRYPTONIGHT}
+
+// IsCPUPowType returns whether the CPU miner is able to solve the proof of
+// work.
+func IsCPUPowType(powType pow.PowType) bool {
+	for _, t := range cpuPowTypes {
+		if t == powType {
+			return true
+		}
+	}
+	return false
+}
+
+// hashUpdate is the number of hashes a worker has performed for a proof of
+// work, or the number of graphs searched for the cuckoo proofs of work.
+type hashUpdate struct {
+	powType pow.PowType
+	hashes  uint64
+}
+
 // CPUMiner provides facilities for solving blocks (mining) using the CPU in
 // a concurrency-safe manner.  It consists of two main goroutines -- a speed
 // monitor and a controller for worker goroutines which generate and solve
@@ -76,10 +101,25 @@ type CPUMiner struct {
 	workerWg          sync.WaitGroup
 	updateNumWorkers  chan struct{}
 	queryHashesPerSec chan float64
-	updateHashes      chan uint64
+	updateHashes      chan hashUpdate
 	speedMonitorQuit  chan struct{}
 	quit              chan struct{}
 
+	// queryPowHashesPerSec is the channel of the numbers of hashes per
+	// second of each proof of work.
+	queryPowHashesPerSec chan map[pow.PowType]float64
+
+	// powTypes are the proofs of work of the workers, which are assigned
+	// to them in turn.
+	powTypes []pow.PowType
+
+	// payAddrs are the addresses the blocks pay to in turn, the mining
+	// addresses of the configuration when empty, and nextPayAddr the index
+	// of the next one.  They are protected by payAddrMtx.
+	payAddrMtx  sync.Mutex
+	payAddrs    []types.Address
+	nextPayAddr int
+
 	// This is a map that keeps track of how many blocks have
 	// been mined on each parent by the CPUMiner. It is only
 	// for use in simulation networks, to diminish memory
@@ -105,8 +145,11 @@ func NewCPUMiner(cfg *config.Config, par *params.Params, policy *mining.Policy,
 		numWorkers:        numWorkers,
 		updateNumWorkers:  make(chan struct{}),
 		queryHashesPerSec: make(chan float64),
-		updateHashes:      make(chan uint64),
+		updateHashes:      make(chan hashUpdate),
 		minedOnParents:    make(map[hash.Hash]uint8),
+
+		queryPowHashesPerSec: make(chan map[pow.PowType]float64),
+		powTypes:             defaultPowTypes,
 	}
 }
 
@@ -166,9 +209,18 @@ func (m *CPUMiner) GenerateNBlocks(n uint32, powType pow.PowType) ([]*hash.Hash,
 		// template on a block that is in the process of becoming stale.
 		m.submitBlockLock.Lock()
 
-		// Choose a payment address at random.
-		rand.Seed(time.Now().UnixNano())
-		payToAddr := m.config.GetMinningAddrs()[rand.Intn(len(m.config.GetMinningAddrs()))]
+		// Choose the next payment address.
+		payToAddr, err := m.nextPayToAddr()
+		if err != nil {
+			m.submitBlockLock.Unlock()
+			m.Lock()
+			close(m.speedMonitorQuit)
+			m.wg.Wait()
+			m.started = false
+			m.discreteMining = false
+			m.Unlock()
+			return nil, err
+		}
 
 		// Create a new block template using the available transactions
 		// in the memory pool as a source of transactions to potentially
@@ -193,31 +245,15 @@ func (m *CPUMiner) GenerateNBlocks(n uint32, powType pow.PowType) ([]*hash.Hash,
 			continue //might try again?
 		}
 
-		var result = false
-		switch powType {
-		case pow.BLAKE2BD:
-			template.Block.Header.Difficulty = uint32(template.PowDiffData.Blake2bDTarget)
-			result = m.solveBlock(template.Block, ticker, nil)
-		case pow.X16RV3:
-			template.Block.Header.Difficulty = uint32(template.PowDiffData.X16rv3DTarget)
-			result = m.solveX16rv3Block(template.Block, ticker, nil)
-		case pow.X8R16:
-			template.Block.Header.Difficulty = uint32(template.PowDiffData.X8r16DTarget)
-			result = m.solveX8r16Block(template.Block, ticker, nil)
-		case pow.BITCOINPAYKECCAK256:
-			template.Block.Header.Difficulty = uint32(template.PowDiffData.BitcoinpayKeccak256Target)
-			result = m.solveBitcoinpayKeccak256Block(template.Block, ticker, nil)
-		case pow.CUCKAROO:
-			template.Block.Header.Difficulty = pow.BigToCompact(new(big.Int).SetUint64(template.PowDiffData.CuckarooBaseDiff))
-			result = m.solveCuckarooBlock(template.Block, ticker, nil, template.PowDiffData.CuckarooDiffScale, template.Height)
-		default:
+		result, err := m.solveTemplate(template, powType, ticker, nil)
+		if err != nil {
 			m.Lock()
 			close(m.speedMonitorQuit)
 			m.wg.Wait()
 			m.started = false
 			m.discreteMining = false
 			m.Unlock()
-			return nil, errors.New("pow not found!") //should miner if error
+			return nil, err //should miner if error
 		}
 
 		// Attempt to solve the block.  The function will exit early
@@ -258,7 +294,8 @@ func (m *CPUMiner) speedMonitor() {
 	log.Trace("CPU miner speed monitor started")
 
 	var hashesPerSec float64
-	var totalHashes uint64
+	powHashesPerSec := make(map[pow.PowType]float64)
+	totalHashes := make(map[pow.PowType]uint64)
 	ticker := time.NewTicker(time.Second * hpsUpdateSecs)
 	defer ticker.Stop()
 
@@ -267,26 +304,39 @@ out:
 		select {
 		// Periodic updates from the workers with how many hashes they
 		// have performed.
-		case numHashes := <-m.updateHashes:
-			totalHashes += numHashes
+		case update := <-m.updateHashes:
+			totalHashes[update.powType] += update.hashes
+			if _, ok := powHashesPerSec[update.powType]; !ok {
+				powHashesPerSec[update.powType] = 0
+			}
 
-		// Time to update the hashes per second.
+		// Time to update the hashes per second of each proof of work.
 		case <-ticker.C:
-			curHashesPerSec := float64(totalHashes) / hpsUpdateSecs
-			if hashesPerSec == 0 {
-				hashesPerSec = curHashesPerSec
-			}
-			hashesPerSec = (hashesPerSec + curHashesPerSec) / 2
-			totalHashes = 0
-			if hashesPerSec != 0 {
-				log.Debug(fmt.Sprintf("Hash speed: %6.0f kilohashes/s",
-					hashesPerSec/1000))
+			hashesPerSec = 0
+			for powType, rate := range powHashesPerSec {
+				curHashesPerSec := float64(totalHashes[powType]) / hpsUpdateSecs
+				if rate == 0 {
+					rate = curHashesPerSec
+				}
+				rate = (rate + curHashesPerSec) / 2
+				powHashesPerSec[powType] = rate
+				hashesPerSec += rate
+				if rate != 0 {
+					log.Debug(fmt.Sprintf("Hash speed: %6.0f kilohashes/s",
+						rate/1000), "pow", pow.PowMapString[powType])
+				}
 			}
+			totalHashes = make(map[pow.PowType]uint64)
 
 		// Request for the number of hashes per second.
 		case m.queryHashesPerSec <- hashesPerSec:
 			// Nothing to do.
 
+		// Request for the number of hashes per second of each proof of
+		// work.
+		case m.queryPowHashesPerSec <- copyHashRates(powHashesPerSec):
+			// Nothing to do.
+
 		case <-m.speedMonitorQuit:
 			break out
 		}
@@ -296,6 +346,77 @@ out:
 	log.Trace("CPU miner speed monitor done")
 }
 
+// copyHashRates returns a copy of the numbers of hashes per second of the
+// proofs of work.
+func copyHashRates(rates map[pow.PowType]float64) map[pow.PowType]float64 {
+	c := make(map[pow.PowType]float64, len(rates))
+	for powType, rate := range rates {
+		c[powType] = rate
+	}
+	return c
+}
+
+// solveTemplate sets the difficulty of the proof of work in the header of the
+// block template and attempts to solve it with the solver of the proof of
+// work.  It returns an error if the CPU miner is not able to solve the proof of
+// work.
+func (m *CPUMiner) solveTemplate(template *types.BlockTemplate, powType pow.PowType, ticker *time.Ticker, quit chan struct{}) (bool, error) {
+	header := &template.Block.Header
+	switch powType {
+	case pow.BLAKE2BD:
+		header.Difficulty = uint32(template.PowDiffData.Blake2bDTarget)
+		return m.solveBlock(template.Block, ticker, quit), nil
+	case pow.X16RV3:
+		header.Difficulty = uint32(template.PowDiffData.X16rv3DTarget)
+		return m.solveX16rv3Block(template.Block, ticker, quit), nil
+	case pow.X8R16:
+		header.Difficulty = uint32(template.PowDiffData.X8r16DTarget)
+		return m.solveX8r16Block(template.Block, ticker, quit), nil
+	case pow.BITCOINPAYKECCAK256:
+		header.Difficulty = uint32(template.PowDiffData.BitcoinpayKeccak256Target)
+		return m.solveBitcoinpayKeccak256Block(template.Block, ticker, quit), nil
+	case pow.PROGPOW:
+		header.Difficulty = uint32(template.PowDiffData.ProgpowTarget)
+		return m.solveProgpowBlock(template.Block, ticker, quit, template.Height), nil
+	case pow.CRYPTONIGHT:
+		header.Difficulty = uint32(template.PowDiffData.CryptonightTarget)
+		return m.solveCryptonightBlock(template.Block, ticker, quit), nil
+	case pow.CUCKAROO:
+		header.Difficulty = pow.BigToCompact(new(big.Int).SetUint64(template.PowDiffData.CuckarooBaseDiff))
+		return m.solveCuckarooBlock(template.Block, ticker, quit, template.PowDiffData.CuckarooDiffScale, template.Height), nil
+	}
+	return false, fmt.Errorf("pow %v is not supported by the CPU miner",
+		pow.PowMapString[powType])
+}
+
+// miningAddrs returns the addresses the blocks pay to in turn.
+//
+// This function MUST be called with the pay address lock held.
+func (m *CPUMiner) miningAddrs() []types.Address {
+	if len(m.payAddrs) > 0 {
+		return m.payAddrs
+	}
+	return m.config.GetMinningAddrs()
+}
+
+// nextPayToAddr returns the address the next block pays to, rotating through
+// the mining addresses.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) nextPayToAddr() (types.Address, error) {
+	m.payAddrMtx.Lock()
+	defer m.payAddrMtx.Unlock()
+
+	addrs := m.miningAddrs()
+	if len(addrs) == 0 {
+		return nil, errors.New("no payment addresses specified via " +
+			"--miningaddr")
+	}
+	addr := addrs[m.nextPayAddr%len(addrs)]
+	m.nextPayAddr++
+	return addr, nil
+}
+
 // solveBlock attempts to find some combination of a nonce, extra nonce, and
 // current timestamp which makes the passed block hash to a value less than the
 // target difficulty.  The timestamp is updated periodically and the passed
@@ -352,7 +473,7 @@ func (m *CPUMiner) solveBlock(msgBlock *types.Block, ticker *time.Ticker, quit c
 			return false
 
 		case <-ticker.C:
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.BLAKE2BD, hashesCompleted}
 			hashesCompleted = 0
 
 			// The current block is stale if the memory pool
@@ -392,7 +513,7 @@ func (m *CPUMiner) solveBlock(msgBlock *types.Block, ticker *time.Ticker, quit c
 		if hashNum.Cmp(target) <= 0 {
 			// The block is solved when the new block hash is less
 			// than the target difficulty.  Yay!
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.BLAKE2BD, hashesCompleted}
 			return true
 		}
 	}
@@ -439,7 +560,7 @@ func (m *CPUMiner) solveCuckarooBlock(msgBlock *types.Block, ticker *time.Ticker
 		hashesCompleted += 2
 		targetDiff := pow.CompactToBig(header.Difficulty)
 		if pow.CalcCuckooDiff(powStruct.GraphWeight(), header.BlockHash()).Cmp(targetDiff) >= 0 {
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.CUCKAROO, hashesCompleted}
 			return true
 		}
 	}
@@ -509,7 +630,10 @@ func (m *CPUMiner) Start() {
 	if m.started || m.discreteMining {
 		return
 	}
-	if len(m.config.GetMinningAddrs()) == 0 {
+	m.payAddrMtx.Lock()
+	numAddrs := len(m.miningAddrs())
+	m.payAddrMtx.Unlock()
+	if numAddrs == 0 {
 		log.Error("Please configure minning address")
 		return
 	}
@@ -530,20 +654,24 @@ func (m *CPUMiner) Start() {
 // It must be run as a goroutine.
 func (m *CPUMiner) miningWorkerController() {
 	// launchWorkers groups common code to launch a specified number of
-	// workers for generating blocks.
+	// workers for generating blocks.  The proofs of work are assigned to
+	// the workers in turn.
 	var runningWorkers []chan struct{}
+	var runningPows []pow.PowType
 	launchWorkers := func(numWorkers uint32) {
 		for i := uint32(0); i < numWorkers; i++ {
 			quit := make(chan struct{})
+			powType := runningPows[len(runningWorkers)%len(runningPows)]
 			runningWorkers = append(runningWorkers, quit)
 
 			m.workerWg.Add(1)
-			go m.generateBlocks(quit)
+			go m.generateBlocks(quit, powType)
 		}
 	}
 
 	// Launch the current number of workers by default.
 	runningWorkers = make([]chan struct{}, 0, m.numWorkers)
+	runningPows = m.powTypes
 	launchWorkers(m.numWorkers)
 
 out:
@@ -551,6 +679,17 @@ out:
 		select {
 		// Update the number of running workers.
 		case <-m.updateNumWorkers:
+			// Switch all the workers to the new proofs of work.
+			if !samePowTypes(runningPows, m.powTypes) {
+				for _, quit := range runningWorkers {
+					close(quit)
+				}
+				runningWorkers = runningWorkers[:0]
+				runningPows = m.powTypes
+				launchWorkers(m.numWorkers)
+				continue
+			}
+
 			// No change.
 			numRunning := uint32(len(runningWorkers))
 			if m.numWorkers == numRunning {
@@ -585,6 +724,20 @@ out:
 	m.wg.Done()
 }
 
+// samePowTypes returns whether the proofs of work are the same, in the same
+// order.
+func samePowTypes(a, b []pow.PowType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Stop gracefully stops the mining process by signalling all workers, and the
 // speed monitor to quit.  Calling this function when the CPU miner has not
 // already been started will have no effect.
@@ -633,6 +786,24 @@ func (m *CPUMiner) HashesPerSecond() float64 {
 	return <-m.queryHashesPerSec
 }
 
+// PowHashesPerSecond returns the number of hashes per second the mining
+// process is performing for each proof of work, or the number of graphs for
+// the cuckoo proofs of work.  Nil is returned if the miner is not currently
+// running.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) PowHashesPerSecond() map[pow.PowType]float64 {
+	m.Lock()
+	defer m.Unlock()
+
+	// Nothing to do if the miner is not currently running.
+	if !m.started {
+		return nil
+	}
+
+	return <-m.queryPowHashesPerSec
+}
+
 // SetNumWorkers sets the number of workers to create which solve blocks.  Any
 // negative values will cause a default number of workers to be used which is
 // based on the number of processor cores in the system.  A value of 0 will
@@ -673,15 +844,76 @@ func (m *CPUMiner) NumWorkers() int32 {
 	return int32(m.numWorkers)
 }
 
+// SetPowTypes sets the proofs of work of the workers, which are assigned to
+// them in turn.  The running workers are switched to the new proofs of work.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) SetPowTypes(powTypes []pow.PowType) error {
+	if len(powTypes) == 0 {
+		return errors.New("no pow to mine")
+	}
+	for _, powType := range powTypes {
+		if !IsCPUPowType(powType) {
+			return fmt.Errorf("pow %d is not supported by the CPU miner",
+				powType)
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.powTypes = append([]pow.PowType(nil), powTypes...)
+
+	// When the miner is already running, notify the controller about the
+	// the change.
+	if m.started && !m.discreteMining {
+		m.updateNumWorkers <- struct{}{}
+	}
+	return nil
+}
+
+// PowTypes returns the proofs of work of the workers, in the order they are
+// assigned to them.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) PowTypes() []pow.PowType {
+	m.Lock()
+	defer m.Unlock()
+
+	return append([]pow.PowType(nil), m.powTypes...)
+}
+
+// SetPayAddrs sets the addresses the blocks pay to in turn.  The mining
+// addresses of the configuration are used when there are none.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) SetPayAddrs(addrs []types.Address) {
+	m.payAddrMtx.Lock()
+	defer m.payAddrMtx.Unlock()
+
+	m.payAddrs = addrs
+	m.nextPayAddr = 0
+}
+
+// PayAddrs returns the addresses the blocks pay to in turn.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) PayAddrs() []types.Address {
+	m.payAddrMtx.Lock()
+	defer m.payAddrMtx.Unlock()
+
+	return append([]types.Address(nil), m.miningAddrs()...)
+}
+
 // generateBlocks is a worker that is controlled by the miningWorkerController.
 // It is self contained in that it creates block templates and attempts to solve
 // them while detecting when it is performing stale work and reacting
 // accordingly by generating a new block template.  When a block is solved, it
-// is submitted.
+// is submitted.  The worker mines the blocks of the proof of work.
 //
 // It must be run as a goroutine.
-func (m *CPUMiner) generateBlocks(quit chan struct{}) {
-	log.Trace("Starting generate blocks worker")
+func (m *CPUMiner) generateBlocks(quit chan struct{}, powType pow.PowType) {
+	log.Trace("Starting generate blocks worker", "pow", pow.PowMapString[powType])
 
 	// Start a ticker which is used to signal checks for stale work and
 	// updates to the speed monitor.
@@ -706,58 +938,83 @@ out:
 		m.submitBlockLock.Lock()
 		time.Sleep(100 * time.Millisecond)
 
-		// Choose a payment address at random.
-		rand.Seed(time.Now().UnixNano())
-		miningaddrs := m.config.GetMinningAddrs()
-		fmt.Printf("why %v, %d \n", miningaddrs, len(miningaddrs))
-		rindex := rand.Intn(len(miningaddrs))
-		payToAddr := miningaddrs[rindex]
-
 		currentOrder := m.blockManager.GetChain().BestSnapshot().GraphState.GetTotal() - 1
 		if currentOrder != 0 && !m.blockManager.IsCurrent() {
+			m.submitBlockLock.Unlock()
 			log.Warn("Client in initial download, Bitcoinpay is downloading blocks...")
-			return
+			if !m.waitRetry(quit) {
+				break out
+			}
+			continue
+		}
+
+		// Choose the next payment address.
+		payToAddr, err := m.nextPayToAddr()
+		if err != nil {
+			m.submitBlockLock.Unlock()
+			log.Error("Failed to create new block ", "err", err)
+			if !m.waitRetry(quit) {
+				break out
+			}
+			continue
 		}
+
 		// Create a new block template using the available transactions
 		// in the memory pool as a source of transactions to potentially
 		// include in the block.
-		template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache, m.txSource, m.timeSource, m.blockManager, payToAddr, nil, pow.BITCOINPAYKECCAK256)
-		m.submitBlockLock.Unlock()
+		template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache, m.txSource, m.timeSource, m.blockManager, payToAddr, nil, powType)
 		if err != nil {
+			m.submitBlockLock.Unlock()
 			errStr := fmt.Sprintf("template: %v", err)
-			log.Error("Failed to create new block ", "err", errStr)
-			continue //TODO do we still continue?
+			log.Error("Failed to create new block ", "err", errStr,
+				"pow", pow.PowMapString[powType])
+			if !m.waitRetry(quit) {
+				break out
+			}
+			continue
 		}
 
 		// Not enough voters.
 		if template == nil {
+			m.submitBlockLock.Unlock()
 			continue
 		}
 
 		// This prevents you from causing memory exhaustion issues
-		// when mining aggressively in a simulation network.
+		// when mining aggressively in a simulation network.  The map is
+		// shared by the workers, so it is accessed with the submit
+		// lock held.
 		if m.config.PrivNet {
 			if m.minedOnParents[template.Block.Header.ParentRoot] >=
 				maxSimnetToMine {
+				m.submitBlockLock.Unlock()
 				log.Trace("too many blocks mined on parent, stopping " +
 					"until there are enough votes on these to make a new " +
 					"block")
 				continue
 			}
 		}
+		m.submitBlockLock.Unlock()
 
 		// Attempt to solve the block.  The function will exit early
 		// with false when conditions that trigger a stale block, so
 		// a new block template can be generated.  When the return is
 		// true a solution was found, so submit the solved block.
-		if m.solveBlock(template.Block, ticker, quit) {
+		solved, err := m.solveTemplate(template, powType, ticker, quit)
+		if err != nil {
+			log.Error("Failed to solve new block ", "err", err)
+			break out
+		}
+		if solved {
 			block := types.NewBlock(template.Block)
 			block.SetHeight(uint(template.Height))
 			if !m.submitBlock(block) {
 				log.Error("Failed to submit new block ", "err")
 				continue
 			}
+			m.submitBlockLock.Lock()
 			m.minedOnParents[template.Block.Header.ParentRoot]++
+			m.submitBlockLock.Unlock()
 		}
 	}
 
@@ -765,6 +1022,17 @@ out:
 	log.Trace("Generate blocks worker done")
 }
 
+// waitRetry waits before a worker tries again to create a block template, and
+// returns false if the worker is stopped meanwhile.
+func (m *CPUMiner) waitRetry(quit chan struct{}) bool {
+	select {
+	case <-quit:
+		return false
+	case <-time.After(time.Second):
+		return true
+	}
+}
+
 func (m *CPUMiner) updateExtraNonce(msgBlock *types.Block, extraNonce uint64) error {
 	// TODO, decided if need extra nonce for coinbase-tx
 	// do nothing for now
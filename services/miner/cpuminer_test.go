@@ -0,0 +1,57 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/core/address"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+)
+
+func TestSetPowTypes(t *testing.T) {
+	m := &CPUMiner{}
+	if err := m.SetPowTypes(nil); err == nil {
+		t.Fatal("set no pow")
+	}
+	if err := m.SetPowTypes([]pow.PowType{pow.BLAKE2BD, pow.CUCKATOO}); err == nil {
+		t.Fatal("set a pow the CPU miner does not support")
+	}
+
+	powTypes := []pow.PowType{pow.BLAKE2BD, pow.BITCOINPAYKECCAK256}
+	if err := m.SetPowTypes(powTypes); err != nil {
+		t.Fatal(err)
+	}
+	powTypes[0] = pow.X16RV3
+	if got := m.PowTypes(); !samePowTypes(got, []pow.PowType{pow.BLAKE2BD,
+		pow.BITCOINPAYKECCAK256}) {
+		t.Fatalf("pow types %v", got)
+	}
+}
+
+func TestNextPayToAddr(t *testing.T) {
+	var addrs []types.Address
+	for _, s := range []string{"RmFa5hnPd3uQRpzr3xWTfr8EFZdX7dS1qzV",
+		"RmQNkCr8ehRUzJhmNmgQVByv7VjakuCjc3d"} {
+		addr, err := address.DecodeAddress(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	m := &CPUMiner{}
+	m.SetPayAddrs(addrs)
+	for i := 0; i < 5; i++ {
+		addr, err := m.nextPayToAddr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := addrs[i%len(addrs)]; addr.String() != want.String() {
+			t.Fatalf("block %d pays to %v, want %v", i, addr, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package miner
+
+import (
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/services/mining"
+	"time"
+)
+
+func (m *CPUMiner) solveCryptonightBlock(msgBlock *types.Block, ticker *time.Ticker, quit chan struct{}) bool {
+	// Create a couple of convenience variables.
+	header := &msgBlock.Header
+
+	// Initial state.
+	lastGenerated := time.Now()
+	lastTxUpdate := m.txSource.LastUpdated()
+	hashesCompleted := uint64(0)
+	target := pow.CompactToBig(uint32(header.Difficulty))
+
+	// Search through the entire nonce range for a solution while
+	// periodically checking for early quit and stale block
+	// conditions along with updates to the speed monitor.
+	for i := uint32(0); i <= maxNonce; i++ {
+		select {
+		case <-quit:
+			return false
+
+		case <-ticker.C:
+			m.updateHashes <- hashUpdate{pow.CRYPTONIGHT, hashesCompleted}
+			hashesCompleted = 0
+
+			// The current block is stale if the memory pool
+			// has been updated since the block template was
+			// generated and it has been at least 3 seconds,
+			// or if it's been one minute.
+			if (lastTxUpdate != m.txSource.LastUpdated() &&
+				time.Now().After(lastGenerated.Add(3*time.Second))) ||
+				time.Now().After(lastGenerated.Add(60*time.Second)) {
+
+				return false
+			}
+
+			err := mining.UpdateBlockTime(msgBlock, m.blockManager.GetChain(), m.timeSource, m.params)
+			if err != nil {
+				log.Warn("CPU miner unable to update block template "+
+					"time: %v", err)
+				return false
+			}
+
+		default:
+			// Non-blocking select to fall through
+		}
+		instance := pow.GetInstance(pow.CRYPTONIGHT, 0, []byte{})
+		powStruct := instance.(*pow.Cryptonight)
+		// Update the nonce and hash the block header.
+		powStruct.Nonce = i
+
+		header.Pow = powStruct
+
+		hashesCompleted++
+		h := hash.HashCryptonight(header.BlockData())
+		hashNum := pow.HashToBig(&h)
+
+		if hashNum.Cmp(target) <= 0 {
+			// The block is solved when the new block hash is less
+			// than the target difficulty.  Yay!
+			m.updateHashes <- hashUpdate{pow.CRYPTONIGHT, hashesCompleted}
+			return true
+		}
+	}
+	return false
+}
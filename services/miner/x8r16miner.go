@@ -55,7 +55,7 @@ func (m *CPUMiner) solveX8r16Block(msgBlock *types.Block, ticker *time.Ticker, q
 			return false
 
 		case <-ticker.C:
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.X8R16, hashesCompleted}
 			hashesCompleted = 0
 
 			// The current block is stale if the memory pool
@@ -95,7 +95,7 @@ func (m *CPUMiner) solveX8r16Block(msgBlock *types.Block, ticker *time.Ticker, q
 		if hashNum.Cmp(target) <= 0 {
 			// The block is solved when the new block hash is less
 			// than the target difficulty.  Yay!
-			m.updateHashes <- hashesCompleted
+			m.updateHashes <- hashUpdate{pow.X8R16, hashesCompleted}
 			return true
 		}
 	}
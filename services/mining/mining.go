@@ -180,6 +180,10 @@ func createCoinbaseTx(subsidyCache *blockchain.SubsidyCache, coinbaseScript []by
 	return types.NewTx(tx), nil
 }
 
+// BlockVersion returns the block version of the network.  The blocks being
+// generated carry it in the lower 2 bytes of their version, the upper bytes
+// signal the consensus deployments being voted on, see
+// blockchain.CalcNextBlockVersion.
 func BlockVersion(net protocol.Network) uint32 {
 	blockVersion := uint32(GeneratedBlockVersion)
 	if net != protocol.MainNet {
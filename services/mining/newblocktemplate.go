@@ -14,6 +14,7 @@ import (
 	"github.com/btceasypay/bitcoinpay/params"
 	"github.com/btceasypay/bitcoinpay/services/blkmgr"
 	"github.com/btceasypay/bitcoinpay/services/mempool"
+	"sort"
 )
 
 // NewBlockTemplate returns a new block template that is ready to be solved
@@ -33,21 +34,16 @@ import (
 // higher fee per kilobyte are preferred.  Finally, the block generation related
 // policy settings are all taken into account.
 //
-// Transactions which only spend outputs from other transactions already in the
-// block chain are immediately added to a priority queue which either
-// prioritizes based on the priority (then fee per kilobyte) or the fee per
-// kilobyte (then priority) depending on whether or not the BlockPrioritySize
-// policy setting allots space for high-priority transactions.  Transactions
-// which spend outputs from other transactions in the source pool are added to a
-// dependency map so they can be added to the priority queue once the
-// transactions they depend on have been included.
+// Every transaction is added along with its ancestor package, which is the
+// transaction along with the transactions of the source pool it depends on
+// that are not in the block yet, with the ancestors ahead of their
+// descendants.  The packages are drawn at random weighted by their fees, so
+// the blocks mined in parallel in the DAG don't all carry the same
+// transactions while the higher fee packages still go first, and a high fee
+// child pays for a low fee parent.  The fees of a package are recomputed once
+// some of its ancestors made it into the block.
 //
-// Once the high-priority area (if configured) has been filled with
-// transactions, or the priority falls below what is considered high-priority,
-// the priority queue is updated to prioritize by fees per kilobyte (then
-// priority).
-//
-// When the fees per kilobyte drop below the TxMinFreeFee policy setting, the
+// When the package fees per kilobyte drop below the TxMinFreeFee policy setting, the
 // transaction will be skipped unless the BlockMinSize policy setting is
 // nonzero, in which case the block will be filled with the low-fee/free
 // transactions until the block size reaches that minimum size.
@@ -148,7 +144,6 @@ func NewBlockTemplate(policy *Policy, params *params.Params,
 	// or not there is an area allocated for high-priority transactions.
 	sourceTxns := txSource.MiningDescs()
 	sortedByFee := policy.BlockPrioritySize == 0
-	weightedRandQueue := newWeightedRandQueue(len(sourceTxns))
 	// Create a slice to hold the transactions to be included in the
 	// generated block with reserved space.  Also create a utxo view to
 	// house all of the input transactions so multiple lookups can be
@@ -160,9 +155,10 @@ func NewBlockTemplate(policy *Policy, params *params.Params,
 	// dependers is used to track transactions which depend on another
 	// transaction in the source pool.  This, in conjunction with the
 	// dependsOn map kept with each dependent transaction helps quickly
-	// determine which dependent transactions are now eligible for inclusion
-	// in the block once each transaction has been included.
-	dependers := make(map[hash.Hash]map[hash.Hash]*WeightedRandTx)
+	// determine the ancestor package of each transaction, which must be
+	// included in the block before it.
+	dependers := make(map[hash.Hash]map[hash.Hash]*txPrioItem)
+	prioItems := make(map[hash.Hash]*txPrioItem, len(sourceTxns))
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
 	// coinbase.  This allows the code below to simply append details about
@@ -206,7 +202,7 @@ mempoolLoop:
 		// Setup dependencies for any transactions which reference
 		// other transactions in the mempool so they can be properly
 		// ordered below.
-		weirandItem := &WeightedRandTx{tx: tx}
+		prioItem := &txPrioItem{tx: tx}
 		for _, txIn := range tx.Tx.TxIn {
 			originHash := &txIn.PreviousOut.Hash
 			entry := utxos.LookupEntry(txIn.PreviousOut)
@@ -224,15 +220,15 @@ mempoolLoop:
 				// ordering dependency.
 				deps, exists := dependers[*originHash]
 				if !exists {
-					deps = make(map[hash.Hash]*WeightedRandTx)
+					deps = make(map[hash.Hash]*txPrioItem)
 					dependers[*originHash] = deps
 				}
-				deps[*weirandItem.tx.Hash()] = weirandItem
-				if weirandItem.dependsOn == nil {
-					weirandItem.dependsOn = make(
+				deps[*prioItem.tx.Hash()] = prioItem
+				if prioItem.dependsOn == nil {
+					prioItem.dependsOn = make(
 						map[hash.Hash]struct{})
 				}
-				weirandItem.dependsOn[*originHash] = struct{}{}
+				prioItem.dependsOn[*originHash] = struct{}{}
 
 				// Skip the check below. We already know the
 				// referenced transaction is available.
@@ -243,18 +239,14 @@ mempoolLoop:
 		// Calculate the final transaction priority using the input
 		// value age sum as well as the adjusted transaction size.  The
 		// formula is: sum(inputValue * inputAge) / adjustedTxSize
-		weirandItem.priority = mempool.CalcPriority(tx.Tx, utxos,
+		prioItem.priority = mempool.CalcPriority(tx.Tx, utxos,
 			nextBlockHeight, blockManager.GetChain().BlockDAG())
 
 		// Calculate the fee in Satoshi/kB.
-		weirandItem.feePerKB = txDesc.FeePerKB
-		weirandItem.fee = txDesc.Fee
-
-		// Add the transaction to the priority queue to mark it ready
-		// for inclusion in the block unless it has dependencies.
-		if weirandItem.dependsOn == nil {
-			weightedRandQueue.Push(weirandItem)
-		}
+		prioItem.feePerKB = txDesc.FeePerKB
+		prioItem.fee = txDesc.Fee
+		prioItem.size = int64(tx.Transaction().SerializeSize())
+		prioItems[*tx.Hash()] = prioItem
 
 		// Merge the referenced outputs from the input transactions to
 		// this transaction into the block utxo view.  This allows the
@@ -262,6 +254,21 @@ mempoolLoop:
 		mergeUtxoView(blockUtxos, utxos)
 	}
 
+	// Weight every transaction by the fees of its ancestor package, so a
+	// low fee parent is mined along with the high fee child spending it
+	// (child-pays-for-parent).  Transactions depending on a transaction
+	// which was skipped above can't be mined and are not queued.
+	ancestorsCache := make(map[hash.Hash]map[hash.Hash]*txPrioItem)
+	weightedRandQueue := newWeightedRandQueue(len(prioItems))
+	for txHash, prioItem := range prioItems {
+		ancestors := txAncestors(prioItem, prioItems, ancestorsCache)
+		if ancestors == nil {
+			delete(prioItems, txHash)
+			continue
+		}
+		weightedRandQueue.Push(newPackageRandTx(prioItem, ancestors))
+	}
+
 	log.Trace(fmt.Sprintf("Weighted random queue len %d, dependers len %d",
 		weightedRandQueue.Len(), len(dependers)))
 
@@ -269,24 +276,72 @@ mempoolLoop:
 
 	blockSigOpCost := coinbaseSigOpCost
 	totalFees := int64(0)
+	included := make(map[hash.Hash]struct{})
+	failed := make(map[hash.Hash]struct{})
 
 	// Choose which transactions make it into the block.
 	for weightedRandQueue.Len() > 0 {
-		// Grab the highest priority (or highest fee per kilobyte
-		// depending on the sort order) transaction.
-		weirandItem := weightedRandQueue.Pop()
-		tx := weirandItem.tx
+		// Draw a transaction, weighted by the fees of its ancestor
+		// package.
+		tx := weightedRandQueue.Pop().tx
+		prioItem := prioItems[*tx.Hash()]
+		if _, ok := included[*tx.Hash()]; ok {
+			continue
+		}
 
 		// Grab any transactions which depend on this one.
 		deps := dependers[*tx.Hash()]
 
+		// The package is the transaction along with its ancestors which
+		// are not in the block yet.  The ancestors already in the block
+		// no longer count in its fees, so requeue it when the fees
+		// changed since it was queued.
+		var pkg []*txPrioItem
+		dependsOnFailed := false
+		for ancestorHash, ancestor := range ancestorsCache[*tx.Hash()] {
+			if _, ok := failed[ancestorHash]; ok {
+				dependsOnFailed = true
+				break
+			}
+			if _, ok := included[ancestorHash]; !ok {
+				pkg = append(pkg, ancestor)
+			}
+		}
+		if dependsOnFailed {
+			log.Trace(fmt.Sprintf("Skipping tx %s since one of its "+
+				"ancestors was skipped", tx.Hash()))
+			failed[*tx.Hash()] = struct{}{}
+			continue
+		}
+		pkgAncestors := make(map[hash.Hash]*txPrioItem, len(pkg))
+		for _, ancestor := range pkg {
+			pkgAncestors[*ancestor.tx.Hash()] = ancestor
+		}
+		if feePerKB := packageFeePerKB(prioItem, pkgAncestors); feePerKB != prioItem.ancestorFeePerKB {
+			weightedRandQueue.Push(newPackageRandTx(prioItem, pkgAncestors))
+			continue
+		}
+
+		// The ancestors must come first in the block, a transaction has
+		// fewer ancestors than any of its descendants.
+		pkg = append(pkg, prioItem)
+		sort.Slice(pkg, func(i, j int) bool {
+			return len(ancestorsCache[*pkg[i].tx.Hash()]) <
+				len(ancestorsCache[*pkg[j].tx.Hash()])
+		})
+
 		// Enforce maximum block size.  Also check for overflow.
-		txSize := uint32(tx.Transaction().SerializeSize())
-		blockPlusTxSize := blockSize + txSize
+		pkgSize := uint32(0)
+		pkgSigOpCost := int64(0)
+		for _, item := range pkg {
+			pkgSize += uint32(item.size)
+			pkgSigOpCost += int64(blockchain.CountSigOps(item.tx))
+		}
+		blockPlusTxSize := blockSize + pkgSize
 		if blockPlusTxSize < blockSize || blockPlusTxSize >= policy.BlockMaxSize {
-			log.Trace(fmt.Sprintf("Skipping tx %s (size %v) because it "+
-				"would exceed the max block size; cur block "+
-				"size %v, cur num tx %v", tx.Hash(), txSize,
+			log.Trace(fmt.Sprintf("Skipping tx %s (package size %v) "+
+				"because it would exceed the max block size; cur "+
+				"block size %v, cur num tx %v", tx.Hash(), pkgSize,
 				blockSize, len(blockTxns)))
 			logSkippedDeps(tx, deps)
 			continue
@@ -294,9 +349,8 @@ mempoolLoop:
 
 		// Enforce maximum signature operation cost per block.  Also
 		// check for overflow.
-		sigOpCost := blockchain.CountSigOps(tx)
-		if blockSigOpCost+int64(sigOpCost) < blockSigOpCost ||
-			blockSigOpCost+int64(sigOpCost) > blockchain.MaxSigOpsPerBlock {
+		if blockSigOpCost+pkgSigOpCost < blockSigOpCost ||
+			blockSigOpCost+pkgSigOpCost > blockchain.MaxSigOpsPerBlock {
 			log.Trace(fmt.Sprintf("Skipping tx %s because it would "+
 				"exceed the maximum sigops per block", tx.Hash()))
 			logSkippedDeps(tx, deps)
@@ -306,68 +360,66 @@ mempoolLoop:
 		// Skip free transactions once the block is larger than the
 		// minimum block size.
 		if sortedByFee &&
-			weirandItem.feePerKB < int64(policy.TxMinFreeFee) &&
+			prioItem.ancestorFeePerKB < int64(policy.TxMinFreeFee) &&
 			(blockPlusTxSize >= policy.BlockMinSize) {
-			log.Trace(fmt.Sprintf("Skipping tx %s with feePerKB %.2d "+
-				"< TxMinFreeFee %d and block size %d >= "+
-				"minBlockSize %d", tx.Hash(), weirandItem.feePerKB,
-				policy.TxMinFreeFee, blockPlusTxSize,
-				policy.BlockMinSize))
+			log.Trace(fmt.Sprintf("Skipping tx %s with package "+
+				"feePerKB %.2d < TxMinFreeFee %d and block size %d "+
+				">= minBlockSize %d", tx.Hash(),
+				prioItem.ancestorFeePerKB, policy.TxMinFreeFee,
+				blockPlusTxSize, policy.BlockMinSize))
 			logSkippedDeps(tx, deps)
 			continue
 		}
 
-		// Ensure the transaction inputs pass all of the necessary
-		// preconditions before allowing it to be added to the block.
-		_, err = blockManager.GetChain().CheckTransactionInputs(tx, blockUtxos)
-		if err != nil {
-			log.Trace(fmt.Sprintf("Skipping tx %s due to error in "+
-				"CheckTransactionInputs: %v", tx.Hash(), err))
-			logSkippedDeps(tx, deps)
-			continue
-		}
-		err = blockchain.ValidateTransactionScripts(tx, blockUtxos,
-			scriptFlags, sigCache)
-		if err != nil {
-			log.Trace(fmt.Sprintf("Skipping tx %s due to error in "+
-				"ValidateTransactionScripts: %v", tx.Hash(), err))
-			logSkippedDeps(tx, deps)
-			continue
-		}
+		for _, item := range pkg {
+			// Ensure the transaction inputs pass all of the necessary
+			// preconditions before allowing it to be added to the
+			// block.  The rest of the package depends on it so it is
+			// skipped along with it.
+			pkgTx := item.tx
+			_, err = blockManager.GetChain().CheckTransactionInputs(pkgTx, blockUtxos)
+			if err != nil {
+				log.Trace(fmt.Sprintf("Skipping tx %s due to error in "+
+					"CheckTransactionInputs: %v", pkgTx.Hash(), err))
+				logSkippedDeps(pkgTx, dependers[*pkgTx.Hash()])
+				failed[*pkgTx.Hash()] = struct{}{}
+				break
+			}
+			err = blockchain.ValidateTransactionScripts(pkgTx, blockUtxos,
+				scriptFlags, sigCache)
+			if err != nil {
+				log.Trace(fmt.Sprintf("Skipping tx %s due to error in "+
+					"ValidateTransactionScripts: %v", pkgTx.Hash(), err))
+				logSkippedDeps(pkgTx, dependers[*pkgTx.Hash()])
+				failed[*pkgTx.Hash()] = struct{}{}
+				break
+			}
 
-		// Spend the transaction inputs in the block utxo view and add
-		// an entry for it to ensure any transactions which reference
-		// this one have it available as an input and can ensure they
-		// aren't double spending.
-		err = spendTransaction(blockUtxos, tx, &hash.ZeroHash)
-		if err != nil {
-			log.Warn(fmt.Sprintf("Unable to spend transaction %v in the preliminary "+
-				"UTXO view for the block template: %v",
-				tx.Hash(), err))
-		}
-		// Add the transaction to the block, increment counters, and
-		// save the fees and signature operation counts to the block
-		// template.
-		blockTxns = append(blockTxns, tx)
-		blockSize += txSize
-		blockSigOpCost += int64(sigOpCost)
-		totalFees += weirandItem.fee
-		txFees = append(txFees, weirandItem.fee)
-		txSigOpCosts = append(txSigOpCosts, int64(sigOpCost))
-
-		log.Trace(fmt.Sprintf("Adding tx %s (priority %.2f, feePerKB %.2d)",
-			weirandItem.tx.Hash(), weirandItem.priority, weirandItem.feePerKB))
-
-		// Add transactions which depend on this one (and also do not
-		// have any other unsatisified dependencies) to the priority
-		// queue.
-		for _, item := range deps {
-			// Add the transaction to the priority queue if there
-			// are no more dependencies after this one.
-			delete(item.dependsOn, *tx.Hash())
-			if len(item.dependsOn) == 0 {
-				weightedRandQueue.Push(item)
+			// Spend the transaction inputs in the block utxo view and
+			// add an entry for it to ensure any transactions which
+			// reference this one have it available as an input and
+			// can ensure they aren't double spending.
+			err = spendTransaction(blockUtxos, pkgTx, &hash.ZeroHash)
+			if err != nil {
+				log.Warn(fmt.Sprintf("Unable to spend transaction %v in the preliminary "+
+					"UTXO view for the block template: %v",
+					pkgTx.Hash(), err))
 			}
+			// Add the transaction to the block, increment counters,
+			// and save the fees and signature operation counts to the
+			// block template.
+			sigOpCost := blockchain.CountSigOps(pkgTx)
+			blockTxns = append(blockTxns, pkgTx)
+			blockSize += uint32(item.size)
+			blockSigOpCost += int64(sigOpCost)
+			totalFees += item.fee
+			txFees = append(txFees, item.fee)
+			txSigOpCosts = append(txSigOpCosts, int64(sigOpCost))
+			included[*pkgTx.Hash()] = struct{}{}
+
+			log.Trace(fmt.Sprintf("Adding tx %s (priority %.2f, feePerKB %.2d, "+
+				"package feePerKB %.2d)", pkgTx.Hash(), item.priority,
+				item.feePerKB, prioItem.ancestorFeePerKB))
 		}
 	}
 
@@ -405,6 +457,14 @@ mempoolLoop:
 	if err != nil {
 		return nil, miningRuleError(ErrGettingDifficulty, err.Error())
 	}
+	reqProgpowDifficulty, err := blockManager.GetChain().CalcNextRequiredDifficulty(ts, pow.PROGPOW)
+	if err != nil {
+		return nil, miningRuleError(ErrGettingDifficulty, err.Error())
+	}
+	reqCryptonightDifficulty, err := blockManager.GetChain().CalcNextRequiredDifficulty(ts, pow.CRYPTONIGHT)
+	if err != nil {
+		return nil, miningRuleError(ErrGettingDifficulty, err.Error())
+	}
 	reqCuckarooDifficulty, err := blockManager.GetChain().CalcNextRequiredDifficulty(ts, pow.CUCKAROO)
 	if err != nil {
 		return nil, miningRuleError(ErrGettingDifficulty, err.Error())
@@ -419,13 +479,21 @@ mempoolLoop:
 		return nil, miningRuleError(ErrGettingDifficulty, err.Error())
 	}
 
-	// Choose the block version to generate based on the network.
-	blockVersion := BlockVersion(params.Net)
+	// Choose the block version to generate based on the network, along
+	// with the bits of the deployments being voted on.
+	blockVersion := blockManager.GetChain().CalcNextBlockVersion()
 
 	// Create a new block ready to be solved.
 	merkles := merkle.BuildMerkleTreeStore(blockTxns, false)
 
 	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+
+	// The state root commits the utxo set once the transactions spent
+	// their inputs.
+	stateRoot, err := blockManager.GetChain().CalcStateRoot(parents, blockTxns)
+	if err != nil {
+		return nil, miningRuleError(ErrFetchTxStore, err.Error())
+	}
 	var block types.Block
 	var reqDiff uint32
 	switch powType {
@@ -443,12 +511,16 @@ mempoolLoop:
 		reqDiff = reqX8r16Difficulty
 	case pow.BITCOINPAYKECCAK256:
 		reqDiff = keccak256Difficulty
+	case pow.PROGPOW:
+		reqDiff = reqProgpowDifficulty
+	case pow.CRYPTONIGHT:
+		reqDiff = reqCryptonightDifficulty
 	}
 	block.Header = types.BlockHeader{
 		Version:    blockVersion,
 		ParentRoot: *paMerkles[len(paMerkles)-1],
 		TxRoot:     *merkles[len(merkles)-1],
-		StateRoot:  hash.Hash{}, //TODO, state root
+		StateRoot:  stateRoot,
 		Timestamp:  ts,
 		Difficulty: reqDiff,
 		Pow:        pow.GetInstance(powType, 0, []byte{}),
@@ -496,6 +568,8 @@ mempoolLoop:
 			X16rv3DTarget:          reqX16rv3Difficulty,
 			X8r16DTarget:           reqX8r16Difficulty,
 			BitcoinpayKeccak256Target: keccak256Difficulty,
+			ProgpowTarget:          reqProgpowDifficulty,
+			CryptonightTarget:      reqCryptonightDifficulty,
 			CuckarooBaseDiff:       pow.CompactToBig(reqCuckarooDifficulty).Uint64(),
 			CuckaroomBaseDiff:      pow.CompactToBig(reqCuckaroomDifficulty).Uint64(),
 			CuckatooBaseDiff:       pow.CompactToBig(reqCuckatooDifficulty).Uint64(),
@@ -551,7 +625,7 @@ func mergeUtxoView(viewA *blockchain.UtxoViewpoint, viewB *blockchain.UtxoViewpo
 // TODO, move the log logic
 // logSkippedDeps logs any dependencies which are also skipped as a result of
 // skipping a transaction while generating a block template at the trace level.
-func logSkippedDeps(tx *types.Tx, deps map[hash.Hash]*WeightedRandTx) {
+func logSkippedDeps(tx *types.Tx, deps map[hash.Hash]*txPrioItem) {
 	if deps == nil {
 		return
 	}
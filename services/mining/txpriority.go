@@ -12,9 +12,14 @@ import (
 type txPrioItem struct {
 	tx       *types.Tx
 	fee      int64
+	size     int64
 	priority float64
 	feePerKB int64
 
+	// ancestorFeePerKB is the fee per kilobyte of the transaction along
+	// with its ancestors which are not in the block yet.
+	ancestorFeePerKB int64
+
 	// dependsOn holds a map of transaction hashes which this one depends
 	// on.  It will only be set when the transaction references other
 	// transactions in the source pool and hence must come after them in
@@ -113,3 +118,56 @@ func txPQByPriority(pq *txPriorityQueue, i, j int) bool {
 	return pq.items[i].priority > pq.items[j].priority
 
 }
+
+// txAncestors returns the in-pool ancestors of the passed item, or nil when
+// one of them is not among the items and the transaction can't be mined.  The
+// ancestors of each item are memoized in the passed cache.
+func txAncestors(item *txPrioItem, items map[hash.Hash]*txPrioItem,
+	cache map[hash.Hash]map[hash.Hash]*txPrioItem) map[hash.Hash]*txPrioItem {
+
+	if ancestors, ok := cache[*item.tx.Hash()]; ok {
+		return ancestors
+	}
+	// Mark the item as visited so a broken dependency loop ends up
+	// without ancestors instead of recursing forever.
+	cache[*item.tx.Hash()] = nil
+
+	ancestors := make(map[hash.Hash]*txPrioItem)
+	for parentHash := range item.dependsOn {
+		parent, ok := items[parentHash]
+		if !ok {
+			return nil
+		}
+		moreAncestors := txAncestors(parent, items, cache)
+		if moreAncestors == nil {
+			return nil
+		}
+		ancestors[parentHash] = parent
+		for ancestorHash, ancestor := range moreAncestors {
+			ancestors[ancestorHash] = ancestor
+		}
+	}
+	cache[*item.tx.Hash()] = ancestors
+	return ancestors
+}
+
+// packageFees returns the fees and the size of the passed item along with the
+// passed ancestors.
+func packageFees(item *txPrioItem, ancestors map[hash.Hash]*txPrioItem) (int64, int64) {
+	fee, size := item.fee, item.size
+	for _, ancestor := range ancestors {
+		fee += ancestor.fee
+		size += ancestor.size
+	}
+	return fee, size
+}
+
+// packageFeePerKB returns the fee per kilobyte of the passed item along with
+// the passed ancestors.
+func packageFeePerKB(item *txPrioItem, ancestors map[hash.Hash]*txPrioItem) int64 {
+	fee, size := packageFees(item, ancestors)
+	if size == 0 {
+		return 0
+	}
+	return fee * 1000 / size
+}
@@ -0,0 +1,61 @@
+package mining
+
+import (
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/types"
+)
+
+// newTestPrioItem returns a priority item of a transaction spending the
+// outputs of the passed parents.
+func newTestPrioItem(lockTime uint32, fee int64, parents ...*txPrioItem) *txPrioItem {
+	tx := types.NewTransaction()
+	tx.LockTime = lockTime
+	item := &txPrioItem{fee: fee, size: 1000}
+	for _, parent := range parents {
+		if item.dependsOn == nil {
+			item.dependsOn = make(map[hash.Hash]struct{})
+		}
+		item.dependsOn[*parent.tx.Hash()] = struct{}{}
+	}
+	item.tx = types.NewTx(tx)
+	item.feePerKB = fee
+	return item
+}
+
+func TestAncestorFeeScoring(t *testing.T) {
+	parent := newTestPrioItem(1, 100)
+	child := newTestPrioItem(2, 10000, parent)
+	other := newTestPrioItem(3, 4000)
+	orphan := newTestPrioItem(4, 90000, newTestPrioItem(5, 0))
+
+	items := map[hash.Hash]*txPrioItem{
+		*parent.tx.Hash(): parent,
+		*child.tx.Hash():  child,
+		*other.tx.Hash():  other,
+		*orphan.tx.Hash(): orphan,
+	}
+	cache := make(map[hash.Hash]map[hash.Hash]*txPrioItem)
+	if ancestors := txAncestors(orphan, items, cache); ancestors != nil {
+		t.Fatalf("orphan has ancestors %v, want none", ancestors)
+	}
+	ancestors := txAncestors(child, items, cache)
+	if len(ancestors) != 1 || ancestors[*parent.tx.Hash()] != parent {
+		t.Fatalf("unexpected ancestors of child %v", ancestors)
+	}
+
+	wq := newWeightedRandQueue(3)
+	for _, item := range []*txPrioItem{parent, child, other} {
+		wq.Push(newPackageRandTx(item, txAncestors(item, items, cache)))
+	}
+	if child.ancestorFeePerKB != 5050 || other.ancestorFeePerKB != 4000 ||
+		parent.ancestorFeePerKB != 100 {
+		t.Fatalf("package fee rates %d, %d, %d, want 5050, 4000, 100",
+			child.ancestorFeePerKB, other.ancestorFeePerKB,
+			parent.ancestorFeePerKB)
+	}
+	if wq.totalFee != 10100+4000+100+3 {
+		t.Fatalf("queue weight %d, want %d", wq.totalFee, 10100+4000+100+3)
+	}
+}
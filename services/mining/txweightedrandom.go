@@ -7,14 +7,26 @@ import (
 	"time"
 )
 
-// weighted random tx
+// weighted random tx, the fee is the weight of the tx
 type WeightedRandTx struct {
 	tx       *types.Tx
 	fee      int64
 	priority float64
 	feePerKB int64
+}
 
-	dependsOn map[hash.Hash]struct{}
+// newPackageRandTx returns the weighted random tx of the item along with its
+// ancestors, weighted by the fees of the package.  The fee per kilobyte of the
+// package is saved in the item.
+func newPackageRandTx(item *txPrioItem, ancestors map[hash.Hash]*txPrioItem) *WeightedRandTx {
+	item.ancestorFeePerKB = packageFeePerKB(item, ancestors)
+	fee, _ := packageFees(item, ancestors)
+	return &WeightedRandTx{
+		tx:       item.tx,
+		fee:      fee,
+		priority: item.priority,
+		feePerKB: item.ancestorFeePerKB,
+	}
 }
 
 // The Queue for weighted rand tx
@@ -45,13 +57,13 @@ func (wq *WeightedRandQueue) Pop() *WeightedRandTx {
 	index := int(0)
 	var item *WeightedRandTx
 	for index, item = range wq.items {
-		total += item.fee
-		if total >= factor {
+		total += item.fee + 1
+		if total > factor {
 			break
 		}
 	}
 	wq.items = append(wq.items[:index], wq.items[index+1:]...)
-	total = total - item.fee - 1
+	wq.totalFee -= item.fee + 1
 
 	return item
 }
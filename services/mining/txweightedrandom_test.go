@@ -18,3 +18,26 @@ func Test_TXWeightedRandom(t *testing.T) {
 		fmt.Println(item.fee)
 	}
 }
+
+func TestWeightedRandQueueDrain(t *testing.T) {
+	const reserve = 10
+	itemQueue := newWeightedRandQueue(reserve)
+	items := make(map[*WeightedRandTx]struct{})
+	for i := 0; i < reserve; i++ {
+		item := &WeightedRandTx{fee: int64(i * 100)}
+		items[item] = struct{}{}
+		itemQueue.Push(item)
+	}
+
+	// Every item is drawn once and the weights are released.
+	for itemQueue.Len() > 0 {
+		item := itemQueue.Pop()
+		if _, ok := items[item]; !ok {
+			t.Fatalf("item with fee %d drawn twice", item.fee)
+		}
+		delete(items, item)
+	}
+	if len(items) != 0 || itemQueue.totalFee != 0 {
+		t.Fatalf("%d items left, total fee %d", len(items), itemQueue.totalFee)
+	}
+}
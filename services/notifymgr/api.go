@@ -0,0 +1,74 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package notifymgr
+
+import (
+	"context"
+	"github.com/btceasypay/bitcoinpay/rpc"
+)
+
+// PublicNotifyAPI provides the event subscriptions, they are only available
+// on connections supporting notifications such as websocket.
+type PublicNotifyAPI struct {
+	ntmgr *NotifyMgr
+}
+
+func NewPublicNotifyAPI(ntmgr *NotifyMgr) *PublicNotifyAPI {
+	return &PublicNotifyAPI{ntmgr}
+}
+
+// SubscribeNewBlocks pushes every block accepted into the DAG with its order,
+// blue and main chain status.
+func (api *PublicNotifyAPI) SubscribeNewBlocks(ctx context.Context) (*rpc.Subscription, error) {
+	return api.subscribe(ctx, subNewBlocks, nil)
+}
+
+// SubscribeBlockOrder pushes the blocks connected to or disconnected from the
+// DAG order.
+func (api *PublicNotifyAPI) SubscribeBlockOrder(ctx context.Context) (*rpc.Subscription, error) {
+	return api.subscribe(ctx, subBlockOrder, nil)
+}
+
+// SubscribeNewTxs pushes the transactions accepted into the mempool.
+func (api *PublicNotifyAPI) SubscribeNewTxs(ctx context.Context) (*rpc.Subscription, error) {
+	return api.subscribe(ctx, subNewTxs, nil)
+}
+
+// SubscribeAddressTxs pushes the transactions paying to or spending from any
+// of the addresses, both when they are accepted into the mempool and when they are connected.
+func (api *PublicNotifyAPI) SubscribeAddressTxs(ctx context.Context, addresses []string) (*rpc.Subscription, error) {
+	if len(addresses) == 0 {
+		return nil, rpc.RpcInvalidError("At least one address is required")
+	}
+	addrs := make(map[string]struct{}, len(addresses))
+	for _, addr := range addresses {
+		addrs[addr] = struct{}{}
+	}
+	return api.subscribe(ctx, subAddressTxs, addrs)
+}
+
+// subscribe creates the rpc subscription and forwards the events of the
+// stream until the client unsubscribes or the connection is closed.
+func (api *PublicNotifyAPI) subscribe(ctx context.Context, kind subscriptionKind, addrs map[string]struct{}) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+	sub := api.ntmgr.rpcSubs.add(kind, addrs)
+
+	go func() {
+		defer api.ntmgr.rpcSubs.remove(sub)
+		for {
+			select {
+			case event := <-sub.events:
+				notifier.Notify(rpcSub.ID, event)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
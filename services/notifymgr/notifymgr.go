@@ -1,10 +1,15 @@
 package notifymgr
 
 import (
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/json"
 	"github.com/btceasypay/bitcoinpay/core/message"
 	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/log"
 	"github.com/btceasypay/bitcoinpay/p2p/peerserver"
+	"github.com/btceasypay/bitcoinpay/params"
 	"github.com/btceasypay/bitcoinpay/rpc"
+	"github.com/btceasypay/bitcoinpay/services/mempool"
 )
 
 // NotifyMgr manage message announce & relay & notification between mempool, websocket, gbt long pull
@@ -12,6 +17,15 @@ import (
 type NotifyMgr struct {
 	Server    *peerserver.PeerServer
 	RpcServer *rpc.RpcServer
+	Params    *params.Params
+
+	// Chain and TxPool give the outputs spent by the transactions, so the
+	// address subscribers are notified of the spends from their addresses.
+	Chain  *blockchain.BlockChain
+	TxPool *mempool.TxPool
+
+	// subscribers of the rpc event streams
+	rpcSubs rpcSubscribers
 }
 
 // AnnounceNewTransactions generates and relays inventory vectors and notifies
@@ -28,7 +42,7 @@ func (ntmgr *NotifyMgr) AnnounceNewTransactions(newTxs []*types.TxDesc) {
 		// reply to p2p
 		ntmgr.RelayInventory(iv, tx)
 		// reply to rpc
-
+		ntmgr.notifyTxAccepted(tx)
 	}
 }
 
@@ -41,3 +55,121 @@ func (ntmgr *NotifyMgr) RelayInventory(invVect *message.InvVect, data interface{
 func (ntmgr *NotifyMgr) BroadcastMessage(msg message.Message) {
 	ntmgr.Server.BroadcastMessage(msg)
 }
+
+// NotifyBlockAccepted notifies the rpc subscribers of new blocks that the
+// block has been accepted into the DAG.
+func (ntmgr *NotifyMgr) NotifyBlockAccepted(block *types.SerializedBlock, status *json.BlockNotifyResult) {
+	ntmgr.rpcSubs.send(subNewBlocks, status)
+}
+
+// NotifyBlockConnected notifies the rpc subscribers of the DAG order and of
+// the watched addresses that the block has been connected.
+func (ntmgr *NotifyMgr) NotifyBlockConnected(block *types.SerializedBlock, status *json.BlockNotifyResult) {
+	ntmgr.rpcSubs.send(subBlockOrder, status)
+
+	if !ntmgr.rpcSubs.has(subAddressTxs) {
+		return
+	}
+	spent := ntmgr.blockSpentScripts(block)
+	for i, tx := range block.Transactions() {
+		ntmgr.rpcSubs.sendTx(tx, spent[i], ntmgr.Params, func(addrs []string) *json.TxNotifyResult {
+			return &json.TxNotifyResult{
+				Txid:       tx.Hash().String(),
+				Size:       tx.Tx.SerializeSize(),
+				Time:       block.Block().Header.Timestamp.Unix(),
+				Addresses:  addrs,
+				BlockHash:  status.Hash,
+				BlockOrder: status.Order,
+			}
+		})
+	}
+}
+
+// NotifyBlockDisconnected notifies the rpc subscribers of the DAG order that
+// the block has been disconnected.
+func (ntmgr *NotifyMgr) NotifyBlockDisconnected(block *types.SerializedBlock, status *json.BlockNotifyResult) {
+	ntmgr.rpcSubs.send(subBlockOrder, status)
+}
+
+// notifyTxAccepted notifies the rpc subscribers of new transactions and of the
+// watched addresses that the transaction has been accepted into the mempool.
+func (ntmgr *NotifyMgr) notifyTxAccepted(tx *types.TxDesc) {
+	newEvent := func(addrs []string) *json.TxNotifyResult {
+		return &json.TxNotifyResult{
+			Txid:      tx.Tx.Hash().String(),
+			Size:      tx.Tx.Tx.SerializeSize(),
+			Fee:       tx.Fee,
+			FeePerKB:  tx.FeePerKB,
+			Time:      tx.Added.Unix(),
+			Addresses: addrs,
+		}
+	}
+	if ntmgr.rpcSubs.has(subNewTxs) {
+		ntmgr.rpcSubs.send(subNewTxs, newEvent(nil))
+	}
+	if ntmgr.rpcSubs.has(subAddressTxs) {
+		ntmgr.rpcSubs.sendTx(tx.Tx, ntmgr.mempoolSpentScripts(tx.Tx),
+			ntmgr.Params, newEvent)
+	}
+}
+
+// blockSpentScripts returns the scripts of the outputs spent by the
+// transactions of the connected block, by index of transaction.
+func (ntmgr *NotifyMgr) blockSpentScripts(block *types.SerializedBlock) map[int][][]byte {
+	spent := make(map[int][][]byte)
+	if ntmgr.Chain == nil {
+		return spent
+	}
+	stxos, err := ntmgr.Chain.FetchSpendJournal(block)
+	if err != nil {
+		log.Warn("Failed to fetch the spent outputs", "block", block.Hash(),
+			"error", err)
+		return spent
+	}
+	for _, stxo := range stxos {
+		spent[int(stxo.TxIndex)] = append(spent[int(stxo.TxIndex)], stxo.PkScript)
+	}
+	return spent
+}
+
+// mempoolSpentScripts returns the scripts of the outputs spent by the
+// transaction accepted into the mempool, which are in the utxo set or in the
+// mempool.
+func (ntmgr *NotifyMgr) mempoolSpentScripts(tx *types.Tx) [][]byte {
+	if tx.Tx.IsCoinBase() {
+		return nil
+	}
+	var spent [][]byte
+	for _, txIn := range tx.Tx.TxIn {
+		prevOut := txIn.PreviousOut
+		if ntmgr.TxPool != nil {
+			prevTx, err := ntmgr.TxPool.FetchTransaction(&prevOut.Hash)
+			if err == nil && prevTx != nil {
+				if int(prevOut.OutIndex) < len(prevTx.Tx.TxOut) {
+					spent = append(spent, prevTx.Tx.TxOut[prevOut.OutIndex].PkScript)
+				}
+				continue
+			}
+		}
+		if ntmgr.Chain == nil {
+			continue
+		}
+		entry, err := ntmgr.Chain.FetchUtxoEntry(prevOut)
+		if err != nil || entry == nil {
+			continue
+		}
+		spent = append(spent, entry.PkScript())
+	}
+	return spent
+}
+
+// APIs returns the rpc subscriptions provided by the notify manager.
+func (ntmgr *NotifyMgr) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			NameSpace: rpc.DefaultServiceNameSpace,
+			Service:   NewPublicNotifyAPI(ntmgr),
+			Public:    true,
+		},
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package notifymgr
+
+import (
+	"github.com/btceasypay/bitcoinpay/core/json"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+	"github.com/btceasypay/bitcoinpay/log"
+	"github.com/btceasypay/bitcoinpay/params"
+	"sync"
+)
+
+const (
+	// maxPendingEvents is the number of notifications buffered for a single
+	// subscriber. Slow subscribers lose the events beyond this limit instead
+	// of stalling the block and transaction handlers.
+	maxPendingEvents = 256
+)
+
+// subscriptionKind is the event stream which a subscriber listens to.
+type subscriptionKind int
+
+const (
+	// subNewBlocks receives every block accepted into the DAG.
+	subNewBlocks subscriptionKind = iota
+
+	// subBlockOrder receives blocks connected to or disconnected from the
+	// DAG order.
+	subBlockOrder
+
+	// subNewTxs receives transactions accepted into the mempool.
+	subNewTxs
+
+	// subAddressTxs receives mempool and connected transactions paying to
+	// or spending from a set of addresses.
+	subAddressTxs
+)
+
+// subscriber is a single RPC subscription waiting for events.
+type subscriber struct {
+	kind   subscriptionKind
+	addrs  map[string]struct{}
+	events chan interface{}
+}
+
+// rpcSubscribers keeps track of the subscribers of each event stream.
+type rpcSubscribers struct {
+	mtx  sync.RWMutex
+	subs map[subscriptionKind]map[*subscriber]struct{}
+}
+
+// add registers a new subscriber for the given event stream.
+func (rs *rpcSubscribers) add(kind subscriptionKind, addrs map[string]struct{}) *subscriber {
+	sub := &subscriber{
+		kind:   kind,
+		addrs:  addrs,
+		events: make(chan interface{}, maxPendingEvents),
+	}
+	rs.mtx.Lock()
+	if rs.subs == nil {
+		rs.subs = make(map[subscriptionKind]map[*subscriber]struct{})
+	}
+	if rs.subs[kind] == nil {
+		rs.subs[kind] = make(map[*subscriber]struct{})
+	}
+	rs.subs[kind][sub] = struct{}{}
+	rs.mtx.Unlock()
+	return sub
+}
+
+// remove unregisters the subscriber.
+func (rs *rpcSubscribers) remove(sub *subscriber) {
+	rs.mtx.Lock()
+	delete(rs.subs[sub.kind], sub)
+	rs.mtx.Unlock()
+}
+
+// has returns whether there is any subscriber for the event stream.
+func (rs *rpcSubscribers) has(kind subscriptionKind) bool {
+	rs.mtx.RLock()
+	defer rs.mtx.RUnlock()
+	return len(rs.subs[kind]) > 0
+}
+
+// send delivers the event to every subscriber of the event stream.
+func (rs *rpcSubscribers) send(kind subscriptionKind, event interface{}) {
+	rs.mtx.RLock()
+	defer rs.mtx.RUnlock()
+	for sub := range rs.subs[kind] {
+		sub.push(event)
+	}
+}
+
+// sendTx delivers the transaction to the address subscribers watching any of
+// the addresses paid by the transaction, or paid by the outputs of the spent
+// scripts.
+func (rs *rpcSubscribers) sendTx(tx *types.Tx, spent [][]byte, par *params.Params, newEvent func(addrs []string) *json.TxNotifyResult) {
+	rs.mtx.RLock()
+	defer rs.mtx.RUnlock()
+	if len(rs.subs[subAddressTxs]) == 0 {
+		return
+	}
+	addrs := txAddresses(tx, spent, par)
+	if len(addrs) == 0 {
+		return
+	}
+	for sub := range rs.subs[subAddressTxs] {
+		matched := []string{}
+		for _, addr := range addrs {
+			if _, ok := sub.addrs[addr]; ok {
+				matched = append(matched, addr)
+			}
+		}
+		if len(matched) > 0 {
+			sub.push(newEvent(matched))
+		}
+	}
+}
+
+// push queues the event without blocking the caller.
+func (sub *subscriber) push(event interface{}) {
+	select {
+	case sub.events <- event:
+	default:
+		log.Warn("RPC subscriber is too slow, dropping notification")
+	}
+}
+
+// txAddresses returns the encoded addresses paid by the outputs of tx and by
+// the spent scripts.
+func txAddresses(tx *types.Tx, spent [][]byte, par *params.Params) []string {
+	if par == nil {
+		return nil
+	}
+	scripts := make([][]byte, 0, len(tx.Tx.TxOut)+len(spent))
+	for _, txOut := range tx.Tx.TxOut {
+		scripts = append(scripts, txOut.PkScript)
+	}
+	scripts = append(scripts, spent...)
+
+	seen := make(map[string]struct{})
+	addrs := []string{}
+	for _, pkScript := range scripts {
+		_, outAddrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, par)
+		if err != nil {
+			continue
+		}
+		for _, addr := range outAddrs {
+			encoded := addr.Encode()
+			if _, ok := seen[encoded]; ok {
+				continue
+			}
+			seen[encoded] = struct{}{}
+			addrs = append(addrs, encoded)
+		}
+	}
+	return addrs
+}
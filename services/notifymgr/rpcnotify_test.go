@@ -0,0 +1,89 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+
+package notifymgr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/address"
+	"github.com/btceasypay/bitcoinpay/core/json"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/crypto/ecc"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+	"github.com/btceasypay/bitcoinpay/params"
+)
+
+// testAddress returns a pay to pubkey hash address and its script.
+func testAddress(t *testing.T, id byte) (string, []byte) {
+	addr, err := address.NewPubKeyHashAddress(bytes.Repeat([]byte{id}, 20),
+		&params.PrivNetParams, ecc.ECDSA_Secp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr.Encode(), pkScript
+}
+
+// TestAddressTxsNotification ensures the subscribers of an address are
+// notified of the connected transactions paying to it or spending from it.
+func TestAddressTxsNotification(t *testing.T) {
+	ntmgr := &NotifyMgr{Params: &params.PrivNetParams}
+	watched, watchedScript := testAddress(t, 1)
+	_, otherScript := testAddress(t, 2)
+	sub := ntmgr.rpcSubs.add(subAddressTxs, map[string]struct{}{watched: {}})
+	defer ntmgr.rpcSubs.remove(sub)
+
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{},
+		types.MaxPrevOutIndex), []byte{0x51, 0x51}))
+	coinbase.AddTxOut(types.NewTxOutput(5000, otherScript))
+	payment := types.NewTransaction()
+	payment.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{1}, 0), nil))
+	payment.AddTxOut(types.NewTxOutput(1000, watchedScript))
+	block := types.NewBlock(&types.Block{
+		Header:       types.BlockHeader{Pow: pow.GetInstance(pow.BLAKE2BD, 0, []byte{})},
+		Transactions: []*types.Transaction{coinbase, payment},
+	})
+
+	status := &json.BlockNotifyResult{Hash: block.Hash().String(), Order: 7}
+	ntmgr.NotifyBlockConnected(block, status)
+	select {
+	case event := <-sub.events:
+		result := event.(*json.TxNotifyResult)
+		if result.Txid != payment.TxHash().String() || result.BlockOrder != 7 ||
+			len(result.Addresses) != 1 || result.Addresses[0] != watched {
+			t.Fatalf("unexpected notification %+v", result)
+		}
+	default:
+		t.Fatalf("the payment to the address was not notified")
+	}
+	if len(sub.events) != 0 {
+		t.Fatalf("the transaction not paying to the address was notified")
+	}
+
+	// A spend from the address is notified with the spent script.
+	paymentHash := payment.TxHash()
+	spend := types.NewTx(types.NewTransaction())
+	spend.Tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&paymentHash, 0), nil))
+	spend.Tx.AddTxOut(types.NewTxOutput(900, otherScript))
+	ntmgr.rpcSubs.sendTx(spend, [][]byte{watchedScript}, ntmgr.Params,
+		func(addrs []string) *json.TxNotifyResult {
+			return &json.TxNotifyResult{Txid: spend.Hash().String(), Addresses: addrs}
+		})
+	select {
+	case event := <-sub.events:
+		result := event.(*json.TxNotifyResult)
+		if result.Txid != spend.Hash().String() || len(result.Addresses) != 1 ||
+			result.Addresses[0] != watched {
+			t.Fatalf("unexpected notification %+v", result)
+		}
+	default:
+		t.Fatalf("the spend from the address was not notified")
+	}
+}
@@ -0,0 +1,417 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+)
+
+const (
+	// maxMessageSize is the largest message accepted from a miner.
+	maxMessageSize = 16 * 1024
+
+	// clientTimeout is the time after which an idle connection is closed.
+	clientTimeout = 10 * time.Minute
+
+	// vardiffShareInterval is the time between the shares of a miner aimed
+	// by the difficulty adjustment.
+	vardiffShareInterval = 10 * time.Second
+
+	// vardiffRetargetInterval is the time between the difficulty
+	// adjustments of a miner.
+	vardiffRetargetInterval = 60 * time.Second
+
+	// vardiffMaxFactor bounds the change of the difficulty at each
+	// adjustment.
+	vardiffMaxFactor = 4
+)
+
+// Stratum error codes.
+const (
+	errOther          = 20
+	errJobNotFound    = 21
+	errDuplicateShare = 22
+	errLowDifficulty  = 23
+	errUnauthorized   = 24
+	errNotSubscribed  = 25
+)
+
+// stratumError is an error returned to a miner.
+type stratumError struct {
+	code    int
+	message string
+}
+
+// MarshalJSON encodes the error as the [code, message, traceback] array of the
+// protocol.
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.code, e.message, nil})
+}
+
+// request is a message from a miner.
+type request struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// response is the reply to a request.
+type response struct {
+	ID     interface{}   `json:"id"`
+	Result interface{}   `json:"result"`
+	Error  *stratumError `json:"error"`
+}
+
+// notification is a message sent to a miner on the initiative of the server.
+type notification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// client is the connection of a miner.
+type client struct {
+	server      *Server
+	conn        net.Conn
+	extraNonce1 []byte
+
+	// writeMtx serializes the messages sent to the miner.
+	writeMtx sync.Mutex
+
+	// mtx protects the state below.
+	mtx        sync.Mutex
+	subscribed bool
+	authorized bool
+	worker     string
+
+	// difficulty is the share difficulty, and prevDifficulty the previous
+	// one which is still accepted until the next job.
+	difficulty     float64
+	prevDifficulty float64
+
+	// shares is the number of shares since the last difficulty
+	// adjustment, at retargetTime.
+	shares       int
+	retargetTime time.Time
+}
+
+// newClient returns the client of the connection of a miner.
+func newClient(s *Server, conn net.Conn, extraNonce1 []byte) *client {
+	return &client{
+		server:         s,
+		conn:           conn,
+		extraNonce1:    extraNonce1,
+		difficulty:     s.minDifficulty,
+		prevDifficulty: s.minDifficulty,
+		retargetTime:   time.Now(),
+	}
+}
+
+// handle reads and answers the requests of the miner until the connection is
+// closed.
+func (c *client) handle() {
+	defer c.conn.Close()
+	log.Debug("Stratum miner connected", "addr", c.conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 1024), maxMessageSize)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(clientTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Debug("Stratum malformed message", "addr",
+				c.conn.RemoteAddr(), "error", err)
+			break
+		}
+		result, serr := c.handleRequest(&req)
+		if req.ID == nil {
+			continue
+		}
+		if err := c.send(&response{ID: req.ID, Result: result, Error: serr}); err != nil {
+			break
+		}
+		if req.Method == "mining.authorize" && serr == nil {
+			c.sendDifficulty()
+			if j := c.server.currentJob(); j != nil {
+				c.sendJob(j, true)
+			}
+		}
+	}
+	log.Debug("Stratum miner disconnected", "addr", c.conn.RemoteAddr(),
+		"worker", c.worker)
+}
+
+// handleRequest returns the result of the request.
+func (c *client) handleRequest(req *request) (interface{}, *stratumError) {
+	switch req.Method {
+	case "mining.subscribe":
+		return c.handleSubscribe()
+	case "mining.authorize":
+		return c.handleAuthorize(req.Params)
+	case "mining.submit":
+		return c.handleSubmit(req.Params)
+	case "mining.extranonce.subscribe":
+		return false, nil
+	}
+	return nil, &stratumError{errOther, "Method not found"}
+}
+
+// handleSubscribe gives the extra nonce of the connection to the miner.
+func (c *client) handleSubscribe() (interface{}, *stratumError) {
+	c.mtx.Lock()
+	c.subscribed = true
+	c.mtx.Unlock()
+
+	id := hex.EncodeToString(c.extraNonce1)
+	return []interface{}{
+		[]interface{}{
+			[]string{"mining.set_difficulty", id},
+			[]string{"mining.notify", id},
+		},
+		id,
+		extraNonce2Size,
+	}, nil
+}
+
+// handleAuthorize authorizes a worker of the miner, with the password of the
+// server if it is set.
+func (c *client) handleAuthorize(params []json.RawMessage) (interface{}, *stratumError) {
+	var worker, pass string
+	if len(params) < 1 || json.Unmarshal(params[0], &worker) != nil {
+		return nil, &stratumError{errOther, "Invalid parameters"}
+	}
+	if len(params) > 1 {
+		json.Unmarshal(params[1], &pass)
+	}
+	if c.server.cfg.StratumPass != "" && pass != c.server.cfg.StratumPass {
+		log.Warn("Stratum authorization failure", "worker", worker,
+			"addr", c.conn.RemoteAddr())
+		return false, &stratumError{errUnauthorized, "Unauthorized worker"}
+	}
+
+	c.mtx.Lock()
+	c.authorized = true
+	c.worker = worker
+	c.mtx.Unlock()
+	return true, nil
+}
+
+// handleSubmit checks a share of the miner, and submits the block if it also
+// solves it.
+func (c *client) handleSubmit(params []json.RawMessage) (interface{}, *stratumError) {
+	c.mtx.Lock()
+	subscribed, authorized := c.subscribed, c.authorized
+	c.mtx.Unlock()
+	if !subscribed {
+		return nil, &stratumError{errNotSubscribed, "Not subscribed"}
+	}
+	if !authorized {
+		return nil, &stratumError{errUnauthorized, "Unauthorized worker"}
+	}
+
+	var args [5]string
+	if len(params) < len(args) {
+		return nil, &stratumError{errOther, "Invalid parameters"}
+	}
+	for i := range args {
+		if err := json.Unmarshal(params[i], &args[i]); err != nil {
+			return nil, &stratumError{errOther, "Invalid parameters"}
+		}
+	}
+	extraNonce2, err := hex.DecodeString(args[2])
+	if err != nil || len(extraNonce2) != extraNonce2Size {
+		return nil, &stratumError{errOther, "Invalid extranonce2"}
+	}
+	ntime, err := strconv.ParseUint(args[3], 16, 32)
+	if err != nil {
+		return nil, &stratumError{errOther, "Invalid ntime"}
+	}
+	nonce, err := strconv.ParseUint(args[4], 16, 32)
+	if err != nil {
+		return nil, &stratumError{errOther, "Invalid nonce"}
+	}
+
+	s := c.server
+	j := s.lookupJob(args[1])
+	if j == nil {
+		return nil, &stratumError{errJobNotFound, "Job not found"}
+	}
+	maxTime := s.timeSource.AdjustedTime().Add(time.Second *
+		blockchain.MaxTimeOffsetSeconds)
+	if int64(ntime) < j.minTime.Unix() || int64(ntime) > maxTime.Unix() {
+		return nil, &stratumError{errOther, "Time out of range"}
+	}
+	if !j.addShare(c.extraNonce1, extraNonce2, uint32(ntime), uint32(nonce)) {
+		return nil, &stratumError{errDuplicateShare, "Duplicate share"}
+	}
+
+	header := j.header(c.extraNonce1, extraNonce2, uint32(ntime), uint32(nonce))
+	header.Pow.SetParams(s.params.PowConfig)
+
+	c.mtx.Lock()
+	difficulty := c.difficulty
+	if c.prevDifficulty < difficulty {
+		difficulty = c.prevDifficulty
+	}
+	c.mtx.Unlock()
+
+	// The share is hashed once and checked against both targets, since the
+	// hash of some pows is costly.
+	powHash := headerPowHash(header, j.height)
+	hashNum := pow.HashToBig(&powHash)
+	shareBits := shareTargetBits(header.Pow, difficulty, header.Difficulty)
+	if hashNum.Cmp(pow.CompactToBig(shareBits)) > 0 {
+		log.Debug("Stratum share rejected", "worker", args[0], "hash",
+			powHash)
+		return nil, &stratumError{errLowDifficulty, "Low difficulty share"}
+	}
+	log.Trace("Stratum share accepted", "worker", args[0], "job", j.id)
+
+	if hashNum.Cmp(pow.CompactToBig(header.Difficulty)) <= 0 {
+		if err := s.submitBlock(j, c, extraNonce2, uint32(ntime), uint32(nonce)); err != nil {
+			log.Warn("Stratum block rejected", "worker", args[0],
+				"error", err)
+			return nil, &stratumError{errOther, fmt.Sprintf("Block rejected: %v", err)}
+		}
+	}
+
+	c.mtx.Lock()
+	c.shares++
+	c.mtx.Unlock()
+	return true, nil
+}
+
+// headerPowHash returns the proof of work hash of the header at the main
+// height, which is compared with the share and the block targets.
+func headerPowHash(header *types.BlockHeader, height uint64) hash.Hash {
+	switch header.Pow.GetPowType() {
+	case pow.X16RV3:
+		return hash.HashX16rv3(header.BlockData())
+	case pow.X8R16:
+		return hash.HashX8r16(header.BlockData())
+	case pow.BITCOINPAYKECCAK256:
+		return hash.HashBitcoinpayKeccak256(header.BlockData())
+	case pow.PROGPOW:
+		return pow.ProgpowHash(pow.ProgpowSealHash(header.BlockData()),
+			header.Pow.GetNonce(), int64(height))
+	case pow.CRYPTONIGHT:
+		return hash.HashCryptonight(header.BlockData())
+	}
+	return header.BlockHash()
+}
+
+// shareTargetBits returns the compact target of the shares of the difficulty,
+// which is the proof of work limit divided by the difficulty, or the block
+// target if it is easier.
+func shareTargetBits(instance pow.IPow, difficulty float64, blockBits uint32) uint32 {
+	limit := instance.GetSafeDiff(0)
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(limit),
+		big.NewFloat(difficulty)).Int(nil)
+	if target.Cmp(pow.CompactToBig(blockBits)) < 0 {
+		return blockBits
+	}
+	return pow.BigToCompact(target)
+}
+
+// nextDifficulty returns the share difficulty after an adjustment, given the
+// number of shares found since the previous one.
+func nextDifficulty(difficulty float64, shares int, elapsed time.Duration, minDifficulty float64) float64 {
+	next := difficulty / vardiffMaxFactor
+	if shares > 0 {
+		next = difficulty * float64(shares) * vardiffShareInterval.Seconds() /
+			elapsed.Seconds()
+	}
+	if next > difficulty*vardiffMaxFactor {
+		next = difficulty * vardiffMaxFactor
+	}
+	if next < difficulty/vardiffMaxFactor {
+		next = difficulty / vardiffMaxFactor
+	}
+	if next < minDifficulty {
+		next = minDifficulty
+	}
+	return next
+}
+
+// retarget adjusts the share difficulty of the miner to its hash rate, and
+// returns whether it has changed.
+func (c *client) retarget() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.prevDifficulty = c.difficulty
+	elapsed := time.Since(c.retargetTime)
+	if elapsed < vardiffRetargetInterval {
+		return false
+	}
+	next := nextDifficulty(c.difficulty, c.shares, elapsed,
+		c.server.minDifficulty)
+	c.shares = 0
+	c.retargetTime = time.Now()
+
+	// Small changes are not worth a message.
+	if next > c.difficulty*0.9 && next < c.difficulty*1.1 {
+		return false
+	}
+	c.difficulty = next
+	log.Debug("Stratum difficulty adjusted", "worker", c.worker,
+		"difficulty", next)
+	return true
+}
+
+// sendJob sends a job to the miner if it is authorized, after adjusting its
+// difficulty.
+func (c *client) sendJob(j *job, cleanJobs bool) {
+	c.mtx.Lock()
+	authorized := c.authorized
+	c.mtx.Unlock()
+	if !authorized {
+		return
+	}
+	if c.retarget() {
+		c.sendDifficulty()
+	}
+	c.send(&notification{Method: "mining.notify", Params: j.notifyParams(cleanJobs)})
+}
+
+// sendDifficulty sends the share difficulty to the miner.
+func (c *client) sendDifficulty() {
+	c.mtx.Lock()
+	difficulty := c.difficulty
+	c.mtx.Unlock()
+	c.send(&notification{Method: "mining.set_difficulty",
+		Params: []interface{}{difficulty}})
+}
+
+// send writes a message to the miner.
+func (c *client) send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(clientTimeout))
+	_, err = c.conn.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/merkle"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+)
+
+const (
+	// extraNonce1Size is the size of the extra nonce given to each
+	// connection, and extraNonce2Size the size of the one rolled by its
+	// miners.
+	extraNonce1Size = 4
+	extraNonce2Size = 4
+
+	// coinbaseTrailerSize is the size of the lock time and of the expiry
+	// which follow the outputs in the serialized coinbase.
+	coinbaseTrailerSize = 8
+)
+
+// job is a block template handed out to the Stratum miners.  The extra nonces
+// are pushed by the null data output of the coinbase, which is its last
+// output, so that the miners build the coinbase hash from coinb1, the extra
+// nonces and coinb2 without knowing the transaction format.
+type job struct {
+	id      string
+	block   *types.Block
+	height  uint64
+	powType pow.PowType
+
+	// minTime is the earliest timestamp of the block.
+	minTime time.Time
+
+	// coinb1 and coinb2 are the serialized coinbase, without witness,
+	// before and after the extra nonces.
+	coinb1 []byte
+	coinb2 []byte
+
+	// merkleBranch are the hashes which the coinbase hash is hashed with,
+	// in order, to get the transaction root.
+	merkleBranch []*hash.Hash
+
+	// created and lastTxUpdate are the times the job was created and the
+	// memory pool last updated before.
+	created      time.Time
+	lastTxUpdate time.Time
+
+	// shares has the shares already submitted for the job, to reject the
+	// duplicates.
+	sharesMtx sync.Mutex
+	shares    map[string]struct{}
+}
+
+// newJob returns the job of the block template.  The coinbase of the job is a
+// copy of the one of the template with the null data output, so the template
+// is left unchanged.
+func newJob(id string, template *types.BlockTemplate, minTime time.Time) (*job, error) {
+	block := *template.Block
+	coinbase := types.NewTxDeep(block.Transactions[0]).Transaction()
+	block.Transactions = append([]*types.Transaction{coinbase},
+		block.Transactions[1:]...)
+	if len(coinbase.TxOut) > blockchain.CoinbaseOutput_data {
+		return nil, fmt.Errorf("the coinbase already has a null data output")
+	}
+
+	// The null data output must come after the tax output, which is
+	// empty on the networks without tax.
+	if len(coinbase.TxOut) <= blockchain.CoinbaseOutput_tax {
+		coinbase.AddTxOut(&types.TxOutput{})
+	}
+	nullData, err := txscript.GenerateProvablyPruneableOut(
+		make([]byte, extraNonce1Size+extraNonce2Size))
+	if err != nil {
+		return nil, err
+	}
+	coinbase.AddTxOut(&types.TxOutput{PkScript: nullData})
+
+	serialized, err := coinbase.SerializeNoWitness()
+	if err != nil {
+		return nil, err
+	}
+	offset := len(serialized) - coinbaseTrailerSize - extraNonce1Size -
+		extraNonce2Size
+
+	txs := make([]*types.Tx, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		txs = append(txs, types.NewTx(tx))
+	}
+	return &job{
+		id:           id,
+		block:        &block,
+		height:       template.Height,
+		powType:      block.Header.Pow.GetPowType(),
+		minTime:      minTime,
+		coinb1:       serialized[:offset],
+		coinb2:       serialized[offset+extraNonce1Size+extraNonce2Size:],
+		merkleBranch: merkleBranch(txs),
+		created:      time.Now(),
+		shares:       make(map[string]struct{}),
+	}, nil
+}
+
+// merkleBranch returns the hashes which the hash of the first transaction is
+// hashed with, in order, to get the merkle root of the transactions.
+func merkleBranch(txs []*types.Tx) []*hash.Hash {
+	tree := merkle.BuildMerkleTreeStore(txs, false)
+	branch := make([]*hash.Hash, 0)
+	for i, width := 0, (len(tree)+1)/2; width > 1; i, width = i+width, width/2 {
+		branch = append(branch, tree[i+1])
+	}
+	return branch
+}
+
+// merkleRoot returns the merkle root of the transactions given the hash of the
+// coinbase and the merkle branch.
+func merkleRoot(coinbaseHash hash.Hash, branch []*hash.Hash) hash.Hash {
+	var buf [hash.HashSize * 2]byte
+	root := coinbaseHash
+	for _, h := range branch {
+		copy(buf[:hash.HashSize], root[:])
+		copy(buf[hash.HashSize:], h[:])
+		root = hash.DoubleHashH(buf[:])
+	}
+	return root
+}
+
+// coinbaseHash returns the hash of the coinbase with the extra nonces.
+func (j *job) coinbaseHash(extraNonce1, extraNonce2 []byte) hash.Hash {
+	data := make([]byte, 0, len(j.coinb1)+extraNonce1Size+extraNonce2Size+
+		len(j.coinb2))
+	data = append(data, j.coinb1...)
+	data = append(data, extraNonce1...)
+	data = append(data, extraNonce2...)
+	data = append(data, j.coinb2...)
+	return hash.DoubleHashH(data)
+}
+
+// header returns the block header of the job with the extra nonces, the time
+// and the nonce submitted by a miner.
+func (j *job) header(extraNonce1, extraNonce2 []byte, ntime, nonce uint32) *types.BlockHeader {
+	header := j.block.Header
+	header.TxRoot = merkleRoot(j.coinbaseHash(extraNonce1, extraNonce2),
+		j.merkleBranch)
+	header.Timestamp = time.Unix(int64(ntime), 0)
+	header.Pow = pow.GetInstance(j.powType, nonce, []byte{})
+	return &header
+}
+
+// solvedBlock returns the block of the job solved by the header.
+func (j *job) solvedBlock(header *types.BlockHeader, extraNonce1, extraNonce2 []byte) (*types.SerializedBlock, error) {
+	coinbase := types.NewTxDeep(j.block.Transactions[0]).Transaction()
+	nullData, err := txscript.GenerateProvablyPruneableOut(
+		append(append([]byte{}, extraNonce1...), extraNonce2...))
+	if err != nil {
+		return nil, err
+	}
+	coinbase.TxOut[len(coinbase.TxOut)-1].PkScript = nullData
+
+	block := &types.Block{
+		Header:       *header,
+		Parents:      j.block.Parents,
+		Transactions: append([]*types.Transaction{coinbase}, j.block.Transactions[1:]...),
+	}
+	sblock := types.NewBlock(block)
+	sblock.SetHeight(uint(j.height))
+	return sblock, nil
+}
+
+// addShare records a share of the job, and returns false if it was already
+// submitted.
+func (j *job) addShare(extraNonce1, extraNonce2 []byte, ntime, nonce uint32) bool {
+	key := fmt.Sprintf("%x%x%08x%08x", extraNonce1, extraNonce2, ntime, nonce)
+
+	j.sharesMtx.Lock()
+	defer j.sharesMtx.Unlock()
+
+	if _, ok := j.shares[key]; ok {
+		return false
+	}
+	j.shares[key] = struct{}{}
+	return true
+}
+
+// notifyParams returns the parameters of the mining.notify message of the
+// job.  The hashes are given in their serialized byte order and the integers
+// as big-endian hexadecimal, as in Bitcoin.
+func (j *job) notifyParams(cleanJobs bool) []interface{} {
+	header := &j.block.Header
+	branch := make([]string, 0, len(j.merkleBranch))
+	for _, h := range j.merkleBranch {
+		branch = append(branch, hex.EncodeToString(h[:]))
+	}
+	return []interface{}{
+		j.id,
+		hex.EncodeToString(header.ParentRoot[:]),
+		hex.EncodeToString(j.coinb1),
+		hex.EncodeToString(j.coinb2),
+		branch,
+		fmt.Sprintf("%08x", header.Version),
+		fmt.Sprintf("%08x", header.Difficulty),
+		fmt.Sprintf("%08x", uint32(header.Timestamp.Unix())),
+		cleanJobs,
+		hex.EncodeToString(header.StateRoot[:]),
+		uint8(j.powType),
+		j.height,
+	}
+}
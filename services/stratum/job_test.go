@@ -0,0 +1,166 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/common/hash"
+	"github.com/btceasypay/bitcoinpay/core/merkle"
+	"github.com/btceasypay/bitcoinpay/core/types"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/params"
+)
+
+// testTemplate returns a block template with a coinbase and the number of
+// other transactions.
+func testTemplate(numTxs int) *types.BlockTemplate {
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{},
+		types.MaxPrevOutIndex), []byte{0x51, 0x51}))
+	coinbase.AddTxOut(types.NewTxOutput(5000, []byte{0x51}))
+
+	block := &types.Block{
+		Header: types.BlockHeader{
+			Version:    1,
+			ParentRoot: hash.Hash{1},
+			Difficulty: 0x207fffff,
+			Timestamp:  time.Unix(1600000000, 0),
+			Pow:        pow.GetInstance(pow.BLAKE2BD, 0, []byte{}),
+		},
+		Parents:      []*hash.Hash{{1}},
+		Transactions: []*types.Transaction{coinbase},
+	}
+	for i := 0; i < numTxs; i++ {
+		tx := types.NewTransaction()
+		tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{byte(i)}, 0),
+			nil))
+		tx.AddTxOut(types.NewTxOutput(uint64(i+1), []byte{0x51}))
+		block.Transactions = append(block.Transactions, tx)
+	}
+	return &types.BlockTemplate{Block: block, Height: 10}
+}
+
+func TestJobSolvedBlock(t *testing.T) {
+	extraNonce1 := []byte{1, 2, 3, 4}
+	extraNonce2 := []byte{5, 6, 7, 8}
+
+	for numTxs := 0; numTxs < 6; numTxs++ {
+		j, err := newJob("1", testTemplate(numTxs), time.Unix(1600000000, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := j.header(extraNonce1, extraNonce2, 1600000001, 42)
+		block, err := j.solvedBlock(header, extraNonce1, extraNonce2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		coinbase := block.Transactions()[0]
+		if got := j.coinbaseHash(extraNonce1, extraNonce2); got != *coinbase.Hash() {
+			t.Fatalf("%d txs: coinbase hash %v, want %v", numTxs, got,
+				coinbase.Hash())
+		}
+		if !bytes.Contains(coinbase.Tx.TxOut[2].PkScript,
+			append(extraNonce1, extraNonce2...)) {
+			t.Fatalf("%d txs: extra nonces missing from the coinbase",
+				numTxs)
+		}
+		tree := merkle.BuildMerkleTreeStore(block.Transactions(), false)
+		if root := tree[len(tree)-1]; header.TxRoot != *root {
+			t.Fatalf("%d txs: tx root %v, want %v", numTxs, header.TxRoot,
+				root)
+		}
+		if *block.Hash() != header.BlockHash() {
+			t.Fatalf("%d txs: block hash %v, want %v", numTxs,
+				block.Hash(), header.BlockHash())
+		}
+		if header.Pow.GetNonce() != 42 || header.Timestamp.Unix() != 1600000001 {
+			t.Fatalf("%d txs: unexpected nonce or time", numTxs)
+		}
+	}
+}
+
+// TestJobTemplateUnchanged ensures the job doesn't change the coinbase of the
+// template it is built from, which the block manager may hand out.
+func TestJobTemplateUnchanged(t *testing.T) {
+	template := testTemplate(2)
+	coinbaseHash := template.Block.Transactions[0].TxHash()
+	outputs := len(template.Block.Transactions[0].TxOut)
+	if _, err := newJob("1", template, time.Unix(1600000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	coinbase := template.Block.Transactions[0]
+	if coinbase.TxHash() != coinbaseHash || len(coinbase.TxOut) != outputs {
+		t.Fatalf("the coinbase of the template was changed")
+	}
+}
+
+// TestHeaderPowHash ensures the share hash is the one the proof of work is
+// verified with.
+func TestHeaderPowHash(t *testing.T) {
+	j, err := newJob("1", testTemplate(1), time.Unix(1600000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, powType := range []pow.PowType{pow.BLAKE2BD, pow.X16RV3,
+		pow.X8R16, pow.BITCOINPAYKECCAK256} {
+
+		// Look for a hash below the pow limit.
+		j.powType = powType
+		var header *types.BlockHeader
+		var h hash.Hash
+		for nonce := uint32(0); ; nonce++ {
+			header = j.header([]byte{1, 2, 3, 4}, []byte{5, 6, 7, 8},
+				1600000001, nonce)
+			header.Pow.SetParams(params.PrivNetParams.PowConfig)
+			h = headerPowHash(header, j.height)
+			if h[len(h)-1] < 0x70 {
+				break
+			}
+		}
+
+		// The compact target just above the hash is met, and the one
+		// just below it isn't.
+		bits := pow.BigToCompact(pow.HashToBig(&h))
+		err := header.Pow.Verify(header.BlockData(), header.BlockHash(), bits+1)
+		if err != nil {
+			t.Fatalf("%s: %v", pow.PowMapString[powType], err)
+		}
+		err = header.Pow.Verify(header.BlockData(), header.BlockHash(), bits-1)
+		if err == nil {
+			t.Fatalf("%s: the hash is above the target",
+				pow.PowMapString[powType])
+		}
+	}
+}
+
+func TestNextDifficulty(t *testing.T) {
+	tests := []struct {
+		difficulty float64
+		shares     int
+		elapsed    time.Duration
+		want       float64
+	}{
+		// On target.
+		{difficulty: 8, shares: 6, elapsed: time.Minute, want: 8},
+		// Twice as fast.
+		{difficulty: 8, shares: 12, elapsed: time.Minute, want: 16},
+		// Bounded increase.
+		{difficulty: 8, shares: 600, elapsed: time.Minute, want: 32},
+		// No shares.
+		{difficulty: 8, shares: 0, elapsed: time.Minute, want: 2},
+		// Minimum difficulty.
+		{difficulty: 1, shares: 0, elapsed: time.Minute, want: 1},
+	}
+	for i, test := range tests {
+		got := nextDifficulty(test.difficulty, test.shares, test.elapsed, 1)
+		if got != test.want {
+			t.Errorf("test %d: difficulty %v, want %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright (c) 2020-2021 The bitcoinpay developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package stratum implements a Stratum V1 mining server.
+//
+// The server hands out the block templates of the node as jobs to the miners
+// connected to it, checks their shares against a per connection difficulty
+// adjusted to their hash rate, and submits the blocks they solve.
+//
+// The messages follow the Bitcoin Stratum protocol with the differences due to
+// the block header.  mining.notify has the parameters
+//
+//	[job_id, parent_root, coinb1, coinb2, merkle_branch, version, nbits,
+//	 ntime, clean_jobs, state_root, pow_type, height]
+//
+// and mining.submit the parameters
+//
+//	[worker, job_id, extranonce2, ntime, nonce]
+//
+// The coinbase hash is the double BLAKE2b hash of coinb1, extranonce1,
+// extranonce2 and coinb2, and the transaction root is found by hashing it with
+// each hash of the merkle branch with the same function.  The share difficulty
+// is relative to the proof of work limit of the network.
+package stratum
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btceasypay/bitcoinpay/config"
+	"github.com/btceasypay/bitcoinpay/core/blockchain"
+	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/types/pow"
+	"github.com/btceasypay/bitcoinpay/engine/txscript"
+	"github.com/btceasypay/bitcoinpay/params"
+	"github.com/btceasypay/bitcoinpay/services/blkmgr"
+	"github.com/btceasypay/bitcoinpay/services/mining"
+)
+
+const (
+	// jobRegenerateSeconds is the number of seconds after which a new job
+	// is created when the memory pool has changed but the tips have not.
+	jobRegenerateSeconds = 60
+
+	// jobCheckSeconds is the number of seconds between the checks of the
+	// memory pool for a new job.
+	jobCheckSeconds = 5
+
+	// maxJobs is the number of recent jobs whose shares are accepted.
+	maxJobs = 8
+
+	// defaultMaxClients is the number of miner connections accepted when
+	// the configuration doesn't set it.
+	defaultMaxClients = 100
+)
+
+// Server is a Stratum V1 mining server.
+type Server struct {
+	cfg        *config.Config
+	params     *params.Params
+	policy     *mining.Policy
+	sigCache   *txscript.SigCache
+	txSource   mining.TxSource
+	timeSource blockchain.MedianTimeSource
+	bm         *blkmgr.BlockManager
+
+	// powType is the proof of work of the jobs, and minDifficulty the
+	// lowest share difficulty.
+	powType       pow.PowType
+	minDifficulty float64
+
+	// maxClients is the most miner connections accepted at once.
+	maxClients int
+
+	listener net.Listener
+
+	// nextExtraNonce1 is the extra nonce of the next connection, and
+	// nextJobID the id of the next job.  They are accessed atomically.
+	nextExtraNonce1 uint32
+	nextJobID       uint64
+
+	mtx     sync.Mutex
+	clients map[*client]struct{}
+	jobs    map[string]*job
+	jobIDs  []string
+	job     *job
+
+	newTips chan struct{}
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewServer returns a Stratum server handing out the block templates of the
+// block manager, which pay to the mining addresses of the configuration.
+func NewServer(cfg *config.Config, par *params.Params, policy *mining.Policy,
+	sigCache *txscript.SigCache, txSource mining.TxSource,
+	timeSource blockchain.MedianTimeSource, bm *blkmgr.BlockManager) (*Server, error) {
+
+	powType, err := parsePowType(cfg.StratumPow)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.GetMinningAddrs()) == 0 {
+		return nil, errors.New("the Stratum server requires a mining address")
+	}
+	minDifficulty := cfg.StratumDiff
+	if minDifficulty <= 0 {
+		minDifficulty = 1
+	}
+	maxClients := cfg.StratumMaxClients
+	if maxClients <= 0 {
+		maxClients = defaultMaxClients
+	}
+	return &Server{
+		cfg:             cfg,
+		params:          par,
+		policy:          policy,
+		sigCache:        sigCache,
+		txSource:        txSource,
+		timeSource:      timeSource,
+		bm:              bm,
+		powType:         powType,
+		minDifficulty:   minDifficulty,
+		maxClients:      maxClients,
+		nextExtraNonce1: rand.Uint32(),
+		clients:         make(map[*client]struct{}),
+		jobs:            make(map[string]*job),
+		newTips:         make(chan struct{}, 1),
+		quit:            make(chan struct{}),
+	}, nil
+}
+
+// parsePowType returns the proof of work of the name.  The cuckoo proofs of
+// work do not fit in the Stratum jobs.
+func parsePowType(name string) (pow.PowType, error) {
+	for powType, powName := range pow.PowMapString {
+		if powName != name {
+			continue
+		}
+		switch powType {
+		case pow.CUCKAROO, pow.CUCKATOO, pow.CUCKAROOM:
+			return 0, fmt.Errorf("the pow %s is not supported by the "+
+				"Stratum server", name)
+		}
+		return powType, nil
+	}
+	return 0, fmt.Errorf("unknown pow %s", name)
+}
+
+// Start starts listening for the Stratum miners.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.cfg.StratumListen)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.bm.GetChain().Subscribe(s.handleChainNotification)
+
+	s.wg.Add(2)
+	go s.jobHandler()
+	go s.acceptHandler()
+	log.Info("Stratum server listening", "addr", listener.Addr(),
+		"pow", pow.PowMapString[s.powType])
+	return nil
+}
+
+// Stop disconnects the miners and stops the server.
+func (s *Server) Stop() {
+	close(s.quit)
+	s.listener.Close()
+
+	s.mtx.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+	log.Info("Stratum server stopped")
+}
+
+// handleChainNotification triggers a new job when a block is connected.
+func (s *Server) handleChainNotification(notification *blockchain.Notification) {
+	if notification.Type != blockchain.BlockConnected {
+		return
+	}
+	select {
+	case s.newTips <- struct{}{}:
+	default:
+	}
+}
+
+// acceptHandler accepts the connections of the miners, up to the most
+// connections of the configuration.
+//
+// It must be run as a goroutine.
+func (s *Server) acceptHandler() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			log.Warn("Stratum accept failed", "error", err)
+			continue
+		}
+		extraNonce1 := make([]byte, extraNonce1Size)
+		binary.BigEndian.PutUint32(extraNonce1,
+			atomic.AddUint32(&s.nextExtraNonce1, 1))
+		c := newClient(s, conn, extraNonce1)
+
+		s.mtx.Lock()
+		if len(s.clients) >= s.maxClients {
+			s.mtx.Unlock()
+			log.Info("Stratum miners exceeded", "max", s.maxClients,
+				"addr", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s.clients[c] = struct{}{}
+		s.mtx.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.handle()
+
+			s.mtx.Lock()
+			delete(s.clients, c)
+			s.mtx.Unlock()
+		}()
+	}
+}
+
+// jobHandler creates a new job when the tips change, or when the memory pool
+// has changed and the current job is old enough.
+//
+// It must be run as a goroutine.
+func (s *Server) jobHandler() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Second * jobCheckSeconds)
+	defer ticker.Stop()
+
+	s.updateJob(true)
+	for {
+		select {
+		case <-s.newTips:
+			s.updateJob(true)
+		case <-ticker.C:
+			s.updateJob(false)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// updateJob creates a new job from the current block template and sends it to
+// the miners, when the tips have changed or force is set, or when the memory
+// pool has changed and the current job is old enough.
+func (s *Server) updateJob(force bool) {
+	chain := s.bm.GetChain()
+
+	// No point in handing out work before the chain is synced.
+	currentOrder := chain.BestSnapshot().GraphState.GetTotal() - 1
+	if currentOrder != 0 && !s.bm.IsCurrent() {
+		return
+	}
+
+	lastTxUpdate := s.txSource.LastUpdated()
+	s.mtx.Lock()
+	current := s.job
+	s.mtx.Unlock()
+	tipsChanged := force || current == nil
+	if !tipsChanged {
+		tips := blockdag.NewHashSet()
+		tips.AddList(chain.GetMiningTips())
+		parents := blockdag.NewHashSet()
+		parents.AddList(current.block.Parents)
+		tipsChanged = !tips.IsEqual(parents)
+	}
+	if !tipsChanged && (lastTxUpdate == current.lastTxUpdate ||
+		time.Since(current.created) < time.Second*jobRegenerateSeconds) {
+		return
+	}
+
+	addrs := s.cfg.GetMinningAddrs()
+	payToAddr := addrs[rand.Intn(len(addrs))]
+	template, err := mining.NewBlockTemplate(s.policy, s.params, s.sigCache,
+		s.txSource, s.timeSource, s.bm, payToAddr, nil, s.powType)
+	if err != nil {
+		log.Warn("Stratum failed to create the block template", "error", err)
+		return
+	}
+	// The job is built from this template, since the cached one may be
+	// replaced meanwhile by a template of another pow or payee.
+	id := strconv.FormatUint(atomic.AddUint64(&s.nextJobID, 1), 16)
+	j, err := newJob(id, template, mining.MinimumMedianTime(chain))
+	if err != nil {
+		log.Warn("Stratum failed to create the job", "error", err)
+		return
+	}
+	s.bm.SetCurrentTemplate(template)
+	j.lastTxUpdate = lastTxUpdate
+
+	s.mtx.Lock()
+	if tipsChanged {
+		s.jobs = make(map[string]*job)
+		s.jobIDs = s.jobIDs[:0]
+	}
+	s.jobs[id] = j
+	s.jobIDs = append(s.jobIDs, id)
+	if len(s.jobIDs) > maxJobs {
+		delete(s.jobs, s.jobIDs[0])
+		s.jobIDs = s.jobIDs[1:]
+	}
+	s.job = j
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mtx.Unlock()
+
+	log.Debug("Stratum job created", "id", id, "height", j.height,
+		"txs", len(j.block.Transactions), "clean", tipsChanged)
+	for _, c := range clients {
+		c.sendJob(j, tipsChanged)
+	}
+}
+
+// currentJob returns the current job, or nil if there is none yet.
+func (s *Server) currentJob() *job {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.job
+}
+
+// lookupJob returns the recent job of the id, or nil if it is unknown.
+func (s *Server) lookupJob(id string) *job {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.jobs[id]
+}
+
+// submitBlock processes a block solved by a miner.
+func (s *Server) submitBlock(j *job, c *client, extraNonce2 []byte, ntime, nonce uint32) error {
+	header := j.header(c.extraNonce1, extraNonce2, ntime, nonce)
+	block, err := j.solvedBlock(header, c.extraNonce1, extraNonce2)
+	if err != nil {
+		return err
+	}
+	isOrphan, err := s.bm.ProcessBlock(block, blockchain.BFNone)
+	if err != nil {
+		return err
+	}
+	if isOrphan {
+		return errors.New("the block is an orphan")
+	}
+	log.Info("Stratum block accepted", "hash", block.Hash(), "height",
+		j.height, "worker", c.worker)
+	return nil
+}
@@ -2,6 +2,7 @@ package tx
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -16,12 +17,28 @@ import (
 	"github.com/btceasypay/bitcoinpay/crypto/ecc"
 	"github.com/btceasypay/bitcoinpay/database"
 	"github.com/btceasypay/bitcoinpay/engine/txscript"
+	"github.com/btceasypay/bitcoinpay/ledger"
 	"github.com/btceasypay/bitcoinpay/params"
 	"github.com/btceasypay/bitcoinpay/rpc"
 	"github.com/btceasypay/bitcoinpay/services/mempool"
 	"time"
 )
 
+const (
+	// defaultConfidenceTimeout is the default number of seconds to wait
+	// for the risk of a transaction to be below the threshold.
+	defaultConfidenceTimeout = 600
+
+	// maxConfidenceTimeout is the most seconds a caller may wait for the
+	// risk of a transaction to be below the threshold.
+	maxConfidenceTimeout = 3600
+
+	// confidencePollInterval is the interval at which the risk of a
+	// transaction is computed again while waiting for it to be below the
+	// threshold.
+	confidencePollInterval = time.Second * 5
+)
+
 func (tm *TxManager) APIs() []rpc.API {
 	return []rpc.API{
 		{
@@ -228,7 +245,51 @@ func (api *PublicTxAPI) SendRawTransaction(hexTx string, allowHighFees *bool) (i
 	return tx.Hash().String(), nil
 }
 
-func (api *PublicTxAPI) GetRawTransaction(txHash hash.Hash, verbose bool) (interface{}, error) {
+// EstimateFee returns the fee rate in atoms/kB a transaction must pay to reach
+// a blue block within the given number of blue blocks, or -1 when not enough
+// transactions have been observed yet.
+func (api *PublicTxAPI) EstimateFee(numBlocks uint32) (interface{}, error) {
+	feeRate, err := api.txManager.feeEstimator.EstimateFee(numBlocks)
+	if err == mempool.ErrInsufficientFeeData {
+		return int64(-1), nil
+	}
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return api.minRelayFeeRate(feeRate), nil
+}
+
+// EstimateSmartFee returns the fee rate in atoms/kB a transaction must pay to
+// reach a blue block within the given number of blue blocks, falling back to
+// the longer targets when there is not enough data for the requested one.
+func (api *PublicTxAPI) EstimateSmartFee(confTarget uint32) (interface{}, error) {
+	feeRate, blocks, err := api.txManager.feeEstimator.EstimateSmartFee(confTarget)
+	if err == mempool.ErrInsufficientFeeData {
+		return json.EstimateSmartFeeResult{
+			Errors: []string{err.Error()},
+			Blocks: blocks,
+		}, nil
+	}
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return json.EstimateSmartFeeResult{
+		FeeRate: api.minRelayFeeRate(feeRate),
+		Blocks:  blocks,
+	}, nil
+}
+
+// minRelayFeeRate raises the fee rate to the minimum relay fee of the mempool
+// when it is lower, since the transaction would not be relayed otherwise.
+func (api *PublicTxAPI) minRelayFeeRate(feeRate int64) int64 {
+	minFeeRate := api.txManager.txMemPool.MinRequiredTxRelayFee(1000)
+	if feeRate < minFeeRate {
+		return minFeeRate
+	}
+	return feeRate
+}
+
+func (api *PublicTxAPI) GetRawTransaction(txHash hash.Hash, verbose bool, risk *bool) (interface{}, error) {
 
 	var mtx *types.Tx
 	var blkHash *hash.Hash
@@ -338,7 +399,80 @@ func (api *PublicTxAPI) GetRawTransaction(txHash hash.Hash, verbose bool) (inter
 	if tx != nil {
 		confirmations = 0
 	}
-	return marshal.MarshalJsonTransaction(mtx, api.txManager.bm.ChainParams(), blkHashStr, confirmations, coinbaseAmout, txsvalid)
+	txr, err := marshal.MarshalJsonTransaction(mtx, api.txManager.bm.ChainParams(), blkHashStr, confirmations, coinbaseAmout, txsvalid)
+	if err != nil {
+		return nil, err
+	}
+	// The risk walks the DAG around the block, so it is only given on request.
+	if risk != nil && *risk {
+		r := float64(1)
+		if blkHash != nil && tx == nil {
+			br, err := api.txManager.bm.GetChain().CalcBlockRisk(blkHash, blockchain.DefaultRiskAlpha)
+			if err != nil {
+				return nil, rpc.RpcInvalidError("Failed to compute the transaction risk: %v", err)
+			}
+			r = br.Risk
+		}
+		txr.Risk = &r
+	}
+	return txr, nil
+}
+
+// Returns the risk that a transaction is reversed by an attacker
+// 1. txid  (string, required)                The hash of the transaction
+// 2. alpha (numeric, optional, default=0.1)  The relative computational power of the attacker
+//
+//Result:
+//{
+// "txid": "value",        (string)  The hash of the transaction
+// "blockhash": "value",   (string)  The block which contains the transaction, if it is mined
+// "alpha": n.nnn,         (numeric) The relative computational power of the attacker
+// "antipast": n,          (numeric) The smallest future set of the block and of its anticone
+// "anticone": n,          (numeric) The number of blocks in the anticone of the block
+// "waitingtime": n,       (numeric) The seconds elapsed since the block was mined
+// "risk": n.nnn,          (numeric) The probability that the transaction is reversed
+//}
+func (api *PublicTxAPI) GetTxRisk(txHash hash.Hash, alpha *float64) (interface{}, error) {
+	a := blockchain.DefaultRiskAlpha
+	if alpha != nil {
+		a = *alpha
+	}
+	return api.txManager.txRisk(&txHash, a)
+}
+
+// txRisk returns the risk that a transaction is reversed, which is the risk of
+// the block containing it, or 1 while it is in the mempool.
+func (tm *TxManager) txRisk(txHash *hash.Hash, alpha float64) (*json.GetTxRiskResult, error) {
+	result := &json.GetTxRiskResult{
+		Txid:  txHash.String(),
+		Alpha: alpha,
+		Risk:  1,
+	}
+	if tm.txMemPool.HaveTransaction(txHash) {
+		return result, nil
+	}
+	txIndex := tm.txIndex
+	if txIndex == nil {
+		return nil, fmt.Errorf("the transaction index " +
+			"must be enabled to query the blockchain (specify --txindex in configuration)")
+	}
+	blockRegion, err := txIndex.TxBlockRegion(*txHash)
+	if err != nil {
+		return nil, errors.New("Failed to retrieve transaction location")
+	}
+	if blockRegion == nil {
+		return nil, rpc.RpcNoTxInfoError(txHash)
+	}
+	risk, err := tm.bm.GetChain().CalcBlockRisk(blockRegion.Hash, alpha)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("Failed to compute the transaction risk: %v", err)
+	}
+	result.BlockHash = blockRegion.Hash.String()
+	result.AntiPast = risk.AntiPast
+	result.Anticone = risk.Anticone
+	result.WaitingTime = risk.WaitingTime
+	result.Risk = risk.Risk
+	return result, nil
 }
 
 // Returns information about an unspent transaction output
@@ -887,7 +1021,7 @@ func (api *PublicTxAPI) GetRawTransactionByHash(txHash hash.Hash, verbose bool)
 			return nil, fmt.Errorf("no tx")
 		}
 	}
-	return api.GetRawTransaction(*txid, verbose)
+	return api.GetRawTransaction(*txid, verbose, nil)
 }
 
 type PrivateTxAPI struct {
@@ -899,6 +1033,52 @@ func NewPrivateTxAPI(tm *TxManager) *PrivateTxAPI {
 	return &ptapi
 }
 
+// Waits until the risk that a transaction is reversed is below a threshold
+// 1. txid          (string, required)                The hash of the transaction
+// 2. riskthreshold (numeric, required)               The highest accepted risk
+// 3. alpha         (numeric, optional, default=0.1)  The relative computational power of the attacker
+// 4. timeout       (numeric, optional, default=600)  The most seconds to wait for, up to 3600
+//
+//Result: the result of getTxRisk when the risk is below the threshold, with
+//"confident": true, or when the timeout expired.
+//
+// It is private since every waiter computes the risk again at each poll.
+func (api *PrivateTxAPI) WaitForConfidence(ctx context.Context, txHash hash.Hash, riskThreshold float64,
+	alpha *float64, timeout *uint) (interface{}, error) {
+	a := blockchain.DefaultRiskAlpha
+	if alpha != nil {
+		a = *alpha
+	}
+	wait := uint(defaultConfidenceTimeout)
+	if timeout != nil {
+		wait = *timeout
+	}
+	if wait > maxConfidenceTimeout {
+		wait = maxConfidenceTimeout
+	}
+	deadline := time.NewTimer(time.Duration(wait) * time.Second)
+	defer deadline.Stop()
+	ticker := time.NewTicker(confidencePollInterval)
+	defer ticker.Stop()
+	for {
+		result, err := api.txManager.txRisk(&txHash, a)
+		if err != nil {
+			return nil, err
+		}
+		if result.Risk <= riskThreshold {
+			result.Confident = true
+			return result, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return result, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
 func (api *PrivateTxAPI) TxSign(privkeyStr string, rawTxStr string) (interface{}, error) {
 	privkeyByte, err := hex.DecodeString(privkeyStr)
 	if err != nil {
@@ -999,3 +1179,51 @@ func (api *PrivateTxAPI) TxSign(privkeyStr string, rawTxStr string) (interface{}
 	}
 	return mtxHex, nil
 }
+
+// Returns statistics about the unspent transaction output set after the last
+// ordered block.  The amounts are in atoms, the total amount including the
+// fees paid to the coinbases, so that it can be reconciled with the genesis
+// ledger and the issued subsidy.  It walks the whole set, so it is not part
+// of the public API.
+//
+//Result:
+//{
+// "bestblock": "value",        (string)  The last block whose outputs are in the set
+// "order": n,                  (numeric) The DAG order of the block
+// "transactions": n,           (numeric) The number of transactions with unspent outputs
+// "txouts": n,                 (numeric) The number of unspent outputs
+// "serializedsize": n,         (numeric) The size of the set as stored in the database
+// "hashserialized": "value",   (string)  The hash of the set, as the content hash of dumpUtxoSet
+// "totalamount": n,            (numeric) The total amount of the set
+// "genesissubsidy": n,         (numeric) The amount paid by the genesis ledger
+// "totalsubsidy": n,           (numeric) The subsidy issued by the main chain
+// "scriptclasses": {           (object)  The unspent outputs by the type of their script
+//  "type": {"txouts": n, "amount": n}
+// }
+//}
+func (api *PrivateTxAPI) GetTxOutSetInfo() (interface{}, error) {
+	chain := api.txManager.bm.GetChain()
+	info, err := chain.FetchUtxoSetInfo()
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to walk the utxo set")
+	}
+	result := json.GetTxOutSetInfoResult{
+		BestBlock:      info.Hash.String(),
+		Order:          info.Order,
+		Transactions:   info.Transactions,
+		TxOuts:         info.Count,
+		SerializedSize: info.SerializedSize,
+		HashSerialized: info.SetHash.String(),
+		TotalAmount:    info.Amount,
+		GenesisSubsidy: ledger.GenesisLedgerSubsidy(),
+		TotalSubsidy:   info.TotalSubsidy,
+		ScriptClasses:  make(map[string]json.TxOutSetClassInfo, len(info.Classes)),
+	}
+	for class, classInfo := range info.Classes {
+		result.ScriptClasses[class.String()] = json.TxOutSetClassInfo{
+			TxOuts: classInfo.Count,
+			Amount: classInfo.Amount,
+		}
+	}
+	return result, nil
+}
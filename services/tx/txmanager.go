@@ -5,6 +5,7 @@ import (
 	"github.com/btceasypay/bitcoinpay/config"
 	"github.com/btceasypay/bitcoinpay/core/blockchain"
 	"github.com/btceasypay/bitcoinpay/core/blockdag"
+	"github.com/btceasypay/bitcoinpay/core/dbnamespace"
 	"github.com/btceasypay/bitcoinpay/core/types"
 	"github.com/btceasypay/bitcoinpay/database"
 	"github.com/btceasypay/bitcoinpay/engine/txscript"
@@ -13,6 +14,7 @@ import (
 	"github.com/btceasypay/bitcoinpay/services/common"
 	"github.com/btceasypay/bitcoinpay/services/index"
 	"github.com/btceasypay/bitcoinpay/services/mempool"
+	"path/filepath"
 	"time"
 )
 
@@ -34,15 +36,47 @@ type TxManager struct {
 
 	//invalidTx hash->block hash
 	invalidTx map[hash.Hash]*blockdag.HashSet
+
+	// fee estimator, its statistics are saved in the db on stop
+	feeEstimator *mempool.FeeEstimator
+
+	// path of the mempool dump, empty if the mempool is not persisted
+	mempoolDumpPath string
 }
 
 func (tm *TxManager) Start() error {
 	log.Info("Starting tx manager")
+
+	// Bring back the transactions the mempool held at the last shutdown.
+	if tm.mempoolDumpPath != "" {
+		n, err := tm.txMemPool.Load(tm.mempoolDumpPath)
+		if err != nil {
+			log.Warn("Failed to load the mempool", "error", err)
+		} else {
+			log.Info("Loaded the mempool", "transactions", n)
+		}
+	}
 	return nil
 }
 
 func (tm *TxManager) Stop() error {
 	log.Info("Stopping tx manager")
+
+	if tm.mempoolDumpPath != "" {
+		err := tm.txMemPool.Dump(tm.mempoolDumpPath)
+		if err != nil {
+			log.Error("Failed to dump the mempool", "error", err)
+		}
+	}
+
+	// Save the fee estimator state so the estimates survive the restart.
+	err := tm.db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Put(dbnamespace.FeeEstimationKeyName,
+			tm.feeEstimator.Save())
+	})
+	if err != nil {
+		log.Error("Failed to save the fee estimator", "error", err)
+	}
 	return nil
 }
 
@@ -50,9 +84,40 @@ func (tm *TxManager) MemPool() blkmgr.TxPool {
 	return tm.txMemPool
 }
 
+func (tm *TxManager) FeeEstimator() *mempool.FeeEstimator {
+	return tm.feeEstimator
+}
+
+// loadFeeEstimator restores the fee estimator saved in the db, or creates a
+// new one when there is none or it can't be restored.
+func loadFeeEstimator(db database.DB) *mempool.FeeEstimator {
+	var feeEstimator *mempool.FeeEstimator
+	err := db.View(func(dbTx database.Tx) error {
+		data := dbTx.Metadata().Get(dbnamespace.FeeEstimationKeyName)
+		if data == nil {
+			return nil
+		}
+		fe, err := mempool.RestoreFeeEstimator(data)
+		if err != nil {
+			return err
+		}
+		feeEstimator = fe
+		return nil
+	})
+	if err != nil {
+		log.Warn("Failed to restore the fee estimator", "error", err)
+	}
+	if feeEstimator == nil {
+		feeEstimator = mempool.NewFeeEstimator(mempool.DefaultEstimateFeeMaxTarget)
+	}
+	return feeEstimator
+}
+
 func NewTxManager(bm *blkmgr.BlockManager, txIndex *index.TxIndex,
 	addrIndex *index.AddrIndex, cfg *config.Config, ntmgr notify.Notify,
 	sigCache *txscript.SigCache, db database.DB) (*TxManager, error) {
+	feeEstimator := loadFeeEstimator(db)
+
 	// mem-pool
 	txC := mempool.Config{
 		Policy: mempool.Policy{
@@ -64,6 +129,8 @@ func NewTxManager(bm *blkmgr.BlockManager, txIndex *index.TxIndex,
 			MaxOrphanTxSize:      mempool.DefaultMaxOrphanTxSize,
 			MaxSigOpsPerTx:       blockchain.MaxSigOpsPerBlock / 5,
 			MinRelayTxFee:        types.Amount(cfg.MinTxFee),
+			MaxPoolSize:          cfg.MaxMempool * 1000000,
+			RejectReplacement:    cfg.RejectReplacement,
 			StandardVerifyFlags: func() (txscript.ScriptFlags, error) {
 				return common.StandardScriptVerifyFlags()
 			},
@@ -78,10 +145,16 @@ func NewTxManager(bm *blkmgr.BlockManager, txIndex *index.TxIndex,
 		SigCache:         sigCache,
 		PastMedianTime:   func() time.Time { return bm.GetChain().BestSnapshot().MedianTime },
 		AddrIndex:        addrIndex,
+		FeeEstimator:     feeEstimator,
 		BD:               bm.GetChain().BlockDAG(),
 		BC:               bm.GetChain(),
 	}
 	txMemPool := mempool.New(&txC)
 	invalidTx := make(map[hash.Hash]*blockdag.HashSet)
-	return &TxManager{bm, txIndex, addrIndex, txMemPool, ntmgr, db, invalidTx}, nil
+	var mempoolDumpPath string
+	if !cfg.NoPersistMempool {
+		mempoolDumpPath = filepath.Join(cfg.DataDir, mempool.MempoolDumpFilename)
+	}
+	return &TxManager{bm, txIndex, addrIndex, txMemPool, ntmgr, db, invalidTx, feeEstimator,
+		mempoolDumpPath}, nil
 }